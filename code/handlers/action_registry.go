@@ -0,0 +1,283 @@
+package handlers
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"start-feishubot/logger"
+)
+
+// ActionSpec 描述责任链中的一个 Action。Execute 返回 true 表示继续执行后续 Action，
+// 返回 false 表示消息已处理完毕
+type ActionSpec struct {
+	// Name 唯一名称，用于按会话开关和日志
+	Name string
+	// Priority 越小越先执行，相同时按注册顺序
+	Priority int
+	// MsgTypes 处理的消息类型，为空表示全部
+	MsgTypes []string
+	// ChatTypes 处理的会话类型，为空表示全部
+	ChatTypes []HandlerType
+	// When 额外的执行条件，为空表示总是执行
+	When func(a *ActionInfo) bool
+	// Terminal 执行后结束责任链，即使 Execute 返回 true
+	Terminal bool
+	// Required 不能按会话关闭，例如去重、@ 判断和额度检查
+	Required bool
+	// Inline 收到事件时立即执行，用于去重、@ 判断等不调用模型的快速过滤，
+	// 通过后其余 Action 交给工作池执行
+	Inline bool
+	Action Action
+}
+
+func (s ActionSpec) accepts(a *ActionInfo) (bool, string) {
+	if len(s.MsgTypes) > 0 && !containsString(s.MsgTypes, a.info.msgType) {
+		return false, "msg_type"
+	}
+	if len(s.ChatTypes) > 0 {
+		found := false
+		for _, t := range s.ChatTypes {
+			if t == a.info.handlerType {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false, "chat_type"
+		}
+	}
+	if s.When != nil && !s.When(a) {
+		return false, "condition"
+	}
+	return true, ""
+}
+
+// ActionSwitches 按会话开关 Action，键为 chat_id，"*" 表示所有会话
+type ActionSwitches map[string]map[string]bool
+
+// ParseActionSwitches 解析形如 "*=pic/vision,oc_xxx=+pic" 的配置：
+// 斜杠分隔 Action 名称，默认表示关闭，以 + 开头表示开启，会话的设置覆盖 "*"
+func ParseActionSwitches(entries []string) (ActionSwitches, error) {
+	switches := make(ActionSwitches)
+	for _, entry := range entries {
+		chatId, names, ok := strings.Cut(entry, "=")
+		chatId = strings.TrimSpace(chatId)
+		if !ok || chatId == "" {
+			return nil, fmt.Errorf("invalid action switch %q", entry)
+		}
+		if switches[chatId] == nil {
+			switches[chatId] = make(map[string]bool)
+		}
+		for _, name := range strings.Split(names, "/") {
+			name = strings.TrimSpace(name)
+			enabled := strings.HasPrefix(name, "+")
+			name = strings.TrimLeft(name, "+-")
+			if name == "" {
+				return nil, fmt.Errorf("invalid action switch %q", entry)
+			}
+			switches[chatId][name] = enabled
+		}
+	}
+	return switches, nil
+}
+
+// Enabled 判断 Action 在会话中是否开启，没有配置时默认开启
+func (s ActionSwitches) Enabled(chatId string, name string) bool {
+	if enabled, ok := s[chatId][name]; ok {
+		return enabled
+	}
+	if enabled, ok := s["*"][name]; ok {
+		return enabled
+	}
+	return true
+}
+
+// ActionRegistry 按优先级排列的 Action 注册表
+type ActionRegistry struct {
+	specs    []ActionSpec
+	switches ActionSwitches
+}
+
+func NewActionRegistry(switches ActionSwitches) *ActionRegistry {
+	return &ActionRegistry{switches: switches}
+}
+
+// Register 注册 Action，名称重复时返回错误
+func (r *ActionRegistry) Register(specs ...ActionSpec) error {
+	for _, spec := range specs {
+		if spec.Name == "" || spec.Action == nil {
+			return fmt.Errorf("action name and implementation are required")
+		}
+		for _, s := range r.specs {
+			if s.Name == spec.Name {
+				return fmt.Errorf("action %s already registered", spec.Name)
+			}
+		}
+		r.specs = append(r.specs, spec)
+	}
+	sort.SliceStable(r.specs, func(i, j int) bool {
+		return r.specs[i].Priority < r.specs[j].Priority
+	})
+	return nil
+}
+
+// Specs 按执行顺序返回全部 Action
+func (r *ActionRegistry) Specs() []ActionSpec {
+	return append([]ActionSpec{}, r.specs...)
+}
+
+// Validate 检查开关配置中是否有未注册或不能关闭的 Action
+func (r *ActionRegistry) Validate() error {
+	for chatId, names := range r.switches {
+		for name := range names {
+			spec, ok := r.lookup(name)
+			if !ok {
+				return fmt.Errorf("unknown action %s for %s", name, chatId)
+			}
+			if spec.Required {
+				return fmt.Errorf("action %s can not be switched", name)
+			}
+		}
+	}
+	return nil
+}
+
+func (r *ActionRegistry) lookup(name string) (ActionSpec, bool) {
+	for _, s := range r.specs {
+		if s.Name == name {
+			return s, true
+		}
+	}
+	return ActionSpec{}, false
+}
+
+// Run 依次执行全部 Action，返回决策路径，例如 "unique:continue"、"pic:skip(msg_type)"
+func (r *ActionRegistry) Run(a *ActionInfo) []string {
+	path, _ := r.run(a, func(ActionSpec) bool { return true })
+	return path
+}
+
+// RunInline 只执行 Inline 的 Action，返回决策路径和是否需要执行其余 Action
+func (r *ActionRegistry) RunInline(a *ActionInfo) ([]string, bool) {
+	return r.run(a, func(s ActionSpec) bool { return s.Inline })
+}
+
+// RunQueued 执行 Inline 以外的 Action
+func (r *ActionRegistry) RunQueued(a *ActionInfo) []string {
+	path, _ := r.run(a, func(s ActionSpec) bool { return !s.Inline })
+	return path
+}
+
+func (r *ActionRegistry) run(a *ActionInfo,
+	include func(ActionSpec) bool) ([]string, bool) {
+	var path []string
+	for _, spec := range r.specs {
+		if !include(spec) {
+			continue
+		}
+		if ok, reason := spec.accepts(a); !ok {
+			path = append(path, spec.Name+":skip("+reason+")")
+			continue
+		}
+		if !spec.Required && !r.switches.Enabled(*a.info.chatId, spec.Name) {
+			path = append(path, spec.Name+":disabled")
+			continue
+		}
+		if !spec.Action.Execute(a) {
+			path = append(path, spec.Name+":stop")
+			return path, false
+		}
+		if spec.Terminal {
+			path = append(path, spec.Name+":terminal")
+			return path, false
+		}
+		path = append(path, spec.Name+":continue")
+	}
+	return path, true
+}
+
+// logActionPath 记录责任链的决策路径，跳过的 Action 只在路径中计数
+func logActionPath(a *ActionInfo, path []string) {
+	var steps []string
+	for _, step := range path {
+		if !strings.Contains(step, ":skip") {
+			steps = append(steps, step)
+		}
+	}
+	logger.Debugf("action path, msg: %s, type: %s, chat: %s, path: %s (skipped %d)",
+		*a.info.msgId, a.info.msgType, a.info.handlerType,
+		strings.Join(steps, " → "), len(path)-len(steps))
+}
+
+var (
+	textTypes = []string{"text", "post"}
+	fileTypes = []string{"file", "media"}
+	// forwardTypes 需要查询后才能转成文字的转发和分享消息
+	forwardTypes = []string{"merge_forward", "share_chat", "share_user"}
+)
+
+func notStreamMode(a *ActionInfo) bool { return !a.handler.config.StreamMode }
+func streamMode(a *ActionInfo) bool    { return a.handler.config.StreamMode }
+
+// defaultActions 内置的 Action，优先级之间留有间隔，方便插入新的 Action
+func defaultActions() []ActionSpec {
+	return []ActionSpec{
+		{Name: "unique", Priority: 10, Required: true, Inline: true,
+			Action: &ProcessedUniqueAction{}},
+		{Name: "mention", Priority: 20, Required: true, Inline: true,
+			Action: &ProcessMentionAction{}},
+		{Name: "access", Priority: 21, Required: true, Inline: true,
+			When: threadResolved, Action: &AccessAction{}},
+		{Name: "ratelimit", Priority: 22, Required: true, Inline: true,
+			When: threadResolved, Action: &RateLimitAction{}},
+		{Name: "thread", Priority: 23, Required: true, When: threadPending,
+			Action: &ThreadMentionAction{}},
+		{Name: "command", Priority: 25, Required: true, Action: &CommandAction{}},
+		{Name: "unsupported", Priority: 26, When: unsupportedMsgType, Terminal: true,
+			Required: true, Action: &UnsupportedAction{}},
+		{Name: "quota", Priority: 30, Required: true, Action: &QuotaAction{}},
+		{Name: "quote", Priority: 40, MsgTypes: textTypes, Action: &QuoteAction{}},
+		{Name: "forward", Priority: 50, MsgTypes: forwardTypes, Action: &ForwardAction{}},
+		{Name: "audio", Priority: 100, MsgTypes: []string{"audio"}, Action: &AudioAction{}},
+		{Name: "media", Priority: 110, MsgTypes: fileTypes, Action: &MediaFileAction{}},
+		{Name: "clear", Priority: 120, Required: true, Action: &ClearAction{}},
+		{Name: "document", Priority: 130, MsgTypes: []string{"file"}, Action: &DocumentAction{}},
+		{Name: "multimodal", Priority: 200, Action: &MultimodalAction{}},
+		{Name: "vision", Priority: 210, Action: &VisionAction{}},
+		{Name: "pic", Priority: 220, Action: &PicAction{}},
+		{Name: "ai_mode", Priority: 300, Action: &AIModeAction{}},
+		{Name: "voice", Priority: 310, Action: &VoiceAction{}},
+		{Name: "asr", Priority: 320, Action: &AsrSettingAction{}},
+		{Name: "transcribe", Priority: 330, Action: &TranscribeAction{}},
+		{Name: "roles", Priority: 340, Action: &RoleListAction{}},
+		{Name: "help", Priority: 350, Required: true, Action: &HelpAction{}},
+		{Name: "balance", Priority: 360, Action: &BalanceAction{}},
+		{Name: "usage", Priority: 370, Action: &UsageAction{}},
+		{Name: "kb", Priority: 380, Action: &KnowledgeAction{}},
+		{Name: "summary", Priority: 385, Action: &SummaryAction{}},
+		{Name: "role_play", Priority: 390, Action: &RolePlayAction{}},
+		{Name: "document_qa", Priority: 400, MsgTypes: textTypes, Action: &DocumentQAAction{}},
+		{Name: "empty", Priority: 900, Required: true, Action: &EmptyAction{}},
+		{Name: "message", Priority: 910, When: notStreamMode, Terminal: true,
+			Action: &MessageAction{}},
+		{Name: "stream", Priority: 920, When: streamMode, Terminal: true,
+			Action: &StreamMessageAction{}},
+	}
+}
+
+// newActionRegistry 注册内置 Action 并加载按会话的开关配置
+func newActionRegistry(entries []string) *ActionRegistry {
+	switches, err := ParseActionSwitches(entries)
+	if err != nil {
+		logger.Warnf("parse action switches failed: %v", err)
+	}
+	registry := NewActionRegistry(switches)
+	if err := registry.Register(defaultActions()...); err != nil {
+		logger.Fatalf("register actions failed: %v", err)
+	}
+	if err := registry.Validate(); err != nil {
+		logger.Warnf("invalid action switches: %v", err)
+	}
+	return registry
+}
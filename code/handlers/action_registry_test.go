@@ -0,0 +1,305 @@
+package handlers
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"start-feishubot/services"
+	"start-feishubot/services/access"
+	"start-feishubot/services/openai"
+	"start-feishubot/services/ratelimit"
+)
+
+// recordAction 记录执行过的 Action，返回预设的结果
+type recordAction struct {
+	name   string
+	result bool
+	calls  *[]string
+}
+
+func (r *recordAction) Execute(a *ActionInfo) bool {
+	*r.calls = append(*r.calls, r.name)
+	return r.result
+}
+
+// commandRecordAction 消息是同名指令时记录调用并停止
+type commandRecordAction struct {
+	recordAction
+}
+
+func (r *commandRecordAction) Execute(a *ActionInfo) bool {
+	if matchCommand(a, r.name) == nil {
+		return true
+	}
+	*r.calls = append(*r.calls, r.name)
+	return false
+}
+
+func TestParseActionSwitches(t *testing.T) {
+	switches, err := ParseActionSwitches([]string{"*=pic/vision", "oc_a=+pic/-voice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		chatId string
+		name   string
+		want   bool
+	}{
+		{"oc_b", "pic", false},
+		{"oc_b", "vision", false},
+		{"oc_b", "voice", true},
+		{"oc_a", "pic", true},
+		{"oc_a", "vision", false},
+		{"oc_a", "voice", false},
+	}
+	for _, tt := range tests {
+		if got := switches.Enabled(tt.chatId, tt.name); got != tt.want {
+			t.Errorf("Enabled(%s, %s) = %v, want %v", tt.chatId, tt.name, got, tt.want)
+		}
+	}
+
+	for _, entry := range []string{"pic", "=pic", "oc_a=", "oc_a=pic//vision"} {
+		if _, err := ParseActionSwitches([]string{entry}); err == nil {
+			t.Errorf("expected error for %q", entry)
+		}
+	}
+}
+
+func TestActionRegistryRun(t *testing.T) {
+	var calls []string
+	action := func(name string, result bool) Action {
+		return &recordAction{name: name, result: result, calls: &calls}
+	}
+	switches, _ := ParseActionSwitches([]string{"oc_a=pic"})
+	registry := NewActionRegistry(switches)
+	err := registry.Register(
+		ActionSpec{Name: "message", Priority: 90, Terminal: true, Action: action("message", true)},
+		ActionSpec{Name: "unique", Priority: 10, Required: true, Action: action("unique", true)},
+		ActionSpec{Name: "audio", Priority: 20, MsgTypes: []string{"audio"}, Action: action("audio", true)},
+		ActionSpec{Name: "pic", Priority: 30, Action: action("pic", true)},
+		ActionSpec{Name: "group", Priority: 40, ChatTypes: []HandlerType{GroupHandler},
+			Action: action("group", false)},
+		ActionSpec{Name: "last", Priority: 100, Action: action("last", true)},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := registry.Register(ActionSpec{Name: "pic", Action: action("pic", true)}); err == nil {
+		t.Error("expected duplicate name error")
+	}
+
+	tests := []struct {
+		name     string
+		chatId   string
+		msgType  string
+		chatType HandlerType
+		calls    string
+		path     string
+	}{
+		{
+			name: "private text", chatId: "oc_b", msgType: "text", chatType: UserHandler,
+			calls: "unique,pic,message",
+			path:  "unique:continue,audio:skip(msg_type),pic:continue,group:skip(chat_type),message:terminal",
+		},
+		{
+			name: "disabled in group", chatId: "oc_a", msgType: "audio", chatType: GroupHandler,
+			calls: "unique,audio,group",
+			path:  "unique:continue,audio:continue,pic:disabled,group:stop",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls = nil
+			chatId := tt.chatId
+			msgId := "om_1"
+			a := &ActionInfo{info: &MsgInfo{chatId: &chatId, msgId: &msgId,
+				msgType: tt.msgType, handlerType: tt.chatType}}
+			path := registry.Run(a)
+			if got := strings.Join(calls, ","); got != tt.calls {
+				t.Errorf("calls = %s, want %s", got, tt.calls)
+			}
+			if got := strings.Join(path, ","); got != tt.path {
+				t.Errorf("path = %s, want %s", got, tt.path)
+			}
+		})
+	}
+
+	invalid := NewActionRegistry(ActionSwitches{"*": {"unique": false}})
+	invalid.Register(registry.Specs()...)
+	if err := invalid.Validate(); err == nil {
+		t.Error("expected error for switching a required action")
+	}
+}
+
+func TestDefaultActions(t *testing.T) {
+	registry := NewActionRegistry(nil)
+	if err := registry.Register(defaultActions()...); err != nil {
+		t.Fatal(err)
+	}
+	specs := registry.Specs()
+	if specs[0].Name != "unique" || !specs[len(specs)-1].Terminal {
+		t.Errorf("unexpected order: first %s, last %s", specs[0].Name,
+			specs[len(specs)-1].Name)
+	}
+}
+
+func TestActionRegistryRunInline(t *testing.T) {
+	var calls []string
+	action := func(name string, result bool) Action {
+		return &recordAction{name: name, result: result, calls: &calls}
+	}
+	registry := NewActionRegistry(nil)
+	registry.Register(
+		ActionSpec{Name: "unique", Priority: 10, Inline: true, Action: action("unique", true)},
+		ActionSpec{Name: "mention", Priority: 20, Inline: true,
+			ChatTypes: []HandlerType{GroupHandler}, Action: action("mention", false)},
+		ActionSpec{Name: "message", Priority: 90, Terminal: true, Action: action("message", true)},
+	)
+	chatId, msgId := "oc_a", "om_1"
+
+	a := &ActionInfo{info: &MsgInfo{chatId: &chatId, msgId: &msgId,
+		handlerType: UserHandler}}
+	path, next := registry.RunInline(a)
+	if !next || strings.Join(path, ",") != "unique:continue,mention:skip(chat_type)" {
+		t.Errorf("inline path = %v, next = %v", path, next)
+	}
+	if got := strings.Join(registry.RunQueued(a), ","); got != "message:terminal" {
+		t.Errorf("queued path = %s", got)
+	}
+
+	calls = nil
+	a.info.handlerType = GroupHandler
+	if path, next := registry.RunInline(a); next || path[len(path)-1] != "mention:stop" {
+		t.Errorf("inline path = %v, next = %v", path, next)
+	}
+	if got := strings.Join(calls, ","); got != "unique,mention" {
+		t.Errorf("calls = %s", got)
+	}
+}
+
+// TestDefaultActionsCommand 多模态模型下指令仍然由对应的 Action 处理，不会被当作对话
+func TestDefaultActionsCommand(t *testing.T) {
+	var calls []string
+	registry := NewActionRegistry(nil)
+	for _, spec := range defaultActions() {
+		// 只保留真实的多模态 Action，其余替换为记录调用，同名指令的 Action 处理后停止
+		if spec.Name != "multimodal" {
+			spec.Action = &commandRecordAction{recordAction{name: spec.Name,
+				calls: &calls}}
+		}
+		if err := registry.Register(spec); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"/help", "help"},
+		{"帮助", "help"},
+		{"/voice off", "voice"},
+		{"/asr lang zh", "asr"},
+		{"转写", "transcribe"},
+		{"/usage 7d", "usage"},
+		{"/kb list", "kb"},
+		{"/summary 50", "summary"},
+		{"余额", "balance"},
+	}
+	for _, model := range []string{"o4-mini", "gpt-4o"} {
+		for _, tt := range tests {
+			calls = nil
+			sessionId, chatId, msgId := "om_multimodal_cmd", "oc_a", "om_1"
+			a := &ActionInfo{
+				handler: &MessageHandler{
+					sessionCache: services.GetSessionCache(),
+					gpt:          &openai.ChatGPT{Model: model},
+				},
+				info: &MsgInfo{chatId: &chatId, msgId: &msgId, sessionId: &sessionId,
+					msgType: "text", handlerType: UserHandler, qParsed: tt.text},
+			}
+			path := strings.Join(registry.Run(a), ",")
+			if !strings.Contains(path, "multimodal:continue") ||
+				!strings.HasSuffix(path, tt.want+":stop") {
+				t.Errorf("%s %q: path = %s, calls = %v", model, tt.text, path, calls)
+			}
+		}
+	}
+}
+
+// TestDefaultActionsThread 话题需要查询时，收到事件时先放行，在工作池中查询后再检查权限和频率
+func TestDefaultActionsThread(t *testing.T) {
+	var calls []string
+	kept := map[string]bool{"mention": true, "access": true, "ratelimit": true,
+		"thread": true}
+	registry := NewActionRegistry(nil)
+	for _, spec := range defaultActions() {
+		switch {
+		case spec.Name == "unique":
+			spec.Action = &recordAction{name: spec.Name, result: true, calls: &calls}
+		case !kept[spec.Name]:
+			spec.Action = &recordAction{name: spec.Name, calls: &calls}
+		}
+		if err := registry.Register(spec); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		name    string
+		msgType string
+		// cached 为查询前缓存的根消息是否 @ 了机器人，为空表示未缓存
+		cached  string
+		mention bool
+		inline  string
+		queued  string
+	}{
+		{"pending mentioned", "file", "", true,
+			"unique:continue,mention:continue,access:skip(condition),ratelimit:skip(condition)",
+			"thread:continue,command:stop"},
+		{"pending not mentioned", "file", "", false,
+			"unique:continue,mention:continue,access:skip(condition),ratelimit:skip(condition)",
+			"thread:stop"},
+		{"cached mentioned", "file", "yes", true,
+			"unique:continue,mention:continue,access:continue,ratelimit:continue",
+			"thread:skip(condition),command:stop"},
+		{"cached not mentioned", "file", "no", false,
+			"unique:continue,mention:stop", ""},
+		// 没有开启 GroupThreadReply 时文字消息需要 @ 机器人，不查询话题
+		{"text", "text", "", false, "unique:continue,mention:stop", ""},
+	}
+	for i, tt := range tests {
+		rootId, msgId, chatId := fmt.Sprintf("om_thread_root_%d", i), "om_reply", "oc_a"
+		if tt.cached != "" {
+			rootMentions.Store(rootId, tt.cached == "yes")
+		}
+		a := &ActionInfo{
+			handler: &MessageHandler{
+				sessionCache: services.GetSessionCache(),
+				chatSettings: services.GetChatSettings(""),
+				access:       access.NewPolicy(nil, nil, nil),
+				limiter:      ratelimit.NewLimiter(ratelimit.Config{}),
+			},
+			info: &MsgInfo{chatId: &chatId, msgId: &msgId, sessionId: &rootId,
+				msgType: tt.msgType, handlerType: GroupHandler, userId: "ou_a"},
+		}
+		path, next := registry.RunInline(a)
+		if got := strings.Join(path, ","); got != tt.inline || next != (tt.queued != "") {
+			t.Errorf("%s: inline path = %s, next = %v", tt.name, got, next)
+			continue
+		}
+		if !next {
+			continue
+		}
+		// 模拟查询到的根消息
+		rootMentions.Store(rootId, tt.mention)
+		var steps []string
+		for _, step := range registry.RunQueued(a) {
+			if !strings.Contains(step, ":skip") || strings.HasPrefix(step, "thread:") {
+				steps = append(steps, step)
+			}
+		}
+		if got := strings.Join(steps, ","); got != tt.queued {
+			t.Errorf("%s: queued path = %s", tt.name, got)
+		}
+	}
+}
@@ -20,11 +20,13 @@ func NewCardHandler(m MessageHandler) CardHandlerFunc {
 		NewPicResolutionHandler,
 		NewVisionResolutionHandler,
 		NewPicTextMoreHandler,
+		NewPicGalleryHandler,
 		NewPicModeChangeHandler,
 		NewRoleTagCardHandler,
 		NewRoleCardHandler,
 		NewAIModeCardHandler,
 		NewVisionModeChangeHandler,
+		NewVoiceCardHandler,
 	}
 
 	return func(ctx context.Context, cardAction *larkcard.CardAction) (interface{}, error) {
@@ -36,6 +38,10 @@ func NewCardHandler(m MessageHandler) CardHandlerFunc {
 		}
 		//pp.Println(cardMsg)
 		//logger.Debug("cardMsg ", cardMsg)
+		if !m.checkCardAccess(ctx, cardMsg, cardAction.OpenID,
+			cardAction.OpenMessageID) {
+			return nil, nil
+		}
 		for _, handler := range handlers {
 			h := handler(cardMsg, m)
 			i, err := h(ctx, cardAction)
@@ -5,8 +5,14 @@ import (
 	"fmt"
 	larkcore "github.com/larksuite/oapi-sdk-go/v3/core"
 	"start-feishubot/logger"
+	"strconv"
+	"time"
 
 	"start-feishubot/services"
+	"start-feishubot/services/openai"
+	"start-feishubot/services/ratelimit"
+	"start-feishubot/services/usage"
+	"start-feishubot/utils/imaging"
 
 	larkcard "github.com/larksuite/oapi-sdk-go/v3/card"
 )
@@ -21,6 +27,14 @@ func NewPicResolutionHandler(cardMsg CardMsg, m MessageHandler) CardHandlerFunc
 			CommonProcessPicStyle(cardMsg, cardAction, m.sessionCache)
 			return nil, nil
 		}
+		if cardMsg.Kind == PicModelKind {
+			CommonProcessPicModel(cardMsg, cardAction, m.sessionCache)
+			return nil, nil
+		}
+		if cardMsg.Kind == PicCountKind {
+			CommonProcessPicCount(cardMsg, cardAction, m.sessionCache)
+			return nil, nil
+		}
 		return nil, ErrNextHandler
 	}
 }
@@ -41,8 +55,12 @@ func NewPicModeChangeHandler(cardMsg CardMsg, m MessageHandler) CardHandlerFunc
 func NewPicTextMoreHandler(cardMsg CardMsg, m MessageHandler) CardHandlerFunc {
 	return func(ctx context.Context, cardAction *larkcard.CardAction) (interface{}, error) {
 		if cardMsg.Kind == PicTextMoreKind {
+			if !m.checkCardLimit(ctx, cardAction.OpenID, cardMsg.chat(),
+				cardAction.OpenMessageID) {
+				return nil, nil
+			}
 			go func() {
-				m.CommonProcessPicMore(cardMsg)
+				m.CommonProcessPicMore(cardMsg, cardAction.OpenID)
 			}()
 			return nil, nil
 		}
@@ -50,6 +68,29 @@ func NewPicTextMoreHandler(cardMsg CardMsg, m MessageHandler) CardHandlerFunc {
 	}
 }
 
+func NewPicGalleryHandler(cardMsg CardMsg, m MessageHandler) CardHandlerFunc {
+	return func(ctx context.Context, cardAction *larkcard.CardAction) (interface{}, error) {
+		switch cardMsg.Kind {
+		case PicVarMoreKind:
+			if !m.checkCardLimit(ctx, cardAction.OpenID, cardMsg.chat(),
+				cardAction.OpenMessageID) {
+				return nil, nil
+			}
+			go func() {
+				m.CommonProcessPicVariation(cardMsg, cardAction.OpenID)
+			}()
+			return nil, nil
+		case PicEditKind:
+			CommonProcessPicEdit(cardMsg, m.sessionCache)
+			return nil, nil
+		case PicReferenceKind:
+			CommonProcessPicReference(cardMsg, m.sessionCache)
+			return nil, nil
+		}
+		return nil, ErrNextHandler
+	}
+}
+
 func CommonProcessPicResolution(msg CardMsg,
 	cardAction *larkcard.CardAction,
 	cache services.SessionServiceCacheInterface) {
@@ -72,16 +113,160 @@ func CommonProcessPicStyle(msg CardMsg,
 		&msg.MsgId)
 }
 
-func (m MessageHandler) CommonProcessPicMore(msg CardMsg) {
+func CommonProcessPicModel(msg CardMsg,
+	cardAction *larkcard.CardAction,
+	cache services.SessionServiceCacheInterface) {
+	option := cardAction.Action.Option
+	cache.SetPicModel(msg.SessionId, option)
+	cache.SetPicEditImages(msg.SessionId, nil)
+	replyMsg(context.Background(), "已更新图片模型为"+option,
+		&msg.MsgId)
+}
+
+func CommonProcessPicCount(msg CardMsg,
+	cardAction *larkcard.CardAction,
+	cache services.SessionServiceCacheInterface) {
+	count, err := strconv.Atoi(cardAction.Action.Option)
+	if err != nil {
+		return
+	}
+	cache.SetPicCount(msg.SessionId, count)
+	replyMsg(context.Background(), fmt.Sprintf("已更新单次生成数量为 %d 张",
+		cache.GetPicCount(msg.SessionId)), &msg.MsgId)
+}
+
+// checkCardLimit 卡片按钮生成图片前按点击的用户和卡片所在的会话检查频率限制和额度，
+// 与消息的 RateLimitAction、QuotaAction 一致
+func (m MessageHandler) checkCardLimit(ctx context.Context, openId string,
+	chat CardChat, msgId string) bool {
+	now := time.Now()
+	denied, exceeded := m.cardLimit(openId, chat, now)
+	if denied != nil {
+		logger.Warnf("card rate limited, user: %s, chat: %s, scope: %s",
+			openId, chat.ChatId, denied.Scope)
+		sendRateLimitedCard(ctx, &msgId, denied, now)
+		return false
+	}
+	if exceeded != nil {
+		logger.Warnf("card quota exceeded, user: %s, chat: %s, scope: %s, period: %s",
+			openId, chat.ChatId, exceeded.Scope, exceeded.Period)
+		sendQuotaExceededCard(ctx, &msgId, exceeded)
+		return false
+	}
+	return true
+}
+
+// cardLimit 超出频率限制或额度时返回原因，管理员不受限制
+func (m MessageHandler) cardLimit(openId string, chat CardChat,
+	now time.Time) (*ratelimit.Denied, *usage.Exceeded) {
+	if m.access.IsAdmin(m.subjectOf(openId, chat.ChatId)) {
+		return nil, nil
+	}
+	if denied := m.limiter.Allow(openId, chat.ChatId, chat.isGroup(),
+		now); denied != nil {
+		return denied, nil
+	}
+	return nil, m.quota.Check(openId, chat.ChatId, chat.isGroup(), now)
+}
+
+// recordCardImageUsage 卡片按钮生成图片的用量记在点击的用户和卡片所在的会话上
+func (m MessageHandler) recordCardImageUsage(openId string, chat CardChat,
+	tokenUsage openai.Usage) {
+	if tokenUsage.TotalTokens == 0 && tokenUsage.Images == 0 {
+		return
+	}
+	m.addUsage(openId, chat.ChatId, string(chat.ChatType), usage.KindImage,
+		tokenUsage)
+}
+
+func (m MessageHandler) CommonProcessPicMore(msg CardMsg, openId string) {
 	resolution := m.sessionCache.GetPicResolution(msg.SessionId)
 	style := m.sessionCache.GetPicStyle(msg.SessionId)
 
 	logger.Debugf("resolution: %v", resolution)
 	logger.Debug("msg: %v", msg)
 	question := msg.Value.(string)
-	bs64, _ := m.gpt.GenerateOneImage(question, resolution, style)
-	replayImageCardByBase64(context.Background(), bs64, &msg.MsgId,
-		&msg.SessionId, question)
+	model := m.sessionCache.GetPicModel(msg.SessionId)
+	count := m.sessionCache.GetPicCount(msg.SessionId)
+	bs64s, tokenUsage, err := m.gpt.GenerateImageWithModel(question, resolution,
+		count, style, model)
+	if err != nil || len(bs64s) == 0 {
+		replyMsg(context.Background(), fmt.Sprintf(
+			"🤖️：图片生成失败，请稍后再试～\n错误信息: %v", err), &msg.MsgId)
+		return
+	}
+	m.recordCardImageUsage(openId, msg.chat(), tokenUsage)
+	replyImageGalleryByBase64(context.Background(), bs64s, &msg.MsgId,
+		&msg.SessionId, msg.chat(), question)
+}
+
+// CommonProcessPicVariation 根据图集中的一张图片生成 1024x1024 的变体
+func (m MessageHandler) CommonProcessPicVariation(msg CardMsg, openId string) {
+	imageKey, _ := msg.Value.(string)
+	data, err := downloadImage("", imageKey)
+	if err == nil {
+		data, err = imaging.Process(data, imaging.DallE2)
+	}
+	if err != nil {
+		replyMsg(context.Background(), fmt.Sprintf(
+			"🤖️：无法处理这张图片～\n错误信息: %v", err), &msg.MsgId)
+		return
+	}
+	count := m.sessionCache.GetPicCount(msg.SessionId)
+	bs64s, tokenUsage, err := m.gpt.GenerateImageVariationFromData(data,
+		string(services.Resolution1024), count)
+	if err != nil || len(bs64s) == 0 {
+		replyMsg(context.Background(), fmt.Sprintf(
+			"🤖️：图片生成失败，请稍后再试～\n错误信息: %v", err), &msg.MsgId)
+		return
+	}
+	m.recordCardImageUsage(openId, msg.chat(), tokenUsage)
+	replyImageGalleryByBase64(context.Background(), bs64s, &msg.MsgId,
+		&msg.SessionId, msg.chat(), "")
+}
+
+// CommonProcessPicEdit 将图集中的一张图片设为待编辑图片，等待修改说明
+func CommonProcessPicEdit(msg CardMsg,
+	cache services.SessionServiceCacheInterface) {
+	if !openai.IsGPTImageModel(cache.GetPicModel(msg.SessionId)) {
+		replyMsg(context.Background(), dallE2EditHint, &msg.MsgId)
+		return
+	}
+	imageKey, _ := msg.Value.(string)
+	cache.SetMode(msg.SessionId, services.ModePicCreate)
+	cache.SetPicEditImages(msg.SessionId,
+		[]services.ImageRef{{ImageKey: imageKey}})
+	replyMsg(context.Background(), "🤖️：请回复修改说明，例如“把天空改成夜晚”",
+		&msg.MsgId)
+}
+
+// CommonProcessPicReference 将图集中的图片加入参考图，可以连续选择多张
+func CommonProcessPicReference(msg CardMsg,
+	cache services.SessionServiceCacheInterface) {
+	if !openai.IsGPTImageModel(cache.GetPicModel(msg.SessionId)) {
+		replyMsg(context.Background(), fmt.Sprintf(
+			"🤖️：参考图需要 %s 模型，请在图片创作设置中切换模型",
+			openai.ImageModelGPTImage), &msg.MsgId)
+		return
+	}
+	imageKey, _ := msg.Value.(string)
+	images := cache.GetPicEditImages(msg.SessionId)
+	for _, image := range images {
+		if image.ImageKey == imageKey {
+			replyMsg(context.Background(), "🤖️：这张图片已经是参考图了",
+				&msg.MsgId)
+			return
+		}
+	}
+	images = append(images, services.ImageRef{ImageKey: imageKey})
+	if len(images) > maxEditImages {
+		images = images[len(images)-maxEditImages:]
+	}
+	cache.SetMode(msg.SessionId, services.ModePicCreate)
+	cache.SetPicEditImages(msg.SessionId, images)
+	replyMsg(context.Background(), fmt.Sprintf(
+		"🤖️：已选择 %d 张参考图，请回复描述生成新图片", len(images)),
+		&msg.MsgId)
 }
 
 func CommonProcessPicModeChange(cardMsg CardMsg,
@@ -99,7 +284,7 @@ func CommonProcessPicModeChange(cardMsg CardMsg,
 		newCard, _ :=
 			newSendCard(
 				withHeader("🖼️ 已进入图片创作模式", larkcard.TemplateBlue),
-				withPicResolutionBtn(&sessionId),
+				withPicResolutionBtn(&sessionId, cardMsg.chat()),
 				withNote("提醒：回复文本或图片，让AI生成相关的图片。"))
 		return newCard, nil, true
 	}
@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+
+	"start-feishubot/services/access"
+	"start-feishubot/services/openai"
+	"start-feishubot/services/ratelimit"
+	"start-feishubot/services/usage"
+)
+
+func TestCardLimit(t *testing.T) {
+	now := time.Now()
+	group := CardChat{ChatId: "oc_group", ChatType: GroupChatType}
+	p2p := CardChat{ChatId: "oc_p2p", ChatType: UserChatType}
+	newHandler := func(t *testing.T) MessageHandler {
+		store, err := usage.NewStore("")
+		if err != nil {
+			t.Fatal(err)
+		}
+		// ou_full 今天已经用完额度
+		if err := store.Add(usage.Record{Time: now, UserId: "ou_full",
+			ChatId: "oc_p2p", PromptTokens: 100}); err != nil {
+			t.Fatal(err)
+		}
+		return MessageHandler{
+			usageStore: store,
+			quota: usage.NewQuotaManager(store, usage.QuotaConfig{
+				User: usage.Quota{Daily: usage.Limit{Tokens: 100}},
+			}),
+			limiter: ratelimit.NewLimiter(ratelimit.Config{
+				User: ratelimit.Rate{Limit: 2, Period: time.Minute},
+				Chat: ratelimit.Rate{Limit: 1, Period: time.Minute},
+			}),
+			access: access.NewPolicy([]string{"ou_admin"}, nil, nil),
+		}
+	}
+	type click struct {
+		openId   string
+		chat     CardChat
+		denied   bool
+		exceeded bool
+	}
+	tests := []struct {
+		name   string
+		clicks []click
+	}{
+		{"user rate", []click{
+			{"ou_a", p2p, false, false},
+			{"ou_a", p2p, false, false},
+			{"ou_a", p2p, true, false},
+			{"ou_b", p2p, false, false},
+		}},
+		// 群聊的会话限制按卡片所在的会话计算
+		{"chat rate", []click{
+			{"ou_a", group, false, false},
+			{"ou_b", group, true, false},
+			{"ou_b", p2p, false, false},
+		}},
+		{"quota", []click{
+			{"ou_full", p2p, false, true},
+			{"ou_full", group, false, true},
+		}},
+		// 管理员不受频率限制和额度限制
+		{"admin", []click{
+			{"ou_admin", group, false, false},
+			{"ou_admin", group, false, false},
+			{"ou_admin", group, false, false},
+		}},
+	}
+	for _, tt := range tests {
+		m := newHandler(t)
+		for i, c := range tt.clicks {
+			denied, exceeded := m.cardLimit(c.openId, c.chat, now)
+			if (denied != nil) != c.denied || (exceeded != nil) != c.exceeded {
+				t.Errorf("%s: click %d cardLimit(%s, %s) = %v, %v", tt.name, i,
+					c.openId, c.chat.ChatId, denied, exceeded)
+			}
+		}
+	}
+}
+
+func TestRecordCardImageUsage(t *testing.T) {
+	a, store := testActionInfo(t, "", "", UserHandler)
+	m := *a.handler
+	chat := CardChat{ChatId: "oc_group", ChatType: GroupChatType}
+	m.recordCardImageUsage("ou_a", chat, openai.Usage{Model: "dall-e-2",
+		Images: 2, Size: "1024x1024"})
+	// 没有用量时不记录
+	m.recordCardImageUsage("ou_a", chat, openai.Usage{})
+	records := store.Query(usage.Filter{})
+	if len(records) != 1 {
+		t.Fatalf("stored %d records, want 1", len(records))
+	}
+	r := records[0]
+	if r.UserId != "ou_a" || r.ChatId != "oc_group" || r.ChatType != string(GroupHandler) ||
+		r.Kind != usage.KindImage || r.Images != 2 || r.Cost <= 0 {
+		t.Errorf("record = %+v", r)
+	}
+}
@@ -50,7 +50,7 @@ func CommonProcessRoleTag(msg CardMsg, cardAction *larkcard.CardAction,
 	//	&msg.MsgId)
 	roles := initialization.GetTitleListByTag(option)
 	//fmt.Printf("roles: %s", roles)
-	SendRoleListCard(context.Background(), &msg.SessionId,
+	SendRoleListCard(context.Background(), &msg.SessionId, msg.chat(),
 		&msg.MsgId, option, *roles)
 	return nil, nil, true
 }
@@ -58,7 +58,7 @@ func CommonProcessVisionModeChange(cardMsg CardMsg,
 		newCard, _ :=
 			newSendCard(
 				withHeader("🕵️️ 已进入图片推理模式", larkcard.TemplateBlue),
-				withVisionDetailLevelBtn(&sessionId),
+				withVisionDetailLevelBtn(&sessionId, cardMsg.chat()),
 				withNote("提醒：回复图片，让LLM和你一起推理图片的内容。"))
 		return newCard, nil, true
 	}
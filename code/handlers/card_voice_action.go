@@ -0,0 +1,36 @@
+package handlers
+
+import (
+	"context"
+
+	"start-feishubot/services"
+	"start-feishubot/services/openai"
+
+	larkcard "github.com/larksuite/oapi-sdk-go/v3/card"
+)
+
+// NewVoiceCardHandler 处理语音回复音色选择
+func NewVoiceCardHandler(cardMsg CardMsg,
+	m MessageHandler) CardHandlerFunc {
+	return func(ctx context.Context, cardAction *larkcard.CardAction) (interface{}, error) {
+
+		if cardMsg.Kind == VoiceChooseKind {
+			CommonProcessVoice(cardMsg, cardAction, m.sessionCache)
+			return nil, nil
+		}
+		return nil, ErrNextHandler
+	}
+}
+
+func CommonProcessVoice(msg CardMsg, cardAction *larkcard.CardAction,
+	cache services.SessionServiceCacheInterface) {
+	option := cardAction.Action.Option
+	if !openai.IsValidVoice(option) {
+		cache.SetVoice(msg.SessionId, "")
+		replyMsg(context.Background(), "已关闭语音回复", &msg.MsgId)
+		return
+	}
+	cache.SetVoice(msg.SessionId, option)
+	replyMsg(context.Background(), "已开启语音回复，音色: "+option,
+		&msg.MsgId)
+}
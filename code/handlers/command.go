@@ -0,0 +1,556 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"start-feishubot/services/access"
+)
+
+// ArgKind 指令参数的类型
+type ArgKind int
+
+const (
+	ArgString ArgKind = iota
+	ArgInt
+	ArgChoice
+)
+
+// CommandArg 按位置解析的指令参数
+type CommandArg struct {
+	Name string
+	// Label 帮助中显示的名称，为空时使用 Name
+	Label   string
+	Kind    ArgKind
+	Choices []string
+	// Optional 可以省略，可选参数只能出现在必填参数之后
+	Optional bool
+	// Rest 取剩余的原始文字，不再拆分和解析选项，只能是最后一个参数
+	Rest bool
+	// AdminChoices 需要管理员才能使用的取值，例如 /kb reindex
+	AdminChoices []string
+}
+
+// CommandFlag 以 --name、--name=value 或 -x 形式出现的选项
+type CommandFlag struct {
+	Name  string
+	Short string
+	Label string
+	// Bool 开关选项，不需要值
+	Bool    bool
+	Choices []string
+	Default string
+	Help    string
+}
+
+// Command 一条指令，英文指令以 / 开头，中文别名可以直接输入
+type Command struct {
+	Name    string
+	Aliases []string
+	Emoji   string
+	Title   string
+	Help    string
+	Args    []CommandArg
+	Flags   []CommandFlag
+	// Capability 使用指令需要的功能权限，为空表示不需要授权
+	Capability access.Capability
+	// Admin 只有管理员可以使用
+	Admin bool
+	// Free 额度用完后依然可以使用
+	Free bool
+}
+
+// Usage 返回指令的用法，例如 /transcribe [--format text|srt]
+func (c *Command) Usage() string {
+	parts := []string{"/" + c.Name}
+	for _, arg := range c.Args {
+		label := arg.label()
+		if arg.Rest {
+			label += "..."
+		}
+		if arg.Optional {
+			parts = append(parts, "["+label+"]")
+		} else {
+			parts = append(parts, "<"+label+">")
+		}
+	}
+	for _, flag := range c.Flags {
+		text := "--" + flag.Name
+		if !flag.Bool {
+			text += " " + flag.label()
+		}
+		parts = append(parts, "["+text+"]")
+	}
+	return strings.Join(parts, " ")
+}
+
+func (arg CommandArg) label() string {
+	if len(arg.Choices) > 0 {
+		return strings.Join(arg.Choices, "|")
+	}
+	if arg.Label != "" {
+		return arg.Label
+	}
+	return arg.Name
+}
+
+func (flag CommandFlag) label() string {
+	if len(flag.Choices) > 0 {
+		return strings.Join(flag.Choices, "|")
+	}
+	if flag.Label != "" {
+		return flag.Label
+	}
+	return flag.Name
+}
+
+func (c *Command) flag(name string) (CommandFlag, bool) {
+	for _, flag := range c.Flags {
+		if flag.Name == name || (flag.Short != "" && flag.Short == name) {
+			return flag, true
+		}
+	}
+	return CommandFlag{}, false
+}
+
+// CommandInput 解析后的指令
+type CommandInput struct {
+	Command *Command
+	// Slash 以 /指令 的形式输入，否则为中文别名
+	Slash bool
+	// Raw 指令名之后的原始文字
+	Raw   string
+	args  map[string]string
+	flags map[string]string
+}
+
+// Arg 返回参数的值，没有填写时返回空字符串，选项类参数统一为 Choices 中的写法
+func (in *CommandInput) Arg(name string) string {
+	return in.args[name]
+}
+
+// Int 返回整数参数的值，没有填写时返回 0
+func (in *CommandInput) Int(name string) int {
+	n, _ := strconv.Atoi(in.args[name])
+	return n
+}
+
+// Flag 返回选项的值，没有填写时返回默认值
+func (in *CommandInput) Flag(name string) string {
+	if value, ok := in.flags[name]; ok {
+		return value
+	}
+	flag, _ := in.Command.flag(name)
+	return flag.Default
+}
+
+// Capability 返回指令需要的功能权限，管理员指令或管理员参数需要 admin
+func (in *CommandInput) Capability() access.Capability {
+	if in.Command.Admin {
+		return access.CapAdmin
+	}
+	for _, arg := range in.Command.Args {
+		if containsString(arg.AdminChoices, in.args[arg.Name]) {
+			return access.CapAdmin
+		}
+	}
+	return in.Command.Capability
+}
+
+// Bool 返回开关选项是否打开
+func (in *CommandInput) Bool(name string) bool {
+	_, ok := in.flags[name]
+	return ok
+}
+
+// CommandError 指令参数错误，回复给用户时附带用法
+type CommandError struct {
+	Command *Command
+	Err     error
+}
+
+func (e *CommandError) Error() string {
+	return e.Err.Error()
+}
+
+// CommandRegistry 指令注册表，按注册顺序生成帮助
+type CommandRegistry struct {
+	commands []*Command
+}
+
+// Register 注册指令，名称或别名重复时返回错误
+func (r *CommandRegistry) Register(commands ...*Command) error {
+	for _, c := range commands {
+		if c.Name == "" {
+			return errors.New("command name is required")
+		}
+		for _, name := range append([]string{c.Name}, c.Aliases...) {
+			if r.Lookup(name) != nil {
+				return fmt.Errorf("command %s already registered", name)
+			}
+		}
+		for i, arg := range c.Args {
+			if arg.Rest && i != len(c.Args)-1 {
+				return fmt.Errorf("command %s: rest argument %s must be last",
+					c.Name, arg.Name)
+			}
+			if i > 0 && c.Args[i-1].Optional && !arg.Optional {
+				return fmt.Errorf("command %s: required argument %s after optional",
+					c.Name, arg.Name)
+			}
+		}
+		r.commands = append(r.commands, c)
+	}
+	return nil
+}
+
+// Commands 按注册顺序返回全部指令
+func (r *CommandRegistry) Commands() []*Command {
+	return append([]*Command{}, r.commands...)
+}
+
+// Lookup 按 /英文名（不区分大小写）、英文名或中文别名查找指令
+func (r *CommandRegistry) Lookup(name string) *Command {
+	slash := strings.HasPrefix(name, "/")
+	name = strings.TrimPrefix(name, "/")
+	for _, c := range r.commands {
+		if strings.EqualFold(c.Name, name) {
+			return c
+		}
+		if !slash && containsString(c.Aliases, name) {
+			return c
+		}
+	}
+	return nil
+}
+
+// Parse 解析一条消息。不是指令时返回 nil；/指令 的参数错误返回 *CommandError，
+// 中文别名后的参数无法解析时视为普通对话，例如“帮助我写一首诗”
+func (r *CommandRegistry) Parse(text string) (*CommandInput, error) {
+	text = strings.TrimSpace(text)
+	name := text
+	if i := strings.IndexFunc(text, unicode.IsSpace); i >= 0 {
+		name = text[:i]
+	}
+	slash := strings.HasPrefix(name, "/")
+	var c *Command
+	for _, cmd := range r.commands {
+		if slash && strings.EqualFold(cmd.Name, name[1:]) ||
+			!slash && containsString(cmd.Aliases, name) {
+			c = cmd
+			break
+		}
+	}
+	if c == nil {
+		return nil, nil
+	}
+	in, err := parseCommandArgs(c, strings.TrimSpace(text[len(name):]))
+	if err != nil {
+		if !slash {
+			return nil, nil
+		}
+		return nil, &CommandError{Command: c, Err: err}
+	}
+	in.Slash = slash
+	return in, nil
+}
+
+func parseCommandArgs(c *Command, raw string) (*CommandInput, error) {
+	in := &CommandInput{
+		Command: c,
+		Raw:     raw,
+		args:    make(map[string]string),
+		flags:   make(map[string]string),
+	}
+	tokens, err := tokenize(raw)
+	if err != nil {
+		return nil, err
+	}
+	position := 0
+	flagsDone := len(c.Flags) == 0
+	for i := 0; i < len(tokens); i++ {
+		tok := tokens[i]
+		if !flagsDone && !tok.quoted && tok.text == "--" {
+			flagsDone = true
+			continue
+		}
+		if !flagsDone && !tok.quoted && isFlagToken(tok.text) {
+			name, value, hasValue := strings.Cut(strings.TrimLeft(tok.text, "-"), "=")
+			flag, ok := c.flag(name)
+			if !ok {
+				return nil, fmt.Errorf("未知的选项 %s", tok.text)
+			}
+			if flag.Bool {
+				if hasValue {
+					return nil, fmt.Errorf("选项 --%s 不需要值", flag.Name)
+				}
+				in.flags[flag.Name] = "true"
+				continue
+			}
+			if !hasValue {
+				if i+1 >= len(tokens) {
+					return nil, fmt.Errorf("选项 --%s 需要指定%s", flag.Name, flag.label())
+				}
+				i++
+				value = tokens[i].text
+			}
+			value, err := checkChoice(value, flag.Choices)
+			if err != nil {
+				return nil, fmt.Errorf("选项 --%s %v", flag.Name, err)
+			}
+			in.flags[flag.Name] = value
+			continue
+		}
+		if position >= len(c.Args) {
+			return nil, fmt.Errorf("多余的参数 %s", tok.text)
+		}
+		arg := c.Args[position]
+		position++
+		if arg.Rest {
+			in.args[arg.Name] = strings.TrimSpace(raw[tok.start:])
+			break
+		}
+		value, err := checkArg(arg, tok.text)
+		if err != nil {
+			return nil, err
+		}
+		in.args[arg.Name] = value
+	}
+	for _, arg := range c.Args[position:] {
+		if !arg.Optional {
+			return nil, fmt.Errorf("缺少参数 %s", arg.label())
+		}
+	}
+	return in, nil
+}
+
+func checkArg(arg CommandArg, value string) (string, error) {
+	switch arg.Kind {
+	case ArgInt:
+		if _, err := strconv.Atoi(value); err != nil {
+			return "", fmt.Errorf("参数 %s 需要是整数，收到 %s", arg.label(), value)
+		}
+	case ArgChoice:
+		value, err := checkChoice(value, arg.Choices)
+		if err != nil {
+			return "", fmt.Errorf("参数 %v", err)
+		}
+		return value, nil
+	}
+	return value, nil
+}
+
+func checkChoice(value string, choices []string) (string, error) {
+	if len(choices) == 0 {
+		return value, nil
+	}
+	for _, choice := range choices {
+		if strings.EqualFold(choice, value) {
+			return choice, nil
+		}
+	}
+	return "", fmt.Errorf("不支持 %s，可选: %s", value, strings.Join(choices, "、"))
+}
+
+// isFlagToken 判断是否为 --name 或 -x，负数不是选项
+func isFlagToken(text string) bool {
+	if strings.HasPrefix(text, "--") {
+		return len(text) > 2
+	}
+	if len(text) == 2 && text[0] == '-' {
+		r := rune(text[1])
+		return r < utf8.RuneSelf && unicode.IsLetter(r)
+	}
+	return false
+}
+
+type token struct {
+	text string
+	// start 在原文中的字节位置，用于取剩余的原始文字
+	start  int
+	quoted bool
+}
+
+var closingQuotes = map[rune]rune{'"': '"', '\'': '\'', '“': '”', '‘': '’'}
+
+// tokenize 按空白拆分参数，支持中英文引号和反斜杠转义
+func tokenize(s string) ([]token, error) {
+	var tokens []token
+	var sb strings.Builder
+	var closing rune
+	inToken, quoted, escaped := false, false, false
+	start := 0
+	for i, r := range s {
+		switch {
+		case escaped:
+			sb.WriteRune(r)
+			escaped = false
+			continue
+		case r == '\\' && i+1 < len(s) && isEscapable(s[i+1:]):
+			escaped = true
+		case closing != 0:
+			if r == closing {
+				closing = 0
+			} else {
+				sb.WriteRune(r)
+			}
+		case unicode.IsSpace(r):
+			if inToken {
+				tokens = append(tokens, token{text: sb.String(), start: start,
+					quoted: quoted})
+				sb.Reset()
+				inToken, quoted = false, false
+			}
+			continue
+		case closingQuotes[r] != 0:
+			closing = closingQuotes[r]
+			quoted = true
+		default:
+			sb.WriteRune(r)
+		}
+		if !inToken {
+			inToken, start = true, i
+		}
+	}
+	if closing != 0 {
+		return nil, errors.New("引号没有闭合")
+	}
+	if inToken {
+		tokens = append(tokens, token{text: sb.String(), start: start, quoted: quoted})
+	}
+	return tokens, nil
+}
+
+// isEscapable 反斜杠只转义引号、空白和反斜杠本身，其余保留原样
+func isEscapable(s string) bool {
+	r, _ := utf8.DecodeRuneInString(s)
+	_, isQuote := closingQuotes[r]
+	return isQuote || r == '”' || r == '’' || r == '\\' || unicode.IsSpace(r)
+}
+
+// commandOf 解析并缓存消息中的指令，不是指令或参数错误时返回 nil
+func commandOf(info *MsgInfo) (*CommandInput, error) {
+	if !info.commandParsed {
+		info.command, info.commandErr = commands.Parse(info.qParsed)
+		info.commandParsed = true
+	}
+	return info.command, info.commandErr
+}
+
+// matchCommand 消息是指定的指令时返回解析结果
+func matchCommand(a *ActionInfo, name string) *CommandInput {
+	in, _ := commandOf(a.info)
+	if in == nil || in.Command.Name != name {
+		return nil
+	}
+	return in
+}
+
+type CommandAction struct { /*指令参数检查*/
+}
+
+// Execute 指令参数错误时回复错误和用法，后续 Action 只需处理解析成功的指令
+func (*CommandAction) Execute(a *ActionInfo) bool {
+	_, err := commandOf(a.info)
+	var cmdErr *CommandError
+	if !errors.As(err, &cmdErr) {
+		return true
+	}
+	replyMsg(*a.ctx, fmt.Sprintf("🤖️：%v\n用法: %s\n回复 */help %s* 查看详细说明",
+		cmdErr.Err, cmdErr.Command.Usage(), cmdErr.Command.Name), a.info.msgId)
+	return false
+}
+
+// isFreeCommand 额度用完后依然可以使用的指令
+func isFreeCommand(info *MsgInfo) bool {
+	in, _ := commandOf(info)
+	return in != nil && in.Command.Free
+}
+
+var commands = newCommandRegistry(builtinCommands())
+
+func newCommandRegistry(list []*Command) *CommandRegistry {
+	registry := &CommandRegistry{}
+	if err := registry.Register(list...); err != nil {
+		panic(err)
+	}
+	return registry
+}
+
+// builtinCommands 内置指令，顺序即帮助卡片中的顺序
+func builtinCommands() []*Command {
+	return []*Command{
+		{Name: "clear", Aliases: []string{"清除"}, Emoji: "🆑", Title: "清除话题上下文",
+			Help: "清除当前话题的历史消息", Free: true},
+		{Name: "ai_mode", Aliases: []string{"发散模式"}, Emoji: "🤖", Title: "发散模式选择",
+			Help: "选择回答的发散程度", Capability: access.CapChat},
+		{Name: "roles", Aliases: []string{"角色列表"}, Emoji: "🛖", Title: "内置角色列表",
+			Help: "按标签浏览内置角色，选择后开启新话题", Capability: access.CapChat},
+		{Name: "system", Aliases: []string{"角色扮演"}, Emoji: "🥷", Title: "角色扮演模式",
+			Help: "设置系统提示词并开启新话题", Capability: access.CapChat,
+			Args: []CommandArg{{Name: "role", Label: "角色信息", Rest: true}}},
+		{Name: "asr", Aliases: []string{"语音设置"}, Emoji: "🎤", Title: "语音转文字设置",
+			Help: "私聊直接发送语音，群聊在 @ 过机器人的话题中发送语音；" +
+				"不带参数查看当前设置，可设置转写模型、语言提示（auto 自动识别），管理员可设置群聊语音开关（on|off）",
+			Capability: access.CapAudio,
+			Args: []CommandArg{
+				{Name: "item", Label: "设置项", Kind: ArgChoice,
+					Choices: []string{"model", "lang", "group"}, Optional: true,
+					AdminChoices: []string{"group"}},
+				{Name: "value", Label: "值", Optional: true},
+			}},
+		{Name: "transcribe", Aliases: []string{"转写"}, Emoji: "📝", Title: "导出转写",
+			Help:       "回复一条语音、音频或视频消息，导出带时间戳和说话人的转写文件",
+			Capability: access.CapAudio,
+			Flags: []CommandFlag{{Name: "format", Short: "f", Label: "格式",
+				Choices: []string{"text", "srt", "vtt", "verbose_json"},
+				Default: "text", Help: "转写文件的格式"}}},
+		{Name: "kb", Aliases: []string{"知识库"}, Emoji: "📚", Title: "知识库",
+			Help:       "查看知识库集合，管理员可重建索引；提问时自动检索并标注来源",
+			Capability: access.CapChat,
+			Args: []CommandArg{
+				{Name: "op", Kind: ArgChoice, Choices: []string{"list", "reindex"},
+					Optional: true, AdminChoices: []string{"reindex"}},
+				{Name: "collection", Label: "集合", Optional: true},
+			}},
+		{Name: "voice", Aliases: []string{"语音回复"}, Emoji: "🔊", Title: "语音回复",
+			Help:       "不带参数选择音色，填写音色直接开启，off 关闭",
+			Capability: access.CapAudio,
+			Args:       []CommandArg{{Name: "voice", Label: "音色|off", Optional: true}}},
+		{Name: "summary", Aliases: []string{"群聊总结"}, Emoji: "🧾", Title: "群聊总结",
+			Help: "总结群里最近的消息，列出决定、待办和待解决的问题；" +
+				"可填写时长（例如 30m、2h、3d，最多 7 天）或条数（例如 100），默认最近 24 小时",
+			Capability: access.CapChat,
+			Args:       []CommandArg{{Name: "range", Label: "时长|条数", Optional: true}}},
+		{Name: "picture", Aliases: []string{"图片创作"}, Emoji: "🎨", Title: "图片创作模式",
+			Help: "开启新话题，根据描述生成或编辑图片", Capability: access.CapPicture},
+		{Name: "vision", Aliases: []string{"图片推理"}, Emoji: "🕵️", Title: "图片推理模式",
+			Help: "开启新话题，发送图片后追问图片内容", Capability: access.CapVision},
+		{Name: "balance", Aliases: []string{"余额"}, Emoji: "🎰", Title: "花费查询",
+			Help: "查看个人和本群的花费，管理员使用 all 查看全局花费", Free: true,
+			Args: []CommandArg{{Name: "scope", Kind: ArgChoice,
+				Choices: []string{"all", "全部"}, Optional: true,
+				AdminChoices: []string{"all", "全部"}}}},
+		{Name: "quota", Aliases: []string{"额度"}, Emoji: "📊", Title: "额度查询",
+			Help: "查看自己的额度；管理员使用 set @用户 每日token/每月token 单独设置额度，" +
+				"reset @用户 恢复默认额度",
+			Args: []CommandArg{
+				{Name: "op", Kind: ArgChoice, Choices: []string{"set", "reset"},
+					Optional: true, AdminChoices: []string{"set", "reset"}},
+				{Name: "target", Label: "@用户 额度", Rest: true, Optional: true},
+			}},
+		{Name: "usage", Aliases: []string{"用量"}, Emoji: "📈", Title: "用量报表",
+			Help: "查看最近几天的用量报表，默认 7 天，追加 csv 导出明细", Admin: true,
+			Args: []CommandArg{
+				{Name: "days", Label: "天数", Optional: true},
+				{Name: "export", Label: "csv", Optional: true},
+			}},
+		{Name: "help", Aliases: []string{"帮助"}, Emoji: "🎒", Title: "需要更多帮助",
+			Help: "查看全部指令，填写指令名查看详细用法", Free: true,
+			Args: []CommandArg{{Name: "command", Label: "指令", Optional: true}}},
+	}
+}
@@ -0,0 +1,249 @@
+package handlers
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"start-feishubot/services/access"
+)
+
+func TestTokenize(t *testing.T) {
+	tests := []struct {
+		input string
+		want  []string
+	}{
+		{"", nil},
+		{"  a  b ", []string{"a", "b"}},
+		{`"hello world" x`, []string{"hello world", "x"}},
+		{`'a "b"' c`, []string{`a "b"`, "c"}},
+		{"“你好 世界” ‘单引号’", []string{"你好 世界", "单引号"}},
+		{`a\ b c\"d`, []string{"a b", `c"d`}},
+		{`C:\path\to "x\"y"`, []string{`C:\path\to`, `x"y`}},
+		{`pre"fix suf"fix`, []string{"prefix suffix"}},
+		{`""`, []string{""}},
+	}
+	for _, tt := range tests {
+		tokens, err := tokenize(tt.input)
+		if err != nil {
+			t.Errorf("tokenize(%q) error: %v", tt.input, err)
+			continue
+		}
+		var got []string
+		for _, tok := range tokens {
+			got = append(got, tok.text)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("tokenize(%q) = %q, want %q", tt.input, got, tt.want)
+		}
+	}
+
+	for _, input := range []string{`"abc`, "“abc", `a 'b`} {
+		if _, err := tokenize(input); err == nil {
+			t.Errorf("expected error for %q", input)
+		}
+	}
+}
+
+func testCommands(t *testing.T) *CommandRegistry {
+	registry := &CommandRegistry{}
+	err := registry.Register(
+		&Command{Name: "clear", Aliases: []string{"清除"}},
+		&Command{Name: "system", Aliases: []string{"角色扮演"},
+			Args: []CommandArg{{Name: "role", Rest: true}}},
+		&Command{Name: "export", Aliases: []string{"导出"},
+			Args: []CommandArg{
+				{Name: "count", Kind: ArgInt},
+				{Name: "scope", Kind: ArgChoice, Choices: []string{"all", "mine"},
+					Optional: true},
+			},
+			Flags: []CommandFlag{
+				{Name: "format", Short: "f", Choices: []string{"text", "srt"},
+					Default: "text"},
+				{Name: "quiet", Short: "q", Bool: true},
+			}},
+		&Command{Name: "help", Aliases: []string{"帮助"},
+			Args: []CommandArg{{Name: "command", Optional: true}}},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return registry
+}
+
+func TestCommandParse(t *testing.T) {
+	registry := testCommands(t)
+	tests := []struct {
+		input   string
+		command string
+		args    map[string]string
+		flags   map[string]string
+	}{
+		{"/clear", "clear", nil, nil},
+		{"/CLEAR ", "clear", nil, nil},
+		{"清除", "clear", nil, nil},
+		{"/system  你是一个 \"翻译\" --助手", "system",
+			map[string]string{"role": "你是一个 \"翻译\" --助手"}, nil},
+		{"角色扮演 翻译", "system", map[string]string{"role": "翻译"}, nil},
+		{"/export 3", "export", map[string]string{"count": "3"},
+			map[string]string{"format": "text"}},
+		{"/export 3 ALL --format=srt -q", "export",
+			map[string]string{"count": "3", "scope": "all"},
+			map[string]string{"format": "srt", "quiet": "true"}},
+		{"/export -f SRT 3", "export", map[string]string{"count": "3"},
+			map[string]string{"format": "srt"}},
+		{"/export -- -3", "export", map[string]string{"count": "-3"}, nil},
+		{"/export -3", "export", map[string]string{"count": "-3"}, nil},
+		{"/help /export", "help", map[string]string{"command": "/export"}, nil},
+		{"/clearall", "", nil, nil},
+		{"清除缓存", "", nil, nil},
+		{"你好", "", nil, nil},
+		// 中文别名后的参数无法解析时是普通对话
+		{"帮助 我 写一首诗", "", nil, nil},
+		{"清除 以前的内容是什么意思", "", nil, nil},
+	}
+	for _, tt := range tests {
+		in, err := registry.Parse(tt.input)
+		if err != nil {
+			t.Errorf("Parse(%q) error: %v", tt.input, err)
+			continue
+		}
+		if tt.command == "" {
+			if in != nil {
+				t.Errorf("Parse(%q) = %s, want not a command", tt.input, in.Command.Name)
+			}
+			continue
+		}
+		if in == nil || in.Command.Name != tt.command {
+			t.Errorf("Parse(%q) = %v, want %s", tt.input, in, tt.command)
+			continue
+		}
+		for name, want := range tt.args {
+			if got := in.Arg(name); got != want {
+				t.Errorf("Parse(%q).Arg(%s) = %q, want %q", tt.input, name, got, want)
+			}
+		}
+		for name, want := range tt.flags {
+			if got := in.Flag(name); got != want {
+				t.Errorf("Parse(%q).Flag(%s) = %q, want %q", tt.input, name, got, want)
+			}
+		}
+	}
+}
+
+func TestCommandParseErrors(t *testing.T) {
+	registry := testCommands(t)
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"/clear now", "多余的参数 now"},
+		{"/system", "缺少参数 role"},
+		{"/export", "缺少参数 count"},
+		{"/export x", "参数 count 需要是整数，收到 x"},
+		{"/export 3 some", "参数 不支持 some，可选: all、mine"},
+		{"/export 3 --format", "选项 --format 需要指定text|srt"},
+		{"/export 3 --format vtt", "选项 --format 不支持 vtt，可选: text、srt"},
+		{"/export 3 --verbose", "未知的选项 --verbose"},
+		{"/export 3 --quiet=1", "选项 --quiet 不需要值"},
+		{`/export "3`, "引号没有闭合"},
+	}
+	for _, tt := range tests {
+		in, err := registry.Parse(tt.input)
+		var cmdErr *CommandError
+		if !errors.As(err, &cmdErr) {
+			t.Errorf("Parse(%q) = %v, %v, want CommandError", tt.input, in, err)
+			continue
+		}
+		if cmdErr.Error() != tt.want {
+			t.Errorf("Parse(%q) error = %q, want %q", tt.input, cmdErr.Error(), tt.want)
+		}
+	}
+}
+
+func TestCommandRegister(t *testing.T) {
+	registry := testCommands(t)
+	bad := []*Command{
+		{Name: "Clear"},
+		{Name: "reset", Aliases: []string{"清除"}},
+		{Name: "rest", Args: []CommandArg{{Name: "a", Rest: true}, {Name: "b"}}},
+		{Name: "opt", Args: []CommandArg{{Name: "a", Optional: true}, {Name: "b"}}},
+	}
+	for _, c := range bad {
+		if err := registry.Register(c); err == nil {
+			t.Errorf("expected error registering %s", c.Name)
+		}
+	}
+	if c := registry.Lookup("/help"); c == nil || c.Name != "help" {
+		t.Errorf("Lookup(/help) = %v", c)
+	}
+	if c := registry.Lookup("导出"); c == nil || c.Name != "export" {
+		t.Errorf("Lookup(导出) = %v", c)
+	}
+	if c := registry.Lookup("/导出"); c != nil {
+		t.Errorf("Lookup(/导出) = %s, want nil", c.Name)
+	}
+}
+
+func TestCommandUsage(t *testing.T) {
+	registry := testCommands(t)
+	tests := map[string]string{
+		"clear":  "/clear",
+		"system": "/system <role...>",
+		"export": "/export <count> [all|mine] [--format text|srt] [--quiet]",
+	}
+	for name, want := range tests {
+		if got := registry.Lookup(name).Usage(); got != want {
+			t.Errorf("Usage(%s) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestBuiltinCommands(t *testing.T) {
+	for _, input := range []string{"/help", "帮助", "/clear", "/balance all", "余额 全部"} {
+		in, err := commands.Parse(input)
+		if err != nil || in == nil || !in.Command.Free {
+			t.Errorf("%q should be a free command", input)
+		}
+	}
+	in, err := commands.Parse("转写 -f srt")
+	if err != nil || in == nil || in.Flag("format") != "srt" {
+		t.Errorf("Parse(转写 -f srt) = %v, %v", in, err)
+	}
+	in, err = commands.Parse("/quota set @小明 100000/3000000")
+	if err != nil || in == nil || in.Arg("op") != "set" ||
+		in.Arg("target") != "@小明 100000/3000000" {
+		t.Errorf("Parse(/quota set) = %v, %v", in, err)
+	}
+}
+
+func TestCommandCapability(t *testing.T) {
+	tests := map[string]access.Capability{
+		"/help":          "",
+		"/balance":       "",
+		"/balance all":   access.CapAdmin,
+		"余额 全部":          access.CapAdmin,
+		"/kb":            access.CapChat,
+		"/kb reindex":    access.CapAdmin,
+		"/quota":         "",
+		"/quota reset x": access.CapAdmin,
+		"/usage 30d":     access.CapAdmin,
+		"/picture":       access.CapPicture,
+		"图片推理":           access.CapVision,
+		"/voice off":     access.CapAudio,
+		"/asr lang zh":   access.CapAudio,
+		"/asr group on":  access.CapAdmin,
+		"/transcribe":    access.CapAudio,
+		"/system 翻译":     access.CapChat,
+	}
+	for input, want := range tests {
+		in, err := commands.Parse(input)
+		if err != nil || in == nil {
+			t.Errorf("Parse(%q) = %v, %v", input, in, err)
+			continue
+		}
+		if got := in.Capability(); got != want {
+			t.Errorf("Capability(%q) = %q, want %q", input, got, want)
+		}
+	}
+}
@@ -10,74 +10,20 @@ import (
 
 // func sendCard
 func msgFilter(msg string) string {
-	//replace @到下一个非空的字段 为 ''
-	regex := regexp.MustCompile(`@[^ ]*`)
-	return regex.ReplaceAllString(msg, "")
+	// 去掉没有替换成姓名的 @ 占位符，例如 @_user_1、@_all
+	return mentionKeyRegex.ReplaceAllString(msg, "")
 }
 
-// Parse rich text json to text
-func parsePostContent(content string) string {
-	var contentMap map[string]interface{}
-	err := json.Unmarshal([]byte(content), &contentMap)
-
-	if err != nil {
-		fmt.Println(err)
-	}
-
-	if contentMap["content"] == nil {
-		return ""
-	}
-	var text string
-	// deal with title
-	if contentMap["title"] != nil && contentMap["title"] != "" {
-		text += contentMap["title"].(string) + "\n"
-	}
-	// deal with content
-	contentList := contentMap["content"].([]interface{})
-	for _, v := range contentList {
-		for _, v1 := range v.([]interface{}) {
-			if v1.(map[string]interface{})["tag"] == "text" {
-				text += v1.(map[string]interface{})["text"].(string)
-			}
-		}
-		// add new line
-		text += "\n"
-	}
-	return msgFilter(text)
-}
-
-func parsePostImageKeys(content string) []string {
-	var contentMap map[string]interface{}
-	err := json.Unmarshal([]byte(content), &contentMap)
-
-	if err != nil {
-		fmt.Println(err)
-		return nil
-	}
-
-	var imageKeys []string
-
-	if contentMap["content"] == nil {
-		return imageKeys
-	}
-
-	contentList := contentMap["content"].([]interface{})
-	for _, v := range contentList {
-		for _, v1 := range v.([]interface{}) {
-			if v1.(map[string]interface{})["tag"] == "img" {
-				imageKeys = append(imageKeys, v1.(map[string]interface{})["image_key"].(string))
-			}
-		}
-	}
-
-	return imageKeys
-}
+var mentionKeyRegex = regexp.MustCompile(`@_user_\d+|@_all`)
 
 func parseContent(content, msgType string) string {
 	//"{\"text\":\"@_user_1  hahaha\"}",
 	//only get text content hahaha
-	if msgType == "post" {
+	switch msgType {
+	case "post":
 		return parsePostContent(content)
+	case "interactive":
+		return parseInteractiveContent(content)
 	}
 
 	var contentMap map[string]interface{}
@@ -88,8 +34,8 @@ func parseContent(content, msgType string) string {
 	if contentMap["text"] == nil {
 		return ""
 	}
-	text := contentMap["text"].(string)
-	return msgFilter(text)
+	// 文本消息保留 @ 占位符，由 renderMentions 替换成姓名
+	return contentMap["text"].(string)
 }
 
 func processMessage(msg interface{}) (string, error) {
@@ -148,6 +94,20 @@ func parseFileKey(content string) string {
 	return fileKey
 }
 
+func parseFileName(content string) string {
+	var contentMap map[string]interface{}
+	err := json.Unmarshal([]byte(content), &contentMap)
+	if err != nil {
+		fmt.Println(err)
+		return ""
+	}
+	if contentMap["file_name"] == nil {
+		return ""
+	}
+	fileName := contentMap["file_name"].(string)
+	return fileName
+}
+
 func parseImageKey(content string) string {
 	var contentMap map[string]interface{}
 	err := json.Unmarshal([]byte(content), &contentMap)
@@ -161,3 +121,99 @@ func parseImageKey(content string) string {
 	imageKey := contentMap["image_key"].(string)
 	return imageKey
 }
+
+// parseShareId 解析 share_chat 消息中的群 id 或 share_user 消息中的用户 id
+func parseShareId(content string) string {
+	var contentMap map[string]interface{}
+	if err := json.Unmarshal([]byte(content), &contentMap); err != nil {
+		return ""
+	}
+	for _, key := range []string{"chat_id", "user_id"} {
+		if id, ok := contentMap[key].(string); ok {
+			return id
+		}
+	}
+	return ""
+}
+
+// parseInteractiveContent 提取卡片消息中的文字。接收到的卡片是简化后的结构，
+// title 加上按段落分组的 elements；同时兼容原始的卡片 JSON
+func parseInteractiveContent(content string) string {
+	var card interface{}
+	if err := json.Unmarshal([]byte(content), &card); err != nil {
+		return ""
+	}
+	var b strings.Builder
+	walkCardElement(&b, card)
+	var lines []string
+	for _, line := range strings.Split(b.String(), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// cardChildKeys 卡片元素中可能包含子元素的字段，按显示顺序排列
+var cardChildKeys = []string{"header", "title", "elements", "fields", "columns",
+	"actions", "extra", "i18n_elements", "zh_cn", "en_us", "ja_jp"}
+
+func walkCardElement(b *strings.Builder, v interface{}) {
+	switch v := v.(type) {
+	case string:
+		b.WriteString(v + "\n")
+	case []interface{}:
+		// 简化结构中 elements 的每一项是一个段落
+		for _, item := range v {
+			if paragraph, ok := item.([]interface{}); ok {
+				for _, inline := range paragraph {
+					writeCardInline(b, inline)
+				}
+				b.WriteString("\n")
+				continue
+			}
+			walkCardElement(b, item)
+		}
+	case map[string]interface{}:
+		if tag, _ := v["tag"].(string); tag != "" && writeCardInline(b, v) {
+			b.WriteString("\n")
+		}
+		for _, key := range cardChildKeys {
+			if child, ok := v[key]; ok {
+				walkCardElement(b, child)
+			}
+		}
+		// div、按钮等元素的文字在 text 字段中
+		if text, ok := v["text"].(map[string]interface{}); ok {
+			walkCardElement(b, text)
+		}
+	}
+}
+
+// writeCardInline 输出一个行内元素的文字，没有文字时返回 false
+func writeCardInline(b *strings.Builder, v interface{}) bool {
+	element, ok := v.(map[string]interface{})
+	if !ok {
+		return false
+	}
+	text, _ := element["text"].(string)
+	if text == "" {
+		text, _ = element["content"].(string)
+	}
+	switch element["tag"] {
+	case "text", "plain_text", "lark_md", "markdown", "md":
+	case "a":
+		if href, _ := element["href"].(string); href != "" {
+			text = fmt.Sprintf("[%s](%s)", text, href)
+		}
+	case "at":
+		name, _ := element["user_name"].(string)
+		text = "@" + name
+	case "img", "image":
+		text = "[图片]"
+	default:
+		return false
+	}
+	b.WriteString(text)
+	return text != ""
+}
@@ -0,0 +1,43 @@
+package handlers
+
+import "testing"
+
+func TestParseInteractiveContent(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{"simplified", `{"title":"上线通知","elements":[` +
+			`[{"tag":"text","text":"版本 "},{"tag":"a","text":"v1.2","href":"https://x.com"}],` +
+			`[{"tag":"at","user_name":"小明"},{"tag":"text","text":" 请确认"}],` +
+			`[{"tag":"img","image_key":"img_1"}]]}`,
+			"上线通知\n版本 [v1.2](https://x.com)\n@小明 请确认\n[图片]"},
+		{"raw card", `{"header":{"title":{"tag":"plain_text","content":"日报"}},"elements":[` +
+			`{"tag":"div","text":{"tag":"lark_md","content":"**完成** 3 项"}},` +
+			`{"tag":"hr"},` +
+			`{"tag":"note","elements":[{"tag":"plain_text","content":"自动生成"}]},` +
+			`{"tag":"action","actions":[{"tag":"button","text":{"tag":"plain_text","content":"查看"}}]}]}`,
+			"日报\n**完成** 3 项\n自动生成\n查看"},
+		{"invalid", `{"title":`, ""},
+	}
+	for _, tt := range tests {
+		if got := parseInteractiveContent(tt.content); got != tt.want {
+			t.Errorf("%s: parseInteractiveContent = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestParseShareId(t *testing.T) {
+	tests := map[string]string{
+		`{"chat_id":"oc_1"}`: "oc_1",
+		`{"user_id":"ou_1"}`: "ou_1",
+		`{"text":"hi"}`:      "",
+		`not json`:           "",
+	}
+	for content, want := range tests {
+		if got := parseShareId(content); got != want {
+			t.Errorf("parseShareId(%s) = %q, want %q", content, got, want)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package handlers
+
+import (
+	"errors"
+
+	"start-feishubot/initialization"
+	"start-feishubot/logger"
+	"start-feishubot/services/workerpool"
+)
+
+// dispatch 在接收事件时执行去重、@ 判断等快速过滤，通过后把其余 Action 交给工作池，
+// 飞书的事件请求不需要等待模型调用完成，避免超时重推
+func (m MessageHandler) dispatch(a *ActionInfo) {
+	path, next := m.actions.RunInline(a)
+	if !next {
+		logActionPath(a, path)
+		return
+	}
+	position, err := m.pool.Submit(*a.info.chatId, func() {
+		logActionPath(a, append(path, m.actions.RunQueued(a)...))
+	})
+	if err != nil {
+		active, waiting := m.pool.Stats()
+		logger.Warnf("reject message %s in chat %s: %v (active %d, waiting %d)",
+			*a.info.msgId, *a.info.chatId, err, active, waiting)
+		go sendBusyCard(*a.ctx, a.info.msgId, errors.Is(err, workerpool.ErrKeyFull))
+		return
+	}
+	if position > 0 {
+		logger.Debugf("message %s queued at position %d", *a.info.msgId, position)
+		go sendQueuedCard(*a.ctx, a.info.msgId, position)
+	}
+}
+
+// close 等待工作池中已提交的消息处理完毕，之后提交的消息会被拒绝
+func (m MessageHandler) close() {
+	m.pool.Close()
+}
+
+func newWorkerPool(config initialization.Config) *workerpool.Pool {
+	return workerpool.New(workerpool.Config{
+		Workers:      config.WorkerConcurrency,
+		QueueSize:    config.WorkerQueueSize,
+		KeyQueueSize: config.WorkerChatQueueSize,
+	})
+}
@@ -0,0 +1,144 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+
+	"start-feishubot/initialization"
+	"start-feishubot/logger"
+	"start-feishubot/services"
+	"start-feishubot/services/access"
+)
+
+type AccessAction struct { /*访问控制*/
+}
+
+// Execute 按消息对应的功能统一检查权限，各 Action 不再单独判断
+func (*AccessAction) Execute(a *ActionInfo) bool {
+	capability := messageCapability(a)
+	if capability == "" {
+		return true
+	}
+	decision := a.handler.access.Check(capability, accessSubject(a))
+	if decision.Allowed {
+		return true
+	}
+	logger.Warnf("access denied, user: %s, chat: %s, capability: %s, reason: %s",
+		a.info.userId, *a.info.chatId, capability, decision.Reason)
+	go sendAccessDeniedCard(*a.ctx, a.info.msgId, capability)
+	return false
+}
+
+// messageCapability 消息需要的功能，帮助、清除、余额等基础指令不需要授权
+func messageCapability(a *ActionInfo) access.Capability {
+	if in, _ := commandOf(a.info); in != nil {
+		return in.Capability()
+	}
+	mode := a.handler.sessionCache.GetMode(*a.info.sessionId)
+	switch a.info.msgType {
+	case "audio", "media":
+		return access.CapAudio
+	case "file":
+		if isMediaMessage(a.info) {
+			return access.CapAudio
+		}
+	case "image":
+		if mode == services.ModePicCreate {
+			return access.CapPicture
+		}
+		return access.CapVision
+	}
+	switch mode {
+	case services.ModePicCreate:
+		return access.CapPicture
+	case services.ModeVision:
+		return access.CapVision
+	}
+	return access.CapChat
+}
+
+// cardCapabilities 卡片按钮对应的功能
+var cardCapabilities = map[CardKind]access.Capability{
+	PicModeChangeKind:    access.CapPicture,
+	PicResolutionKind:    access.CapPicture,
+	PicStyleKind:         access.CapPicture,
+	PicModelKind:         access.CapPicture,
+	PicCountKind:         access.CapPicture,
+	PicEditKind:          access.CapPicture,
+	PicReferenceKind:     access.CapPicture,
+	PicTextMoreKind:      access.CapPicture,
+	PicVarMoreKind:       access.CapPicture,
+	VisionModeChangeKind: access.CapVision,
+	VisionStyleKind:      access.CapVision,
+	VoiceChooseKind:      access.CapAudio,
+	RoleTagsChooseKind:   access.CapChat,
+	RoleChooseKind:       access.CapChat,
+	AIModeChooseKind:     access.CapChat,
+}
+
+// checkCardAccess 检查卡片按钮的权限，会话为发出卡片时写入按钮的会话
+func (m MessageHandler) checkCardAccess(ctx context.Context, cardMsg CardMsg,
+	openId string, msgId string) bool {
+	capability, allowed := m.cardAccess(cardMsg, openId)
+	if allowed {
+		return true
+	}
+	logger.Warnf("card access denied, user: %s, chat: %s, kind: %s", openId,
+		cardMsg.ChatId, cardMsg.Kind)
+	sendAccessDeniedCard(ctx, &msgId, capability)
+	return false
+}
+
+// cardAccess 卡片按钮需要的功能和是否允许，不需要授权的按钮 capability 为空
+func (m MessageHandler) cardAccess(cardMsg CardMsg, openId string) (
+	capability access.Capability, allowed bool) {
+	capability, ok := cardCapabilities[cardMsg.Kind]
+	if !ok {
+		return "", true
+	}
+	return capability,
+		m.access.Check(capability, m.subjectOf(openId, cardMsg.ChatId)).Allowed
+}
+
+func accessSubject(a *ActionInfo) access.Subject {
+	return a.handler.subjectOf(a.info.userId, *a.info.chatId)
+}
+
+// subjectOf 规则中有部门时查询用户所属部门，查询失败时记为未知，由策略按禁止处理
+func (m MessageHandler) subjectOf(userId, chatId string) access.Subject {
+	subject := access.Subject{UserId: userId, ChatId: chatId}
+	if m.access.NeedsDepartments() {
+		departments, ok := userDepartments(userId)
+		subject.Departments = departments
+		subject.DepartmentsUnknown = !ok
+	}
+	return subject
+}
+
+// isAdmin 用户是否为管理员，管理员不受额度和频率限制
+func isAdmin(a *ActionInfo) bool {
+	return a.handler.access.IsAdmin(accessSubject(a))
+}
+
+func newAccessPolicy(config initialization.Config) *access.Policy {
+	policy, err := parseAccessPolicy(config)
+	if err != nil {
+		// 名单无法解析时宁可拒绝启动，避免误放行
+		logger.Fatalf("%v", err)
+	}
+	return policy
+}
+
+// parseAccessPolicy 解析允许和禁止名单。允许名单解析失败时不能忽略，
+// 否则等同于允许所有人
+func parseAccessPolicy(config initialization.Config) (*access.Policy, error) {
+	allow, err := access.ParseRules(config.AccessAllow)
+	if err != nil {
+		return nil, fmt.Errorf("parse ACCESS_ALLOW failed: %w", err)
+	}
+	deny, err := access.ParseRules(config.AccessDeny)
+	if err != nil {
+		return nil, fmt.Errorf("parse ACCESS_DENY failed: %w", err)
+	}
+	return access.NewPolicy(config.AdminOpenIds, allow, deny), nil
+}
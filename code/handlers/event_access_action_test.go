@@ -0,0 +1,63 @@
+package handlers
+
+import (
+	"testing"
+
+	"start-feishubot/initialization"
+	"start-feishubot/services/access"
+)
+
+func TestParseAccessPolicy(t *testing.T) {
+	tests := []struct {
+		allow []string
+		deny  []string
+		err   bool
+	}{
+		{nil, nil, false},
+		{[]string{"picture=ou_a"}, []string{"audio=oc_b"}, false},
+		// 允许名单无法解析时不能当作没有配置，否则会允许所有人
+		{[]string{"picture=xyz"}, nil, true},
+		{[]string{"image=ou_a"}, nil, true},
+		{nil, []string{"audio"}, true},
+	}
+	for _, tt := range tests {
+		policy, err := parseAccessPolicy(initialization.Config{
+			AccessAllow: tt.allow, AccessDeny: tt.deny})
+		if (err != nil) != tt.err || (policy == nil) != tt.err {
+			t.Errorf("parseAccessPolicy(%q, %q) = %v, %v", tt.allow, tt.deny,
+				policy, err)
+		}
+	}
+}
+
+func TestCardAccess(t *testing.T) {
+	allow, _ := access.ParseRules([]string{"picture=oc_design"})
+	deny, _ := access.ParseRules([]string{"audio=oc_quiet"})
+	m := MessageHandler{access: access.NewPolicy([]string{"ou_root"}, allow, deny)}
+	tests := []struct {
+		kind       CardKind
+		openId     string
+		chatId     string
+		capability access.Capability
+		allowed    bool
+	}{
+		// 按发出卡片的会话判断
+		{VoiceChooseKind, "ou_a", "oc_quiet", access.CapAudio, false},
+		{VoiceChooseKind, "ou_a", "oc_other", access.CapAudio, true},
+		{PicVarMoreKind, "ou_a", "oc_design", access.CapPicture, true},
+		{PicVarMoreKind, "ou_a", "oc_other", access.CapPicture, false},
+		// 旧卡片没有会话，只能按用户判断
+		{PicTextMoreKind, "ou_a", "", access.CapPicture, false},
+		{VoiceChooseKind, "ou_root", "oc_quiet", access.CapAudio, true},
+		// 不需要授权的按钮
+		{ClearCardKind, "ou_a", "oc_quiet", "", true},
+	}
+	for _, tt := range tests {
+		capability, allowed := m.cardAccess(CardMsg{Kind: tt.kind, ChatId: tt.chatId},
+			tt.openId)
+		if capability != tt.capability || allowed != tt.allowed {
+			t.Errorf("cardAccess(%s, %s, %s) = %s, %v", tt.kind, tt.openId, tt.chatId,
+				capability, allowed)
+		}
+	}
+}
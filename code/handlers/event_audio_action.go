@@ -1,16 +1,38 @@
 package handlers
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
-	"os"
+	"strings"
+	"sync"
+	"time"
 
 	"start-feishubot/initialization"
+	"start-feishubot/logger"
+	"start-feishubot/services"
+	"start-feishubot/services/openai"
+	"start-feishubot/services/usage"
+	"start-feishubot/utils"
 	"start-feishubot/utils/audio"
+	"start-feishubot/utils/transcript"
 
 	larkim "github.com/larksuite/oapi-sdk-go/v3/service/im/v1"
 )
 
+const (
+	// 转写前统一转换为 16kHz 单声道，每分钟约 1.9MB，
+	// 按 10 分钟切分远低于接口 25MB 的限制
+	transcribeSampleRate  = 16000
+	transcribeSegment     = 10 * time.Minute
+	transcribeOverlap     = 3 * time.Second
+	transcribeConcurrency = 4
+	// 拼接相邻两段转写结果时查找重复内容的范围
+	transcribeMergeWindow = 80
+	transcribeMergeMin    = 4
+)
+
 type AudioAction struct { /*语音*/
 }
 
@@ -20,41 +42,14 @@ func (*AudioAction) Execute(a *ActionInfo) bool {
 		return true
 	}
 
-	// 只有私聊才解析语音,其他不解析
-	if a.info.handlerType != UserHandler {
-		return true
-	}
-
+	// 群聊语音是否处理已由 ProcessMentionAction 和 ThreadMentionAction 判断
 	//判断是否是语音
 	if a.info.msgType == "audio" {
-		fileKey := a.info.fileKey
-		//fmt.Printf("fileKey: %s \n", fileKey)
-		msgId := a.info.msgId
-		//fmt.Println("msgId: ", *msgId)
-		req := larkim.NewGetMessageResourceReqBuilder().MessageId(
-			*msgId).FileKey(fileKey).Type("file").Build()
-		resp, err := initialization.GetLarkClient().Im.MessageResource.Get(context.Background(), req)
-		//fmt.Println(resp, err)
-		if err != nil {
-			fmt.Println(err)
-			return true
-		}
-		f := fmt.Sprintf("%s.ogg", fileKey)
-		resp.WriteFile(f)
-		defer os.Remove(f)
-
-		//fmt.Println("f: ", f)
-		output := fmt.Sprintf("%s.mp3", fileKey)
-		// 等待转换完成
-		audio.OggToWavByPath(f, output)
-		defer os.Remove(output)
-		//fmt.Println("output: ", output)
-
-		text, err := a.handler.gpt.AudioToText(output)
+		text, err := transcribeVoice(a)
 		if err != nil {
-			fmt.Println(err)
-
-			sendMsg(*a.ctx, fmt.Sprintf("🤖️：语音转换失败，请稍后再试～\n错误信息: %v", err), a.info.msgId)
+			logger.Warnf("transcribe voice failed, msg: %s, chat: %s: %v",
+				*a.info.msgId, *a.info.chatId, err)
+			sendMsg(*a.ctx, fmt.Sprintf("🤖️：语音转换失败，请稍后再试～\n错误信息: %v", err), a.info.chatId)
 			return false
 		}
 
@@ -67,3 +62,300 @@ func (*AudioAction) Execute(a *ActionInfo) bool {
 	return true
 
 }
+
+// transcribeVoice 下载飞书语音并在内存中转换为 WAV 后转写为文字
+func transcribeVoice(a *ActionInfo) (string, error) {
+	req := larkim.NewGetMessageResourceReqBuilder().MessageId(
+		*a.info.msgId).FileKey(a.info.fileKey).Type("file").Build()
+	resp, err := initialization.GetLarkClient().Im.MessageResource.Get(context.Background(), req)
+	if err != nil {
+		return "", err
+	}
+	if !resp.Success() {
+		return "", errors.New(resp.Msg)
+	}
+
+	voice, err := audio.DecodeOgg(resp.File)
+	if err != nil {
+		return "", err
+	}
+	text, tokenUsage, err := transcribeAudio(a.handler.gpt, voice, a.info.fileKey,
+		transcribeOptions(a.handler.chatSettings.Get(*a.info.chatId)))
+	recordTranscribeUsage(a, tokenUsage)
+	return text, err
+}
+
+// recordTranscribeUsage 记录转写的音频时长，部分片段失败时已完成的片段同样计费
+func recordTranscribeUsage(a *ActionInfo, tokenUsage openai.Usage) {
+	if tokenUsage.Seconds == 0 {
+		return
+	}
+	recordUsage(a, usage.KindAudio, tokenUsage)
+}
+
+func transcribeOptions(setting services.ChatSetting) openai.TranscribeOptions {
+	return openai.TranscribeOptions{
+		Model:    setting.TranscribeModel,
+		Language: setting.TranscribeLanguage,
+	}
+}
+
+// transcribeAudio 转写任意长度的音频，超过单段长度时切分为有重叠的片段并发转写后拼接，
+// 返回所有转写成功的片段的用量
+func transcribeAudio(gpt *openai.ChatGPT, a *audio.Audio, name string,
+	opts openai.TranscribeOptions) (string, openai.Usage, error) {
+	segments := splitForTranscribe(a)
+	texts := make([]string, len(segments))
+	usages := make([]openai.Usage, len(segments))
+	err := forEachSegment(segments, func(i int, wav []byte) error {
+		var err error
+		texts[i], usages[i], err = gpt.Transcribe(fmt.Sprintf("%s_%d.wav", name, i),
+			bytes.NewReader(wav), opts)
+		if err == nil {
+			usages[i] = segmentUsage(usages[i], segments[i])
+		}
+		return err
+	})
+	if err != nil {
+		return "", sumTranscribeUsage(usages), err
+	}
+
+	var text string
+	for _, t := range texts {
+		text = utils.MergeOverlap(text, strings.TrimSpace(t),
+			transcribeMergeWindow, transcribeMergeMin)
+	}
+	return text, sumTranscribeUsage(usages), nil
+}
+
+// transcribeAudioSegments 与 transcribeAudio 相同，但保留每句的时间戳
+func transcribeAudioSegments(gpt *openai.ChatGPT, a *audio.Audio, name string,
+	opts openai.TranscribeOptions) ([]transcript.Segment, openai.Usage, error) {
+	segments := splitForTranscribe(a)
+	results := make([][]transcript.Segment, len(segments))
+	usages := make([]openai.Usage, len(segments))
+	err := forEachSegment(segments, func(i int, wav []byte) error {
+		verbose, tokenUsage, err := gpt.TranscribeVerbose(
+			fmt.Sprintf("%s_%d.wav", name, i), bytes.NewReader(wav), opts)
+		if err != nil {
+			return err
+		}
+		usages[i] = segmentUsage(tokenUsage, segments[i])
+		results[i] = toTranscriptSegments(verbose, segments[i].Start)
+		return nil
+	})
+	if err != nil {
+		return nil, sumTranscribeUsage(usages), err
+	}
+
+	var merged []transcript.Segment
+	for _, r := range results {
+		merged = transcript.Merge(merged, r)
+	}
+	return merged, sumTranscribeUsage(usages), nil
+}
+
+// segmentUsage 接口没有返回时长时按上传片段的长度计费
+func segmentUsage(tokenUsage openai.Usage, segment audio.Segment) openai.Usage {
+	if tokenUsage.Seconds == 0 {
+		tokenUsage.Seconds = segment.Audio.Duration().Seconds()
+	}
+	return tokenUsage
+}
+
+// sumTranscribeUsage 合并各片段的用量，失败的片段没有用量
+func sumTranscribeUsage(usages []openai.Usage) openai.Usage {
+	var total openai.Usage
+	for _, u := range usages {
+		if u.Model != "" {
+			total.Model = u.Model
+		}
+		total.Seconds += u.Seconds
+	}
+	return total
+}
+
+func toTranscriptSegments(verbose *openai.Transcription,
+	offset time.Duration) []transcript.Segment {
+	var segments []transcript.Segment
+	for _, s := range verbose.Segments {
+		segments = append(segments, transcript.Segment{
+			Start: offset + secondsToDuration(s.Start),
+			End:   offset + secondsToDuration(s.End),
+			Text:  s.Text,
+		})
+	}
+	return segments
+}
+
+func secondsToDuration(seconds float64) time.Duration {
+	return time.Duration(seconds * float64(time.Second))
+}
+
+func splitForTranscribe(a *audio.Audio) []audio.Segment {
+	// whisper 内部使用 16kHz 单声道，提前降采样可以减小上传体积
+	a = a.Mono().Resample(transcribeSampleRate)
+	return audio.Split(a, transcribeSegment, transcribeOverlap)
+}
+
+// forEachSegment 将每个片段编码为 WAV 后并发调用 fn，最多同时处理 transcribeConcurrency 个片段
+func forEachSegment(segments []audio.Segment,
+	fn func(i int, wav []byte) error) error {
+	errs := make([]error, len(segments))
+	sem := make(chan struct{}, transcribeConcurrency)
+	var wg sync.WaitGroup
+	for i, segment := range segments {
+		wg.Add(1)
+		go func(i int, segment audio.Segment) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			wav, err := audio.EncodeWav(segment.Audio)
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			errs[i] = fn(i, wav)
+		}(i, segment)
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			return fmt.Errorf("transcribing segment at %s: %w",
+				segments[i].Start, err)
+		}
+	}
+	return nil
+}
+
+// cachedThreadMention 只根据本地缓存判断消息是否回复在 @ 过机器人的话题中，
+// known 为 false 时需要查询话题的根消息
+func cachedThreadMention(a *ActionInfo) (mentioned bool, known bool) {
+	rootId := *a.info.sessionId
+	if rootId == *a.info.msgId {
+		return false, true
+	}
+	// 话题中已有上下文，说明机器人参与过该话题
+	if len(a.handler.sessionCache.GetMsg(rootId)) > 0 {
+		return true, true
+	}
+	if mentioned, ok := rootMentions.Load(rootId); ok {
+		return mentioned.(bool), true
+	}
+	return false, false
+}
+
+var rootMentions sync.Map
+
+// messageMentionsMe 查询消息是否 @ 了机器人，结果按消息缓存
+func (m MessageHandler) messageMentionsMe(msgId string) bool {
+	if mentioned, ok := rootMentions.Load(msgId); ok {
+		return mentioned.(bool)
+	}
+	msg, err := getMessage(msgId)
+	if err != nil {
+		return false
+	}
+	mentioned := false
+	bot := m.bot()
+	for _, user := range messageMentions(msg.Mentions) {
+		if bot.is(user.OpenId, user.Name) {
+			mentioned = true
+			break
+		}
+	}
+	rootMentions.Store(msgId, mentioned)
+	return mentioned
+}
+
+type AsrSettingAction struct { /*语音转文字设置*/
+}
+
+// Execute 处理按会话的语音转文字设置:
+//
+//	/asr                 查看当前设置
+//	/asr model 模型名     切换转写模型
+//	/asr lang zh         设置语言提示，auto 为自动识别
+//	/asr group on|off    管理员设置群聊中是否处理未 @ 机器人的语音
+func (*AsrSettingAction) Execute(a *ActionInfo) bool {
+	in := matchCommand(a, "asr")
+	if in == nil {
+		return true
+	}
+	setting := a.handler.chatSettings.Get(*a.info.chatId)
+	item := in.Arg("item")
+	if item == "" {
+		replyMsg(*a.ctx, formatTranscribeSetting(setting,
+			a.info.handlerType == GroupHandler), a.info.msgId)
+		return false
+	}
+	value := strings.ToLower(in.Arg("value"))
+	if value == "" {
+		replyMsg(*a.ctx, "🤖️：用法 */asr model 模型名*、*/asr lang 语言* 或 */asr group on|off*",
+			a.info.msgId)
+		return false
+	}
+
+	switch item {
+	case "model":
+		if !openai.IsValidTranscribeModel(value) {
+			replyMsg(*a.ctx, fmt.Sprintf("🤖️：不支持的模型 %s，可选: %s", value,
+				strings.Join(openai.TranscribeModels, ", ")), a.info.msgId)
+			return false
+		}
+		setting.TranscribeModel = value
+	case "lang":
+		if value == "auto" {
+			value = ""
+		} else if len(value) != 2 {
+			replyMsg(*a.ctx, "🤖️：语言请使用 ISO-639-1 代码，例如 zh、en、ja，或 auto 自动识别",
+				a.info.msgId)
+			return false
+		}
+		setting.TranscribeLanguage = value
+	case "group":
+		if a.info.handlerType != GroupHandler {
+			replyMsg(*a.ctx, "🤖️：私聊中始终处理语音，无需开启", a.info.msgId)
+			return false
+		}
+		if value != "on" && value != "off" {
+			replyMsg(*a.ctx, "🤖️：群聊语音开关只能是 on 或 off", a.info.msgId)
+			return false
+		}
+		setting.GroupVoice = value == "on"
+	}
+
+	if err := a.handler.chatSettings.Set(*a.info.chatId, setting); err != nil {
+		replyMsg(*a.ctx, fmt.Sprintf("🤖️：保存设置失败\n错误信息: %v", err),
+			a.info.msgId)
+		return false
+	}
+	replyMsg(*a.ctx, "🤖️：已更新\n"+formatTranscribeSetting(setting,
+		a.info.handlerType == GroupHandler), a.info.msgId)
+	return false
+}
+
+func formatTranscribeSetting(setting services.ChatSetting, isGroup bool) string {
+	model := setting.TranscribeModel
+	if model == "" {
+		model = openai.DefaultTranscribeModel
+	}
+	language := setting.TranscribeLanguage
+	if language == "" {
+		language = "自动识别"
+	}
+	lines := []string{
+		"转写模型: " + model,
+		"语言提示: " + language,
+	}
+	if isGroup {
+		groupVoice := "仅处理 @ 过机器人的话题中的语音"
+		if setting.GroupVoice {
+			groupVoice = "处理群内所有语音"
+		}
+		lines = append(lines, "群聊语音: "+groupVoice)
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,33 @@
+package handlers
+
+import (
+	"math"
+	"testing"
+
+	"start-feishubot/services/openai"
+	"start-feishubot/utils/audio"
+)
+
+func TestTranscribeUsage(t *testing.T) {
+	// 16kHz 单声道 30 秒
+	segment := audio.Segment{Audio: &audio.Audio{SampleRate: 16000, Channels: 1,
+		Samples: make([]int16, 16000*30)}}
+	usages := []openai.Usage{
+		// 接口返回了时长
+		segmentUsage(openai.Usage{Model: "whisper-1", Seconds: 29.5}, segment),
+		// 没有返回时长时按片段长度计
+		segmentUsage(openai.Usage{Model: "whisper-1"}, segment),
+		// 失败的片段
+		{},
+	}
+	if usages[1].Seconds != 30 {
+		t.Errorf("segmentUsage() = %+v, want 30 seconds", usages[1])
+	}
+	total := sumTranscribeUsage(usages)
+	if total.Model != "whisper-1" || math.Abs(total.Seconds-59.5) > 1e-9 {
+		t.Errorf("sumTranscribeUsage() = %+v", total)
+	}
+	if got := sumTranscribeUsage(nil); got.Seconds != 0 {
+		t.Errorf("sumTranscribeUsage(nil) = %+v", got)
+	}
+}
@@ -3,10 +3,10 @@ package handlers
 import (
 	"context"
 	"fmt"
+	"time"
 
 	"start-feishubot/initialization"
 	"start-feishubot/services/openai"
-	"start-feishubot/utils"
 
 	larkim "github.com/larksuite/oapi-sdk-go/v3/service/im/v1"
 )
@@ -16,12 +16,24 @@ type MsgInfo struct {
 	msgType     string
 	msgId       *string
 	chatId      *string
+	userId      string
 	qParsed     string
 	fileKey     string
+	fileName    string // file、media 消息的文件名
+	parentId    string // 回复的消息 id
 	imageKey    string
 	imageKeys   []string // post 消息卡片中的图片组
+	shareId     string   // 分享的群 id 或用户 id
 	sessionId   *string
 	mention     []*larkim.MentionEvent
+	mentions    []mentionedUser // @ 的其他用户，不包括机器人
+	quote       *quotedMessage  // 回复的消息，由 QuoteAction 填充
+	// 解析后的指令，由 commandOf 首次调用时填充
+	command       *CommandInput
+	commandErr    error
+	commandParsed bool
+	// 是否回复在 @ 过机器人的话题中需要查询后才能确定，由 ProcessMentionAction 设置
+	threadPending bool
 }
 type ActionInfo struct {
 	handler *MessageHandler
@@ -57,11 +69,66 @@ func (*ProcessMentionAction) Execute(a *ActionInfo) bool {
 		if a.handler.judgeIfMentionMe(a.info.mention) {
 			return true
 		}
+		// 群聊中的语音消息无法 @ 机器人，群开启了语音处理时全部处理
+		if a.info.msgType == "audio" &&
+			a.handler.chatSettings.Get(*a.info.chatId).GroupVoice {
+			return true
+		}
+		// 开启 GroupThreadReply 后，机器人参与过的话题中的消息不需要再 @ 机器人；
+		// 语音、文件、转发和分享的消息同样无法 @ 机器人，只处理 @ 过机器人的话题中的消息
+		if !a.handler.config.GroupThreadReply && a.info.msgType != "audio" &&
+			!containsString(noMentionTypes, a.info.msgType) {
+			return false
+		}
+		mentioned, known := cachedThreadMention(a)
+		if !known {
+			// 需要查询话题的根消息，交给 ThreadMentionAction 在工作池中判断
+			a.info.threadPending = true
+			return true
+		}
+		return mentioned
+	}
+	return false
+}
+
+type ThreadMentionAction struct { /*话题是否 @ 过机器人*/
+}
+
+// Execute 查询话题的根消息是否 @ 了机器人，通过后补上收到事件时跳过的权限和频率检查
+func (*ThreadMentionAction) Execute(a *ActionInfo) bool {
+	if !a.handler.messageMentionsMe(*a.info.sessionId) {
 		return false
 	}
+	a.info.threadPending = false
+	return (&AccessAction{}).Execute(a) && (&RateLimitAction{}).Execute(a)
+}
+
+// threadPending 消息是否还在等待话题查询的结果
+func threadPending(a *ActionInfo) bool { return a.info.threadPending }
+
+func threadResolved(a *ActionInfo) bool { return !a.info.threadPending }
+
+// noMentionTypes 发送时无法 @ 机器人的消息类型
+var noMentionTypes = []string{"file", "media", "merge_forward", "share_chat",
+	"share_user", "interactive"}
+
+type UnsupportedAction struct { /*暂不支持的消息*/
+}
+
+func (*UnsupportedAction) Execute(a *ActionInfo) bool {
+	name := "这类消息"
+	if a.info.msgType == "sticker" {
+		name = "表情包"
+	}
+	replyMsg(*a.ctx, fmt.Sprintf("🤖️：暂时还看不懂%s，可以发送文字、图片、语音或文件给我～",
+		name), a.info.msgId)
 	return false
 }
 
+func unsupportedMsgType(a *ActionInfo) bool {
+	return !supportedMsgTypes[a.info.msgType]
+}
+
 type EmptyAction struct { /*空消息*/
 }
 
@@ -80,8 +147,7 @@ type ClearAction struct { /*清除消息*/
 }
 
 func (*ClearAction) Execute(a *ActionInfo) bool {
-	if _, foundClear := utils.EitherTrimEqual(a.info.qParsed,
-		"/clear", "清除"); foundClear {
+	if matchCommand(a, "clear") != nil {
 		sendClearCacheCheckCard(*a.ctx, a.info.sessionId,
 			a.info.msgId)
 		return false
@@ -93,8 +159,8 @@ type RolePlayAction struct { /*角色扮演*/
 }
 
 func (*RolePlayAction) Execute(a *ActionInfo) bool {
-	if system, foundSystem := utils.EitherCutPrefix(a.info.qParsed,
-		"/system ", "角色扮演 "); foundSystem {
+	if in := matchCommand(a, "system"); in != nil {
+		system := in.Arg("role")
 		a.handler.sessionCache.Clear(*a.info.sessionId)
 		systemMsg := append([]openai.Messages{}, openai.Messages{
 			Role: "system", Content: system,
@@ -111,37 +177,58 @@ type HelpAction struct { /*帮助*/
 }
 
 func (*HelpAction) Execute(a *ActionInfo) bool {
-	if _, foundHelp := utils.EitherTrimEqual(a.info.qParsed, "/help",
-		"帮助"); foundHelp {
-		sendHelpCard(*a.ctx, a.info.sessionId, a.info.msgId)
+	in := matchCommand(a, "help")
+	if in == nil {
+		return true
+	}
+	if name := in.Arg("command"); name != "" {
+		c := commands.Lookup(name)
+		if c == nil && !in.Slash {
+			// “帮助 xxx” 不是指令名时当作普通对话
+			return true
+		}
+		if c == nil {
+			replyMsg(*a.ctx, fmt.Sprintf("🤖️：没有指令 %s，回复 */help* 查看全部指令",
+				name), a.info.msgId)
+			return false
+		}
+		sendCommandHelpCard(*a.ctx, a.info.msgId, c)
 		return false
 	}
-	return true
+	sendHelpCard(*a.ctx, a.info.sessionId, a.info.msgId)
+	return false
 }
 
 type BalanceAction struct { /*余额*/
 }
 
 func (*BalanceAction) Execute(a *ActionInfo) bool {
-	if _, foundBalance := utils.EitherTrimEqual(a.info.qParsed,
-		"/balance", "余额"); foundBalance {
-		balanceResp, err := a.handler.gpt.GetBalance()
-		if err != nil {
-			replyMsg(*a.ctx, "查询余额失败，请稍后再试", a.info.msgId)
+	if in := matchCommand(a, "balance"); in != nil {
+		if scope := in.Arg("scope"); scope == "all" || scope == "全部" {
+			sendGlobalBalanceCard(*a.ctx, a.info.msgId,
+				a.handler.usageStore, time.Now())
 			return false
 		}
-		sendBalanceCard(*a.ctx, a.info.sessionId, *balanceResp)
+		sendBalanceCard(*a.ctx, a.info.msgId, a.handler.usageStore,
+			a.info.userId, groupChatId(a), time.Now())
 		return false
 	}
 	return true
 }
 
+// groupChatId 群聊中返回群 id，私聊返回空
+func groupChatId(a *ActionInfo) string {
+	if a.info.handlerType != GroupHandler {
+		return ""
+	}
+	return *a.info.chatId
+}
+
 type RoleListAction struct { /*角色列表*/
 }
 
 func (*RoleListAction) Execute(a *ActionInfo) bool {
-	if _, foundSystem := utils.EitherTrimEqual(a.info.qParsed,
-		"/roles", "角色列表"); foundSystem {
+	if matchCommand(a, "roles") != nil {
 		//a.handler.sessionCache.Clear(*a.info.sessionId)
 		//systemMsg := append([]openai.Messages{}, openai.Messages{
 		//	Role: "system", Content: system,
@@ -150,7 +237,7 @@ func (*RoleListAction) Execute(a *ActionInfo) bool {
 		//sendSystemInstructionCard(*a.ctx, a.info.sessionId,
 		//	a.info.msgId, system)
 		tags := initialization.GetAllUniqueTags()
-		SendRoleTagsCard(*a.ctx, a.info.sessionId, a.info.msgId, *tags)
+		SendRoleTagsCard(*a.ctx, a.info.sessionId, cardChatOf(a), a.info.msgId, *tags)
 		return false
 	}
 	return true
@@ -160,9 +247,8 @@ type AIModeAction struct { /*发散模式*/
 }
 
 func (*AIModeAction) Execute(a *ActionInfo) bool {
-	if _, foundMode := utils.EitherCutPrefix(a.info.qParsed,
-		"/ai_mode", "发散模式"); foundMode {
-		SendAIModeListsCard(*a.ctx, a.info.sessionId, a.info.msgId, openai.AIModeStrs)
+	if matchCommand(a, "ai_mode") != nil {
+		SendAIModeListsCard(*a.ctx, a.info.sessionId, cardChatOf(a), a.info.msgId, openai.AIModeStrs)
 		return false
 	}
 	return true
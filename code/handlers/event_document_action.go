@@ -0,0 +1,234 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"start-feishubot/initialization"
+	"start-feishubot/services"
+	"start-feishubot/services/openai"
+	"start-feishubot/services/usage"
+	"start-feishubot/utils/document"
+
+	larkcard "github.com/larksuite/oapi-sdk-go/v3/card"
+	larkim "github.com/larksuite/oapi-sdk-go/v3/service/im/v1"
+)
+
+const (
+	// maxDocumentSize 可以解析的文档大小上限
+	maxDocumentSize = 20 << 20
+	// maxDocumentRunes 文档保留的最大字数，超出部分不参与问答
+	maxDocumentRunes = 200000
+	// documentContextRunes 每次提问时最多提交给模型的文档字数
+	documentContextRunes = 6000
+)
+
+const documentPrompt = "你是一名文档助手。下面是用户上传的文档《%s》中与问题相关的片段，" +
+	"每个片段以 [片段 N] 开头。请只根据这些片段，用用户所用的语言回答问题，" +
+	"并在引用内容的句子后标注片段编号，例如 [片段 2]。" +
+	"如果片段中没有答案，请直接说明文档中没有找到相关内容，不要编造。\n\n%s"
+
+type DocumentAction struct { /*文档解析*/
+}
+
+func (*DocumentAction) Execute(a *ActionInfo) bool {
+	if a.info.msgType != "file" || !document.IsSupported(a.info.fileName) {
+		return true
+	}
+
+	data, err := downloadMessageFile(*a.info.msgId, a.info.fileKey, maxDocumentSize)
+	if errors.Is(err, errFileTooLarge) {
+		replyMsg(*a.ctx, fmt.Sprintf("🤖️：文档需要小于 %d MB", maxDocumentSize>>20),
+			a.info.msgId)
+		return false
+	}
+	if err != nil {
+		replyMsg(*a.ctx, fmt.Sprintf("🤖️：文档下载失败，请稍后再试～\n错误信息: %v",
+			err), a.info.msgId)
+		return false
+	}
+	text, err := document.Extract(a.info.fileName, data)
+	switch {
+	case errors.Is(err, document.ErrNoText):
+		replyMsg(*a.ctx, "🤖️：没有在文档中找到文字，可能是扫描件或图片，"+
+			"可以截图后使用图片推理模式", a.info.msgId)
+		return false
+	case errors.Is(err, document.ErrEncrypted):
+		replyMsg(*a.ctx, "🤖️：文档已加密，请去掉密码后重新发送", a.info.msgId)
+		return false
+	case err != nil:
+		replyMsg(*a.ctx, fmt.Sprintf("🤖️：文档解析失败～\n错误信息: %v", err),
+			a.info.msgId)
+		return false
+	case strings.TrimSpace(text) == "":
+		replyMsg(*a.ctx, "🤖️：没有在文档中找到文字", a.info.msgId)
+		return false
+	}
+
+	truncated := false
+	if runes := []rune(text); len(runes) > maxDocumentRunes {
+		text = string(runes[:maxDocumentRunes])
+		truncated = true
+	}
+	chunks := document.Chunk(text, document.DefaultChunkSize,
+		document.DefaultChunkOverlap)
+	a.handler.sessionCache.SetDocument(*a.info.sessionId, &services.Document{
+		Name:   a.info.fileName,
+		Chunks: chunks,
+	})
+	sendDocumentReadyCard(*a.ctx, a.info.msgId, a.info.fileName,
+		utf8.RuneCountInString(text), len(chunks), truncated)
+	return false
+}
+
+type DocumentQAAction struct { /*文档问答*/
+}
+
+func (*DocumentQAAction) Execute(a *ActionInfo) bool {
+	if a.info.msgType != "text" && a.info.msgType != "post" {
+		return true
+	}
+	if strings.HasPrefix(a.info.qParsed, "/") ||
+		a.handler.sessionCache.GetMode(*a.info.sessionId) != services.ModeGPT {
+		return true
+	}
+	doc := a.handler.sessionCache.GetDocument(*a.info.sessionId)
+	if doc == nil || len(doc.Chunks) == 0 {
+		return true
+	}
+
+	selected := selectDocumentChunks(a.info.qParsed, doc.Chunks,
+		documentContextRunes)
+	var excerpts strings.Builder
+	for _, i := range selected {
+		fmt.Fprintf(&excerpts, "[片段 %d]\n%s\n\n", i+1, doc.Chunks[i])
+	}
+
+	// 历史中只保存问答，文档片段每次根据问题重新检索
+	history := setDefaultPrompt(a.handler.sessionCache.GetMsg(*a.info.sessionId))
+	history = append(history, openai.Messages{
+		Role: "user", Content: a.info.qParsed,
+	})
+	msg := []openai.Messages{{
+		Role:    "system",
+		Content: fmt.Sprintf(documentPrompt, doc.Name, excerpts.String()),
+	}}
+	for _, m := range history {
+		if m.Role != "system" {
+			msg = append(msg, m)
+		}
+	}
+
+	aiMode := a.handler.sessionCache.GetAIMode(*a.info.sessionId)
+	completions, tokenUsage, err := a.handler.gpt.CompletionsWithUsage(msg, aiMode)
+	if err != nil {
+		replyMsg(*a.ctx, fmt.Sprintf(
+			"🤖️：消息机器人摆烂了，请稍后再试～\n错误信息: %v", err), a.info.msgId)
+		return false
+	}
+	recordUsage(a, usage.KindChat, tokenUsage)
+	history = append(history, completions)
+	a.handler.sessionCache.SetMsg(*a.info.sessionId, history)
+	sendDocumentAnswerCard(*a.ctx, a.info.msgId, doc.Name, completions.Content,
+		selected)
+	return false
+}
+
+// selectDocumentChunks 选出回答问题需要的片段，按原文顺序返回下标。
+// 文档较短时全部提交，否则按相关度在字数预算内选取，没有命中时使用文档开头
+func selectDocumentChunks(question string, chunks []string, budget int) []int {
+	total := 0
+	all := make([]int, len(chunks))
+	for i, chunk := range chunks {
+		total += utf8.RuneCountInString(chunk)
+		all[i] = i
+	}
+	if total <= budget {
+		return all
+	}
+
+	ranked := document.Rank(question, chunks, 0)
+	if len(ranked) == 0 {
+		ranked = all
+	}
+	var selected []int
+	used := 0
+	for _, i := range ranked {
+		n := utf8.RuneCountInString(chunks[i])
+		if used+n > budget {
+			if len(selected) == 0 {
+				selected = append(selected, i)
+			}
+			break
+		}
+		selected = append(selected, i)
+		used += n
+	}
+	sort.Ints(selected)
+	return selected
+}
+
+// errFileTooLarge 消息中的文件超过了下载上限
+var errFileTooLarge = errors.New("file too large")
+
+// downloadMessageFile 下载文件、音视频或语音消息中的文件，超过 limit 字节时返回 errFileTooLarge
+func downloadMessageFile(msgId string, fileKey string, limit int64) ([]byte, error) {
+	req := larkim.NewGetMessageResourceReqBuilder().MessageId(
+		msgId).FileKey(fileKey).Type("file").Build()
+	resp, err := initialization.GetLarkClient().Im.MessageResource.Get(
+		context.Background(), req)
+	if err != nil {
+		return nil, err
+	}
+	if !resp.Success() {
+		return nil, errors.New(resp.Msg)
+	}
+	return readLimited(resp.File, limit)
+}
+
+// readLimited 读取不超过 limit 字节的内容，不会把超出的部分全部读入内存
+func readLimited(r io.Reader, limit int64) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > limit {
+		return nil, fmt.Errorf("%w: 超过 %d MB", errFileTooLarge, limit>>20)
+	}
+	return data, nil
+}
+
+func sendDocumentReadyCard(ctx context.Context, msgId *string, fileName string,
+	runes int, chunks int, truncated bool) {
+	content := fmt.Sprintf("已读取 **%d** 字，切分为 **%d** 个片段。\n"+
+		"在本话题中直接提问即可，例如：*总结一下这份文档*", runes, chunks)
+	if truncated {
+		content += fmt.Sprintf("\n文档较长，只保留了前 %d 字", maxDocumentRunes)
+	}
+	newCard, _ := newSendCard(
+		withHeader("📄 "+fileName, larkcard.TemplateTurquoise),
+		withMainMd(content),
+		withNote("文档在话题中保留 12 小时，回复 清除 可以移除"),
+	)
+	replyCard(ctx, msgId, newCard)
+}
+
+func sendDocumentAnswerCard(ctx context.Context, msgId *string, fileName string,
+	content string, chunks []int) {
+	refs := make([]string, len(chunks))
+	for i, chunk := range chunks {
+		refs[i] = fmt.Sprint(chunk + 1)
+	}
+	newCard, _ := newSendCard(
+		withHeader("📄 "+fileName, larkcard.TemplateBlue),
+		withMainText(content),
+		withNote(fmt.Sprintf("参考片段：%s。回答仅基于文档内容，请以原文为准",
+			strings.Join(refs, "、"))),
+	)
+	replyCard(ctx, msgId, newCard)
+}
@@ -0,0 +1,32 @@
+package handlers
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestReadLimited(t *testing.T) {
+	tests := []struct {
+		name    string
+		size    int
+		limit   int64
+		wantErr error
+	}{
+		{"empty", 0, 10, nil},
+		{"under limit", 9, 10, nil},
+		{"at limit", 10, 10, nil},
+		{"over limit", 11, 10, errFileTooLarge},
+		{"far over limit", 1 << 20, 10, errFileTooLarge},
+	}
+	for _, tt := range tests {
+		data, err := readLimited(strings.NewReader(strings.Repeat("a", tt.size)), tt.limit)
+		if !errors.Is(err, tt.wantErr) {
+			t.Errorf("%s: readLimited() error = %v, want %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if err == nil && len(data) != tt.size {
+			t.Errorf("%s: readLimited() read %d bytes, want %d", tt.name, len(data), tt.size)
+		}
+	}
+}
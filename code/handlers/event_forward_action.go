@@ -0,0 +1,91 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"start-feishubot/initialization"
+
+	larkim "github.com/larksuite/oapi-sdk-go/v3/service/im/v1"
+)
+
+type ForwardAction struct { /*转发和分享的消息*/
+}
+
+// Execute 把合并转发的聊天记录、分享的群名片和个人名片转成文字，交给后续的 Action 回答
+func (*ForwardAction) Execute(a *ActionInfo) bool {
+	var text string
+	var err error
+	switch a.info.msgType {
+	case "merge_forward":
+		text, err = forwardedTranscript(*a.info.msgId)
+	case "share_chat":
+		text, err = sharedChatText(a.info.shareId)
+	case "share_user":
+		text = sharedUserText(a.info.shareId)
+	default:
+		return true
+	}
+	if err != nil {
+		replyMsg(*a.ctx, fmt.Sprintf("🤖️：读取转发的消息失败，请稍后再试～\n错误信息: %v",
+			err), a.info.msgId)
+		return false
+	}
+	a.info.qParsed = text
+	return true
+}
+
+// forwardedTranscript 展开合并转发的消息，转成带发送者和时间的聊天记录。
+// 只展开第一层，嵌套的合并转发显示为 [聊天记录]
+func forwardedTranscript(msgId string) (string, error) {
+	items, err := getMessageItems(msgId)
+	if err != nil {
+		return "", err
+	}
+	var messages []*larkim.Message
+	for _, item := range items {
+		if item.UpperMessageId != nil && *item.UpperMessageId == msgId {
+			messages = append(messages, item)
+		}
+	}
+	if len(messages) == 0 {
+		return "", errors.New("没有找到转发的消息")
+	}
+	return "以下是转发的聊天记录：\n" + formatChatLines(chatLines(messages)) +
+		"\n请总结这段聊天记录的要点", nil
+}
+
+func sharedChatText(chatId string) (string, error) {
+	if chatId == "" {
+		return "", errors.New("没有找到分享的群")
+	}
+	resp, err := initialization.GetLarkClient().Im.Chat.Get(context.Background(),
+		larkim.NewGetChatReqBuilder().ChatId(chatId).Build())
+	if err != nil {
+		return "", err
+	}
+	if !resp.Success() {
+		return "", errors.New(resp.Msg)
+	}
+	var name, description string
+	if resp.Data.Name != nil {
+		name = *resp.Data.Name
+	}
+	if resp.Data.Description != nil {
+		description = strings.TrimSpace(*resp.Data.Description)
+	}
+	text := fmt.Sprintf("分享了群「%s」", name)
+	if description != "" {
+		text += "\n群简介：" + description
+	}
+	return text, nil
+}
+
+func sharedUserText(openId string) string {
+	if name := userName(openId); name != "" {
+		return fmt.Sprintf("分享了联系人「%s」", name)
+	}
+	return "分享了一位联系人"
+}
@@ -0,0 +1,220 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"start-feishubot/logger"
+	"start-feishubot/services/knowledge"
+	"start-feishubot/services/openai"
+	"start-feishubot/services/usage"
+
+	larkcard "github.com/larksuite/oapi-sdk-go/v3/card"
+)
+
+const knowledgePrompt = "以下是从内部知识库中检索到的资料，每条以 [编号] 开头并注明来源。" +
+	"回答时优先依据这些资料，并在引用处标注编号，例如 [1]；" +
+	"资料与问题无关时忽略它们，资料不足时说明知识库中没有相关内容，不要编造。\n\n%s"
+
+type KnowledgeAction struct { /*知识库*/
+}
+
+func (*KnowledgeAction) Execute(a *ActionInfo) bool {
+	in := matchCommand(a, "kb")
+	if in == nil {
+		return true
+	}
+	if in.Arg("op") != "reindex" {
+		sendKnowledgeListCard(*a.ctx, a.info.msgId,
+			a.handler.knowledge.Collections(), a.handler.config.KnowledgeDir)
+		return false
+	}
+
+	dir := a.handler.config.KnowledgeDir
+	names, err := knowledge.CollectionNames(dir)
+	if err != nil {
+		replyMsg(*a.ctx, fmt.Sprintf("🤖️：读取知识库目录 %s 失败～\n错误信息: %v",
+			dir, err), a.info.msgId)
+		return false
+	}
+	if name := in.Arg("collection"); name != "" {
+		if !containsString(names, name) {
+			replyMsg(*a.ctx, fmt.Sprintf("🤖️：知识库中没有集合 %s，可选：%s",
+				name, strings.Join(names, "、")), a.info.msgId)
+			return false
+		}
+		names = []string{name}
+	} else {
+		// 目录中已删除的集合同时从索引中移除
+		for _, c := range a.handler.knowledge.Collections() {
+			if !containsString(names, c.Name) {
+				if err := a.handler.knowledge.Remove(c.Name); err != nil {
+					logger.Errorf("remove knowledge collection %s failed: %v",
+						c.Name, err)
+				}
+			}
+		}
+	}
+	if len(names) == 0 {
+		replyMsg(*a.ctx, fmt.Sprintf("🤖️：知识库目录 %s 中没有文档", dir),
+			a.info.msgId)
+		return false
+	}
+
+	replyMsg(*a.ctx, fmt.Sprintf("🤖️：开始重建 %s 的索引，完成后会通知你～",
+		strings.Join(names, "、")), a.info.msgId)
+	go reindexKnowledge(a, dir, names)
+	return false
+}
+
+func reindexKnowledge(a *ActionInfo, dir string, names []string) {
+	model := a.handler.config.EmbeddingModel
+	embed := func(texts []string) ([][]float32, error) {
+		vectors, tokenUsage, err := a.handler.gpt.Embeddings(model, texts)
+		if err != nil {
+			return nil, err
+		}
+		recordUsage(a, usage.KindEmbedding, tokenUsage)
+		return vectors, nil
+	}
+	var lines []string
+	for _, name := range names {
+		stats, err := a.handler.knowledge.Reindex(dir, name, model, embed)
+		switch {
+		case errors.Is(err, knowledge.ErrIndexing):
+			lines = append(lines, fmt.Sprintf("**%s**：正在重建中，已跳过", name))
+		case err != nil:
+			logger.Errorf("reindex knowledge collection %s failed: %v", name, err)
+			lines = append(lines, fmt.Sprintf("**%s**：失败，%v", name, err))
+		default:
+			line := fmt.Sprintf("**%s**：%d 个文件，%d 个片段，新计算 %d 个",
+				name, stats.Files, stats.Chunks, stats.Embedded)
+			if len(stats.Skipped) > 0 {
+				line += fmt.Sprintf("，无法解析：%s", strings.Join(stats.Skipped, "、"))
+			}
+			lines = append(lines, line)
+		}
+	}
+	newCard, _ := newSendCard(
+		withHeader("📚 知识库索引完成", larkcard.TemplateGreen),
+		withMainMd(strings.Join(lines, "\n")),
+		withNote("内容未变化的文件会复用已有向量，不会重复计费"),
+	)
+	replyCard(*a.ctx, a.info.msgId, newCard)
+}
+
+// retrieveKnowledge 检索与问题相关的知识库片段，知识库为空或检索失败时返回 nil
+func retrieveKnowledge(a *ActionInfo, question string) []knowledge.Result {
+	if a.handler.knowledge.Empty() || strings.TrimSpace(question) == "" {
+		return nil
+	}
+	model := a.handler.config.EmbeddingModel
+	vectors, tokenUsage, err := a.handler.gpt.Embeddings(model, []string{question})
+	if err != nil {
+		logger.Warnf("embed question failed: %v", err)
+		return nil
+	}
+	recordUsage(a, usage.KindEmbedding, tokenUsage)
+	return a.handler.knowledge.Search(vectors[0], model,
+		a.handler.config.KnowledgeTopK, a.handler.config.KnowledgeMinScore)
+}
+
+// withKnowledge 在最后一条消息之前插入检索到的资料，资料不写入话题历史
+func withKnowledge(msg []openai.Messages,
+	results []knowledge.Result) []openai.Messages {
+	if len(results) == 0 || len(msg) == 0 {
+		return msg
+	}
+	var sb strings.Builder
+	for i, r := range results {
+		fmt.Fprintf(&sb, "[%d] 来源：%s\n%s\n\n", i+1, r.Source, r.Text)
+	}
+	result := make([]openai.Messages, 0, len(msg)+1)
+	result = append(result, msg[:len(msg)-1]...)
+	result = append(result, openai.Messages{
+		Role: "system", Content: fmt.Sprintf(knowledgePrompt, sb.String()),
+	})
+	return append(result, msg[len(msg)-1])
+}
+
+func containsString(items []string, item string) bool {
+	for _, v := range items {
+		if v == item {
+			return true
+		}
+	}
+	return false
+}
+
+func sendKnowledgeListCard(ctx context.Context, msgId *string,
+	collections []knowledge.Collection, dir string) {
+	var content string
+	if len(collections) == 0 {
+		content = "知识库还没有索引"
+	}
+	for _, c := range collections {
+		chunks := 0
+		for _, f := range c.Files {
+			chunks += f.Chunks
+		}
+		content += fmt.Sprintf("**%s**　%d 个文件，%d 个片段，更新于 %s\n",
+			c.Name, len(c.Files), chunks, c.UpdatedAt.Format("2006-01-02 15:04"))
+	}
+	note := "管理员回复 /kb reindex [集合] 重建索引"
+	if _, err := os.Stat(dir); err != nil {
+		note = fmt.Sprintf("知识库目录 %s 不存在，", dir) + note
+	}
+	newCard, _ := newSendCard(
+		withHeader("📚 知识库", larkcard.TemplateTurquoise),
+		withMainMd(strings.TrimSpace(content)),
+		withNote(note),
+	)
+	replyCard(ctx, msgId, newCard)
+}
+
+func sendKnowledgeTopicCard(ctx context.Context, msgId *string, content string,
+	newTopic bool, results []knowledge.Result) {
+	title := "🔃️ 上下文的话题"
+	if newTopic {
+		title = "👻️ 已开启新的话题"
+	}
+	newCard, _ := newSendCard(
+		withHeader(title, larkcard.TemplateBlue),
+		withMainText(content),
+		withSplitLine(),
+		withKnowledgeSources(results),
+		withNote("提醒：点击对话框参与回复，可保持话题连贯"))
+	replyCard(ctx, msgId, newCard)
+}
+
+// updateKnowledgeFinalCard 流式回答完成，检索到知识库资料时附上参考资料
+func updateKnowledgeFinalCard(ctx context.Context, content string, msgId *string,
+	newTopic bool, results []knowledge.Result) error {
+	if len(results) == 0 {
+		return updateFinalCard(ctx, content, msgId, newTopic)
+	}
+	title := "🔃️ 上下文的话题"
+	if newTopic {
+		title = "👻️ 已开启新的话题"
+	}
+	newCard, _ := newSendCard(
+		withHeader(title, larkcard.TemplateBlue),
+		withMainText(content),
+		withSplitLine(),
+		withKnowledgeSources(results),
+		withNote("已完成，您可以继续提问或者选择其他功能。"))
+	return PatchCard(ctx, msgId, newCard)
+}
+
+// withKnowledgeSources 回答引用的知识库片段
+func withKnowledgeSources(results []knowledge.Result) larkcard.MessageCardElement {
+	sources := make([]string, len(results))
+	for i, r := range results {
+		sources[i] = fmt.Sprintf("[%d] %s · 片段 %d（相似度 %.2f）",
+			i+1, r.Source, r.Index+1, r.Score)
+	}
+	return withMainMd("📚 **参考资料**\n" + strings.Join(sources, "\n"))
+}
@@ -0,0 +1,32 @@
+package handlers
+
+import (
+	"strings"
+	"testing"
+
+	"start-feishubot/services/knowledge"
+	"start-feishubot/services/openai"
+)
+
+func TestWithKnowledge(t *testing.T) {
+	msg := []openai.Messages{
+		{Role: "system", Content: "prompt"},
+		{Role: "user", Content: "请假流程？"},
+	}
+	results := []knowledge.Result{
+		{Source: "hr/leave.md", Index: 2, Text: "请假需要提前一天申请", Score: 0.82},
+	}
+
+	got := withKnowledge(msg, results)
+	if len(got) != 3 || got[0].Content != "prompt" || got[2].Content != "请假流程？" || got[1].Role != "system" ||
+		!strings.Contains(got[1].Content, "[1] 来源：hr/leave.md\n请假需要提前一天申请") {
+		t.Errorf("withKnowledge() = %+v", got)
+	}
+	// 资料不写入话题历史
+	if len(msg) != 2 || msg[1].Content != "请假流程？" {
+		t.Errorf("withKnowledge() modified msg: %+v", msg)
+	}
+	if got := withKnowledge(msg, nil); len(got) != len(msg) {
+		t.Errorf("withKnowledge(nil) = %+v", got)
+	}
+}
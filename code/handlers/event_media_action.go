@@ -0,0 +1,219 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"start-feishubot/services/openai"
+	"start-feishubot/services/usage"
+	"start-feishubot/utils/audio"
+	"start-feishubot/utils/transcript"
+
+	larkcard "github.com/larksuite/oapi-sdk-go/v3/card"
+	larkim "github.com/larksuite/oapi-sdk-go/v3/service/im/v1"
+)
+
+const (
+	// 两句之间停顿超过该时长视为换人发言
+	speakerTurnPause = 1500 * time.Millisecond
+	// 总结时最多提交的转写字数，超出部分只保留在附件中
+	maxSummaryInput = 20000
+	// 接口单次上传的文件大小上限
+	whisperMaxFileSize = 25 << 20
+	// maxMediaSize 下载的音视频文件大小上限，文件会整体读入内存
+	maxMediaSize = 500 << 20
+)
+
+// mediaExtensions 作为音视频处理的文件扩展名
+var mediaExtensions = map[string]bool{
+	".mp3": true, ".m4a": true, ".wav": true, ".ogg": true, ".oga": true,
+	".opus": true, ".flac": true, ".aac": true, ".amr": true, ".wma": true,
+	".mp4": true, ".mov": true, ".mkv": true, ".webm": true, ".avi": true,
+	".m4v": true, ".flv": true, ".mpeg": true, ".mpga": true,
+}
+
+// whisperExtensions 没有 ffmpeg 时可以直接上传转写的格式
+var whisperExtensions = map[string]bool{
+	".flac": true, ".m4a": true, ".mp3": true, ".mp4": true, ".mpeg": true,
+	".mpga": true, ".oga": true, ".ogg": true, ".wav": true, ".webm": true,
+}
+
+const summaryPrompt = "你是一名会议记录员。下面是一段会议或音视频的转写文字，" +
+	"说话人是根据停顿推测的，可能不准确。请用用户所用的语言输出 Markdown 格式的纪要，" +
+	"包含三个部分：**概要**（三句话以内）、**要点**（列表）、**待办事项**（列表，" +
+	"注明负责人，没有则写“无”）。不要编造转写中没有的信息。"
+
+type MediaFileAction struct { /*音视频文件*/
+}
+
+func (*MediaFileAction) Execute(a *ActionInfo) bool {
+	if !isMediaMessage(a.info) {
+		return true
+	}
+	if !AzureModeCheck(a) {
+		replyMsg(*a.ctx, "🤖️：Azure OpenAI 接口下暂不支持音视频转写", a.info.msgId)
+		return false
+	}
+
+	replyMsg(*a.ctx, fmt.Sprintf("🤖️：收到 %s，正在转写和总结，请稍候～",
+		a.info.fileName), a.info.msgId)
+	segments, err := transcribeMedia(a, mediaRef{
+		msgId:    *a.info.msgId,
+		msgType:  a.info.msgType,
+		fileKey:  a.info.fileKey,
+		fileName: a.info.fileName,
+	})
+	if err != nil {
+		replyMsg(*a.ctx, fmt.Sprintf("🤖️：音视频转写失败，请稍后再试～\n错误信息: %v",
+			err), a.info.msgId)
+		return false
+	}
+	if len(segments) == 0 {
+		replyMsg(*a.ctx, "🤖️：没有识别到语音内容", a.info.msgId)
+		return false
+	}
+
+	turns := transcript.Turns(segments, speakerTurnPause)
+	text := transcript.Text(turns)
+	summary, err := summarizeTranscript(a, text)
+	if err != nil {
+		summary = fmt.Sprintf("总结失败: %v", err)
+	}
+	duration := segments[len(segments)-1].End
+	sendMediaSummaryCard(*a.ctx, a.info.msgId, a.info.fileName, duration,
+		len(turns), summary)
+
+	fileName := strings.TrimSuffix(a.info.fileName,
+		filepath.Ext(a.info.fileName)) + "_transcript.txt"
+	fileKey, err := uploadFile(larkim.FileTypeStream, fileName,
+		strings.NewReader(text), 0)
+	if err == nil {
+		err = replyFile(*a.ctx, fileKey, a.info.msgId)
+	}
+	if err != nil {
+		replyMsg(*a.ctx, fmt.Sprintf("🤖️：转写文件上传失败\n错误信息: %v", err),
+			a.info.msgId)
+	}
+	return false
+}
+
+// isMediaMessage 视频消息，或者扩展名为音视频格式的文件消息
+func isMediaMessage(info *MsgInfo) bool {
+	return isMediaType(info.msgType, info.fileName)
+}
+
+func isMediaType(msgType string, fileName string) bool {
+	switch msgType {
+	case "media":
+		return true
+	case "file":
+		return mediaExtensions[strings.ToLower(filepath.Ext(fileName))]
+	}
+	return false
+}
+
+// mediaRef 指向一条语音、视频或音视频文件消息中的文件
+type mediaRef struct {
+	msgId    string
+	msgType  string
+	fileKey  string
+	fileName string
+}
+
+// getMediaRef 查询消息中的音视频文件，消息不是语音、视频或音视频文件时返回错误
+func getMediaRef(msgId string) (mediaRef, error) {
+	msg, err := getMessage(msgId)
+	if err != nil {
+		return mediaRef{}, err
+	}
+	content := *msg.Body.Content
+	ref := mediaRef{
+		msgId:    msgId,
+		msgType:  *msg.MsgType,
+		fileKey:  parseFileKey(content),
+		fileName: parseFileName(content),
+	}
+	if ref.msgType != "audio" && !isMediaType(ref.msgType, ref.fileName) {
+		return mediaRef{}, errors.New("不是语音、音频或视频消息")
+	}
+	return ref, nil
+}
+
+// transcribeMedia 下载语音或音视频文件并转写。安装了 ffmpeg 时提取音轨后分段转写，
+// 否则只能处理语音、WAV 或者不超过 25MB 的常见音视频格式
+func transcribeMedia(a *ActionInfo, ref mediaRef) ([]transcript.Segment, error) {
+	data, err := downloadMessageFile(ref.msgId, ref.fileKey, maxMediaSize)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := transcribeOptions(a.handler.chatSettings.Get(*a.info.chatId))
+	ext := strings.ToLower(filepath.Ext(ref.fileName))
+	var media *audio.Audio
+	switch {
+	case audio.FFmpegAvailable():
+		media, err = audio.ExtractAudio(*a.ctx, bytes.NewReader(data),
+			transcribeSampleRate)
+	case ref.msgType == "audio":
+		media, err = audio.DecodeOgg(bytes.NewReader(data))
+	case ext == ".wav":
+		media, err = audio.ReadWav(bytes.NewReader(data))
+	case whisperExtensions[ext] && len(data) <= whisperMaxFileSize:
+		verbose, tokenUsage, err := a.handler.gpt.TranscribeVerbose(ref.fileName,
+			bytes.NewReader(data), opts)
+		if err != nil {
+			return nil, err
+		}
+		segments := toTranscriptSegments(verbose, 0)
+		if tokenUsage.Seconds == 0 && len(segments) > 0 {
+			tokenUsage.Seconds = segments[len(segments)-1].End.Seconds()
+		}
+		recordTranscribeUsage(a, tokenUsage)
+		return segments, nil
+	default:
+		return nil, fmt.Errorf("服务器未安装 ffmpeg，无法处理该文件: %w",
+			audio.ErrFFmpegNotFound)
+	}
+	if err != nil {
+		return nil, err
+	}
+	segments, tokenUsage, err := transcribeAudioSegments(a.handler.gpt, media,
+		ref.fileKey, opts)
+	recordTranscribeUsage(a, tokenUsage)
+	return segments, err
+}
+
+func summarizeTranscript(a *ActionInfo, text string) (string, error) {
+	if runes := []rune(text); len(runes) > maxSummaryInput {
+		text = string(runes[:maxSummaryInput]) + "\n（转写内容过长，后续部分已省略）"
+	}
+	msg := []openai.Messages{
+		{Role: "system", Content: summaryPrompt},
+		{Role: "user", Content: text},
+	}
+	completions, tokenUsage, err := a.handler.gpt.CompletionsWithUsage(msg,
+		openai.Fresh)
+	if err != nil {
+		return "", err
+	}
+	recordUsage(a, usage.KindChat, tokenUsage)
+	return completions.Content, nil
+}
+
+func sendMediaSummaryCard(ctx context.Context, msgId *string, fileName string,
+	duration time.Duration, turns int, summary string) {
+	newCard, _ := newSendCard(
+		withHeader("📝 "+fileName, larkcard.TemplateTurquoise),
+		withMainMd(fmt.Sprintf("**时长** %s　**发言轮次** %d",
+			transcript.FormatTimestamp(duration), turns)),
+		withSplitLine(),
+		withMainMd(summary),
+		withNote("完整转写见附件，说话人根据停顿推测，仅供参考。"),
+	)
+	replyCard(ctx, msgId, newCard)
+}
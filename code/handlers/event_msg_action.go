@@ -8,6 +8,7 @@ import (
 	"time"
 
 	"start-feishubot/services/openai"
+	"start-feishubot/services/usage"
 )
 
 func setDefaultPrompt(msg []openai.Messages) []openai.Messages {
@@ -44,9 +45,6 @@ type MessageAction struct { /*消息*/
 }
 
 func (*MessageAction) Execute(a *ActionInfo) bool {
-	if a.handler.config.StreamMode {
-		return true
-	}
 	msg := a.handler.sessionCache.GetMsg(*a.info.sessionId)
 	// 如果没有提示词，默认模拟ChatGPT
 	msg = setDefaultPrompt(msg)
@@ -56,16 +54,24 @@ func (*MessageAction) Execute(a *ActionInfo) bool {
 
 	// get ai mode as temperature
 	aiMode := a.handler.sessionCache.GetAIMode(*a.info.sessionId)
-	fmt.Println("msg: ", msg)
-	fmt.Println("aiMode: ", aiMode)
-	completions, err := a.handler.gpt.Completions(msg, aiMode)
+	// 检索到的知识库资料只用于本次回答，不写入话题历史
+	results := retrieveKnowledge(a, a.info.qParsed)
+	completions, tokenUsage, err := a.handler.gpt.CompletionsWithUsage(
+		withKnowledge(msg, results), aiMode)
 	if err != nil {
 		replyMsg(*a.ctx, fmt.Sprintf(
 			"🤖️：消息机器人摆烂了，请稍后再试～\n错误信息: %v", err), a.info.msgId)
 		return false
 	}
+	recordUsage(a, usage.KindChat, tokenUsage)
 	msg = append(msg, completions)
 	a.handler.sessionCache.SetMsg(*a.info.sessionId, msg)
+	defer replyVoice(a, completions.Content)
+	if len(results) > 0 {
+		sendKnowledgeTopicCard(*a.ctx, a.info.msgId, completions.Content,
+			len(msg) == 3, results)
+		return false
+	}
 	//if new topic
 	if len(msg) == 3 {
 		//fmt.Println("new topic", msg[1].Content)
@@ -101,9 +107,6 @@ type StreamMessageAction struct { /*消息*/
 }
 
 func (m *StreamMessageAction) Execute(a *ActionInfo) bool {
-	if !a.handler.config.StreamMode {
-		return true
-	}
 	msg := a.handler.sessionCache.GetMsg(*a.info.sessionId)
 	// 如果没有提示词，默认模拟ChatGPT
 	msg = setDefaultPrompt(msg)
@@ -122,6 +125,8 @@ func (m *StreamMessageAction) Execute(a *ActionInfo) bool {
 	if err2 != nil {
 		return false
 	}
+	// 检索到的知识库资料只用于本次回答，不写入话题历史
+	results := retrieveKnowledge(a, a.info.qParsed)
 
 	answer := ""
 	chatResponseStream := make(chan string)
@@ -151,8 +156,8 @@ func (m *StreamMessageAction) Execute(a *ActionInfo) bool {
 		aiMode := a.handler.sessionCache.GetAIMode(*a.info.sessionId)
 		//fmt.Println("msg: ", msg)
 		//fmt.Println("aiMode: ", aiMode)
-		if err := a.handler.gpt.StreamChat(*a.ctx, msg, aiMode,
-			chatResponseStream); err != nil {
+		if err := a.handler.gpt.StreamChat(*a.ctx, withKnowledge(msg, results),
+			aiMode, chatResponseStream); err != nil {
 			err := updateFinalCard(*a.ctx, "聊天失败", cardId, ifNewTopic)
 			if err != nil {
 				return
@@ -187,7 +192,8 @@ func (m *StreamMessageAction) Execute(a *ActionInfo) bool {
 			answer += res
 			//pp.Println("answer", answer)
 		case <-done: // 添加 done 信号的处理
-			err := updateFinalCard(*a.ctx, answer, cardId, ifNewTopic)
+			err := updateKnowledgeFinalCard(*a.ctx, answer, cardId, ifNewTopic,
+				results)
 			if err != nil {
 				return false
 			}
@@ -196,6 +202,10 @@ func (m *StreamMessageAction) Execute(a *ActionInfo) bool {
 				Role: "assistant", Content: answer,
 			})
 			a.handler.sessionCache.SetMsg(*a.info.sessionId, msg)
+			recordUsage(a, usage.KindChat, openai.EstimateUsage(
+				a.handler.gpt.Model, withKnowledge(msg[:len(msg)-1], results),
+				answer))
+			replyVoice(a, answer)
 			close(chatResponseStream)
 			log.Printf("\n\n\n")
 			jsonByteArray, err := json.Marshal(msg)
@@ -1,10 +1,10 @@
 package handlers
 
 import (
-	"context"
 	"fmt"
 	"start-feishubot/services"
 	"start-feishubot/services/openai"
+	"start-feishubot/services/usage"
 )
 
 // MultimodalAction 处理多模态消息（文本、图片或组合）
@@ -15,6 +15,15 @@ func (ma *MultimodalAction) Execute(a *ActionInfo) bool {
 	if a.handler.gpt.Model != "o4-mini" && a.handler.gpt.Model != "gpt-4o" {
 		return true
 	}
+	// 指令交给后面对应的 Action 处理
+	if in, _ := commandOf(a.info); in != nil {
+		return true
+	}
+	// 图片创作和图片推理模式下的消息交给对应的 Action 处理
+	switch a.handler.sessionCache.GetMode(*a.info.sessionId) {
+	case services.ModePicCreate, services.ModeVision:
+		return true
+	}
 
 	// 处理不同类型的消息
 	switch a.info.msgType {
@@ -31,90 +40,63 @@ func (ma *MultimodalAction) Execute(a *ActionInfo) bool {
 
 func (ma *MultimodalAction) handleTextMessage(a *ActionInfo) bool {
 	// 处理纯文本消息
+	// 话题中有文档时交给文档问答处理
+	if a.handler.sessionCache.GetDocument(*a.info.sessionId) != nil {
+		return true
+	}
 	msg := a.handler.sessionCache.GetMsg(*a.info.sessionId)
+	// 话题中有图片时按图片推理处理，追问可以继续参考之前的图片
+	if openai.HasImages(msg) {
+		return replyVisionTurn(a, a.info.qParsed, nil,
+			string(services.VisionDetailHigh))
+	}
 	msg = setDefaultPrompt(msg)
 	msg = append(msg, openai.Messages{
 		Role: "user", Content: a.info.qParsed,
 	})
 
 	aiMode := a.handler.sessionCache.GetAIMode(*a.info.sessionId)
-	completions, err := a.handler.gpt.Completions(msg, aiMode)
+	// 检索到的知识库资料只用于本次回答，不写入话题历史
+	results := retrieveKnowledge(a, a.info.qParsed)
+	completions, tokenUsage, err := a.handler.gpt.CompletionsWithUsage(
+		withKnowledge(msg, results), aiMode)
 	if err != nil {
 		replyMsg(*a.ctx, fmt.Sprintf("🤖️：消息处理失败，请稍后再试～\n错误信息: %v", err), a.info.msgId)
 		return false
 	}
+	recordUsage(a, usage.KindChat, tokenUsage)
 
 	msg = append(msg, completions)
 	a.handler.sessionCache.SetMsg(*a.info.sessionId, msg)
 
-	if len(msg) == 3 {
+	if len(results) > 0 {
+		sendKnowledgeTopicCard(*a.ctx, a.info.msgId, completions.Content,
+			len(msg) == 3, results)
+	} else if len(msg) == 3 {
 		sendNewTopicCard(*a.ctx, a.info.sessionId, a.info.msgId, completions.Content)
 	} else {
 		sendOldTopicCard(*a.ctx, a.info.sessionId, a.info.msgId, completions.Content)
 	}
+	replyVoice(a, completions.Content)
 
 	return false
 }
 
 func (ma *MultimodalAction) handleImageMessage(a *ActionInfo) bool {
 	// 处理图片消息
-	base64, err := downloadAndEncodeImage(a.info.imageKey, a.info.msgId)
-	if err != nil {
-		replyWithErrorMsg(*a.ctx, err, a.info.msgId)
-		return false
-	}
-
-	// 创建多模态消息
-	msg := createVisionMessages("解释这个图片", base64, "high")
-	completions, err := a.handler.gpt.GetVisionInfo(msg)
-	if err != nil {
-		replyWithErrorMsg(*a.ctx, err, a.info.msgId)
-		return false
-	}
-
-	sendVisionTopicCard(*a.ctx, a.info.sessionId, a.info.msgId, completions.Content)
-	return false
+	return replyVisionTurn(a, "解释这个图片", []string{a.info.imageKey},
+		string(services.VisionDetailHigh))
 }
 
 func (ma *MultimodalAction) handlePostMessage(a *ActionInfo) bool {
-	// 处理富文本消息（可能包含文本和图片）
-	var base64s []string
-
-	for _, imageKey := range a.info.imageKeys {
-		if imageKey == "" {
-			continue
-		}
-		base64, err := downloadAndEncodeImage(imageKey, a.info.msgId)
-		if err != nil {
-			replyWithErrorMsg(*a.ctx, err, a.info.msgId)
-			return false
-		}
-		base64s = append(base64s, base64)
-	}
-
-	// 如果没有图片，则作为纯文本处理
-	if len(base64s) == 0 {
+	// 处理富文本消息（可能包含文本和图片），如果没有图片，则作为纯文本处理
+	if !hasImageKey(a.info.imageKeys) {
 		return ma.handleTextMessage(a)
 	}
-
-	// 创建多模态消息
-	msg := createMultipleVisionMessages(a.info.qParsed, base64s, "high")
-	completions, err := a.handler.gpt.GetVisionInfo(msg)
-	if err != nil {
-		replyWithErrorMsg(*a.ctx, err, a.info.msgId)
-		return false
+	prompt := a.info.qParsed
+	if prompt == "" {
+		prompt = "解释这个图片"
 	}
-
-	sendVisionTopicCard(*a.ctx, a.info.sessionId, a.info.msgId, completions.Content)
-	return false
-}
-
-// 发送多模态消息处理中的提示卡片
-func sendMultimodalProcessingCard(ctx context.Context, sessionId *string, msgId *string) {
-	sendSystemCard(ctx, "🤖️：正在处理您的多模态消息，请稍候...", sessionId, msgId)
-}
-
-// 发送多模态消息回复卡片
-func sendMultimodalResponseCard(ctx context.Context, sessionId *string, msgId *string, content string) {
-	sendSystemCard(ctx, fmt.Sprintf("🤖️：%s", content), sessionId, msgId)
+	return replyVisionTurn(a, prompt, a.info.imageKeys,
+		string(services.VisionDetailHigh))
 }
@@ -2,14 +2,17 @@ package handlers
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"os"
+	"io"
 	"start-feishubot/logger"
+	"strings"
 
 	"start-feishubot/initialization"
 	"start-feishubot/services"
 	"start-feishubot/services/openai"
-	"start-feishubot/utils"
+	"start-feishubot/services/usage"
+	"start-feishubot/utils/imaging"
 
 	larkim "github.com/larksuite/oapi-sdk-go/v3/service/im/v1"
 )
@@ -23,14 +26,13 @@ func (*PicAction) Execute(a *ActionInfo) bool {
 		return true
 	}
 	// 开启图片创作模式
-	if _, foundPic := utils.EitherTrimEqual(a.info.qParsed,
-		"/picture", "图片创作"); foundPic {
+	if matchCommand(a, "picture") != nil {
 		a.handler.sessionCache.Clear(*a.info.sessionId)
 		a.handler.sessionCache.SetMode(*a.info.sessionId,
 			services.ModePicCreate)
 		a.handler.sessionCache.SetPicResolution(*a.info.sessionId,
 			services.Resolution1024)
-		sendPicCreateInstructionCard(*a.ctx, a.info.sessionId,
+		sendPicCreateInstructionCard(*a.ctx, a.info.sessionId, cardChatOf(a),
 			a.info.msgId)
 		return false
 	}
@@ -40,71 +42,266 @@ func (*PicAction) Execute(a *ActionInfo) bool {
 	logger.Debug("MODE:", mode)
 	// 收到一张图片,且不在图片创作模式下, 提醒是否切换到图片创作模式
 	if a.info.msgType == "image" && mode != services.ModePicCreate {
-		sendPicModeCheckCard(*a.ctx, a.info.sessionId, a.info.msgId)
+		sendPicModeCheckCard(*a.ctx, a.info.sessionId, cardChatOf(a), a.info.msgId)
+		return false
+	}
+
+	if a.info.msgType == "image" && mode == services.ModePicCreate &&
+		openai.IsGPTImageModel(a.handler.sessionCache.GetPicModel(*a.info.sessionId)) {
+		// gpt-image 模型收到图片后等待编辑指令，可以连续发送多张
+		images := append(a.handler.sessionCache.GetPicEditImages(*a.info.sessionId),
+			services.ImageRef{MsgId: *a.info.msgId, ImageKey: a.info.imageKey})
+		if len(images) > maxEditImages {
+			images = images[len(images)-maxEditImages:]
+		}
+		a.handler.sessionCache.SetPicEditImages(*a.info.sessionId, images)
+		replyMsg(*a.ctx, fmt.Sprintf("🤖️：已收到 %d 张图片，请发送修改说明，"+
+			"例如“去掉背景”。说明中加上 --mask 时最后一张图片作为蒙版", len(images)),
+			a.info.msgId)
 		return false
 	}
 
 	if a.info.msgType == "image" && mode == services.ModePicCreate {
-		//保存图片
-		imageKey := a.info.imageKey
-		//fmt.Printf("fileKey: %s \n", imageKey)
-		msgId := a.info.msgId
-		//fmt.Println("msgId: ", *msgId)
-		req := larkim.NewGetMessageResourceReqBuilder().MessageId(
-			*msgId).FileKey(imageKey).Type("image").Build()
-		resp, err := initialization.GetLarkClient().Im.MessageResource.Get(context.Background(), req)
-		//fmt.Println(resp, err)
+		data, err := downloadImage(*a.info.msgId, a.info.imageKey)
 		if err != nil {
-			//fmt.Println(err)
 			replyMsg(*a.ctx, fmt.Sprintf("🤖️：图片下载失败，请稍后再试～\n 错误信息: %v", err),
 				a.info.msgId)
 			return false
 		}
-
-		f := fmt.Sprintf("%s.png", imageKey)
-		resp.WriteFile(f)
-		defer os.Remove(f)
-		resolution := a.handler.sessionCache.GetPicResolution(*a.
-			info.sessionId)
-
-		openai.ConvertJpegToPNG(f)
-		openai.ConvertToRGBA(f, f)
-
-		//图片校验
-		err = openai.VerifyPngs([]string{f})
+		data, err = imaging.Process(data, imaging.DallE2)
 		if err != nil {
-			replyMsg(*a.ctx, fmt.Sprintf("🤖️：无法解析图片，请发送原图并尝试重新操作～"),
+			replyMsg(*a.ctx, fmt.Sprintf("🤖️：无法解析图片，请发送原图并尝试重新操作～\n错误信息: %v", err),
 				a.info.msgId)
 			return false
 		}
-		bs64, err := a.handler.gpt.GenerateOneImageVariation(f, resolution)
+		// dall-e-2 只支持 256、512 和 1024 的正方形尺寸
+		resolution := a.handler.sessionCache.GetPicResolution(*a.
+			info.sessionId)
+		if !isDallE2Resolution(resolution) {
+			resolution = string(services.Resolution1024)
+		}
+		bs64s, tokenUsage, err := a.handler.gpt.GenerateImageVariationFromData(data,
+			resolution, a.handler.sessionCache.GetPicCount(*a.info.sessionId))
+		if err == nil && len(bs64s) == 0 {
+			err = errors.New("no image returned")
+		}
 		if err != nil {
 			replyMsg(*a.ctx, fmt.Sprintf(
 				"🤖️：图片生成失败，请稍后再试～\n错误信息: %v", err), a.info.msgId)
 			return false
 		}
-		replayImagePlainByBase64(*a.ctx, bs64, a.info.msgId)
+		recordImageUsage(a, tokenUsage)
+		replyImageGalleryByBase64(*a.ctx, bs64s, a.info.msgId,
+			a.info.sessionId, cardChatOf(a), "")
 		return false
 
 	}
 
+	// 图文消息中的图片按文字说明编辑
+	if a.info.msgType == "post" && mode == services.ModePicCreate &&
+		len(a.info.imageKeys) > 0 {
+		var images []services.ImageRef
+		for _, imageKey := range a.info.imageKeys {
+			images = append(images, services.ImageRef{MsgId: *a.info.msgId,
+				ImageKey: imageKey})
+		}
+		return editImages(a, images, a.info.qParsed)
+	}
+
 	// 生成图片
 	if mode == services.ModePicCreate {
+		if images := a.handler.sessionCache.GetPicEditImages(
+			*a.info.sessionId); len(images) > 0 {
+			a.handler.sessionCache.SetPicEditImages(*a.info.sessionId, nil)
+			return editImages(a, images, a.info.qParsed)
+		}
 		resolution := a.handler.sessionCache.GetPicResolution(*a.
 			info.sessionId)
 		style := a.handler.sessionCache.GetPicStyle(*a.
 			info.sessionId)
-		bs64, err := a.handler.gpt.GenerateOneImage(a.info.qParsed,
-			resolution, style)
+		model := a.handler.sessionCache.GetPicModel(*a.info.sessionId)
+		count := a.handler.sessionCache.GetPicCount(*a.info.sessionId)
+		bs64s, tokenUsage, err := a.handler.gpt.GenerateImageWithModel(
+			a.info.qParsed, resolution, count, style, model)
+		if err == nil && len(bs64s) == 0 {
+			err = errors.New("no image returned")
+		}
 		if err != nil {
 			replyMsg(*a.ctx, fmt.Sprintf(
 				"🤖️：图片生成失败，请稍后再试～\n错误信息: %v", err), a.info.msgId)
 			return false
 		}
-		replayImageCardByBase64(*a.ctx, bs64, a.info.msgId, a.info.sessionId,
+		recordImageUsage(a, tokenUsage)
+		replyImageGalleryByBase64(*a.ctx, bs64s, a.info.msgId, a.info.sessionId, cardChatOf(a),
 			a.info.qParsed)
 		return false
 	}
 
 	return true
 }
+
+// maxEditImages gpt-image 模型单次编辑最多接受的图片数
+const maxEditImages = 16
+
+// editImages 按 prompt 编辑图片，prompt 中带 --mask 或“蒙版”时最后一张图片作为蒙版。
+// 选择 gpt-image 模型时支持多张图片，否则使用 dall-e-2 编辑一张图片
+func editImages(a *ActionInfo, refs []services.ImageRef, prompt string) bool {
+	prompt, useMask := cutMaskFlag(prompt)
+	if prompt == "" {
+		replyMsg(*a.ctx, "🤖️：请在图片之外附上修改说明，例如“把天空改成夜晚”",
+			a.info.msgId)
+		return false
+	}
+	if useMask && len(refs) < 2 {
+		replyMsg(*a.ctx, "🤖️：使用蒙版时需要至少两张图片，最后一张作为蒙版",
+			a.info.msgId)
+		return false
+	}
+	model := a.handler.sessionCache.GetPicModel(*a.info.sessionId)
+	if !openai.IsGPTImageModel(model) {
+		model = openai.ImageModelDallE2
+	}
+	if model == openai.ImageModelDallE2 && !useMask {
+		replyMsg(*a.ctx, dallE2EditHint, a.info.msgId)
+		return false
+	}
+
+	var images []openai.ImageFile
+	for i, ref := range refs {
+		data, err := downloadImage(ref.MsgId, ref.ImageKey)
+		if err == nil {
+			data, err = imaging.Process(data, imageOptions(model))
+		}
+		if err != nil {
+			replyMsg(*a.ctx, fmt.Sprintf("🤖️：无法处理第 %d 张图片～\n错误信息: %v",
+				i+1, err), a.info.msgId)
+			return false
+		}
+		images = append(images, openai.ImageFile{
+			Name: fmt.Sprintf("%s.png", ref.ImageKey), Data: data})
+	}
+	request := openai.ImageEditRequestBody{
+		Images: images,
+		Prompt: prompt,
+		Model:  model,
+		Size:   a.handler.sessionCache.GetPicResolution(*a.info.sessionId),
+	}
+	if useMask {
+		request.Mask = &images[len(images)-1]
+		request.Images = images[:len(images)-1]
+		if err := checkMaskSize(request.Images, *request.Mask); err != nil {
+			replyMsg(*a.ctx, fmt.Sprintf("🤖️：%v", err), a.info.msgId)
+			return false
+		}
+	}
+	if !openai.IsGPTImageModel(model) && len(request.Images) > 1 {
+		replyMsg(*a.ctx, "🤖️：dall-e 只能编辑一张图片，编辑多张图片请在图片创作设置中选择 gpt-image-1",
+			a.info.msgId)
+		return false
+	}
+	if !openai.IsGPTImageModel(model) {
+		// dall-e-2 只支持 256、512 和 1024 的正方形尺寸
+		request.Size = string(services.Resolution1024)
+	}
+
+	bs64s, tokenUsage, err := a.handler.gpt.EditImage(request)
+	if err == nil && len(bs64s) == 0 {
+		err = errors.New("no image returned")
+	}
+	if err != nil {
+		replyMsg(*a.ctx, fmt.Sprintf(
+			"🤖️：图片编辑失败，请稍后再试～\n错误信息: %v", err), a.info.msgId)
+		return false
+	}
+	recordImageUsage(a, tokenUsage)
+	replyImageGalleryByBase64(*a.ctx, bs64s, a.info.msgId, a.info.sessionId, cardChatOf(a), "")
+	return false
+}
+
+// dallE2EditHint dall-e-2 只重绘透明区域，没有蒙版时处理后的图片完全不透明，不会有变化
+const dallE2EditHint = "🤖️：dall-e-2 只会重绘蒙版中透明的区域，请发送包含原图和蒙版的图文消息，" +
+	"并在说明中加上 --mask；不使用蒙版时请在图片创作设置中选择 gpt-image-1 模型"
+
+// checkMaskSize 蒙版需要与处理后的每张图片尺寸一致
+func checkMaskSize(images []openai.ImageFile, mask openai.ImageFile) error {
+	maskSize, err := imaging.Size(mask.Data)
+	if err != nil {
+		return fmt.Errorf("无法解析蒙版: %v", err)
+	}
+	for i, image := range images {
+		size, err := imaging.Size(image.Data)
+		if err != nil {
+			return fmt.Errorf("无法解析第 %d 张图片: %v", i+1, err)
+		}
+		if size != maskSize {
+			return fmt.Errorf("蒙版尺寸 %dx%d 与第 %d 张图片的尺寸 %dx%d 不一致，"+
+				"请使用与原图同样大小的蒙版", maskSize.X, maskSize.Y, i+1, size.X, size.Y)
+		}
+	}
+	return nil
+}
+
+// cutMaskFlag 去掉说明中的蒙版标记，返回去掉后的说明和是否使用蒙版
+func cutMaskFlag(prompt string) (string, bool) {
+	useMask := false
+	var fields []string
+	for _, field := range strings.Fields(prompt) {
+		if field == "--mask" || field == "蒙版" {
+			useMask = true
+			continue
+		}
+		fields = append(fields, field)
+	}
+	return strings.Join(fields, " "), useMask
+}
+
+// downloadImage 下载消息中的图片，msgId 为空时下载机器人自己上传的图片
+func downloadImage(msgId string, imageKey string) ([]byte, error) {
+	if msgId == "" {
+		resp, err := initialization.GetLarkClient().Im.Image.Get(
+			context.Background(),
+			larkim.NewGetImageReqBuilder().ImageKey(imageKey).Build())
+		if err != nil {
+			return nil, err
+		}
+		if !resp.Success() {
+			return nil, errors.New(resp.Msg)
+		}
+		return io.ReadAll(resp.File)
+	}
+	req := larkim.NewGetMessageResourceReqBuilder().MessageId(
+		msgId).FileKey(imageKey).Type("image").Build()
+	resp, err := initialization.GetLarkClient().Im.MessageResource.Get(
+		context.Background(), req)
+	if err != nil {
+		return nil, err
+	}
+	if !resp.Success() {
+		return nil, errors.New(resp.Msg)
+	}
+	return io.ReadAll(resp.File)
+}
+
+// imageOptions 返回图片模型要求的输入格式
+func imageOptions(model string) imaging.Options {
+	if openai.IsGPTImageModel(model) {
+		return imaging.GPTImage
+	}
+	return imaging.DallE2
+}
+
+func isDallE2Resolution(resolution string) bool {
+	switch services.Resolution(resolution) {
+	case services.Resolution256, services.Resolution512, services.Resolution1024:
+		return true
+	}
+	return false
+}
+
+// recordImageUsage 记录图片的用量，gpt-image 模型按 token 计费，dall-e 按张数和尺寸计费
+func recordImageUsage(a *ActionInfo, tokenUsage openai.Usage) {
+	if tokenUsage.TotalTokens == 0 && tokenUsage.Images == 0 {
+		return
+	}
+	recordUsage(a, usage.KindImage, tokenUsage)
+}
@@ -0,0 +1,50 @@
+package handlers
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"testing"
+
+	"start-feishubot/services/openai"
+	"start-feishubot/utils/imaging"
+)
+
+func TestCheckMaskSize(t *testing.T) {
+	newImage := func(t *testing.T, w, h int) openai.ImageFile {
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, w, h))); err != nil {
+			t.Fatal(err)
+		}
+		data, err := imaging.Process(buf.Bytes(), imaging.DallE2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return openai.ImageFile{Name: "image.png", Data: data}
+	}
+	tests := []struct {
+		name    string
+		images  [][2]int
+		mask    [2]int
+		wantErr bool
+	}{
+		{"same size", [][2]int{{512, 512}}, [2]int{512, 512}, false},
+		// 处理后都补成 1024 的正方形
+		{"padded to same size", [][2]int{{1024, 600}}, [2]int{600, 1024}, false},
+		{"different size", [][2]int{{512, 512}}, [2]int{256, 256}, true},
+		{"second image differs", [][2]int{{256, 256}, {512, 512}}, [2]int{256, 256}, true},
+	}
+	for _, tt := range tests {
+		var images []openai.ImageFile
+		for _, size := range tt.images {
+			images = append(images, newImage(t, size[0], size[1]))
+		}
+		err := checkMaskSize(images, newImage(t, tt.mask[0], tt.mask[1]))
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: checkMaskSize() = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+	}
+	if err := checkMaskSize(nil, openai.ImageFile{Data: []byte("not an image")}); err == nil {
+		t.Error("checkMaskSize() with invalid mask = nil, want error")
+	}
+}
@@ -0,0 +1,208 @@
+package handlers
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"start-feishubot/initialization"
+	"start-feishubot/logger"
+	"start-feishubot/services/openai"
+	"start-feishubot/services/usage"
+)
+
+type QuotaAction struct { /*额度*/
+}
+
+func (*QuotaAction) Execute(a *ActionInfo) bool {
+	if in := matchCommand(a, "quota"); in != nil {
+		processQuotaCommand(a, in.Arg("op"),
+			strings.Fields(stripMentions(in.Arg("target"), a.info.mentions)))
+		return false
+	}
+
+	// 管理员和基础指令不受额度限制
+	if isAdmin(a) || isFreeCommand(a.info) {
+		return true
+	}
+	exceeded := a.handler.quota.Check(a.info.userId, *a.info.chatId,
+		a.info.handlerType == GroupHandler, time.Now())
+	if exceeded == nil {
+		return true
+	}
+	logger.Warnf("quota exceeded, user: %s, chat: %s, scope: %s, period: %s",
+		a.info.userId, *a.info.chatId, exceeded.Scope, exceeded.Period)
+	sendQuotaExceededCard(*a.ctx, a.info.msgId, exceeded)
+	return false
+}
+
+// processQuotaCommand 处理额度指令:
+//
+//	/quota                          查看自己的额度
+//	/quota set @用户 每日/每月token  管理员为用户单独设置额度
+//	/quota reset @用户               管理员取消用户的单独额度
+func processQuotaCommand(a *ActionInfo, op string, args []string) {
+	if op == "" {
+		sendQuotaCard(*a.ctx, a.info.msgId, quotaStatus(a))
+		return
+	}
+
+	target, args := quotaTarget(a, args)
+	if target == "" {
+		replyMsg(*a.ctx, "🤖️：请 @ 需要调整额度的用户，或者填写用户的 open_id", a.info.msgId)
+		return
+	}
+	switch op {
+	case "set":
+		if len(args) == 0 {
+			replyMsg(*a.ctx, "🤖️：请填写额度，格式为 每日token/每月token，例如 100000/3000000", a.info.msgId)
+			return
+		}
+		_, quota, err := usage.ParseOverride(target + "=" + args[0])
+		if err != nil {
+			replyMsg(*a.ctx, fmt.Sprintf("🤖️：额度格式错误～\n错误信息: %v", err), a.info.msgId)
+			return
+		}
+		if err := a.handler.quota.SetOverride(target, quota); err != nil {
+			logger.Errorf("save quota override failed: %v", err)
+		}
+		replyMsg(*a.ctx, fmt.Sprintf("🤖️：已将 %s 的额度调整为 每日 %s / 每月 %s",
+			target, formatTokenLimit(quota.Daily.Tokens),
+			formatTokenLimit(quota.Monthly.Tokens)), a.info.msgId)
+	case "reset":
+		if err := a.handler.quota.ClearOverride(target); err != nil {
+			logger.Errorf("save quota override failed: %v", err)
+		}
+		replyMsg(*a.ctx, fmt.Sprintf("🤖️：已恢复 %s 的默认额度", target), a.info.msgId)
+	}
+}
+
+// quotaTarget 从参数或 @ 列表中找到被操作的用户
+func quotaTarget(a *ActionInfo, args []string) (string, []string) {
+	if len(args) > 0 && strings.HasPrefix(args[0], "ou_") {
+		return args[0], args[1:]
+	}
+	for _, user := range a.info.mentions {
+		if user.OpenId != "" {
+			return user.OpenId, args
+		}
+	}
+	return "", args
+}
+
+// QuotaLine 额度卡片中的一行
+type QuotaLine struct {
+	Title string
+	Used  usage.Total
+	Limit usage.Limit
+}
+
+func quotaStatus(a *ActionInfo) []QuotaLine {
+	now := time.Now()
+	q := a.handler.quota
+	userQuota, overridden := q.UserQuota(a.info.userId)
+	userTitle := "个人"
+	if overridden {
+		userTitle = "个人（单独设置）"
+	}
+	userFilter := usage.Filter{UserId: a.info.userId}
+	lines := []QuotaLine{
+		{userTitle + "今日", q.Used(userFilter, usage.PeriodDay, now), userQuota.Daily},
+		{userTitle + "本月", q.Used(userFilter, usage.PeriodMonth, now), userQuota.Monthly},
+	}
+	if a.info.handlerType == GroupHandler {
+		groupFilter := usage.Filter{ChatId: *a.info.chatId}
+		groupQuota := q.Config().Group
+		lines = append(lines,
+			QuotaLine{"本群今日", q.Used(groupFilter, usage.PeriodDay, now), groupQuota.Daily},
+			QuotaLine{"本群本月", q.Used(groupFilter, usage.PeriodMonth, now), groupQuota.Monthly})
+	}
+	return lines
+}
+
+func newQuotaManager(store usage.StoreInterface,
+	config initialization.Config) *usage.QuotaManager {
+	overrides := make(map[string]usage.Quota)
+	for _, override := range config.QuotaOverrides {
+		userId, userQuota, err := usage.ParseOverride(override)
+		if err != nil {
+			logger.Warnf("skip quota override: %v", err)
+			continue
+		}
+		overrides[userId] = userQuota
+	}
+	quota := usage.NewQuotaManager(store, usage.QuotaConfig{
+		User: usage.Quota{
+			Daily:   usage.Limit{Tokens: config.QuotaUserDailyTokens, Cost: config.QuotaUserDailyCost},
+			Monthly: usage.Limit{Tokens: config.QuotaUserMonthlyTokens, Cost: config.QuotaUserMonthlyCost},
+		},
+		Group: usage.Quota{
+			Daily:   usage.Limit{Tokens: config.QuotaGroupDailyTokens, Cost: config.QuotaGroupDailyCost},
+			Monthly: usage.Limit{Tokens: config.QuotaGroupMonthlyTokens, Cost: config.QuotaGroupMonthlyCost},
+		},
+		Global: usage.Quota{
+			Daily:   usage.Limit{Tokens: config.QuotaGlobalDailyTokens, Cost: config.QuotaGlobalDailyCost},
+			Monthly: usage.Limit{Tokens: config.QuotaGlobalMonthlyTokens, Cost: config.QuotaGlobalMonthlyCost},
+		},
+		Overrides: overrides,
+	})
+	if err := quota.LoadOverrides(config.QuotaOverridesFile); err != nil {
+		logger.Errorf("load quota overrides from %s failed: %v",
+			config.QuotaOverridesFile, err)
+	}
+	return quota
+}
+
+// recordUsage 记录一次模型调用的用量，供额度和账单统计使用
+func recordUsage(a *ActionInfo, kind usage.Kind, u openai.Usage) {
+	a.handler.addUsage(a.info.userId, *a.info.chatId, string(a.info.handlerType),
+		kind, u)
+}
+
+// addUsage 记录一次调用的用量，卡片按钮没有 ActionInfo 时直接调用
+func (m MessageHandler) addUsage(userId, chatId, chatType string,
+	kind usage.Kind, u openai.Usage) {
+	cost := usage.Cost(m.prices, u.Model, u.PromptTokens, u.CompletionTokens)
+	if u.Characters > 0 {
+		// 按字符计费的价格同样以每百万计
+		cost += usage.Cost(m.prices, u.Model, u.Characters, 0)
+	}
+	if u.Images > 0 {
+		cost += usage.ImageCost(m.prices, u.Model, u.Size, u.Images)
+	}
+	if u.Seconds > 0 {
+		cost += usage.AudioCost(m.prices, u.Model, u.Seconds)
+	}
+	record := usage.Record{
+		Time:             time.Now(),
+		UserId:           userId,
+		ChatId:           chatId,
+		ChatType:         chatType,
+		Kind:             kind,
+		Model:            u.Model,
+		PromptTokens:     u.PromptTokens,
+		CompletionTokens: u.CompletionTokens,
+		Images:           u.Images,
+		Cost:             cost,
+	}
+	if err := m.usageStore.Add(record); err != nil {
+		logger.Errorf("record usage failed: %v", err)
+	}
+}
+
+func formatTokenLimit(tokens int) string {
+	if tokens <= 0 {
+		return "不限"
+	}
+	return fmt.Sprintf("%d", tokens)
+}
+
+func formatQuotaUsage(used usage.Total, limit usage.Limit) string {
+	text := fmt.Sprintf("%d / %s tokens", used.Tokens, formatTokenLimit(limit.Tokens))
+	if limit.Cost > 0 {
+		text += fmt.Sprintf("，%.4f$ / %.2f$", used.Cost, limit.Cost)
+	} else {
+		text += fmt.Sprintf("，%.4f$", used.Cost)
+	}
+	return text
+}
@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"context"
+	"math"
+	"testing"
+
+	"start-feishubot/services/openai"
+	"start-feishubot/services/usage"
+)
+
+// testActionInfo 只带用量记录和额度的 ActionInfo，不会调用飞书和 OpenAI 接口
+func testActionInfo(t *testing.T, userId, chatId string,
+	handlerType HandlerType) (*ActionInfo, *usage.Store) {
+	store, err := usage.NewStore("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	return &ActionInfo{
+		ctx: &ctx,
+		handler: &MessageHandler{
+			usageStore: store,
+			quota:      usage.NewQuotaManager(store, usage.QuotaConfig{}),
+			prices:     usage.DefaultPrices,
+		},
+		info: &MsgInfo{
+			handlerType: handlerType,
+			userId:      userId,
+			chatId:      &chatId,
+		},
+	}, store
+}
+
+func TestRecordUsage(t *testing.T) {
+	tests := []struct {
+		kind   usage.Kind
+		usage  openai.Usage
+		tokens int
+		cost   float64
+	}{
+		{usage.KindChat, openai.Usage{Model: "gpt-4o", PromptTokens: 1000000,
+			CompletionTokens: 100000}, 1100000, 3.5},
+		// 语音合成的字符数只计入花费
+		{usage.KindAudio, openai.Usage{Model: "tts-1", Characters: 1000}, 0, 0.015},
+		// dall-e 按张数和尺寸计费
+		{usage.KindImage, openai.Usage{Model: "dall-e-3", Images: 2,
+			Size: "1024x1792"}, 0, 0.16},
+		// 语音转文字按时长计费
+		{usage.KindAudio, openai.Usage{Model: "whisper-1", Seconds: 90}, 0, 0.009},
+		{usage.KindChat, openai.Usage{Model: "unknown", PromptTokens: 10}, 10, 0},
+	}
+	for _, tt := range tests {
+		a, store := testActionInfo(t, "ou_a", "oc_a", GroupHandler)
+		recordUsage(a, tt.kind, tt.usage)
+		records := store.Query(usage.Filter{})
+		if len(records) != 1 {
+			t.Fatalf("recordUsage(%s) stored %d records", tt.usage.Model, len(records))
+		}
+		r := records[0]
+		if r.Tokens() != tt.tokens || math.Abs(r.Cost-tt.cost) > 1e-9 ||
+			r.Kind != tt.kind || r.Images != tt.usage.Images || r.UserId != "ou_a" ||
+			r.ChatType != string(GroupHandler) {
+			t.Errorf("recordUsage(%s) = %+v, want %d tokens, %v$", tt.usage.Model, r,
+				tt.tokens, tt.cost)
+		}
+	}
+}
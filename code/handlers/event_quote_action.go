@@ -0,0 +1,136 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"start-feishubot/logger"
+	"start-feishubot/services"
+	"start-feishubot/services/access"
+	"start-feishubot/utils/document"
+
+	larkim "github.com/larksuite/oapi-sdk-go/v3/service/im/v1"
+)
+
+// quotedMessage 用户回复的消息
+type quotedMessage struct {
+	msgId     string
+	msgType   string
+	sender    string
+	text      string
+	imageKeys []string
+}
+
+type QuoteAction struct { /*引用消息*/
+}
+
+// Execute 用户回复一条消息并提问时，把被回复的消息加入提问，
+// 这样可以针对群里的任意一条消息提问。引用中有图片时按图片推理回答
+func (*QuoteAction) Execute(a *ActionInfo) bool {
+	if a.info.parentId == "" {
+		return true
+	}
+	if in, _ := commandOf(a.info); in != nil {
+		return true
+	}
+	// 回复话题的第一条消息是在话题中继续提问，不是引用；已有对话时它也已经在上下文中
+	if a.info.parentId == *a.info.sessionId {
+		return true
+	}
+	history := len(a.handler.sessionCache.GetMsg(*a.info.sessionId)) > 0
+	msg, err := getMessage(a.info.parentId)
+	if err != nil {
+		logger.Warnf("get quoted message %s failed: %v", a.info.parentId, err)
+		return true
+	}
+	// 引用机器人自己的回答时，回答已经在上下文中
+	if history && msg.Sender != nil && msg.Sender.SenderType != nil &&
+		*msg.Sender.SenderType == "app" {
+		return true
+	}
+	quote := newQuotedMessage(a.info.parentId, msg)
+	applyQuote(a, quote)
+	if len(quote.imageKeys) == 0 || !AzureModeCheck(a) {
+		return true
+	}
+	// 没有图片推理权限时只使用引用中的文字
+	if !a.handler.access.Check(access.CapVision, accessSubject(a)).Allowed {
+		return true
+	}
+
+	switch a.handler.sessionCache.GetMode(*a.info.sessionId) {
+	case services.ModeGPT:
+		return replyVisionTurn(a, a.info.qParsed, a.info.imageKeys,
+			string(services.VisionDetailHigh))
+	case services.ModeVision:
+		return replyVisionTurn(a, a.info.qParsed, a.info.imageKeys,
+			a.handler.sessionCache.GetVisionDetail(*a.info.sessionId))
+	}
+	return true
+}
+
+// applyQuote 记录引用的消息并把它加入提问。图片创作模式下提问就是图片描述，
+// 加入引用会改变生成的图片，只记录不修改
+func applyQuote(a *ActionInfo, quote *quotedMessage) {
+	a.info.quote = quote
+	if a.handler.sessionCache.GetMode(*a.info.sessionId) == services.ModePicCreate {
+		return
+	}
+	a.info.qParsed = quotePrompt(quote, a.info.qParsed)
+}
+
+func newQuotedMessage(msgId string, msg *larkim.Message) *quotedMessage {
+	text, imageKeys := messageText(msg)
+	quote := &quotedMessage{
+		msgId:     msgId,
+		msgType:   *msg.MsgType,
+		sender:    senderName(msg.Sender),
+		text:      text,
+		imageKeys: imageKeys,
+	}
+	if quote.msgType == "file" {
+		if content := quotedDocument(msgId, msg); content != "" {
+			quote.text += "\n" + content
+		}
+	}
+	return quote
+}
+
+// quotedDocument 引用的文件是支持的文档时，提取开头的文字
+func quotedDocument(msgId string, msg *larkim.Message) string {
+	content := *msg.Body.Content
+	fileName := parseFileName(content)
+	if !document.IsSupported(fileName) {
+		return ""
+	}
+	data, err := downloadMessageFile(msgId, parseFileKey(content), maxDocumentSize)
+	if errors.Is(err, errFileTooLarge) {
+		return ""
+	}
+	if err != nil {
+		logger.Warnf("download quoted document %s failed: %v", fileName, err)
+		return ""
+	}
+	text, err := document.Extract(fileName, data)
+	if err != nil {
+		logger.Warnf("extract quoted document %s failed: %v", fileName, err)
+		return ""
+	}
+	if runes := []rune(strings.TrimSpace(text)); len(runes) > documentContextRunes {
+		return string(runes[:documentContextRunes]) + "\n（文档较长，只保留了开头部分）"
+	}
+	return strings.TrimSpace(text)
+}
+
+// quotePrompt 把引用的消息放在问题前面，只 @ 机器人没有提问时默认请求解释
+func quotePrompt(quote *quotedMessage, question string) string {
+	if strings.TrimSpace(question) == "" {
+		question = "请解释这条消息"
+	}
+	header := "引用的消息："
+	if quote.sender != "" {
+		header = fmt.Sprintf("引用 %s 的消息：", quote.sender)
+	}
+	return header + "\n" + quoteLines(quote.text) + "\n\n" + question
+}
@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"start-feishubot/services"
+
+	larkim "github.com/larksuite/oapi-sdk-go/v3/service/im/v1"
+)
+
+func testMessage(msgType, content string, mentions ...*larkim.Mention) *larkim.Message {
+	return &larkim.Message{
+		MsgType:  &msgType,
+		Body:     &larkim.MessageBody{Content: &content},
+		Mentions: mentions,
+	}
+}
+
+func TestMessageText(t *testing.T) {
+	key, id, name := "@_user_1", "ou_alice", "小明"
+	tests := []struct {
+		msg    *larkim.Message
+		text   string
+		images []string
+	}{
+		{testMessage("text", `{"text":"@_user_1 明天开会"}`,
+			&larkim.Mention{Key: &key, Id: &id, Name: &name}),
+			"@小明 明天开会", nil},
+		{testMessage("post", `{"title":"周报","content":[[{"tag":"text","text":"进度"}],`+
+			`[{"tag":"img","image_key":"img_1"}]]}`),
+			"**周报**\n进度", []string{"img_1"}},
+		{testMessage("image", `{"image_key":"img_2"}`), "[图片]", []string{"img_2"}},
+		{testMessage("file", `{"file_key":"f","file_name":"a.pdf"}`), "[文件 a.pdf]", nil},
+		{testMessage("sticker", `{"file_key":"s"}`), "[表情]", nil},
+		{testMessage("share_user", `{"user_id":"ou_x"}`), "[个人名片]", nil},
+		{testMessage("interactive", `{"title":"审批","elements":[[{"tag":"text","text":"请假 3 天"}]]}`),
+			"审批\n请假 3 天", nil},
+		{testMessage("interactive", `{"elements":[]}`), "[卡片]", nil},
+		{testMessage("location", `{}`), "[location 消息]", nil},
+	}
+	for _, tt := range tests {
+		text, images := messageText(tt.msg)
+		if text != tt.text || !reflect.DeepEqual(images, tt.images) {
+			t.Errorf("messageText(%s) = %q, %v, want %q, %v", *tt.msg.MsgType,
+				text, images, tt.text, tt.images)
+		}
+	}
+}
+
+func TestQuotePrompt(t *testing.T) {
+	tests := []struct {
+		quote    quotedMessage
+		question string
+		want     string
+	}{
+		{quotedMessage{sender: "小明", text: "第一行\n第二行"}, "这是什么意思？",
+			"引用 小明 的消息：\n> 第一行\n> 第二行\n\n这是什么意思？"},
+		{quotedMessage{text: "[图片]"}, " ",
+			"引用的消息：\n> [图片]\n\n请解释这条消息"},
+	}
+	for _, tt := range tests {
+		if got := quotePrompt(&tt.quote, tt.question); got != tt.want {
+			t.Errorf("quotePrompt(%q) = %q, want %q", tt.question, got, tt.want)
+		}
+	}
+}
+
+// testQuoteAction 回复 parentId 的消息，不会调用飞书接口
+func testQuoteAction(sessionId, parentId, question string,
+	mode services.SessionMode) *ActionInfo {
+	cache := services.GetSessionCache()
+	cache.Clear(sessionId)
+	cache.SetMode(sessionId, mode)
+	ctx := context.Background()
+	msgId, chatId := "om_reply", "oc_a"
+	return &ActionInfo{
+		ctx:     &ctx,
+		handler: &MessageHandler{sessionCache: cache},
+		info: &MsgInfo{
+			handlerType: GroupHandler,
+			msgType:     "text",
+			msgId:       &msgId,
+			chatId:      &chatId,
+			sessionId:   &sessionId,
+			parentId:    parentId,
+			qParsed:     question,
+		},
+	}
+}
+
+func TestQuoteActionSkipsThreadRoot(t *testing.T) {
+	// 没有对话历史（例如图片创作模式）时回复话题的第一条消息也不是引用
+	for _, mode := range []services.SessionMode{services.ModePicCreate, services.ModeGPT} {
+		a := testQuoteAction("om_quote_root", "om_quote_root", "一只猫", mode)
+		if !(&QuoteAction{}).Execute(a) {
+			t.Errorf("%s: Execute() = false", mode)
+		}
+		if a.info.qParsed != "一只猫" || a.info.quote != nil {
+			t.Errorf("%s: qParsed = %q, quote = %v", mode, a.info.qParsed, a.info.quote)
+		}
+	}
+}
+
+func TestApplyQuote(t *testing.T) {
+	quote := &quotedMessage{msgId: "om_quoted", sender: "小明", text: "明天开会"}
+	tests := []struct {
+		mode services.SessionMode
+		want string
+	}{
+		{services.ModeGPT, "引用 小明 的消息：\n> 明天开会\n\n几点？"},
+		{services.ModeVision, "引用 小明 的消息：\n> 明天开会\n\n几点？"},
+		// 图片创作的提问就是图片描述，不加入引用
+		{services.ModePicCreate, "几点？"},
+	}
+	for _, tt := range tests {
+		a := testQuoteAction("om_quote_session", "om_quoted", "几点？", tt.mode)
+		applyQuote(a, quote)
+		if a.info.qParsed != tt.want || a.info.quote != quote {
+			t.Errorf("%s: qParsed = %q, want %q", tt.mode, a.info.qParsed, tt.want)
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package handlers
+
+import (
+	"time"
+
+	"start-feishubot/initialization"
+	"start-feishubot/logger"
+	"start-feishubot/services/ratelimit"
+)
+
+type RateLimitAction struct { /*请求频率限制*/
+}
+
+func (*RateLimitAction) Execute(a *ActionInfo) bool {
+	if isAdmin(a) {
+		return true
+	}
+	denied := a.handler.limiter.Allow(a.info.userId, *a.info.chatId,
+		a.info.handlerType == GroupHandler, time.Now())
+	if denied == nil {
+		return true
+	}
+	logger.Warnf("rate limited, user: %s, chat: %s, scope: %s, retry at: %s",
+		a.info.userId, *a.info.chatId, denied.Scope,
+		denied.RetryAt.Format("15:04:05"))
+	go sendRateLimitedCard(*a.ctx, a.info.msgId, denied, time.Now())
+	return false
+}
+
+func newRateLimiter(config initialization.Config) *ratelimit.Limiter {
+	parse := func(key string, value string) ratelimit.Rate {
+		rate, err := ratelimit.ParseRate(value)
+		if err != nil {
+			logger.Warnf("ignore %s: %v", key, err)
+		}
+		return rate
+	}
+	return ratelimit.NewLimiter(ratelimit.Config{
+		User:   parse("RATE_LIMIT_USER", config.RateLimitUser),
+		Chat:   parse("RATE_LIMIT_CHAT", config.RateLimitChat),
+		Global: parse("RATE_LIMIT_GLOBAL", config.RateLimitGlobal),
+	})
+}
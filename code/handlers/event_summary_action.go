@@ -0,0 +1,388 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"start-feishubot/initialization"
+	"start-feishubot/services/openai"
+	"start-feishubot/services/usage"
+
+	larkcard "github.com/larksuite/oapi-sdk-go/v3/card"
+	larkcore "github.com/larksuite/oapi-sdk-go/v3/core"
+	larkim "github.com/larksuite/oapi-sdk-go/v3/service/im/v1"
+)
+
+const (
+	// defaultSummaryWindow 不填写范围时总结的时长
+	defaultSummaryWindow = 24 * time.Hour
+	// maxSummaryWindow 最多可以总结的时长
+	maxSummaryWindow = 7 * 24 * time.Hour
+	// maxSummaryMessages 一次最多总结的消息条数
+	maxSummaryMessages = 500
+	// summaryPageSize 查询历史消息时每页的条数，接口上限为 50
+	summaryPageSize = 50
+	// maxChatSummaryInput 提交给模型的聊天记录字数，超出时只保留最近的部分
+	maxChatSummaryInput = 20000
+)
+
+const chatSummaryPrompt = "你是一名群聊助理。下面是一段群聊记录，每行的格式为 [时间] 姓名：内容。" +
+	"请用聊天记录所用的语言总结，只输出 JSON，不要输出其他内容，格式为：\n" +
+	`{"overview":"一两句话概括讨论了什么",` +
+	`"decisions":["已经达成的决定"],` +
+	`"action_items":[{"owner":"负责人姓名，没有明确负责人时留空","task":"待办事项","due":"截止时间，没有则留空"}],` +
+	`"questions":["还没有结论的问题"]}` + "\n" +
+	"没有对应内容的字段返回空数组，不要编造聊天记录中没有的信息。"
+
+// summaryRange 总结的范围，count 大于 0 时按条数，否则按时长
+type summaryRange struct {
+	window time.Duration
+	count  int
+}
+
+// parseSummaryRange 解析范围参数：纯数字为条数，其余为时长，例如 30m、2h、3d
+func parseSummaryRange(s string) (summaryRange, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if s == "" {
+		return summaryRange{window: defaultSummaryWindow}, nil
+	}
+	if n, err := strconv.Atoi(s); err == nil {
+		if n <= 0 || n > maxSummaryMessages {
+			return summaryRange{}, fmt.Errorf("条数需要在 1 到 %d 之间", maxSummaryMessages)
+		}
+		return summaryRange{count: n}, nil
+	}
+	var window time.Duration
+	var err error
+	if strings.HasSuffix(s, "d") {
+		var days int
+		days, err = strconv.Atoi(strings.TrimSuffix(s, "d"))
+		window = time.Duration(days) * 24 * time.Hour
+	} else {
+		window, err = time.ParseDuration(s)
+	}
+	if err != nil {
+		return summaryRange{}, fmt.Errorf("无法识别 %s，请填写时长（例如 2h、3d）或条数（例如 100）", s)
+	}
+	if window <= 0 || window > maxSummaryWindow {
+		return summaryRange{}, errors.New("时长需要大于 0，最多 7 天")
+	}
+	return summaryRange{window: window}, nil
+}
+
+func (r summaryRange) String() string {
+	if r.count > 0 {
+		return fmt.Sprintf("最近 %d 条消息", r.count)
+	}
+	if r.window%(24*time.Hour) == 0 {
+		return fmt.Sprintf("最近 %d 天", r.window/(24*time.Hour))
+	}
+	if r.window%time.Hour == 0 {
+		return fmt.Sprintf("最近 %d 小时", r.window/time.Hour)
+	}
+	return fmt.Sprintf("最近 %d 分钟", r.window/time.Minute)
+}
+
+type SummaryAction struct { /*群聊总结*/
+}
+
+// Execute 总结群聊消息:
+//
+//	/summary        最近 24 小时
+//	/summary 2h     最近 2 小时，支持 m、h、d
+//	/summary 100    最近 100 条消息
+func (*SummaryAction) Execute(a *ActionInfo) bool {
+	in := matchCommand(a, "summary")
+	if in == nil {
+		return true
+	}
+	r, err := parseSummaryRange(in.Arg("range"))
+	if err != nil {
+		if !in.Slash {
+			// “群聊总结 xxx” 不是范围时当作普通对话
+			return true
+		}
+		replyMsg(*a.ctx, "🤖️："+err.Error(), a.info.msgId)
+		return false
+	}
+	if a.info.handlerType != GroupHandler {
+		replyMsg(*a.ctx, "🤖️：请在群聊中使用群聊总结", a.info.msgId)
+		return false
+	}
+
+	messages, err := listChatMessages(*a.ctx, *a.info.chatId, r, time.Now(),
+		*a.info.msgId)
+	if err != nil {
+		replyMsg(*a.ctx, fmt.Sprintf("🤖️：获取群聊记录失败，请确认机器人有读取群消息的权限～\n错误信息: %v",
+			err), a.info.msgId)
+		return false
+	}
+	if len(messages) == 0 {
+		replyMsg(*a.ctx, fmt.Sprintf("🤖️：%s没有可以总结的消息", r), a.info.msgId)
+		return false
+	}
+
+	lines := chatLines(messages)
+	content, tokenUsage, err := summarizeChat(a, formatChatLines(lines))
+	if err != nil {
+		replyMsg(*a.ctx, fmt.Sprintf("🤖️：总结失败，请稍后再试～\n错误信息: %v", err),
+			a.info.msgId)
+		return false
+	}
+	recordUsage(a, usage.KindChat, tokenUsage)
+	summary, err := parseChatSummary(content)
+	if err != nil {
+		// 模型没有按格式返回时直接展示原文
+		summary = chatSummary{Overview: content}
+	}
+	sendChatSummaryCard(*a.ctx, a.info.msgId, r, lines, summary)
+	return false
+}
+
+type listMessagesResp struct {
+	larkcore.CodeError
+	Data *larkim.ListMessageRespData `json:"data"`
+}
+
+// listChatMessages 从新到旧翻页查询群聊消息，按时间顺序返回。
+// 跳过已撤回的消息、机器人发送的消息和本次的总结指令
+func listChatMessages(ctx context.Context, chatId string, r summaryRange,
+	now time.Time, skipMsgId string) ([]*larkim.Message, error) {
+	limit := maxSummaryMessages
+	if r.count > 0 {
+		limit = r.count
+	}
+	since := now.Add(-maxSummaryWindow)
+	if r.count == 0 {
+		since = now.Add(-r.window)
+	}
+
+	var messages []*larkim.Message
+	pageToken := ""
+	for len(messages) < limit {
+		query := larkcore.QueryParams{}
+		query.Set("container_id_type", "chat")
+		query.Set("container_id", chatId)
+		query.Set("start_time", strconv.FormatInt(since.Unix(), 10))
+		query.Set("end_time", strconv.FormatInt(now.Unix(), 10))
+		// SDK 的请求构造器不支持排序，直接按倒序请求，方便按条数截取最近的消息
+		query.Set("sort_type", "ByCreateTimeDesc")
+		query.Set("page_size", strconv.Itoa(summaryPageSize))
+		if pageToken != "" {
+			query.Set("page_token", pageToken)
+		}
+		resp, err := initialization.GetLarkClient().Do(ctx, &larkcore.ApiReq{
+			HttpMethod:                http.MethodGet,
+			ApiPath:                   "/open-apis/im/v1/messages",
+			QueryParams:               query,
+			SupportedAccessTokenTypes: []larkcore.AccessTokenType{larkcore.AccessTokenTypeTenant},
+		})
+		if err != nil {
+			return nil, err
+		}
+		var result listMessagesResp
+		if err = json.Unmarshal(resp.RawBody, &result); err != nil {
+			return nil, err
+		}
+		if result.Code != 0 {
+			return nil, errors.New(result.Msg)
+		}
+		if result.Data == nil {
+			break
+		}
+		for _, msg := range result.Data.Items {
+			if summarizable(msg, skipMsgId) {
+				messages = append(messages, msg)
+			}
+			if len(messages) >= limit {
+				break
+			}
+		}
+		if result.Data.HasMore == nil || !*result.Data.HasMore ||
+			result.Data.PageToken == nil {
+			break
+		}
+		pageToken = *result.Data.PageToken
+	}
+	for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+		messages[i], messages[j] = messages[j], messages[i]
+	}
+	return messages, nil
+}
+
+func summarizable(msg *larkim.Message, skipMsgId string) bool {
+	if msg.MessageId == nil || *msg.MessageId == skipMsgId ||
+		msg.MsgType == nil || msg.Body == nil || msg.Body.Content == nil {
+		return false
+	}
+	if msg.Deleted != nil && *msg.Deleted {
+		return false
+	}
+	return msg.Sender == nil || msg.Sender.SenderType == nil ||
+		*msg.Sender.SenderType != "app"
+}
+
+// chatLine 聊天记录中的一条消息
+type chatLine struct {
+	time   time.Time
+	sender string
+	text   string
+}
+
+// chatLines 把消息转成聊天记录。发送者的姓名优先使用其他消息中 @ 该用户时的姓名，
+// 没有时查询通讯录，仍然查询不到时按出现顺序编号
+func chatLines(messages []*larkim.Message) []chatLine {
+	names := make(map[string]string)
+	for _, msg := range messages {
+		for _, user := range messageMentions(msg.Mentions) {
+			if user.OpenId != "" && user.Name != "" {
+				names[user.OpenId] = user.Name
+			}
+		}
+	}
+	unknown := 0
+	lines := make([]chatLine, 0, len(messages))
+	for _, msg := range messages {
+		var senderId string
+		if msg.Sender != nil && msg.Sender.Id != nil {
+			senderId = *msg.Sender.Id
+		}
+		name, ok := names[senderId]
+		if !ok {
+			if name = senderName(msg.Sender); name == "" {
+				unknown++
+				name = fmt.Sprintf("成员%d", unknown)
+			}
+			names[senderId] = name
+		}
+		text, _ := messageText(msg)
+		lines = append(lines, chatLine{
+			time:   messageTime(msg),
+			sender: name,
+			text:   strings.TrimSpace(text),
+		})
+	}
+	return lines
+}
+
+// messageTime 消息的发送时间，create_time 为毫秒时间戳
+func messageTime(msg *larkim.Message) time.Time {
+	if msg.CreateTime == nil {
+		return time.Time{}
+	}
+	ms, err := strconv.ParseInt(*msg.CreateTime, 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	return time.UnixMilli(ms)
+}
+
+// formatChatLines 把聊天记录格式化为提交给模型的文字，过长时只保留最近的部分
+func formatChatLines(lines []chatLine) string {
+	var b strings.Builder
+	for _, line := range lines {
+		text := strings.Join(strings.Fields(strings.ReplaceAll(line.text, "\n", " ")), " ")
+		fmt.Fprintf(&b, "[%s] %s：%s\n", line.time.Format("01-02 15:04"),
+			line.sender, text)
+	}
+	text := b.String()
+	if runes := []rune(text); len(runes) > maxChatSummaryInput {
+		text = "（更早的消息已省略）\n" + string(runes[len(runes)-maxChatSummaryInput:])
+	}
+	return text
+}
+
+func summarizeChat(a *ActionInfo, text string) (string, openai.Usage, error) {
+	msg := []openai.Messages{
+		{Role: "system", Content: chatSummaryPrompt},
+		{Role: "user", Content: text},
+	}
+	completions, tokenUsage, err := a.handler.gpt.CompletionsWithUsage(msg,
+		openai.Fresh)
+	if err != nil {
+		return "", tokenUsage, err
+	}
+	return completions.Content, tokenUsage, nil
+}
+
+// chatSummary 模型返回的结构化总结
+type chatSummary struct {
+	Overview    string           `json:"overview"`
+	Decisions   []string         `json:"decisions"`
+	ActionItems []chatActionItem `json:"action_items"`
+	Questions   []string         `json:"questions"`
+}
+
+type chatActionItem struct {
+	Owner string `json:"owner"`
+	Task  string `json:"task"`
+	Due   string `json:"due"`
+}
+
+// parseChatSummary 解析模型返回的 JSON，兼容包在代码块中的情况
+func parseChatSummary(content string) (chatSummary, error) {
+	start := strings.Index(content, "{")
+	end := strings.LastIndex(content, "}")
+	if start < 0 || end < start {
+		return chatSummary{}, errors.New("no json object in summary")
+	}
+	var summary chatSummary
+	if err := json.Unmarshal([]byte(content[start:end+1]), &summary); err != nil {
+		return chatSummary{}, err
+	}
+	return summary, nil
+}
+
+// formatActionItem 待办事项的一行，负责人和截止时间可以为空
+func formatActionItem(item chatActionItem) string {
+	line := "- "
+	if item.Owner != "" {
+		line += fmt.Sprintf("**%s**：", item.Owner)
+	}
+	line += item.Task
+	if item.Due != "" {
+		line += fmt.Sprintf("（截止 %s）", item.Due)
+	}
+	return line
+}
+
+func formatSummaryList(items []string) string {
+	if len(items) == 0 {
+		return "无"
+	}
+	lines := make([]string, len(items))
+	for i, item := range items {
+		lines[i] = "- " + item
+	}
+	return strings.Join(lines, "\n")
+}
+
+func sendChatSummaryCard(ctx context.Context, msgId *string, r summaryRange,
+	lines []chatLine, summary chatSummary) {
+	actionItems := "无"
+	if len(summary.ActionItems) > 0 {
+		items := make([]string, len(summary.ActionItems))
+		for i, item := range summary.ActionItems {
+			items[i] = formatActionItem(item)
+		}
+		actionItems = strings.Join(items, "\n")
+	}
+	newCard, _ := newSendCard(
+		withHeader("🧾 群聊总结", larkcard.TemplateTurquoise),
+		withMainMd(summary.Overview),
+		withSplitLine(),
+		withMainMd("**✅ 决定**\n"+formatSummaryList(summary.Decisions)),
+		withMainMd("**📋 待办**\n"+actionItems),
+		withMainMd("**❓ 待解决的问题**\n"+formatSummaryList(summary.Questions)),
+		withNote(fmt.Sprintf("%s，共 %d 条消息（%s 至 %s），由 AI 生成，仅供参考",
+			r, len(lines), lines[0].time.Format("01-02 15:04"),
+			lines[len(lines)-1].time.Format("01-02 15:04"))),
+	)
+	replyCard(ctx, msgId, newCard)
+}
@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	larkim "github.com/larksuite/oapi-sdk-go/v3/service/im/v1"
+)
+
+func TestParseSummaryRange(t *testing.T) {
+	tests := []struct {
+		input string
+		want  summaryRange
+		desc  string
+	}{
+		{"", summaryRange{window: 24 * time.Hour}, "最近 1 天"},
+		{"100", summaryRange{count: 100}, "最近 100 条消息"},
+		{"2h", summaryRange{window: 2 * time.Hour}, "最近 2 小时"},
+		{"30M", summaryRange{window: 30 * time.Minute}, "最近 30 分钟"},
+		{"3d", summaryRange{window: 72 * time.Hour}, "最近 3 天"},
+	}
+	for _, tt := range tests {
+		got, err := parseSummaryRange(tt.input)
+		if err != nil || got != tt.want || got.String() != tt.desc {
+			t.Errorf("parseSummaryRange(%q) = %+v %q, %v, want %+v %q",
+				tt.input, got, got.String(), err, tt.want, tt.desc)
+		}
+	}
+	for _, input := range []string{"0", "501", "8d", "-1h", "昨天", "d"} {
+		if _, err := parseSummaryRange(input); err == nil {
+			t.Errorf("expected error for %q", input)
+		}
+	}
+}
+
+func TestParseChatSummary(t *testing.T) {
+	content := "```json\n" + `{"overview":"讨论了上线计划","decisions":["周五上线"],` +
+		`"action_items":[{"owner":"小明","task":"准备回滚方案","due":"周四"}],` +
+		`"questions":[]}` + "\n```"
+	got, err := parseChatSummary(content)
+	want := chatSummary{
+		Overview:    "讨论了上线计划",
+		Decisions:   []string{"周五上线"},
+		ActionItems: []chatActionItem{{Owner: "小明", Task: "准备回滚方案", Due: "周四"}},
+		Questions:   []string{},
+	}
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("parseChatSummary = %+v, %v", got, err)
+	}
+	if _, err = parseChatSummary("没有结论"); err == nil {
+		t.Error("expected error for plain text")
+	}
+	if line := formatActionItem(chatActionItem{Task: "整理文档"}); line != "- 整理文档" {
+		t.Errorf("formatActionItem = %q", line)
+	}
+}
+
+func TestChatLines(t *testing.T) {
+	alice, bob := "ou_alice", "ou_bob"
+	key, name, bobName := "@_user_1", "Alice", "Bob"
+	msg := func(sender *string, createTime, text string, mentions ...*larkim.Mention) *larkim.Message {
+		m := testMessage("text", `{"text":"`+text+`"}`, mentions...)
+		m.Sender = &larkim.Sender{Id: sender}
+		m.CreateTime = &createTime
+		return m
+	}
+	lines := chatLines([]*larkim.Message{
+		msg(&bob, "1700000000000", "@_user_1 周五上线吗",
+			&larkim.Mention{Key: &key, Id: &alice, Name: &name}),
+		msg(&alice, "1700000060000", "@_user_1 可以",
+			&larkim.Mention{Key: &key, Id: &bob, Name: &bobName}),
+	})
+	if len(lines) != 2 || lines[0].text != "@Alice 周五上线吗" || lines[0].sender != "Bob" ||
+		lines[1].sender != "Alice" ||
+		!lines[1].time.Equal(time.UnixMilli(1700000060000)) {
+		t.Errorf("chatLines = %+v", lines)
+	}
+
+	long := []chatLine{{sender: "Alice", text: strings.Repeat("长", maxChatSummaryInput)},
+		{sender: "Bob", text: "最后一句\n换行"}}
+	text := formatChatLines(long)
+	if !strings.HasPrefix(text, "（更早的消息已省略）") || !strings.HasSuffix(text, "Bob：最后一句 换行\n") {
+		t.Errorf("formatChatLines kept %q...", []rune(text)[:20])
+	}
+}
@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"start-feishubot/services/openai"
+	"start-feishubot/utils/transcript"
+
+	larkim "github.com/larksuite/oapi-sdk-go/v3/service/im/v1"
+)
+
+type TranscribeAction struct { /*转写指令*/
+}
+
+// Execute 回复一条语音、音频或视频消息，输出转写文件:
+//
+//	/transcribe                 带时间戳和说话人的转写文本
+//	/transcribe --format srt    SRT 字幕，另支持 vtt、verbose_json
+func (*TranscribeAction) Execute(a *ActionInfo) bool {
+	in := matchCommand(a, "transcribe")
+	if in == nil {
+		return true
+	}
+	if !AzureModeCheck(a) {
+		replyMsg(*a.ctx, "🤖️：Azure OpenAI 接口下暂不支持音视频转写", a.info.msgId)
+		return false
+	}
+	format := openai.ResponseFormat(in.Flag("format"))
+	if a.info.parentId == "" {
+		replyMsg(*a.ctx, "🤖️：请回复一条语音、音频或视频消息，"+
+			"并输入 */transcribe --format srt*", a.info.msgId)
+		return false
+	}
+	ref, err := getMediaRef(a.info.parentId)
+	if err != nil {
+		replyMsg(*a.ctx, fmt.Sprintf("🤖️：无法转写引用的消息\n错误信息: %v", err),
+			a.info.msgId)
+		return false
+	}
+
+	segments, err := transcribeMedia(a, ref)
+	if err != nil {
+		replyMsg(*a.ctx, fmt.Sprintf("🤖️：音视频转写失败，请稍后再试～\n错误信息: %v",
+			err), a.info.msgId)
+		return false
+	}
+	content, ext, err := renderTranscript(segments, format)
+	if err != nil {
+		replyMsg(*a.ctx, fmt.Sprintf("🤖️：生成转写文件失败\n错误信息: %v", err),
+			a.info.msgId)
+		return false
+	}
+
+	name := strings.TrimSuffix(ref.fileName, filepath.Ext(ref.fileName))
+	if name == "" {
+		name = "voice"
+	}
+	fileKey, err := uploadFile(larkim.FileTypeStream, name+ext,
+		strings.NewReader(content), 0)
+	if err == nil {
+		err = replyFile(*a.ctx, fileKey, a.info.msgId)
+	}
+	if err != nil {
+		replyMsg(*a.ctx, fmt.Sprintf("🤖️：转写文件上传失败\n错误信息: %v", err),
+			a.info.msgId)
+	}
+	return false
+}
+
+// renderTranscript 按格式生成转写文件内容，返回内容和文件扩展名
+func renderTranscript(segments []transcript.Segment,
+	format openai.ResponseFormat) (string, string, error) {
+	switch format {
+	case openai.ResponseFormatSRT:
+		return transcript.SRT(segments), ".srt", nil
+	case openai.ResponseFormatVTT:
+		return transcript.VTT(segments), ".vtt", nil
+	case openai.ResponseFormatVerboseJSON:
+		data, err := json.MarshalIndent(toTranscription(segments), "", "  ")
+		return string(data), ".json", err
+	default:
+		turns := transcript.Turns(segments, speakerTurnPause)
+		return transcript.Text(turns), ".txt", nil
+	}
+}
+
+// toTranscription 将拼接后的分段转换回与接口一致的 verbose_json 结构
+func toTranscription(segments []transcript.Segment) openai.Transcription {
+	result := openai.Transcription{Format: openai.ResponseFormatVerboseJSON}
+	var texts []string
+	for i, s := range segments {
+		result.Segments = append(result.Segments, openai.TranscriptionSegment{
+			Id:    i,
+			Start: s.Start.Seconds(),
+			End:   s.End.Seconds(),
+			Text:  s.Text,
+		})
+		texts = append(texts, strings.TrimSpace(s.Text))
+	}
+	if len(segments) > 0 {
+		result.Duration = segments[len(segments)-1].End.Seconds()
+	}
+	result.Text = strings.Join(texts, " ")
+	return result
+}
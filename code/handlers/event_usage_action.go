@@ -0,0 +1,213 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"start-feishubot/initialization"
+	"start-feishubot/logger"
+	"start-feishubot/services/usage"
+
+	larkcard "github.com/larksuite/oapi-sdk-go/v3/card"
+	larkim "github.com/larksuite/oapi-sdk-go/v3/service/im/v1"
+)
+
+const defaultUsageWindowDays = 7
+
+type UsageAction struct { /*用量报表*/
+}
+
+// Execute 处理用量报表指令:
+//
+//	/usage          最近 7 天的用量报表
+//	/usage 30d      最近 30 天的用量报表
+//	/usage 30d csv  导出最近 30 天的用量明细
+func (*UsageAction) Execute(a *ActionInfo) bool {
+	in := matchCommand(a, "usage")
+	if in == nil {
+		return true
+	}
+
+	days := defaultUsageWindowDays
+	exportCSV := false
+	for _, arg := range []string{in.Arg("days"), in.Arg("export")} {
+		if arg == "" {
+			continue
+		}
+		if strings.EqualFold(arg, "csv") {
+			exportCSV = true
+			continue
+		}
+		n, err := parseUsageWindow(arg)
+		if err != nil {
+			replyMsg(*a.ctx, fmt.Sprintf("🤖️：%v，例如 */usage 30d*", err), a.info.msgId)
+			return false
+		}
+		days = n
+	}
+
+	until := time.Now()
+	since := usage.DayStart(until).AddDate(0, 0, 1-days)
+	if exportCSV {
+		if err := replyUsageCSV(*a.ctx, a.handler.usageStore, since, until,
+			a.info.msgId); err != nil {
+			replyMsg(*a.ctx, fmt.Sprintf("🤖️：导出失败，请稍后再试～\n错误信息: %v", err), a.info.msgId)
+		}
+		return false
+	}
+	report := usage.BuildReport(a.handler.usageStore, since, until)
+	sendUsageReportCard(*a.ctx, a.info.msgId, report)
+	return false
+}
+
+// parseUsageWindow 解析统计窗口，支持 7d 或 7 的写法，单位为天
+func parseUsageWindow(s string) (int, error) {
+	s = strings.TrimSuffix(strings.ToLower(s), "d")
+	days, err := strconv.Atoi(s)
+	if err != nil || days <= 0 || days > usage.RetentionDays {
+		return 0, fmt.Errorf("统计天数需要在 1-%d 之间", usage.RetentionDays)
+	}
+	return days, nil
+}
+
+func replyUsageCSV(ctx context.Context, store usage.StoreInterface,
+	since, until time.Time, msgId *string) error {
+	var buf bytes.Buffer
+	records := store.Query(usage.Filter{Since: since, Until: until})
+	if err := usage.WriteCSV(&buf, records); err != nil {
+		return err
+	}
+	fileName := fmt.Sprintf("usage_%s_%s.csv", since.Format("20060102"),
+		until.Format("20060102"))
+	fileKey, err := uploadFile(larkim.FileTypeStream, fileName, &buf, 0)
+	if err != nil {
+		return err
+	}
+	return replyFile(ctx, fileKey, msgId)
+}
+
+func sendUsageReportCard(ctx context.Context, msgId *string,
+	report usage.Report) {
+	newCard, _ := newSendCard(
+		withHeader("📈 用量报表", larkcard.TemplateIndigo),
+		withMainMd(fmt.Sprintf("**%s ~ %s**\n合计: %s",
+			report.Since.Format("2006-01-02"), report.Until.Format("2006-01-02"),
+			formatSpend(report.Total))),
+		withSplitLine(),
+		withMainMd("**👤 用户 Top10**\n"+formatRanked(report.Users, 10,
+			formatUserKey)),
+		withSplitLine(),
+		withMainMd("**👥 群组 Top10**\n"+formatRanked(report.Groups, 10,
+			chatName)),
+		withSplitLine(),
+		withMainMd("**🧠 模型分布**\n"+formatModelMix(report)),
+		withSplitLine(),
+		withMainMd("**📅 每日趋势**\n"+formatDailyTrend(report.Daily)),
+		withNote("回复 */usage 天数 csv* 可导出用量明细"),
+	)
+	replyCard(ctx, msgId, newCard)
+}
+
+func formatModelMix(report usage.Report) string {
+	if len(report.Models) == 0 {
+		return "暂无记录"
+	}
+	var lines []string
+	for _, m := range report.Models {
+		share := 0.0
+		if report.Total.Tokens > 0 {
+			share = float64(m.Total.Tokens) * 100 / float64(report.Total.Tokens)
+		}
+		lines = append(lines, fmt.Sprintf("%s: %.1f%%（%s）", m.Key, share,
+			formatSpend(m.Total)))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// formatDailyTrend 用字符条形图展示每日 token 用量
+func formatDailyTrend(daily []usage.Ranked) string {
+	maxTokens := 0
+	for _, d := range daily {
+		if d.Total.Tokens > maxTokens {
+			maxTokens = d.Total.Tokens
+		}
+	}
+	var lines []string
+	for _, d := range daily {
+		bar := ""
+		if maxTokens > 0 {
+			bar = strings.Repeat("▇", d.Total.Tokens*20/maxTokens)
+		}
+		lines = append(lines, fmt.Sprintf("%s %s %d", d.Key[5:], bar,
+			d.Total.Tokens))
+	}
+	return strings.Join(lines, "\n")
+}
+
+var chatNames sync.Map
+
+// chatName 查询群名称，查询失败时返回 chat_id
+func chatName(chatId string) string {
+	if name, ok := chatNames.Load(chatId); ok {
+		return name.(string)
+	}
+	client := initialization.GetLarkClient()
+	resp, err := client.Im.Chat.Get(context.Background(),
+		larkim.NewGetChatReqBuilder().ChatId(chatId).Build())
+	if err != nil || !resp.Success() || resp.Data.Name == nil ||
+		*resp.Data.Name == "" {
+		return chatId
+	}
+	chatNames.Store(chatId, *resp.Data.Name)
+	return *resp.Data.Name
+}
+
+// startUsageDigest 按配置的时间每周向管理员群推送一次用量周报
+func startUsageDigest(config initialization.Config,
+	store usage.StoreInterface) {
+	if config.UsageDigestChatId == "" {
+		return
+	}
+	weekday := time.Weekday(config.UsageDigestWeekday % 7)
+	go func() {
+		for {
+			next := nextDigestTime(time.Now(), weekday, config.UsageDigestHour)
+			time.Sleep(time.Until(next))
+			sendUsageDigest(config.UsageDigestChatId, store, next)
+		}
+	}()
+}
+
+func nextDigestTime(now time.Time, weekday time.Weekday, hour int) time.Time {
+	next := time.Date(now.Year(), now.Month(), now.Day(), hour, 0, 0, 0,
+		now.Location())
+	next = next.AddDate(0, 0, (int(weekday)-int(now.Weekday())+7)%7)
+	if !next.After(now) {
+		next = next.AddDate(0, 0, 7)
+	}
+	return next
+}
+
+func sendUsageDigest(chatId string, store usage.StoreInterface,
+	until time.Time) {
+	since := usage.DayStart(until).AddDate(0, 0, -7)
+	report := usage.BuildReport(store, since, usage.DayStart(until))
+	var lines []string
+	lines = append(lines, fmt.Sprintf("📈 用量周报 %s ~ %s",
+		report.Since.Format("2006-01-02"),
+		report.Until.AddDate(0, 0, -1).Format("2006-01-02")))
+	lines = append(lines, "合计: "+formatSpend(report.Total))
+	lines = append(lines, "", "用户 Top5:", formatRanked(report.Users, 5, nil))
+	lines = append(lines, "", "群组 Top5:", formatRanked(report.Groups, 5, chatName))
+	lines = append(lines, "", "模型分布:", formatModelMix(report))
+	lines = append(lines, "", "每日趋势:", formatDailyTrend(report.Daily))
+	if err := sendMsg(context.Background(), strings.Join(lines, "\n"),
+		&chatId); err != nil {
+		logger.Errorf("send usage digest failed: %v", err)
+	}
+}
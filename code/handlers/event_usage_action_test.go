@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+
+	"start-feishubot/services/usage"
+)
+
+func TestParseUsageWindow(t *testing.T) {
+	tests := []struct {
+		input string
+		want  int
+		err   bool
+	}{
+		{"7", 7, false},
+		{"30d", 30, false},
+		{"30D", 30, false},
+		{"1", 1, false},
+		{"366d", usage.RetentionDays, false},
+		{"367d", 0, true},
+		{"0", 0, true},
+		{"-3d", 0, true},
+		{"2w", 0, true},
+		{"d", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := parseUsageWindow(tt.input)
+		if (err != nil) != tt.err || got != tt.want {
+			t.Errorf("parseUsageWindow(%q) = %d, %v", tt.input, got, err)
+		}
+	}
+}
+
+func TestNextDigestTime(t *testing.T) {
+	loc := time.FixedZone("CST", 8*3600)
+	// 2024-05-15 是周三
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2024, 5, day, hour, minute, 0, 0, loc)
+	}
+	tests := []struct {
+		now     time.Time
+		weekday time.Weekday
+		hour    int
+		want    time.Time
+	}{
+		// 当天还没到推送时间
+		{at(15, 8, 59), time.Wednesday, 9, at(15, 9, 0)},
+		// 恰好在推送时间或已经过了，推迟到下周
+		{at(15, 9, 0), time.Wednesday, 9, at(22, 9, 0)},
+		{at(15, 23, 0), time.Wednesday, 9, at(22, 9, 0)},
+		// 本周之后的某天
+		{at(15, 10, 0), time.Friday, 9, at(17, 9, 0)},
+		// 本周已经过去的某天，跨周并跨月
+		{at(15, 10, 0), time.Monday, 0, at(20, 0, 0)},
+		{time.Date(2024, 5, 31, 12, 0, 0, 0, loc), time.Sunday, 9,
+			time.Date(2024, 6, 2, 9, 0, 0, 0, loc)},
+		{time.Date(2024, 12, 31, 12, 0, 0, 0, loc), time.Tuesday, 9,
+			time.Date(2025, 1, 7, 9, 0, 0, 0, loc)},
+	}
+	for _, tt := range tests {
+		if got := nextDigestTime(tt.now, tt.weekday, tt.hour); !got.Equal(tt.want) {
+			t.Errorf("nextDigestTime(%v, %s, %d) = %v, want %v", tt.now,
+				tt.weekday, tt.hour, got, tt.want)
+		}
+	}
+}
@@ -3,13 +3,9 @@ package handlers
 import (
 	"context"
 	"fmt"
-	"os"
-	"start-feishubot/initialization"
 	"start-feishubot/services"
 	"start-feishubot/services/openai"
-	"start-feishubot/utils"
-
-	larkim "github.com/larksuite/oapi-sdk-go/v3/service/im/v1"
+	"start-feishubot/services/usage"
 )
 
 type VisionAction struct { /*图片推理*/
@@ -20,9 +16,9 @@ func (va *VisionAction) Execute(a *ActionInfo) bool {
 		return true
 	}
 
-	if isVisionCommand(a) {
+	if matchCommand(a, "vision") != nil {
 		initializeVisionMode(a)
-		sendVisionInstructionCard(*a.ctx, a.info.sessionId, a.info.msgId)
+		sendVisionInstructionCard(*a.ctx, a.info.sessionId, cardChatOf(a), a.info.msgId)
 		return false
 	}
 
@@ -30,7 +26,7 @@ func (va *VisionAction) Execute(a *ActionInfo) bool {
 
 	if a.info.msgType == "image" {
 		if mode != services.ModeVision {
-			sendVisionModeCheckCard(*a.ctx, a.info.sessionId, a.info.msgId)
+			sendVisionModeCheckCard(*a.ctx, a.info.sessionId, cardChatOf(a), a.info.msgId)
 			return false
 		}
 
@@ -41,12 +37,14 @@ func (va *VisionAction) Execute(a *ActionInfo) bool {
 		return va.handleVisionPost(a)
 	}
 
-	return true
-}
+	// 图片推理模式下的文字追问，带上历史中的图片
+	if a.info.msgType == "text" && mode == services.ModeVision &&
+		openai.HasImages(a.handler.sessionCache.GetMsg(*a.info.sessionId)) {
+		detail := a.handler.sessionCache.GetVisionDetail(*a.info.sessionId)
+		return replyVisionTurn(a, a.info.qParsed, nil, detail)
+	}
 
-func isVisionCommand(a *ActionInfo) bool {
-	_, foundPic := utils.EitherTrimEqual(a.info.qParsed, "/vision", "图片推理")
-	return foundPic
+	return true
 }
 
 func initializeVisionMode(a *ActionInfo) {
@@ -57,104 +55,112 @@ func initializeVisionMode(a *ActionInfo) {
 
 func (va *VisionAction) handleVisionImage(a *ActionInfo) bool {
 	detail := a.handler.sessionCache.GetVisionDetail(*a.info.sessionId)
-	base64, err := downloadAndEncodeImage(a.info.imageKey, a.info.msgId)
-	if err != nil {
-		replyWithErrorMsg(*a.ctx, err, a.info.msgId)
-		return false
-	}
-
-	return va.processImageAndReply(a, base64, detail)
+	return replyVisionTurn(a, "解释这个图片", []string{a.info.imageKey}, detail)
 }
 
 func (va *VisionAction) handleVisionPost(a *ActionInfo) bool {
 	detail := a.handler.sessionCache.GetVisionDetail(*a.info.sessionId)
-	var base64s []string
-
-	for _, imageKey := range a.info.imageKeys {
-		if imageKey == "" {
-			continue
+	if !hasImageKey(a.info.imageKeys) {
+		// 没有图片的追问沿用历史中的图片
+		if openai.HasImages(a.handler.sessionCache.GetMsg(*a.info.sessionId)) {
+			return replyVisionTurn(a, a.info.qParsed, nil, detail)
 		}
-		base64, err := downloadAndEncodeImage(imageKey, a.info.msgId)
-		if err != nil {
-			replyWithErrorMsg(*a.ctx, err, a.info.msgId)
-			return false
-		}
-		base64s = append(base64s, base64)
-	}
-
-	if len(base64s) == 0 {
 		replyMsg(*a.ctx, "🤖️：请发送一张图片", a.info.msgId)
 		return false
 	}
-
-	return va.processMultipleImagesAndReply(a, base64s, detail)
+	prompt := a.info.qParsed
+	if prompt == "" {
+		prompt = "解释这个图片"
+	}
+	return replyVisionTurn(a, prompt, a.info.imageKeys, detail)
 }
 
-func downloadAndEncodeImage(imageKey string, msgId *string) (string, error) {
-	f := fmt.Sprintf("%s.png", imageKey)
-	defer os.Remove(f)
-
-	req := larkim.NewGetMessageResourceReqBuilder().MessageId(*msgId).FileKey(imageKey).Type("image").Build()
-	resp, err := initialization.GetLarkClient().Im.MessageResource.Get(context.Background(), req)
-	if err != nil {
-		return "", err
+func hasImageKey(imageKeys []string) bool {
+	for _, imageKey := range imageKeys {
+		if imageKey != "" {
+			return true
+		}
 	}
-
-	resp.WriteFile(f)
-	return openai.GetBase64FromImage(f)
+	return false
 }
 
-func replyWithErrorMsg(ctx context.Context, err error, msgId *string) {
-	replyMsg(ctx, fmt.Sprintf("🤖️：图片下载失败，请稍后再试～\n 错误信息: %v", err), msgId)
-}
+// visionHistoryImageBudget 追问时重新附带的历史图片最多消耗的 token，
+// 大约是 4 张 detail=high 的正方形图片
+const visionHistoryImageBudget = 3000
+
+// replyVisionTurn 把本轮的文字和图片加入会话历史，连同预算内的历史图片一起发送，
+// 历史中只保存图片的引用，追问时重新下载
+func replyVisionTurn(a *ActionInfo, prompt string, imageKeys []string,
+	detail string) bool {
+	refs := make([]openai.ImageAttachment, 0, len(imageKeys))
+	for _, imageKey := range imageKeys {
+		refs = append(refs, openai.ImageAttachment{MsgId: *a.info.msgId, ImageKey: imageKey})
+	}
+	// 引用的消息中的图片属于被引用的消息，需要按那条消息下载
+	if q := a.info.quote; q != nil {
+		for _, imageKey := range q.imageKeys {
+			refs = append(refs, openai.ImageAttachment{MsgId: q.msgId, ImageKey: imageKey})
+		}
+	}
+	current := make(map[string]openai.VisionImage)
+	var attachments []openai.ImageAttachment
+	for _, ref := range refs {
+		if ref.ImageKey == "" {
+			continue
+		}
+		image, err := downloadAndEncodeImage(ref.ImageKey, &ref.MsgId, detail)
+		if err != nil {
+			replyWithErrorMsg(*a.ctx, err, a.info.msgId)
+			return false
+		}
+		current[ref.ImageKey] = image
+		ref.Detail = detail
+		ref.Tokens = image.Tokens
+		attachments = append(attachments, ref)
+	}
 
-func (va *VisionAction) processImageAndReply(a *ActionInfo, base64 string, detail string) bool {
-	msg := createVisionMessages("解释这个图片", base64, detail)
-	completions, err := a.handler.gpt.GetVisionInfo(msg)
+	msg := a.handler.sessionCache.GetMsg(*a.info.sessionId)
+	msg = setDefaultPrompt(msg)
+	msg = append(msg, openai.Messages{
+		Role: "user", Content: prompt, Images: attachments,
+	})
+	visionMsg, images, err := openai.BuildVisionMessages(msg,
+		visionHistoryImageBudget, func(ref openai.ImageAttachment) (
+			openai.VisionImage, error) {
+			if image, ok := current[ref.ImageKey]; ok {
+				return image, nil
+			}
+			return downloadAndEncodeImage(ref.ImageKey, &ref.MsgId, ref.Detail)
+		})
 	if err != nil {
 		replyWithErrorMsg(*a.ctx, err, a.info.msgId)
 		return false
 	}
-	sendVisionTopicCard(*a.ctx, a.info.sessionId, a.info.msgId, completions.Content)
-	return false
-}
 
-func (va *VisionAction) processMultipleImagesAndReply(a *ActionInfo, base64s []string, detail string) bool {
-	msg := createMultipleVisionMessages(a.info.qParsed, base64s, detail)
-	completions, err := a.handler.gpt.GetVisionInfo(msg)
+	completions, tokenUsage, err := a.handler.gpt.GetVisionInfoWithUsage(visionMsg)
 	if err != nil {
 		replyWithErrorMsg(*a.ctx, err, a.info.msgId)
 		return false
 	}
-	sendVisionTopicCard(*a.ctx, a.info.sessionId, a.info.msgId, completions.Content)
+	recordUsage(a, usage.KindVision, tokenUsage)
+
+	msg = append(msg, completions)
+	a.handler.sessionCache.SetMsg(*a.info.sessionId, msg)
+	sendVisionTopicCard(*a.ctx, a.info.sessionId, a.info.msgId, completions.Content,
+		images)
 	return false
 }
 
-func createVisionMessages(query, base64Image, detail string) []openai.VisionMessages {
-	return []openai.VisionMessages{
-		{
-			Role: "user",
-			Content: []openai.ContentType{
-				{Type: "text", Text: query},
-				{Type: "image_url", ImageURL: &openai.ImageURL{
-					URL:    "data:image/jpeg;base64," + base64Image,
-					Detail: detail,
-				}},
-			},
-		},
+// downloadAndEncodeImage 下载图片并按 detail 缩放，编码为带正确类型的 data URL
+func downloadAndEncodeImage(imageKey string, msgId *string,
+	detail string) (openai.VisionImage, error) {
+	data, err := downloadImage(*msgId, imageKey)
+	if err != nil {
+		return openai.VisionImage{}, err
 	}
+	return openai.PrepareVisionImage(data, detail)
 }
 
-func createMultipleVisionMessages(query string, base64Images []string, detail string) []openai.VisionMessages {
-	content := []openai.ContentType{{Type: "text", Text: query}}
-	for _, base64Image := range base64Images {
-		content = append(content, openai.ContentType{
-			Type: "image_url",
-			ImageURL: &openai.ImageURL{
-				URL:    "data:image/jpeg;base64," + base64Image,
-				Detail: detail,
-			},
-		})
-	}
-	return []openai.VisionMessages{{Role: "user", Content: content}}
+func replyWithErrorMsg(ctx context.Context, err error, msgId *string) {
+	replyMsg(ctx, fmt.Sprintf("🤖️：图片下载失败，请稍后再试～\n 错误信息: %v", err), msgId)
 }
@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"start-feishubot/logger"
+	"start-feishubot/services/openai"
+	"start-feishubot/services/usage"
+	"start-feishubot/utils/audio"
+
+	larkim "github.com/larksuite/oapi-sdk-go/v3/service/im/v1"
+)
+
+type VoiceAction struct { /*语音回复*/
+}
+
+// Execute 处理语音回复指令:
+//
+//	/voice        选择语音回复的音色
+//	/voice nova   直接开启语音回复并使用 nova 音色
+//	/voice off    关闭语音回复
+func (*VoiceAction) Execute(a *ActionInfo) bool {
+	in := matchCommand(a, "voice")
+	if in == nil {
+		return true
+	}
+	if !AzureModeCheck(a) {
+		return false
+	}
+
+	voice := strings.ToLower(in.Arg("voice"))
+	switch {
+	case voice == "":
+		SendVoiceListCard(*a.ctx, a.info.sessionId, cardChatOf(a), a.info.msgId,
+			a.handler.sessionCache.GetVoice(*a.info.sessionId))
+	case voice == "off" || voice == "关闭":
+		a.handler.sessionCache.SetVoice(*a.info.sessionId, "")
+		replyMsg(*a.ctx, "🤖️：已关闭语音回复", a.info.msgId)
+	case openai.IsValidVoice(voice):
+		a.handler.sessionCache.SetVoice(*a.info.sessionId, voice)
+		replyMsg(*a.ctx, "🤖️：已开启语音回复，音色: "+voice, a.info.msgId)
+	default:
+		replyMsg(*a.ctx, fmt.Sprintf("🤖️：不支持的音色 %s，可选: %s",
+			voice, strings.Join(openai.Voices, ", ")), a.info.msgId)
+	}
+	return false
+}
+
+// replyVoice 开启语音回复时，将回答合成为语音并回复到原消息，失败时只记录日志
+func replyVoice(a *ActionInfo, answer string) {
+	voice := a.handler.sessionCache.GetVoice(*a.info.sessionId)
+	if voice == "" || strings.TrimSpace(answer) == "" {
+		return
+	}
+	if err := sendVoiceReply(a, voice, answer); err != nil {
+		logger.Warnf("voice reply failed: %v", err)
+		replyMsg(*a.ctx, fmt.Sprintf("🤖️：语音合成失败，请稍后再试～\n错误信息: %v",
+			err), a.info.msgId)
+	}
+}
+
+func sendVoiceReply(a *ActionInfo, voice string, answer string) error {
+	speech, tokenUsage, err := a.handler.gpt.TextToSpeech(answer, voice)
+	if err != nil {
+		return err
+	}
+	recordUsage(a, usage.KindAudio, tokenUsage)
+
+	// 飞书上传语音时需要指定时长
+	duration, err := audio.OggDuration(bytes.NewReader(speech))
+	if err != nil {
+		return err
+	}
+	fileKey, err := uploadFile(larkim.FileTypeOpus, "reply.opus",
+		bytes.NewReader(speech), int(duration.Milliseconds()))
+	if err != nil {
+		return err
+	}
+	return replyAudio(*a.ctx, fileKey, a.info.msgId)
+}
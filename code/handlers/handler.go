@@ -5,6 +5,11 @@ import (
 	"fmt"
 	larkcore "github.com/larksuite/oapi-sdk-go/v3/core"
 	"start-feishubot/logger"
+	"start-feishubot/services/access"
+	"start-feishubot/services/knowledge"
+	"start-feishubot/services/ratelimit"
+	"start-feishubot/services/usage"
+	"start-feishubot/services/workerpool"
 	"strings"
 
 	"start-feishubot/initialization"
@@ -15,19 +20,18 @@ import (
 	larkim "github.com/larksuite/oapi-sdk-go/v3/service/im/v1"
 )
 
-// 责任链
-func chain(data *ActionInfo, actions ...Action) bool {
-	for _, v := range actions {
-		if !v.Execute(data) {
-			return false
-		}
-	}
-	return true
-}
-
 type MessageHandler struct {
 	sessionCache services.SessionServiceCacheInterface
 	msgCache     services.MsgCacheInterface
+	chatSettings services.ChatSettingServiceInterface
+	usageStore   usage.StoreInterface
+	quota        *usage.QuotaManager
+	limiter      *ratelimit.Limiter
+	access       *access.Policy
+	prices       map[string]usage.Price
+	knowledge    *knowledge.Index
+	actions      *ActionRegistry
+	pool         *workerpool.Pool
 	gpt          *openai.ChatGPT
 	config       initialization.Config
 }
@@ -38,15 +42,19 @@ func (m MessageHandler) cardHandler(ctx context.Context,
 	return messageHandler(ctx, cardAction)
 }
 
+// supportedMsgTypes 可以处理的消息类型，其余类型例如表情包只回复暂不支持
+var supportedMsgTypes = map[string]bool{
+	"text": true, "image": true, "audio": true, "post": true, "file": true,
+	"media": true, "merge_forward": true, "share_chat": true, "share_user": true,
+	"interactive": true,
+}
+
 func judgeMsgType(event *larkim.P2MessageReceiveV1) (string, error) {
 	msgType := event.Event.Message.MessageType
-
-	switch *msgType {
-	case "text", "image", "audio", "post":
-		return *msgType, nil
-	default:
-		return "", fmt.Errorf("unknown message type: %v", *msgType)
+	if msgType == nil || *msgType == "" {
+		return "", fmt.Errorf("empty message type")
 	}
+	return *msgType, nil
 }
 
 func (m MessageHandler) msgReceivedHandler(ctx context.Context, event *larkim.P2MessageReceiveV1) error {
@@ -67,8 +75,20 @@ func (m MessageHandler) msgReceivedHandler(ctx context.Context, event *larkim.P2
 	content := event.Event.Message.Content
 	msgId := event.Event.Message.MessageId
 	rootId := event.Event.Message.RootId
+	var parentId string
+	if event.Event.Message.ParentId != nil {
+		parentId = *event.Event.Message.ParentId
+	}
 	chatId := event.Event.Message.ChatId
 	mention := event.Event.Message.Mentions
+	var userId string
+	if sender := event.Event.Sender; sender != nil && sender.SenderId != nil &&
+		sender.SenderId.OpenId != nil {
+		userId = *sender.SenderId.OpenId
+	}
+
+	mentions := mentionedUsers(mention, m.bot())
+	text := renderMentions(parseContent(*content, msgType), mentions)
 
 	sessionId := rootId
 	if sessionId == nil || *sessionId == "" {
@@ -79,36 +99,26 @@ func (m MessageHandler) msgReceivedHandler(ctx context.Context, event *larkim.P2
 		msgType:     msgType,
 		msgId:       msgId,
 		chatId:      chatId,
-		qParsed:     strings.Trim(parseContent(*content, msgType), " "),
+		userId:      userId,
+		qParsed:     strings.Trim(text, " "),
 		fileKey:     parseFileKey(*content),
+		fileName:    parseFileName(*content),
+		parentId:    parentId,
 		imageKey:    parseImageKey(*content),
 		imageKeys:   parsePostImageKeys(*content),
+		shareId:     parseShareId(*content),
 		sessionId:   sessionId,
 		mention:     mention,
+		mentions:    mentions,
 	}
+	// Action 在工作池中执行时请求已经结束，不能继续使用请求的 context
+	actionCtx := context.Background()
 	data := &ActionInfo{
-		ctx:     &ctx,
+		ctx:     &actionCtx,
 		handler: &m,
 		info:    &msgInfo,
 	}
-	actions := []Action{
-		&ProcessedUniqueAction{}, //避免重复处理
-		&ProcessMentionAction{},  //判断机器人是否应该被调用
-		&AudioAction{},           //语音处理
-		&ClearAction{},           //清除消息处理
-		&MultimodalAction{},      //多模态消息处理（支持 o4-mini 模型）
-		&VisionAction{},          //图片推理处理
-		&PicAction{},             //图片处理
-		&AIModeAction{},          //模式切换处理
-		&RoleListAction{},        //角色列表处理
-		&HelpAction{},            //帮助处理
-		&BalanceAction{},         //余额处理
-		&RolePlayAction{},        //角色扮演处理
-		&MessageAction{},         //消息处理
-		&EmptyAction{},           //空消息处理
-		&StreamMessageAction{},   //流式消息处理
-	}
-	chain(data, actions...)
+	m.dispatch(data)
 	return nil
 }
 
@@ -116,22 +126,28 @@ var _ MessageHandlerInterface = (*MessageHandler)(nil)
 
 func NewMessageHandler(gpt *openai.ChatGPT,
 	config initialization.Config) MessageHandlerInterface {
+	usageStore := usage.GetStore(config.UsageFile)
+	prices, err := usage.ParsePrices(config.ModelPrices)
+	if err != nil {
+		logger.Warnf("parse model prices failed: %v", err)
+	}
 	return &MessageHandler{
 		sessionCache: services.GetSessionCache(),
 		msgCache:     services.GetMsgCache(),
+		chatSettings: services.GetChatSettings(config.ChatSettingsFile),
+		usageStore:   usageStore,
+		quota:        newQuotaManager(usageStore, config),
+		limiter:      newRateLimiter(config),
+		access:       newAccessPolicy(config),
+		prices:       prices,
+		knowledge:    knowledge.GetIndex(config.KnowledgeIndexFile),
+		actions:      newActionRegistry(config.ActionSwitches),
+		pool:         newWorkerPool(config),
 		gpt:          gpt,
 		config:       config,
 	}
 }
 
-func (m MessageHandler) judgeIfMentionMe(mention []*larkim.
-	MentionEvent) bool {
-	if len(mention) != 1 {
-		return false
-	}
-	return *mention[0].Name == m.config.FeishuBotName
-}
-
 func AzureModeCheck(a *ActionInfo) bool {
 	if a.handler.config.AzureOn {
 		//sendMsg(*a.ctx, "Azure Openai 接口下，暂不支持此功能", a.info.chatId)
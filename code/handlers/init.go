@@ -6,6 +6,7 @@ import (
 
 	"start-feishubot/initialization"
 	"start-feishubot/services/openai"
+	"start-feishubot/services/usage"
 
 	larkcard "github.com/larksuite/oapi-sdk-go/v3/card"
 	larkim "github.com/larksuite/oapi-sdk-go/v3/service/im/v1"
@@ -14,6 +15,7 @@ import (
 type MessageHandlerInterface interface {
 	msgReceivedHandler(ctx context.Context, event *larkim.P2MessageReceiveV1) error
 	cardHandler(ctx context.Context, cardAction *larkcard.CardAction) (interface{}, error)
+	close()
 }
 
 type HandlerType string
@@ -28,6 +30,12 @@ var handlers MessageHandlerInterface
 
 func InitHandlers(gpt *openai.ChatGPT, config initialization.Config) {
 	handlers = NewMessageHandler(gpt, config)
+	startUsageDigest(config, usage.GetStore(config.UsageFile))
+}
+
+// Close 不再接收新消息，等待工作池中已提交的消息处理完毕
+func Close() {
+	handlers.close()
 }
 
 func Handler(ctx context.Context, event *larkim.P2MessageReceiveV1) error {
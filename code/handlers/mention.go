@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"sort"
+	"strings"
+
+	"start-feishubot/initialization"
+
+	larkim "github.com/larksuite/oapi-sdk-go/v3/service/im/v1"
+)
+
+// mentionedUser 消息中 @ 的其他用户，不包括机器人
+type mentionedUser struct {
+	Key    string // 消息文本中的占位符，例如 @_user_1
+	Name   string
+	OpenId string
+}
+
+// botIdentity 用于识别机器人自己的 open_id 和名称，
+// 启动时没有查询到 open_id 时按名称匹配
+type botIdentity struct {
+	OpenId string
+	Name   string
+}
+
+func (m MessageHandler) bot() botIdentity {
+	return botIdentity{
+		OpenId: initialization.GetBotInfo().OpenId,
+		Name:   m.config.FeishuBotName,
+	}
+}
+
+func (b botIdentity) is(openId, name string) bool {
+	if b.OpenId != "" {
+		return openId == b.OpenId
+	}
+	return name != "" && name == b.Name
+}
+
+// isMentionEvent 事件中的 @ 是否是机器人
+func (b botIdentity) isMentionEvent(mention *larkim.MentionEvent) bool {
+	var openId, name string
+	if mention.Id != nil && mention.Id.OpenId != nil {
+		openId = *mention.Id.OpenId
+	}
+	if mention.Name != nil {
+		name = *mention.Name
+	}
+	return b.is(openId, name)
+}
+
+// judgeIfMentionMe 消息中任意一个 @ 是机器人即可，
+// 支持“@机器人 请帮我问问 @小明”这样同时 @ 多人的消息
+func (m MessageHandler) judgeIfMentionMe(mention []*larkim.MentionEvent) bool {
+	bot := m.bot()
+	for _, v := range mention {
+		if bot.isMentionEvent(v) {
+			return true
+		}
+	}
+	return false
+}
+
+// mentionedUsers 消息中 @ 的其他用户，按出现的顺序
+func mentionedUsers(mention []*larkim.MentionEvent, bot botIdentity) []mentionedUser {
+	var users []mentionedUser
+	for _, v := range mention {
+		if v.Key == nil || bot.isMentionEvent(v) {
+			continue
+		}
+		user := mentionedUser{Key: *v.Key}
+		if v.Name != nil {
+			user.Name = *v.Name
+		}
+		if v.Id != nil && v.Id.OpenId != nil {
+			user.OpenId = *v.Id.OpenId
+		}
+		users = append(users, user)
+	}
+	return users
+}
+
+// renderMentions 去掉 @ 机器人的占位符，把 @ 其他用户的占位符换成 @姓名，
+// 让模型知道消息提到了谁
+func renderMentions(text string, users []mentionedUser) string {
+	sorted := append([]mentionedUser{}, users...)
+	// 先匹配较长的占位符，避免 @_user_1 替换掉 @_user_10 的前缀
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return len(sorted[i].Key) > len(sorted[j].Key)
+	})
+	pairs := make([]string, 0, len(sorted)*2)
+	for _, user := range sorted {
+		if user.Name != "" {
+			pairs = append(pairs, user.Key, "@"+user.Name)
+		}
+	}
+	if len(pairs) > 0 {
+		text = strings.NewReplacer(pairs...).Replace(text)
+	}
+	return msgFilter(text)
+}
+
+// stripMentions 去掉指令参数中 @ 用户的姓名，姓名中可能有空格
+func stripMentions(text string, users []mentionedUser) string {
+	for _, user := range users {
+		if user.Name != "" {
+			text = strings.Replace(text, "@"+user.Name, "", 1)
+		}
+	}
+	return text
+}
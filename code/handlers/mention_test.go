@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"reflect"
+	"testing"
+
+	larkim "github.com/larksuite/oapi-sdk-go/v3/service/im/v1"
+)
+
+func mentionEvent(key, openId, name string) *larkim.MentionEvent {
+	return &larkim.MentionEvent{
+		Key:  &key,
+		Id:   &larkim.UserId{OpenId: &openId},
+		Name: &name,
+	}
+}
+
+func TestBotIdentity(t *testing.T) {
+	tests := []struct {
+		bot    botIdentity
+		openId string
+		name   string
+		want   bool
+	}{
+		{botIdentity{OpenId: "ou_bot", Name: "chatGpt"}, "ou_bot", "新名字", true},
+		{botIdentity{OpenId: "ou_bot", Name: "chatGpt"}, "ou_alice", "chatGpt", false},
+		{botIdentity{Name: "chatGpt"}, "ou_bot", "chatGpt", true},
+		{botIdentity{Name: "chatGpt"}, "ou_bot", "ChatGPT", false},
+		{botIdentity{}, "ou_bot", "", false},
+	}
+	for _, tt := range tests {
+		if got := tt.bot.is(tt.openId, tt.name); got != tt.want {
+			t.Errorf("%+v.is(%s, %s) = %v, want %v", tt.bot, tt.openId, tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestMentionedUsers(t *testing.T) {
+	bot := botIdentity{OpenId: "ou_bot", Name: "chatGpt"}
+	mention := []*larkim.MentionEvent{
+		mentionEvent("@_user_1", "ou_bot", "chatGpt"),
+		mentionEvent("@_user_2", "ou_alice", "Alice Wang"),
+		mentionEvent("@_user_3", "ou_bob", "小明"),
+	}
+	users := mentionedUsers(mention, bot)
+	want := []mentionedUser{
+		{Key: "@_user_2", Name: "Alice Wang", OpenId: "ou_alice"},
+		{Key: "@_user_3", Name: "小明", OpenId: "ou_bob"},
+	}
+	if !reflect.DeepEqual(users, want) {
+		t.Errorf("mentionedUsers = %+v, want %+v", users, want)
+	}
+}
+
+func TestRenderMentions(t *testing.T) {
+	users := []mentionedUser{
+		{Key: "@_user_1", Name: "小明"},
+		{Key: "@_user_2", Name: ""},
+		{Key: "@_user_10", Name: "Alice Wang"},
+	}
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"@_user_0 你好", " 你好"},
+		{"@_user_0 请帮我问问 @_user_1 进度", " 请帮我问问 @小明 进度"},
+		{"@_user_10 和 @_user_1", "@Alice Wang 和 @小明"},
+		{"@_user_2 @_all 开会", "  开会"},
+		{"邮箱 a@b.com", "邮箱 a@b.com"},
+	}
+	for _, tt := range tests {
+		if got := renderMentions(tt.input, users); got != tt.want {
+			t.Errorf("renderMentions(%q) = %q, want %q", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestStripMentions(t *testing.T) {
+	users := []mentionedUser{{Key: "@_user_2", Name: "Alice Wang", OpenId: "ou_alice"}}
+	got := stripMentions("@Alice Wang 100000/3000000", users)
+	if got != " 100000/3000000" {
+		t.Errorf("stripMentions = %q", got)
+	}
+}
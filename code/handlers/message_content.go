@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"start-feishubot/initialization"
+
+	larkim "github.com/larksuite/oapi-sdk-go/v3/service/im/v1"
+)
+
+// getMessage 查询一条消息的内容、发送者和 @ 列表
+func getMessage(msgId string) (*larkim.Message, error) {
+	items, err := getMessageItems(msgId)
+	if err != nil {
+		return nil, err
+	}
+	for _, item := range items {
+		if item.MessageId != nil && *item.MessageId == msgId {
+			return item, nil
+		}
+	}
+	return items[0], nil
+}
+
+// getMessageItems 查询消息，合并转发的消息会同时返回其中的子消息，
+// 子消息的 upper_message_id 为上一层的消息 id
+func getMessageItems(msgId string) ([]*larkim.Message, error) {
+	resp, err := initialization.GetLarkClient().Im.Message.Get(
+		context.Background(),
+		larkim.NewGetMessageReqBuilder().MessageId(msgId).Build())
+	if err != nil {
+		return nil, err
+	}
+	if !resp.Success() {
+		return nil, errors.New(resp.Msg)
+	}
+	var items []*larkim.Message
+	for _, item := range resp.Data.Items {
+		if item.Body != nil && item.Body.Content != nil && item.MsgType != nil {
+			items = append(items, item)
+		}
+	}
+	if len(items) == 0 {
+		return nil, errors.New("message not found")
+	}
+	return items, nil
+}
+
+// messageText 把查询到的消息转成提供给模型的文字，图片只返回 image_key，
+// 无法转成文字的消息用 [类型] 占位
+func messageText(msg *larkim.Message) (string, []string) {
+	content := *msg.Body.Content
+	switch *msg.MsgType {
+	case "text":
+		return renderMentions(parseContent(content, "text"),
+			messageMentions(msg.Mentions)), nil
+	case "post":
+		return renderMentions(parsePostContent(content), messageMentions(msg.Mentions)),
+			parsePostImageKeys(content)
+	case "image":
+		return "[图片]", []string{parseImageKey(content)}
+	case "file":
+		return fmt.Sprintf("[文件 %s]", parseFileName(content)), nil
+	case "media":
+		return fmt.Sprintf("[视频 %s]", parseFileName(content)), nil
+	case "audio":
+		return "[语音]", nil
+	case "interactive":
+		if text := parseInteractiveContent(content); text != "" {
+			return text, nil
+		}
+		return "[卡片]", nil
+	case "merge_forward":
+		return "[聊天记录]", nil
+	case "share_chat":
+		return "[群名片]", nil
+	case "share_user":
+		return "[个人名片]", nil
+	case "sticker":
+		return "[表情]", nil
+	default:
+		return fmt.Sprintf("[%s 消息]", *msg.MsgType), nil
+	}
+}
+
+// messageMentions 查询到的消息中的 @ 列表，机器人也按姓名显示
+func messageMentions(mentions []*larkim.Mention) []mentionedUser {
+	var users []mentionedUser
+	for _, v := range mentions {
+		if v.Key == nil {
+			continue
+		}
+		user := mentionedUser{Key: *v.Key}
+		if v.Name != nil {
+			user.Name = *v.Name
+		}
+		if v.Id != nil {
+			user.OpenId = *v.Id
+		}
+		users = append(users, user)
+	}
+	return users
+}
+
+// senderName 消息发送者的姓名，应用发送的消息显示为机器人，查询不到姓名时返回空
+func senderName(sender *larkim.Sender) string {
+	if sender == nil || sender.Id == nil {
+		return ""
+	}
+	if sender.SenderType != nil && *sender.SenderType == "app" {
+		return "机器人"
+	}
+	return userName(*sender.Id)
+}
+
+// quoteLines 把多行文字转成 Markdown 引用
+func quoteLines(text string) string {
+	lines := strings.Split(strings.TrimSpace(text), "\n")
+	for i, line := range lines {
+		lines[i] = "> " + line
+	}
+	return strings.Join(lines, "\n")
+}
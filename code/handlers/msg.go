@@ -4,13 +4,20 @@ import (
 	"bytes"
 	"context"
 	"encoding/base64"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"start-feishubot/logger"
+	"strings"
+	"time"
 
 	"start-feishubot/initialization"
 	"start-feishubot/services"
+	"start-feishubot/services/access"
 	"start-feishubot/services/openai"
+	"start-feishubot/services/ratelimit"
+	"start-feishubot/services/usage"
 
 	"github.com/google/uuid"
 	larkcard "github.com/larksuite/oapi-sdk-go/v3/card"
@@ -26,12 +33,17 @@ var (
 	VisionModeChangeKind = CardKind("vision_mode")      // 切换图片解析模式
 	PicResolutionKind    = CardKind("pic_resolution")   // 图片分辨率调整
 	PicStyleKind         = CardKind("pic_style")        // 图片风格调整
+	PicModelKind         = CardKind("pic_model")        // 图片模型选择
+	PicCountKind         = CardKind("pic_count")        // 单次生成图片数量
+	PicEditKind          = CardKind("pic_edit")         // 编辑生成的图片
+	PicReferenceKind     = CardKind("pic_reference")    // 生成的图片作为参考图
 	VisionStyleKind      = CardKind("vision_style")     // 图片推理级别调整
 	PicTextMoreKind      = CardKind("pic_text_more")    // 重新根据文本生成图片
 	PicVarMoreKind       = CardKind("pic_var_more")     // 变量图片
 	RoleTagsChooseKind   = CardKind("role_tags_choose") // 内置角色所属标签选择
 	RoleChooseKind       = CardKind("role_choose")      // 内置角色选择
 	AIModeChooseKind     = CardKind("ai_mode_choose")   // AI模式选择
+	VoiceChooseKind      = CardKind("voice_choose")     // 语音回复音色选择
 )
 
 var (
@@ -42,11 +54,44 @@ var (
 type CardMsg struct {
 	Kind      CardKind
 	ChatType  CardChatType
+	ChatId    string
 	Value     interface{}
 	SessionId string
 	MsgId     string
 }
 
+// chat 按钮所在的会话
+func (msg CardMsg) chat() CardChat {
+	return CardChat{ChatId: msg.ChatId, ChatType: msg.ChatType}
+}
+
+// CardChat 发出卡片的会话。卡片回调中没有会话信息，需要检查权限、额度或频率限制的按钮
+// 在 value 中带上会话，回调时按会话判断
+type CardChat struct {
+	ChatId   string
+	ChatType CardChatType
+}
+
+func (c CardChat) value(v map[string]interface{}) map[string]interface{} {
+	v["chatId"] = c.ChatId
+	v["chatType"] = c.ChatType
+	return v
+}
+
+// cardChatOf 消息所在的会话
+func cardChatOf(a *ActionInfo) CardChat {
+	chat := CardChat{ChatId: *a.info.chatId, ChatType: UserChatType}
+	if a.info.handlerType == GroupHandler {
+		chat.ChatType = GroupChatType
+	}
+	return chat
+}
+
+// isGroup 会话是否为群聊
+func (c CardChat) isGroup() bool {
+	return c.ChatType == GroupChatType
+}
+
 type MenuOption struct {
 	value string
 	label string
@@ -202,6 +247,82 @@ func withImageDiv(imageKey string) larkcard.MessageCardElement {
 	return imageElement
 }
 
+// columnSet 多列布局，SDK 中没有对应的组件，这里直接生成卡片 JSON
+type columnSet struct {
+	columns [][]larkcard.MessageCardElement
+}
+
+func (c *columnSet) Tag() string {
+	return "column_set"
+}
+
+func (c *columnSet) MarshalJSON() ([]byte, error) {
+	columns := make([]map[string]interface{}, 0, len(c.columns))
+	for _, elements := range c.columns {
+		columns = append(columns, map[string]interface{}{
+			"tag":            "column",
+			"width":          "weighted",
+			"weight":         1,
+			"vertical_align": "top",
+			"elements":       elements,
+		})
+	}
+	return json.Marshal(map[string]interface{}{
+		"tag":              c.Tag(),
+		"flex_mode":        "bisect",
+		"background_style": "default",
+		"columns":          columns,
+	})
+}
+
+// withImageGallery 每行两张图片，图片下方是对应的操作按钮
+func withImageGallery(imageKeys []string, msgId *string,
+	sessionId *string, chat CardChat) []larkcard.MessageCardElement {
+	var rows []larkcard.MessageCardElement
+	for i := 0; i < len(imageKeys); i += 2 {
+		row := &columnSet{}
+		for _, imageKey := range imageKeys[i:minInt(i+2, len(imageKeys))] {
+			row.columns = append(row.columns, []larkcard.MessageCardElement{
+				withImageDiv(imageKey),
+				withImageActionBtn(imageKey, msgId, sessionId, chat),
+			})
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+func withImageActionBtn(imageKey string, msgId *string,
+	sessionId *string, chat CardChat) larkcard.MessageCardElement {
+	value := func(kind CardKind) map[string]interface{} {
+		return chat.value(map[string]interface{}{
+			"value":     imageKey,
+			"kind":      kind,
+			"msgId":     *msgId,
+			"sessionId": *sessionId,
+		})
+	}
+	actions := larkcard.NewMessageCardAction().
+		Actions([]larkcard.MessageCardActionElement{
+			newBtn("变体", value(PicVarMoreKind),
+				larkcard.MessageCardButtonTypeDefault),
+			newBtn("编辑", value(PicEditKind),
+				larkcard.MessageCardButtonTypeDefault),
+			newBtn("作为参考", value(PicReferenceKind),
+				larkcard.MessageCardButtonTypeDefault),
+		}).
+		Layout(larkcard.MessageCardActionLayoutFlow.Ptr()).
+		Build()
+	return actions
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
 // withMdAndExtraBtn 用于生成带有额外按钮的消息体
 func withMdAndExtraBtn(msg string, btn *larkcard.
 	MessageCardEmbedButton) larkcard.MessageCardElement {
@@ -289,21 +410,19 @@ func withClearDoubleCheckBtn(sessionID *string) larkcard.MessageCardElement {
 	return actions
 }
 
-func withPicModeDoubleCheckBtn(sessionID *string) larkcard.
+func withPicModeDoubleCheckBtn(sessionID *string, chat CardChat) larkcard.
 	MessageCardElement {
-	confirmBtn := newBtn("切换模式", map[string]interface{}{
+	confirmBtn := newBtn("切换模式", chat.value(map[string]interface{}{
 		"value":     "1",
 		"kind":      PicModeChangeKind,
-		"chatType":  UserChatType,
 		"sessionId": *sessionID,
-	}, larkcard.MessageCardButtonTypeDanger,
+	}), larkcard.MessageCardButtonTypeDanger,
 	)
-	cancelBtn := newBtn("我再想想", map[string]interface{}{
+	cancelBtn := newBtn("我再想想", chat.value(map[string]interface{}{
 		"value":     "0",
 		"kind":      PicModeChangeKind,
 		"sessionId": *sessionID,
-		"chatType":  UserChatType,
-	},
+	}),
 		larkcard.MessageCardButtonTypeDefault)
 
 	actions := larkcard.NewMessageCardAction().
@@ -313,21 +432,19 @@ func withPicModeDoubleCheckBtn(sessionID *string) larkcard.
 
 	return actions
 }
-func withVisionModeDoubleCheckBtn(sessionID *string) larkcard.
+func withVisionModeDoubleCheckBtn(sessionID *string, chat CardChat) larkcard.
 	MessageCardElement {
-	confirmBtn := newBtn("切换模式", map[string]interface{}{
+	confirmBtn := newBtn("切换模式", chat.value(map[string]interface{}{
 		"value":     "1",
 		"kind":      VisionModeChangeKind,
-		"chatType":  UserChatType,
 		"sessionId": *sessionID,
-	}, larkcard.MessageCardButtonTypeDanger,
+	}), larkcard.MessageCardButtonTypeDanger,
 	)
-	cancelBtn := newBtn("我再想想", map[string]interface{}{
+	cancelBtn := newBtn("我再想想", chat.value(map[string]interface{}{
 		"value":     "0",
 		"kind":      VisionModeChangeKind,
 		"sessionId": *sessionID,
-		"chatType":  UserChatType,
-	},
+	}),
 		larkcard.MessageCardButtonTypeDefault)
 
 	actions := larkcard.NewMessageCardAction().
@@ -349,15 +466,15 @@ func withOneBtn(btn *larkcard.MessageCardEmbedButton) larkcard.
 
 //新建对话按钮
 
-func withPicResolutionBtn(sessionID *string) larkcard.
+func withPicResolutionBtn(sessionID *string, chat CardChat) larkcard.
 	MessageCardElement {
 	resolutionMenu := newMenu("默认分辨率",
-		map[string]interface{}{
+		chat.value(map[string]interface{}{
 			"value":     "0",
 			"kind":      PicResolutionKind,
 			"sessionId": *sessionID,
 			"msgId":     *sessionID,
-		},
+		}),
 		// dall-e-2 256, 512, 1024
 		//MenuOption{
 		//	label: "256x256",
@@ -383,12 +500,12 @@ func withPicResolutionBtn(sessionID *string) larkcard.
 	)
 
 	styleMenu := newMenu("风格",
-		map[string]interface{}{
+		chat.value(map[string]interface{}{
 			"value":     "0",
 			"kind":      PicStyleKind,
 			"sessionId": *sessionID,
 			"msgId":     *sessionID,
-		},
+		}),
 		MenuOption{
 			label: "生动风格",
 			value: string(services.PicStyleVivid),
@@ -399,22 +516,57 @@ func withPicResolutionBtn(sessionID *string) larkcard.
 		},
 	)
 
+	var modelOptions []MenuOption
+	for _, model := range openai.ImageModels {
+		modelOptions = append(modelOptions, MenuOption{
+			label: model,
+			value: model,
+		})
+	}
+	modelMenu := newMenu("模型",
+		chat.value(map[string]interface{}{
+			"value":     "0",
+			"kind":      PicModelKind,
+			"sessionId": *sessionID,
+			"msgId":     *sessionID,
+		}),
+		modelOptions...,
+	)
+
+	var countOptions []MenuOption
+	for i := 1; i <= services.MaxPicCount; i++ {
+		countOptions = append(countOptions, MenuOption{
+			label: fmt.Sprintf("%d 张", i),
+			value: fmt.Sprintf("%d", i),
+		})
+	}
+	countMenu := newMenu("数量",
+		chat.value(map[string]interface{}{
+			"value":     "0",
+			"kind":      PicCountKind,
+			"sessionId": *sessionID,
+			"msgId":     *sessionID,
+		}),
+		countOptions...,
+	)
+
 	actions := larkcard.NewMessageCardAction().
-		Actions([]larkcard.MessageCardActionElement{resolutionMenu, styleMenu}).
+		Actions([]larkcard.MessageCardActionElement{resolutionMenu, styleMenu,
+			modelMenu, countMenu}).
 		Layout(larkcard.MessageCardActionLayoutFlow.Ptr()).
 		Build()
 	return actions
 }
 
-func withVisionDetailLevelBtn(sessionID *string) larkcard.
+func withVisionDetailLevelBtn(sessionID *string, chat CardChat) larkcard.
 	MessageCardElement {
 	detailMenu := newMenu("选择图片解析度，默认为高",
-		map[string]interface{}{
+		chat.value(map[string]interface{}{
 			"value":     "0",
 			"kind":      VisionStyleKind,
 			"sessionId": *sessionID,
 			"msgId":     *sessionID,
-		},
+		}),
 		MenuOption{
 			label: "高",
 			value: string(services.VisionDetailHigh),
@@ -432,7 +584,7 @@ func withVisionDetailLevelBtn(sessionID *string) larkcard.
 
 	return actions
 }
-func withRoleTagsBtn(sessionID *string, tags ...string) larkcard.
+func withRoleTagsBtn(sessionID *string, chat CardChat, tags ...string) larkcard.
 	MessageCardElement {
 	var menuOptions []MenuOption
 
@@ -443,12 +595,12 @@ func withRoleTagsBtn(sessionID *string, tags ...string) larkcard.
 		})
 	}
 	cancelMenu := newMenu("选择角色分类",
-		map[string]interface{}{
+		chat.value(map[string]interface{}{
 			"value":     "0",
 			"kind":      RoleTagsChooseKind,
 			"sessionId": *sessionID,
 			"msgId":     *sessionID,
-		},
+		}),
 		menuOptions...,
 	)
 
@@ -459,7 +611,7 @@ func withRoleTagsBtn(sessionID *string, tags ...string) larkcard.
 	return actions
 }
 
-func withRoleBtn(sessionID *string, titles ...string) larkcard.
+func withRoleBtn(sessionID *string, chat CardChat, titles ...string) larkcard.
 	MessageCardElement {
 	var menuOptions []MenuOption
 
@@ -470,12 +622,12 @@ func withRoleBtn(sessionID *string, titles ...string) larkcard.
 		})
 	}
 	cancelMenu := newMenu("查看内置角色",
-		map[string]interface{}{
+		chat.value(map[string]interface{}{
 			"value":     "0",
 			"kind":      RoleChooseKind,
 			"sessionId": *sessionID,
 			"msgId":     *sessionID,
-		},
+		}),
 		menuOptions...,
 	)
 
@@ -486,7 +638,7 @@ func withRoleBtn(sessionID *string, titles ...string) larkcard.
 	return actions
 }
 
-func withAIModeBtn(sessionID *string, aiModeStrs []string) larkcard.MessageCardElement {
+func withAIModeBtn(sessionID *string, chat CardChat, aiModeStrs []string) larkcard.MessageCardElement {
 	var menuOptions []MenuOption
 	for _, label := range aiModeStrs {
 		menuOptions = append(menuOptions, MenuOption{
@@ -496,12 +648,12 @@ func withAIModeBtn(sessionID *string, aiModeStrs []string) larkcard.MessageCardE
 	}
 
 	cancelMenu := newMenu("选择模式",
-		map[string]interface{}{
+		chat.value(map[string]interface{}{
 			"value":     "0",
 			"kind":      AIModeChooseKind,
 			"sessionId": *sessionID,
 			"msgId":     *sessionID,
-		},
+		}),
 		menuOptions...,
 	)
 
@@ -512,6 +664,32 @@ func withAIModeBtn(sessionID *string, aiModeStrs []string) larkcard.MessageCardE
 	return actions
 }
 
+func withVoiceBtn(sessionID *string, chat CardChat, msgId *string) larkcard.MessageCardElement {
+	menuOptions := []MenuOption{{label: "关闭语音回复", value: "off"}}
+	for _, voice := range openai.Voices {
+		menuOptions = append(menuOptions, MenuOption{
+			label: voice,
+			value: voice,
+		})
+	}
+
+	voiceMenu := newMenu("选择音色",
+		chat.value(map[string]interface{}{
+			"value":     "0",
+			"kind":      VoiceChooseKind,
+			"sessionId": *sessionID,
+			"msgId":     *msgId,
+		}),
+		menuOptions...,
+	)
+
+	actions := larkcard.NewMessageCardAction().
+		Actions([]larkcard.MessageCardActionElement{voiceMenu}).
+		Layout(larkcard.MessageCardActionLayoutFlow.Ptr()).
+		Build()
+	return actions
+}
+
 func replyMsg(ctx context.Context, msg string, msgId *string) error {
 	msg, i := processMessage(msg)
 	if i != nil {
@@ -574,12 +752,73 @@ func uploadImage(base64Str string) (*string, error) {
 	return resp.Data.ImageKey, nil
 }
 
-func replyImage(ctx context.Context, ImageKey *string,
+// uploadFile 上传文件，duration 仅对音视频文件有效，单位毫秒
+func uploadFile(fileType string, fileName string, file io.Reader,
+	duration int) (*string, error) {
+	client := initialization.GetLarkClient()
+	body := larkim.NewCreateFileReqBodyBuilder().
+		FileType(fileType).
+		FileName(fileName).
+		File(file)
+	if duration > 0 {
+		body = body.Duration(duration)
+	}
+	resp, err := client.Im.File.Create(context.Background(),
+		larkim.NewCreateFileReqBuilder().
+			Body(body.Build()).
+			Build())
+
+	// 处理错误
+	if err != nil {
+		fmt.Println(err)
+		return nil, err
+	}
+
+	// 服务端错误处理
+	if !resp.Success() {
+		fmt.Println(resp.Code, resp.Msg, resp.RequestId())
+		return nil, errors.New(resp.Msg)
+	}
+	return resp.Data.FileKey, nil
+}
+
+func replyAudio(ctx context.Context, fileKey *string,
 	msgId *string) error {
-	//fmt.Println("sendMsg", ImageKey, msgId)
+	msgAudio := larkim.MessageAudio{FileKey: *fileKey}
+	content, err := msgAudio.String()
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+	client := initialization.GetLarkClient()
 
-	msgImage := larkim.MessageImage{ImageKey: *ImageKey}
-	content, err := msgImage.String()
+	resp, err := client.Im.Message.Reply(ctx, larkim.NewReplyMessageReqBuilder().
+		MessageId(*msgId).
+		Body(larkim.NewReplyMessageReqBodyBuilder().
+			MsgType(larkim.MsgTypeAudio).
+			Uuid(uuid.New().String()).
+			Content(content).
+			Build()).
+		Build())
+
+	// 处理错误
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	// 服务端错误处理
+	if !resp.Success() {
+		fmt.Println(resp.Code, resp.Msg, resp.RequestId())
+		return errors.New(resp.Msg)
+	}
+	return nil
+}
+
+func replyFile(ctx context.Context, fileKey *string,
+	msgId *string) error {
+	msgFile := larkim.MessageFile{FileKey: *fileKey}
+	content, err := msgFile.String()
 	if err != nil {
 		fmt.Println(err)
 		return err
@@ -589,7 +828,7 @@ func replyImage(ctx context.Context, ImageKey *string,
 	resp, err := client.Im.Message.Reply(ctx, larkim.NewReplyMessageReqBuilder().
 		MessageId(*msgId).
 		Body(larkim.NewReplyMessageReqBodyBuilder().
-			MsgType(larkim.MsgTypeImage).
+			MsgType(larkim.MsgTypeFile).
 			Uuid(uuid.New().String()).
 			Content(content).
 			Build()).
@@ -609,22 +848,56 @@ func replyImage(ctx context.Context, ImageKey *string,
 	return nil
 }
 
-func replayImageCardByBase64(ctx context.Context, base64Str string,
-	msgId *string, sessionId *string, question string) error {
-	imageKey, err := uploadImage(base64Str)
+func replyImage(ctx context.Context, ImageKey *string,
+	msgId *string) error {
+	//fmt.Println("sendMsg", ImageKey, msgId)
+
+	msgImage := larkim.MessageImage{ImageKey: *ImageKey}
+	content, err := msgImage.String()
 	if err != nil {
+		fmt.Println(err)
 		return err
 	}
-	//example := "img_v2_041b28e3-5680-48c2-9af2-497ace79333g"
-	//imageKey := &example
-	//fmt.Println("imageKey", *imageKey)
-	err = sendImageCard(ctx, *imageKey, msgId, sessionId, question)
+	client := initialization.GetLarkClient()
+
+	resp, err := client.Im.Message.Reply(ctx, larkim.NewReplyMessageReqBuilder().
+		MessageId(*msgId).
+		Body(larkim.NewReplyMessageReqBodyBuilder().
+			MsgType(larkim.MsgTypeImage).
+			Uuid(uuid.New().String()).
+			Content(content).
+			Build()).
+		Build())
+
+	// 处理错误
 	if err != nil {
+		fmt.Println(err)
 		return err
 	}
+
+	// 服务端错误处理
+	if !resp.Success() {
+		fmt.Println(resp.Code, resp.Msg, resp.RequestId())
+		return errors.New(resp.Msg)
+	}
 	return nil
 }
 
+// replyImageGalleryByBase64 上传生成的图片并以图集卡片回复，
+// question 不为空时附带“再来一组”按钮
+func replyImageGalleryByBase64(ctx context.Context, base64Strs []string,
+	msgId *string, sessionId *string, chat CardChat, question string) error {
+	var imageKeys []string
+	for _, base64Str := range base64Strs {
+		imageKey, err := uploadImage(base64Str)
+		if err != nil {
+			return err
+		}
+		imageKeys = append(imageKeys, *imageKey)
+	}
+	return sendImageGalleryCard(ctx, imageKeys, msgId, sessionId, chat, question)
+}
+
 func replayImagePlainByBase64(ctx context.Context, base64Str string,
 	msgId *string) error {
 	imageKey, err := uploadImage(base64Str)
@@ -642,7 +915,7 @@ func replayImagePlainByBase64(ctx context.Context, base64Str string,
 }
 
 func replayVariantImageByBase64(ctx context.Context, base64Str string,
-	msgId *string, sessionId *string) error {
+	msgId *string, sessionId *string, chat CardChat) error {
 	imageKey, err := uploadImage(base64Str)
 	if err != nil {
 		return err
@@ -650,7 +923,7 @@ func replayVariantImageByBase64(ctx context.Context, base64Str string,
 	//example := "img_v2_041b28e3-5680-48c2-9af2-497ace79333g"
 	//imageKey := &example
 	//fmt.Println("imageKey", *imageKey)
-	err = sendVarImageCard(ctx, *imageKey, msgId, sessionId)
+	err = sendVarImageCard(ctx, *imageKey, msgId, sessionId, chat)
 	if err != nil {
 		return err
 	}
@@ -713,39 +986,40 @@ func sendSystemInstructionCard(ctx context.Context,
 }
 
 func sendPicCreateInstructionCard(ctx context.Context,
-	sessionId *string, msgId *string) {
+	sessionId *string, chat CardChat, msgId *string) {
 	newCard, _ := newSendCard(
 		withHeader("🖼️ 已进入图片创作模式", larkcard.TemplateBlue),
-		withPicResolutionBtn(sessionId),
-		withNote("提醒：回复文本或图片，让AI生成相关的图片。"))
+		withPicResolutionBtn(sessionId, chat),
+		withNote("提醒：回复文本生成图片，单次最多生成 4 张，可对每张图片生成变体、编辑或作为参考图；"+
+			"发送图片并附上修改说明可编辑图片，选择 gpt-image-1 模型时支持多张图片和蒙版。"))
 	replyCard(ctx, msgId, newCard)
 }
 
 func sendVisionInstructionCard(ctx context.Context,
-	sessionId *string, msgId *string) {
+	sessionId *string, chat CardChat, msgId *string) {
 	newCard, _ := newSendCard(
 		withHeader("🕵️️ 已进入图片推理模式", larkcard.TemplateBlue),
-		withVisionDetailLevelBtn(sessionId),
-		withNote("提醒：回复图片，让LLM和你一起推理图片的内容。"))
+		withVisionDetailLevelBtn(sessionId, chat),
+		withNote("提醒：回复图片或图文消息，让LLM和你一起推理图片的内容，之后可以继续用文字追问。"))
 	replyCard(ctx, msgId, newCard)
 }
 
 func sendPicModeCheckCard(ctx context.Context,
-	sessionId *string, msgId *string) {
+	sessionId *string, chat CardChat, msgId *string) {
 	newCard, _ := newSendCard(
 		withHeader("🖼️ 机器人提醒", larkcard.TemplateBlue),
 		withMainMd("收到图片，是否进入图片创作模式？"),
 		withNote("请注意，这将开始一个全新的对话，您将无法利用之前话题的历史信息"),
-		withPicModeDoubleCheckBtn(sessionId))
+		withPicModeDoubleCheckBtn(sessionId, chat))
 	replyCard(ctx, msgId, newCard)
 }
 func sendVisionModeCheckCard(ctx context.Context,
-	sessionId *string, msgId *string) {
+	sessionId *string, chat CardChat, msgId *string) {
 	newCard, _ := newSendCard(
 		withHeader("🕵️ 机器人提醒", larkcard.TemplateBlue),
 		withMainMd("检测到图片，是否进入图片推理模式？"),
 		withNote("请注意，这将开始一个全新的对话，您将无法利用之前话题的历史信息"),
-		withVisionModeDoubleCheckBtn(sessionId))
+		withVisionModeDoubleCheckBtn(sessionId, chat))
 	replyCard(ctx, msgId, newCard)
 }
 
@@ -768,110 +1042,400 @@ func sendOldTopicCard(ctx context.Context,
 }
 
 func sendVisionTopicCard(ctx context.Context,
-	sessionId *string, msgId *string, content string) {
+	sessionId *string, msgId *string, content string,
+	images []openai.VisionImage) {
 	newCard, _ := newSendCard(
 		withHeader("🕵️图片推理结果", larkcard.TemplateBlue),
 		withMainText(content),
-		withNote("让LLM和你一起推理图片的内容~"))
+		withNote("让LLM和你一起推理图片的内容~"+formatImageTokens(images)))
 	replyCard(ctx, msgId, newCard)
 }
 
+// formatImageTokens 汇总图片的尺寸和估算的 token 消耗
+func formatImageTokens(images []openai.VisionImage) string {
+	if len(images) == 0 {
+		return ""
+	}
+	tokens := 0
+	var sizes []string
+	for _, image := range images {
+		tokens += image.Tokens
+		sizes = append(sizes, fmt.Sprintf("%dx%d", image.Width, image.Height))
+	}
+	return fmt.Sprintf("\n图片 %s，预计消耗约 %d tokens",
+		strings.Join(sizes, "、"), tokens)
+}
+
 func sendHelpCard(ctx context.Context,
 	sessionId *string, msgId *string) {
+	elements := []larkcard.MessageCardElement{
+		withMainMd("**🤠你好呀~ 我来自企联AI，一款基于OpenAI的智能助手！**"),
+	}
+	for _, c := range commands.Commands() {
+		elements = append(elements, withSplitLine())
+		content := formatCommandHelp(c)
+		if c.Name == "clear" {
+			elements = append(elements, withMdAndExtraBtn(content,
+				newBtn("立刻清除", map[string]interface{}{
+					"value":     "1",
+					"kind":      ClearCardKind,
+					"chatType":  UserChatType,
+					"sessionId": *sessionId,
+				}, larkcard.MessageCardButtonTypeDanger)))
+			continue
+		}
+		elements = append(elements, withMainMd(content))
+	}
+	for _, tip := range helpTips {
+		elements = append(elements, withSplitLine(), withMainMd(tip))
+	}
+	elements = append(elements,
+		withNote("回复 /help 指令名 查看参数说明，参数中有空格时请加引号"))
 	newCard, _ := newSendCard(
 		withHeader("🎒需要帮助吗？", larkcard.TemplateBlue),
-		withMainMd("**🤠你好呀~ 我来自企联AI，一款基于OpenAI的智能助手！**"),
-		withSplitLine(),
-		withMdAndExtraBtn(
-			"** 🆑 清除话题上下文**\n文本回复 *清除* 或 */clear*",
-			newBtn("立刻清除", map[string]interface{}{
-				"value":     "1",
-				"kind":      ClearCardKind,
-				"chatType":  UserChatType,
-				"sessionId": *sessionId,
-			}, larkcard.MessageCardButtonTypeDanger)),
-		withSplitLine(),
-		withMainMd("🤖 **发散模式选择** \n"+" 文本回复 *发散模式* 或 */ai_mode*"),
-		withSplitLine(),
-		withMainMd("🛖 **内置角色列表** \n"+" 文本回复 *角色列表* 或 */roles*"),
-		withSplitLine(),
-		withMainMd("🥷 **角色扮演模式**\n文本回复*角色扮演* 或 */system*+空格+角色信息"),
-		withSplitLine(),
-		withMainMd("🎤 **AI语音对话**\n私聊模式下直接发送语音"),
-		withSplitLine(),
-		withMainMd("🎨 **图片创作模式**\n回复*图片创作* 或 */picture*"),
-		withSplitLine(),
-		withMainMd("🕵️ **图片推理模式** \n"+" 文本回复 *图片推理* 或 */vision*"),
-		withSplitLine(),
-		withMainMd("🎰 **Token余额查询**\n回复*余额* 或 */balance*"),
-		withSplitLine(),
-		withMainMd("🔃️ **历史话题回档** 🚧\n"+" 进入话题的回复详情页,文本回复 *恢复* 或 */reload*"),
-		withSplitLine(),
-		withMainMd("📤 **话题内容导出** 🚧\n"+" 文本回复 *导出* 或 */export*"),
-		withSplitLine(),
-		withMainMd("🎰 **连续对话与多话题模式**\n"+" 点击对话框参与回复，可保持话题连贯。同时，单独提问即可开启全新新话题"),
-		withSplitLine(),
-		withMainMd("🎒 **需要更多帮助**\n文本回复 *帮助* 或 */help*"),
-	)
+		elements...)
 	replyCard(ctx, msgId, newCard)
 }
 
-func sendImageCard(ctx context.Context, imageKey string,
-	msgId *string, sessionId *string, question string) error {
-	newCard, _ := newSimpleSendCard(
-		withImageDiv(imageKey),
-		withSplitLine(),
-		//再来一张
-		withOneBtn(newBtn("再来一张", map[string]interface{}{
-			"value":     question,
-			"kind":      PicTextMoreKind,
-			"chatType":  UserChatType,
-			"msgId":     *msgId,
-			"sessionId": *sessionId,
-		}, larkcard.MessageCardButtonTypePrimary)),
+// helpTips 不需要指令的功能说明
+var helpTips = []string{
+	"📝 **音视频纪要**\n发送会议录音或视频文件，自动转写并总结",
+	"📄 **文档问答**\n发送 PDF、Word、Excel、CSV、Markdown 或代码文件，在话题中直接提问",
+	"💬 **引用提问**\n回复群里任意一条消息并 @ 我，可以针对这条消息的文字、图片或文档提问",
+	"🎰 **连续对话与多话题模式**\n点击对话框参与回复，可保持话题连贯。同时，单独提问即可开启全新新话题",
+}
+
+// formatCommandHelp 帮助卡片中一条指令的说明
+func formatCommandHelp(c *Command) string {
+	title := fmt.Sprintf("%s **%s**", c.Emoji, c.Title)
+	if c.Admin {
+		title += "（管理员）"
+	}
+	lines := []string{title, c.Help}
+	reply := fmt.Sprintf("文本回复 *%s* 或 */%s*", strings.Join(c.Aliases, "* 或 *"), c.Name)
+	if len(c.Args) > 0 || len(c.Flags) > 0 {
+		reply += "，用法 " + c.Usage()
+	}
+	return strings.Join(append(lines, reply), "\n")
+}
+
+// sendCommandHelpCard 一条指令的详细用法
+func sendCommandHelpCard(ctx context.Context, msgId *string, c *Command) {
+	names := []string{"/" + c.Name}
+	names = append(names, c.Aliases...)
+	lines := []string{
+		c.Help,
+		"",
+		"**用法**\n" + c.Usage(),
+		"**名称**\n" + strings.Join(names, "、"),
+	}
+	if len(c.Args) > 0 {
+		var args []string
+		for _, arg := range c.Args {
+			text := arg.label()
+			if arg.Optional {
+				text += "（可选）"
+			}
+			if arg.Rest {
+				text += "，之后的全部文字"
+			}
+			args = append(args, text)
+		}
+		lines = append(lines, "**参数**\n"+strings.Join(args, "\n"))
+	}
+	if len(c.Flags) > 0 {
+		var flags []string
+		for _, flag := range c.Flags {
+			text := "--" + flag.Name
+			if flag.Short != "" {
+				text += "、-" + flag.Short
+			}
+			if !flag.Bool {
+				text += " " + flag.label()
+			}
+			if flag.Help != "" {
+				text += "　" + flag.Help
+			}
+			if flag.Default != "" {
+				text += "，默认 " + flag.Default
+			}
+			flags = append(flags, text)
+		}
+		lines = append(lines, "**选项**\n"+strings.Join(flags, "\n"))
+	}
+	title := fmt.Sprintf("%s %s", c.Emoji, c.Title)
+	if c.Admin {
+		title += "（管理员）"
+	}
+	newCard, _ := newSendCard(
+		withHeader(title, larkcard.TemplateBlue),
+		withMainMd(strings.Join(lines, "\n")),
+		withNote("参数中有空格时请加引号，例如 \"一段 文字\""),
 	)
 	replyCard(ctx, msgId, newCard)
-	return nil
+}
+
+func sendImageGalleryCard(ctx context.Context, imageKeys []string,
+	msgId *string, sessionId *string, chat CardChat, question string) error {
+	elements := withImageGallery(imageKeys, msgId, sessionId, chat)
+	if question != "" {
+		elements = append(elements,
+			withSplitLine(),
+			//再来一组
+			withOneBtn(newBtn("再来一组", chat.value(map[string]interface{}{
+				"value":     question,
+				"kind":      PicTextMoreKind,
+				"msgId":     *msgId,
+				"sessionId": *sessionId,
+			}), larkcard.MessageCardButtonTypePrimary)))
+	}
+	elements = append(elements, withNote(
+		"变体：生成相似的图片；编辑：发送修改说明来修改这张图片；作为参考：可选多张，再发送描述生成新图片"))
+	newCard, err := newSimpleSendCard(elements...)
+	if err != nil {
+		return err
+	}
+	return replyCard(ctx, msgId, newCard)
 }
 
 func sendVarImageCard(ctx context.Context, imageKey string,
-	msgId *string, sessionId *string) error {
+	msgId *string, sessionId *string, chat CardChat) error {
 	newCard, _ := newSimpleSendCard(
 		withImageDiv(imageKey),
 		withSplitLine(),
 		//再来一张
-		withOneBtn(newBtn("再来一张", map[string]interface{}{
+		withOneBtn(newBtn("再来一张", chat.value(map[string]interface{}{
 			"value":     imageKey,
 			"kind":      PicVarMoreKind,
-			"chatType":  UserChatType,
 			"msgId":     *msgId,
 			"sessionId": *sessionId,
-		}, larkcard.MessageCardButtonTypePrimary)),
+		}), larkcard.MessageCardButtonTypePrimary)),
 	)
 	replyCard(ctx, msgId, newCard)
 	return nil
 }
 
 func sendBalanceCard(ctx context.Context, msgId *string,
-	balance openai.BalanceResponse) {
+	store usage.StoreInterface, userId string, chatId string, now time.Time) {
+	today := usage.DayStart(now)
+	month := usage.MonthStart(now)
+	userMonth := store.Query(usage.Filter{UserId: userId, Since: month})
+	elements := []larkcard.MessageCardElement{
+		withMainMd(fmt.Sprintf("**👤 个人**\n今日: %s\n本月: %s",
+			formatSpend(usage.Sum(filterSince(userMonth, today))),
+			formatSpend(usage.Sum(userMonth)))),
+	}
+	if chatId != "" {
+		groupMonth := store.Query(usage.Filter{ChatId: chatId, Since: month})
+		elements = append(elements, withSplitLine(),
+			withMainMd(fmt.Sprintf("**👥 本群**\n今日: %s\n本月: %s",
+				formatSpend(usage.Sum(filterSince(groupMonth, today))),
+				formatSpend(usage.Sum(groupMonth)))))
+	}
+	if len(userMonth) > 0 {
+		elements = append(elements, withSplitLine(),
+			withMainMd("**🧠 本月个人按模型**\n"+formatRanked(
+				usage.GroupBy(userMonth, usage.ByModel), 0, nil)))
+	}
+	elements = append(elements,
+		withNote("花费根据本地记录的 token 用量和模型价格估算，仅供参考"))
+	newCard, _ := newSendCard(
+		withHeader("🎰️ 花费查询", larkcard.TemplateBlue),
+		elements...)
+	replyCard(ctx, msgId, newCard)
+}
+
+func sendGlobalBalanceCard(ctx context.Context, msgId *string,
+	store usage.StoreInterface, now time.Time) {
+	today := usage.DayStart(now)
+	month := store.Query(usage.Filter{Since: usage.MonthStart(now)})
+	newCard, _ := newSendCard(
+		withHeader("🎰️ 全局花费", larkcard.TemplateIndigo),
+		withMainMd(fmt.Sprintf("**🌐 全局**\n今日: %s\n本月: %s",
+			formatSpend(usage.Sum(filterSince(month, today))),
+			formatSpend(usage.Sum(month)))),
+		withSplitLine(),
+		withMainMd("**👤 本月用户 Top10**\n"+formatRanked(
+			usage.GroupBy(month, usage.ByUser), 10, formatUserKey)),
+		withSplitLine(),
+		withMainMd("**👥 本月会话 Top10**\n"+formatRanked(
+			usage.GroupBy(month, usage.ByChat), 10, nil)),
+		withSplitLine(),
+		withMainMd("**🧠 本月按模型**\n"+formatRanked(
+			usage.GroupBy(month, usage.ByModel), 0, nil)),
+		withNote("花费根据本地记录的 token 用量和模型价格估算，仅供参考"),
+	)
+	replyCard(ctx, msgId, newCard)
+}
+
+func filterSince(records []usage.Record, since time.Time) []usage.Record {
+	var result []usage.Record
+	for _, r := range records {
+		if !r.Time.Before(since) {
+			result = append(result, r)
+		}
+	}
+	return result
+}
+
+func formatSpend(total usage.Total) string {
+	return fmt.Sprintf("%.4f$（%d tokens，%d 次请求）",
+		total.Cost, total.Tokens, total.Requests)
+}
+
+// formatUserKey 用 at 标签展示用户名
+func formatUserKey(openId string) string {
+	if openId == "" {
+		return "未知用户"
+	}
+	return fmt.Sprintf("<at id=%s></at>", openId)
+}
+
+func formatRanked(ranked []usage.Ranked, n int,
+	formatKey func(string) string) string {
+	ranked = usage.Top(ranked, n)
+	if len(ranked) == 0 {
+		return "暂无记录"
+	}
+	var lines []string
+	for i, r := range ranked {
+		key := r.Key
+		if formatKey != nil {
+			key = formatKey(key)
+		}
+		lines = append(lines, fmt.Sprintf("%d. %s: %s", i+1, key,
+			formatSpend(r.Total)))
+	}
+	return strings.Join(lines, "\n")
+}
+
+var quotaScopeNames = map[usage.Scope]string{
+	usage.ScopeUser:   "个人",
+	usage.ScopeGroup:  "本群",
+	usage.ScopeGlobal: "全局",
+}
+
+var quotaPeriodNames = map[usage.Period]string{
+	usage.PeriodDay:   "今日",
+	usage.PeriodMonth: "本月",
+}
+
+func sendQuotaExceededCard(ctx context.Context, msgId *string,
+	exceeded *usage.Exceeded) {
+	newCard, _ := newSendCard(
+		withHeader("⛔️ 额度已用完", larkcard.TemplateRed),
+		withMainMd(fmt.Sprintf("%s%s额度已用完，暂时无法继续使用～",
+			quotaScopeNames[exceeded.Scope], quotaPeriodNames[exceeded.Period])),
+		withMainMd(fmt.Sprintf("已用: %s",
+			formatQuotaUsage(exceeded.Used, exceeded.Limit))),
+		withNote(fmt.Sprintf("额度将于 %s 重置，如需提高额度请联系管理员",
+			exceeded.ResetAt.Format("2006-01-02 15:04"))),
+	)
+	replyCard(ctx, msgId, newCard)
+}
+
+var capabilityNames = map[access.Capability]string{
+	access.CapChat:    "对话",
+	access.CapVision:  "图片推理",
+	access.CapPicture: "图片创作",
+	access.CapAudio:   "语音与音视频",
+}
+
+func sendAccessDeniedCard(ctx context.Context, msgId *string,
+	capability access.Capability) {
+	content := fmt.Sprintf("你没有使用 **%s** 的权限～", capabilityNames[capability])
+	if capability == access.CapAdmin {
+		content = "这是管理员指令，你没有使用权限～"
+	}
+	newCard, _ := newSendCard(
+		withHeader("🔒 没有权限", larkcard.TemplateRed),
+		withMainMd(content),
+		withNote("如需开通请联系管理员，回复 /help 查看可以使用的功能"),
+	)
+	replyCard(ctx, msgId, newCard)
+}
+
+var rateLimitScopeNames = map[ratelimit.Scope]string{
+	ratelimit.ScopeUser:   "你",
+	ratelimit.ScopeChat:   "本群",
+	ratelimit.ScopeGlobal: "机器人",
+}
+
+func sendRateLimitedCard(ctx context.Context, msgId *string,
+	denied *ratelimit.Denied, now time.Time) {
+	wait := denied.RetryAt.Sub(now).Round(time.Second)
+	if wait < time.Second {
+		wait = time.Second
+	}
+	newCard, _ := newSendCard(
+		withHeader("🐢 请求太频繁", larkcard.TemplateOrange),
+		withMainMd(fmt.Sprintf("%s的请求太频繁了，请在 **%s**（约 %s 后）再试～",
+			rateLimitScopeNames[denied.Scope], denied.RetryAt.Format("15:04:05"),
+			formatWait(wait))),
+		withNote(fmt.Sprintf("限制为每 %s 最多 %d 次，这条消息没有被处理",
+			formatWait(denied.Rate.Period), denied.Rate.Limit)),
+	)
+	replyCard(ctx, msgId, newCard)
+}
+
+// formatWait 把时长格式化为“1 分钟 30 秒”
+func formatWait(d time.Duration) string {
+	d = d.Round(time.Second)
+	var parts []string
+	if h := int(d.Hours()); h > 0 {
+		parts = append(parts, fmt.Sprintf("%d 小时", h))
+	}
+	if m := int(d.Minutes()) % 60; m > 0 {
+		parts = append(parts, fmt.Sprintf("%d 分钟", m))
+	}
+	if s := int(d.Seconds()) % 60; s > 0 || len(parts) == 0 {
+		parts = append(parts, fmt.Sprintf("%d 秒", s))
+	}
+	return strings.Join(parts, " ")
+}
+
+func sendQueuedCard(ctx context.Context, msgId *string, position int) {
 	newCard, _ := newSendCard(
-		withHeader("🎰️ 余额查询", larkcard.TemplateBlue),
-		withMainMd(fmt.Sprintf("总额度: %.2f$", balance.TotalGranted)),
-		withMainMd(fmt.Sprintf("已用额度: %.2f$", balance.TotalUsed)),
-		withMainMd(fmt.Sprintf("可用额度: %.2f$",
-			balance.TotalAvailable)),
-		withNote(fmt.Sprintf("有效期: %s - %s",
-			balance.EffectiveAt.Format("2006-01-02 15:04:05"),
-			balance.ExpiresAt.Format("2006-01-02 15:04:05"))),
+		withHeader("⏳ 排队中", larkcard.TemplateOrange),
+		withMainMd(fmt.Sprintf("当前请求较多，你的消息排在第 **%d** 位，轮到后会自动回复～",
+			position)),
+		withNote("同一会话的消息按发送顺序依次处理，无需重复发送"),
 	)
 	replyCard(ctx, msgId, newCard)
 }
 
+// sendBusyCard 排队已满时提示稍后再试，chatFull 表示当前会话排队的消息过多
+func sendBusyCard(ctx context.Context, msgId *string, chatFull bool) {
+	content := "当前请求过多，机器人忙不过来了，请稍后再试～"
+	if chatFull {
+		content = "本会话还有多条消息正在排队，请等待回复后再发送～"
+	}
+	newCard, _ := newSendCard(
+		withHeader("🚦 机器人繁忙", larkcard.TemplateRed),
+		withMainMd(content),
+		withNote("这条消息没有被处理，请稍后重新发送"),
+	)
+	replyCard(ctx, msgId, newCard)
+}
+
+func sendQuotaCard(ctx context.Context, msgId *string, lines []QuotaLine) {
+	elements := []larkcard.MessageCardElement{}
+	for _, line := range lines {
+		elements = append(elements, withMainMd(fmt.Sprintf("**%s**: %s",
+			line.Title, formatQuotaUsage(line.Used, line.Limit))))
+	}
+	elements = append(elements, withNote("额度按自然日和自然月统计"))
+	newCard, _ := newSendCard(
+		withHeader("📊 额度使用情况", larkcard.TemplateBlue),
+		elements...)
+	replyCard(ctx, msgId, newCard)
+}
+
 func SendRoleTagsCard(ctx context.Context,
-	sessionId *string, msgId *string, roleTags []string) {
+	sessionId *string, chat CardChat, msgId *string, roleTags []string) {
 	newCard, _ := newSendCard(
 		withHeader("🛖 请选择角色类别", larkcard.TemplateIndigo),
-		withRoleTagsBtn(sessionId, roleTags...),
+		withRoleTagsBtn(sessionId, chat, roleTags...),
 		withNote("提醒：选择角色所属分类，以便我们为您推荐更多相关角色。"))
 	err := replyCard(ctx, msgId, newCard)
 	if err != nil {
@@ -880,23 +1444,37 @@ func SendRoleTagsCard(ctx context.Context,
 }
 
 func SendRoleListCard(ctx context.Context,
-	sessionId *string, msgId *string, roleTag string, roleList []string) {
+	sessionId *string, chat CardChat, msgId *string, roleTag string, roleList []string) {
 	newCard, _ := newSendCard(
 		withHeader("🛖 角色列表"+" - "+roleTag, larkcard.TemplateIndigo),
-		withRoleBtn(sessionId, roleList...),
+		withRoleBtn(sessionId, chat, roleList...),
 		withNote("提醒：选择内置场景，快速进入角色扮演模式。"))
 	replyCard(ctx, msgId, newCard)
 }
 
 func SendAIModeListsCard(ctx context.Context,
-	sessionId *string, msgId *string, aiModeStrs []string) {
+	sessionId *string, chat CardChat, msgId *string, aiModeStrs []string) {
 	newCard, _ := newSendCard(
 		withHeader("🤖 发散模式选择", larkcard.TemplateIndigo),
-		withAIModeBtn(sessionId, aiModeStrs),
+		withAIModeBtn(sessionId, chat, aiModeStrs),
 		withNote("提醒：选择内置模式，让AI更好的理解您的需求。"))
 	replyCard(ctx, msgId, newCard)
 }
 
+func SendVoiceListCard(ctx context.Context,
+	sessionId *string, chat CardChat, msgId *string, current string) {
+	status := "当前未开启语音回复"
+	if current != "" {
+		status = "当前音色: " + current
+	}
+	newCard, _ := newSendCard(
+		withHeader("🔊 语音回复", larkcard.TemplateIndigo),
+		withMainMd(status),
+		withVoiceBtn(sessionId, chat, msgId),
+		withNote("提醒：开启后机器人会在文字回答之后追加一条语音消息。"))
+	replyCard(ctx, msgId, newCard)
+}
+
 func sendOnProcessCard(ctx context.Context,
 	sessionId *string, msgId *string, ifNewTopic bool) (*string,
 	error) {
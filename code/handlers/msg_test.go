@@ -0,0 +1,22 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFormatWait(t *testing.T) {
+	tests := map[time.Duration]string{
+		0:                              "0 秒",
+		1500 * time.Millisecond:        "2 秒",
+		time.Minute:                    "1 分钟",
+		90 * time.Second:               "1 分钟 30 秒",
+		2*time.Hour + 5*time.Second:    "2 小时 5 秒",
+		time.Hour + 59*time.Minute + 1: "1 小时 59 分钟",
+	}
+	for d, want := range tests {
+		if got := formatWait(d); got != want {
+			t.Errorf("formatWait(%s) = %q, want %q", d, got, want)
+		}
+	}
+}
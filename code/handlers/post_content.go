@@ -0,0 +1,197 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"start-feishubot/logger"
+)
+
+// postElement 富文本中的一个行内元素，不同 tag 使用不同的字段
+type postElement struct {
+	Tag       string   `json:"tag"`
+	Text      string   `json:"text"`
+	Href      string   `json:"href"`
+	UserId    string   `json:"user_id"`
+	UserName  string   `json:"user_name"`
+	ImageKey  string   `json:"image_key"`
+	FileKey   string   `json:"file_key"`
+	EmojiType string   `json:"emoji_type"`
+	Language  string   `json:"language"`
+	Style     []string `json:"style"`
+}
+
+// postBody 一种语言的富文本，content 的每一项是一个段落
+type postBody struct {
+	Title   string          `json:"title"`
+	Content [][]postElement `json:"content"`
+}
+
+// postLocales 多语言富文本中优先使用的语言
+var postLocales = []string{"zh_cn", "en_us", "ja_jp"}
+
+// parsePost 解析富文本消息。接收到的消息直接是 {"title","content"}，
+// 发送格式按语言分组，例如 {"zh_cn":{...}}，也可能再包一层 {"post":{...}}
+func parsePost(content string) (*postBody, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(content), &fields); err != nil {
+		return nil, fmt.Errorf("invalid post content: %w", err)
+	}
+	if inner, ok := fields["post"]; ok {
+		return parsePost(string(inner))
+	}
+	_, hasTitle := fields["title"]
+	if _, hasContent := fields["content"]; hasContent || hasTitle {
+		var body postBody
+		if err := json.Unmarshal([]byte(content), &body); err != nil {
+			return nil, fmt.Errorf("invalid post body: %w", err)
+		}
+		return &body, nil
+	}
+
+	locales := append([]string{}, postLocales...)
+	var others []string
+	for locale := range fields {
+		if !containsString(postLocales, locale) {
+			others = append(others, locale)
+		}
+	}
+	sort.Strings(others)
+	for _, locale := range append(locales, others...) {
+		raw, ok := fields[locale]
+		if !ok {
+			continue
+		}
+		var body postBody
+		if err := json.Unmarshal(raw, &body); err != nil {
+			return nil, fmt.Errorf("invalid post body of %s: %w", locale, err)
+		}
+		return &body, nil
+	}
+	return nil, errors.New("post content has no body")
+}
+
+// Markdown 把富文本转成 Markdown。@ 用户保留消息中的占位符，
+// 由 renderMentions 统一换成姓名或去掉 @ 机器人；图片单独通过 ImageKeys 获取
+func (p *postBody) Markdown() string {
+	var lines []string
+	if title := strings.TrimSpace(p.Title); title != "" {
+		lines = append(lines, "**"+title+"**")
+	}
+	for _, paragraph := range p.Content {
+		var b strings.Builder
+		for _, element := range paragraph {
+			if element.Tag == "code_block" {
+				// 代码块独占多行
+				if b.Len() > 0 {
+					lines = append(lines, b.String())
+					b.Reset()
+				}
+				lines = append(lines, codeBlock(element))
+				continue
+			}
+			b.WriteString(element.markdown())
+		}
+		if b.Len() > 0 || len(paragraph) == 0 {
+			lines = append(lines, b.String())
+		}
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n"))
+}
+
+// ImageKeys 富文本中按顺序出现的图片
+func (p *postBody) ImageKeys() []string {
+	var imageKeys []string
+	for _, paragraph := range p.Content {
+		for _, element := range paragraph {
+			if element.Tag == "img" && element.ImageKey != "" {
+				imageKeys = append(imageKeys, element.ImageKey)
+			}
+		}
+	}
+	return imageKeys
+}
+
+func (e postElement) markdown() string {
+	switch e.Tag {
+	case "text":
+		return styled(e.Text, e.Style)
+	case "a":
+		if e.Href == "" {
+			return e.Text
+		}
+		if e.Text == "" || e.Text == e.Href {
+			return e.Href
+		}
+		return fmt.Sprintf("[%s](%s)", e.Text, e.Href)
+	case "at":
+		// 接收到的消息中 user_id 是 @_user_1 这样的占位符，查询到的消息中可能是 open_id
+		if strings.HasPrefix(e.UserId, "@_") {
+			return e.UserId
+		}
+		if e.UserName != "" {
+			return "@" + e.UserName
+		}
+		return ""
+	case "emotion":
+		return "[" + e.EmojiType + "]"
+	case "media":
+		return "[视频]"
+	case "hr":
+		return "\n---\n"
+	case "md":
+		return e.Text
+	case "img":
+		return ""
+	default:
+		return e.Text
+	}
+}
+
+// styled 按样式给文字加上 Markdown 标记，首尾的空白留在标记外，下划线没有对应的标记
+func styled(text string, style []string) string {
+	core := strings.TrimSpace(text)
+	if core == "" || len(style) == 0 {
+		return text
+	}
+	start := strings.Index(text, core)
+	prefix, suffix := text[:start], text[start+len(core):]
+	for _, s := range style {
+		switch s {
+		case "bold":
+			core = "**" + core + "**"
+		case "italic":
+			core = "*" + core + "*"
+		case "lineThrough":
+			core = "~~" + core + "~~"
+		}
+	}
+	return prefix + core + suffix
+}
+
+func codeBlock(e postElement) string {
+	return "```" + strings.ToLower(e.Language) + "\n" +
+		strings.TrimRight(e.Text, "\n") + "\n```"
+}
+
+// parsePostContent 把富文本消息转成 Markdown，无法解析时返回空
+func parsePostContent(content string) string {
+	post, err := parsePost(content)
+	if err != nil {
+		logger.Warnf("parse post failed: %v", err)
+		return ""
+	}
+	return post.Markdown()
+}
+
+// parsePostImageKeys 富文本消息中的图片，其他类型的消息返回空
+func parsePostImageKeys(content string) []string {
+	post, err := parsePost(content)
+	if err != nil {
+		return nil
+	}
+	return post.ImageKeys()
+}
@@ -0,0 +1,91 @@
+package handlers
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParsePost(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    string
+		images  []string
+	}{
+		{"text and styles",
+			`{"title":"","content":[[{"tag":"text","text":"普通 "},` +
+				`{"tag":"text","text":"加粗","style":["bold"]},` +
+				`{"tag":"text","text":" 斜体","style":["italic","underline"]},` +
+				`{"tag":"text","text":" 删除","style":["lineThrough"]}]]}`,
+			"普通 **加粗** *斜体* ~~删除~~", nil},
+		{"title and links",
+			`{"title":"周报","content":[[{"tag":"a","text":"文档","href":"https://a.com"},` +
+				`{"tag":"text","text":" "},{"tag":"a","text":"https://b.com","href":"https://b.com"}]]}`,
+			"**周报**\n[文档](https://a.com) https://b.com", nil},
+		{"mentions keep keys",
+			`{"content":[[{"tag":"at","user_id":"@_user_1","user_name":""},` +
+				`{"tag":"text","text":" 请看 "},{"tag":"at","user_id":"ou_x","user_name":"小明"}]]}`,
+			"@_user_1 请看 @小明", nil},
+		{"code block",
+			`{"content":[[{"tag":"text","text":"代码："},` +
+				`{"tag":"code_block","language":"GO","text":"fmt.Println(1)\n"}],` +
+				`[{"tag":"text","text":"结束"}]]}`,
+			"代码：\n```go\nfmt.Println(1)\n```\n结束", nil},
+		{"images emotions and md",
+			`{"content":[[{"tag":"img","image_key":"img_1"}],` +
+				`[{"tag":"emotion","emoji_type":"SMILE"},{"tag":"md","text":"**md**"}],` +
+				`[{"tag":"img","image_key":"img_2"},{"tag":"media","file_key":"f"}]]}`,
+			"[SMILE]**md**\n[视频]", []string{"img_1", "img_2"}},
+		{"locale keyed",
+			`{"en_us":{"title":"Hi","content":[[{"tag":"text","text":"english"}]]},` +
+				`"zh_cn":{"title":"你好","content":[[{"tag":"text","text":"中文"}]]}}`,
+			"**你好**\n中文", nil},
+		{"wrapped post",
+			`{"post":{"ja_jp":{"content":[[{"tag":"text","text":"日本語"}]]}}}`,
+			"日本語", nil},
+		{"empty paragraphs",
+			`{"content":[[{"tag":"text","text":"a"}],[],[{"tag":"text","text":"b"}]]}`,
+			"a\n\nb", nil},
+	}
+	for _, tt := range tests {
+		post, err := parsePost(tt.content)
+		if err != nil {
+			t.Errorf("%s: parsePost error: %v", tt.name, err)
+			continue
+		}
+		if got := post.Markdown(); got != tt.want {
+			t.Errorf("%s: Markdown() = %q, want %q", tt.name, got, tt.want)
+		}
+		if got := post.ImageKeys(); !reflect.DeepEqual(got, tt.images) {
+			t.Errorf("%s: ImageKeys() = %v, want %v", tt.name, got, tt.images)
+		}
+	}
+}
+
+func TestParsePostErrors(t *testing.T) {
+	tests := []string{
+		`not json`,
+		`{"content":"text"}`,
+		`{"content":[["text"]]}`,
+		`{"zh_cn":"text"}`,
+		`{}`,
+	}
+	for _, content := range tests {
+		if _, err := parsePost(content); err == nil {
+			t.Errorf("parsePost(%s) expected error", content)
+		}
+		if got := parsePostContent(content); got != "" {
+			t.Errorf("parsePostContent(%s) = %q, want empty", content, got)
+		}
+	}
+}
+
+func TestPostMentions(t *testing.T) {
+	content := `{"content":[[{"tag":"at","user_id":"@_user_1"},{"tag":"text","text":" 问问 "},` +
+		`{"tag":"at","user_id":"@_user_2"}]]}`
+	users := []mentionedUser{{Key: "@_user_2", Name: "小明"}}
+	// @_user_1 是机器人，不在 users 中，会被去掉
+	if got := renderMentions(parsePostContent(content), users); got != " 问问 @小明" {
+		t.Errorf("renderMentions(post) = %q", got)
+	}
+}
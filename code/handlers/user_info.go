@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"start-feishubot/initialization"
+	"start-feishubot/logger"
+
+	larkcontact "github.com/larksuite/oapi-sdk-go/v3/service/contact/v3"
+)
+
+// userInfoTTL 用户姓名和所属部门的缓存时间
+const userInfoTTL = 10 * time.Minute
+
+type userInfo struct {
+	name        string
+	departments []string
+	expireAt    time.Time
+}
+
+var userInfoCache sync.Map
+
+// getUserInfo 查询用户的姓名和所属部门的 open_department_id，结果按用户缓存
+func getUserInfo(openId string) (userInfo, bool) {
+	if openId == "" {
+		return userInfo{}, false
+	}
+	if v, ok := userInfoCache.Load(openId); ok {
+		info := v.(userInfo)
+		if time.Now().Before(info.expireAt) {
+			return info, true
+		}
+	}
+	info, err := fetchUserInfo(openId)
+	if err != nil {
+		logger.Warnf("get user info of %s failed: %v", openId, err)
+		return userInfo{}, false
+	}
+	info.expireAt = time.Now().Add(userInfoTTL)
+	userInfoCache.Store(openId, info)
+	return info, true
+}
+
+// userDepartments 用户所属部门，查询失败时 ok 为 false
+func userDepartments(openId string) (departments []string, ok bool) {
+	info, ok := getUserInfo(openId)
+	return info.departments, ok
+}
+
+// userName 用户姓名，查询失败或没有通讯录权限时返回空
+func userName(openId string) string {
+	info, _ := getUserInfo(openId)
+	return info.name
+}
+
+func fetchUserInfo(openId string) (userInfo, error) {
+	resp, err := initialization.GetLarkClient().Contact.User.Get(context.Background(),
+		larkcontact.NewGetUserReqBuilder().
+			UserId(openId).
+			UserIdType(larkcontact.UserIdTypeOpenId).
+			DepartmentIdType(larkcontact.DepartmentIdTypeOpenDepartmentId).
+			Build())
+	if err != nil {
+		return userInfo{}, err
+	}
+	if !resp.Success() {
+		return userInfo{}, errors.New(resp.Msg)
+	}
+	if resp.Data == nil || resp.Data.User == nil {
+		return userInfo{}, nil
+	}
+	var info userInfo
+	if resp.Data.User.Name != nil {
+		info.name = *resp.Data.User.Name
+	}
+	info.departments = resp.Data.User.DepartmentIds
+	return info, nil
+}
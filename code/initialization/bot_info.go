@@ -0,0 +1,58 @@
+package initialization
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+
+	larkcore "github.com/larksuite/oapi-sdk-go/v3/core"
+)
+
+// BotInfo 机器人自身的信息，用 open_id 判断消息是否 @ 了机器人
+type BotInfo struct {
+	AppName string `json:"app_name"`
+	OpenId  string `json:"open_id"`
+}
+
+type botInfoResp struct {
+	larkcore.CodeError
+	Bot *BotInfo `json:"bot"`
+}
+
+var botInfo BotInfo
+
+// LoadBotInfo 启动时查询机器人信息。查询失败时 open_id 为空，
+// 判断 @ 时退回按 BOT_NAME 匹配
+func LoadBotInfo() {
+	info, err := fetchBotInfo(context.Background())
+	if err != nil {
+		log.Printf("get bot info failed, fall back to BOT_NAME: %v", err)
+		return
+	}
+	botInfo = *info
+	log.Printf("bot info loaded, name: %s, open_id: %s", info.AppName, info.OpenId)
+}
+
+func fetchBotInfo(ctx context.Context) (*BotInfo, error) {
+	resp, err := larkClient.Get(ctx, "/open-apis/bot/v3/info", nil,
+		larkcore.AccessTokenTypeTenant)
+	if err != nil {
+		return nil, err
+	}
+	var result botInfoResp
+	if err = json.Unmarshal(resp.RawBody, &result); err != nil {
+		return nil, fmt.Errorf("status %d: %w", resp.StatusCode, err)
+	}
+	if result.Code != 0 {
+		return nil, fmt.Errorf("code %d: %s", result.Code, result.Msg)
+	}
+	if result.Bot == nil || result.Bot.OpenId == "" {
+		return nil, fmt.Errorf("empty bot info")
+	}
+	return result.Bot, nil
+}
+
+func GetBotInfo() BotInfo {
+	return botInfo
+}
@@ -21,6 +21,7 @@ type Config struct {
 	FeishuAppEncryptKey        string
 	FeishuAppVerificationToken string
 	FeishuBotName              string
+	GroupThreadReply           bool
 	OpenaiApiKeys              []string
 	HttpPort                   int
 	HttpsPort                  int
@@ -38,6 +39,41 @@ type Config struct {
 	AzureResourceName          string
 	AzureOpenaiToken           string
 	StreamMode                 bool
+	AdminOpenIds               []string
+	UsageFile                  string
+	QuotaUserDailyTokens       int
+	QuotaUserMonthlyTokens     int
+	QuotaUserDailyCost         float64
+	QuotaUserMonthlyCost       float64
+	QuotaGroupDailyTokens      int
+	QuotaGroupMonthlyTokens    int
+	QuotaGroupDailyCost        float64
+	QuotaGroupMonthlyCost      float64
+	QuotaGlobalDailyTokens     int
+	QuotaGlobalMonthlyTokens   int
+	QuotaGlobalDailyCost       float64
+	QuotaGlobalMonthlyCost     float64
+	QuotaOverrides             []string
+	QuotaOverridesFile         string
+	ModelPrices                []string
+	UsageDigestChatId          string
+	UsageDigestWeekday         int
+	UsageDigestHour            int
+	ChatSettingsFile           string
+	KnowledgeDir               string
+	KnowledgeIndexFile         string
+	EmbeddingModel             string
+	KnowledgeTopK              int
+	KnowledgeMinScore          float64
+	ActionSwitches             []string
+	WorkerConcurrency          int
+	WorkerQueueSize            int
+	WorkerChatQueueSize        int
+	RateLimitUser              string
+	RateLimitChat              string
+	RateLimitGlobal            string
+	AccessAllow                []string
+	AccessDeny                 []string
 }
 
 var (
@@ -72,6 +108,7 @@ func LoadConfig(cfg string) *Config {
 		FeishuAppEncryptKey:        getViperStringValue("APP_ENCRYPT_KEY", ""),
 		FeishuAppVerificationToken: getViperStringValue("APP_VERIFICATION_TOKEN", ""),
 		FeishuBotName:              getViperStringValue("BOT_NAME", ""),
+		GroupThreadReply:           getViperBoolValue("GROUP_THREAD_REPLY", false),
 		OpenaiApiKeys:              getViperStringArray("OPENAI_KEY", []string{""}),
 		OpenaiModel:                getViperStringValue("OPENAI_MODEL", "chatgpt-4o-latest"),
 		OpenAIHttpClientTimeOut:    getViperIntValue("OPENAI_HTTP_CLIENT_TIMEOUT", 550),
@@ -89,6 +126,41 @@ func LoadConfig(cfg string) *Config {
 		AzureResourceName:          getViperStringValue("AZURE_RESOURCE_NAME", ""),
 		AzureOpenaiToken:           getViperStringValue("AZURE_OPENAI_TOKEN", ""),
 		StreamMode:                 getViperBoolValue("STREAM_MODE", false),
+		AdminOpenIds:               getViperStringList("ADMIN_OPEN_IDS", nil),
+		UsageFile:                  getViperStringValue("USAGE_FILE", "./usage.jsonl"),
+		QuotaUserDailyTokens:       getViperIntValue("QUOTA_USER_DAILY_TOKENS", 0),
+		QuotaUserMonthlyTokens:     getViperIntValue("QUOTA_USER_MONTHLY_TOKENS", 0),
+		QuotaUserDailyCost:         getViperFloatValue("QUOTA_USER_DAILY_COST", 0),
+		QuotaUserMonthlyCost:       getViperFloatValue("QUOTA_USER_MONTHLY_COST", 0),
+		QuotaGroupDailyTokens:      getViperIntValue("QUOTA_GROUP_DAILY_TOKENS", 0),
+		QuotaGroupMonthlyTokens:    getViperIntValue("QUOTA_GROUP_MONTHLY_TOKENS", 0),
+		QuotaGroupDailyCost:        getViperFloatValue("QUOTA_GROUP_DAILY_COST", 0),
+		QuotaGroupMonthlyCost:      getViperFloatValue("QUOTA_GROUP_MONTHLY_COST", 0),
+		QuotaGlobalDailyTokens:     getViperIntValue("QUOTA_GLOBAL_DAILY_TOKENS", 0),
+		QuotaGlobalMonthlyTokens:   getViperIntValue("QUOTA_GLOBAL_MONTHLY_TOKENS", 0),
+		QuotaGlobalDailyCost:       getViperFloatValue("QUOTA_GLOBAL_DAILY_COST", 0),
+		QuotaGlobalMonthlyCost:     getViperFloatValue("QUOTA_GLOBAL_MONTHLY_COST", 0),
+		QuotaOverrides:             getViperStringList("QUOTA_OVERRIDES", nil),
+		QuotaOverridesFile:         getViperStringValue("QUOTA_OVERRIDES_FILE", "./quota_overrides.json"),
+		ModelPrices:                getViperStringList("MODEL_PRICES", nil),
+		UsageDigestChatId:          getViperStringValue("USAGE_DIGEST_CHAT_ID", ""),
+		UsageDigestWeekday:         getViperIntValue("USAGE_DIGEST_WEEKDAY", 1),
+		UsageDigestHour:            getViperIntValue("USAGE_DIGEST_HOUR", 9),
+		ChatSettingsFile:           getViperStringValue("CHAT_SETTINGS_FILE", "./chat_settings.json"),
+		KnowledgeDir:               getViperStringValue("KB_DIR", "./knowledge"),
+		KnowledgeIndexFile:         getViperStringValue("KB_INDEX_FILE", "./kb_index.json"),
+		EmbeddingModel:             getViperStringValue("EMBEDDING_MODEL", "text-embedding-3-small"),
+		KnowledgeTopK:              getViperIntValue("KB_TOP_K", 4),
+		KnowledgeMinScore:          getViperFloatValue("KB_MIN_SCORE", 0.3),
+		ActionSwitches:             getViperStringList("ACTION_SWITCHES", nil),
+		WorkerConcurrency:          getViperIntValue("WORKER_CONCURRENCY", 8),
+		WorkerQueueSize:            getViperIntValue("WORKER_QUEUE_SIZE", 100),
+		WorkerChatQueueSize:        getViperIntValue("WORKER_CHAT_QUEUE_SIZE", 10),
+		RateLimitUser:              getViperStringValue("RATE_LIMIT_USER", "10/1m"),
+		RateLimitChat:              getViperStringValue("RATE_LIMIT_CHAT", "30/1m"),
+		RateLimitGlobal:            getViperStringValue("RATE_LIMIT_GLOBAL", ""),
+		AccessAllow:                getViperStringList("ACCESS_ALLOW", nil),
+		AccessDeny:                 getViperStringList("ACCESS_DENY", nil),
 	}
 
 	return config
@@ -102,8 +174,8 @@ func getViperStringValue(key string, defaultValue string) string {
 	return value
 }
 
-//OPENAI_KEY: sk-xxx,sk-xxx,sk-xxx
-//result:[sk-xxx sk-xxx sk-xxx]
+// OPENAI_KEY: sk-xxx,sk-xxx,sk-xxx
+// result:[sk-xxx sk-xxx sk-xxx]
 func getViperStringArray(key string, defaultValue []string) []string {
 	value := viper.GetString(key)
 	if value == "" {
@@ -113,6 +185,23 @@ func getViperStringArray(key string, defaultValue []string) []string {
 	return filterFormatKey(raw)
 }
 
+// ADMIN_OPEN_IDS: ou_xxx, ou_yyy
+// result:[ou_xxx ou_yyy]
+func getViperStringList(key string, defaultValue []string) []string {
+	value := viper.GetString(key)
+	if value == "" {
+		return defaultValue
+	}
+	var result []string
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			result = append(result, item)
+		}
+	}
+	return result
+}
+
 func getViperIntValue(key string, defaultValue int) int {
 	value := viper.GetString(key)
 	if value == "" {
@@ -126,6 +215,19 @@ func getViperIntValue(key string, defaultValue int) int {
 	return intValue
 }
 
+func getViperFloatValue(key string, defaultValue float64) float64 {
+	value := viper.GetString(key)
+	if value == "" {
+		return defaultValue
+	}
+	floatValue, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		fmt.Printf("Invalid value for %s, using default value %v\n", key, defaultValue)
+		return defaultValue
+	}
+	return floatValue
+}
+
 func getViperBoolValue(key string, defaultValue bool) bool {
 	value := viper.GetString(key)
 	if value == "" {
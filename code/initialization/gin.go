@@ -1,6 +1,7 @@
 package initialization
 
 import (
+	"context"
 	"crypto/tls"
 	"crypto/x509"
 	"fmt"
@@ -35,18 +36,18 @@ func loadCertificate(config Config) (cert tls.Certificate, err error) {
 	return cert, nil
 }
 
-func startHTTPServer(config Config, r *gin.Engine) (err error) {
+func newHTTPServer(config Config, r *gin.Engine) *http.Server {
 	log.Printf("http server started: http://localhost:%d/webhook/event\n\n", config.HttpPort)
-	err = r.Run(fmt.Sprintf(":%d", config.HttpPort))
-	if err != nil {
-		return fmt.Errorf("failed to start http server: %v", err)
+	return &http.Server{
+		Addr:    fmt.Sprintf(":%d", config.HttpPort),
+		Handler: r,
 	}
-	return nil
 }
-func startHTTPSServer(config Config, r *gin.Engine) (err error) {
+
+func newHTTPSServer(config Config, r *gin.Engine) (*http.Server, error) {
 	cert, err := loadCertificate(config)
 	if err != nil {
-		return fmt.Errorf("failed to load certificate: %v", err)
+		return nil, fmt.Errorf("failed to load certificate: %v", err)
 	}
 	server := &http.Server{
 		Addr:    fmt.Sprintf(":%d", config.HttpsPort),
@@ -56,17 +57,37 @@ func startHTTPSServer(config Config, r *gin.Engine) (err error) {
 		},
 	}
 	fmt.Printf("https server started: https://localhost:%d/webhook/event\n", config.HttpsPort)
-	err = server.ListenAndServeTLS("", "")
-	if err != nil {
-		return fmt.Errorf("failed to start https server: %v", err)
-	}
-	return nil
+	return server, nil
 }
-func StartServer(config Config, r *gin.Engine) (err error) {
+
+// StartServer 启动服务，ctx 取消后不再接受新请求，等待进行中的请求完成后返回
+func StartServer(ctx context.Context, config Config, r *gin.Engine) error {
+	var server *http.Server
+	serve := func() error { return server.ListenAndServe() }
 	if config.UseHttps {
-		err = startHTTPSServer(config, r)
+		var err error
+		if server, err = newHTTPSServer(config, r); err != nil {
+			return err
+		}
+		serve = func() error { return server.ListenAndServeTLS("", "") }
 	} else {
-		err = startHTTPServer(config, r)
+		server = newHTTPServer(config, r)
+	}
+
+	errCh := make(chan error, 1)
+	go func() { errCh <- serve() }()
+	select {
+	case err := <-errCh:
+		return fmt.Errorf("failed to start server: %v", err)
+	case <-ctx.Done():
 	}
-	return err
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("failed to shutdown server: %v", err)
+	}
+	return nil
 }
+
+// shutdownTimeout 退出时等待进行中的请求的最长时间
+const shutdownTimeout = 10 * time.Second
@@ -2,9 +2,12 @@ package main
 
 import (
 	"context"
+	"os"
+	"os/signal"
 	"start-feishubot/handlers"
 	"start-feishubot/initialization"
 	"start-feishubot/logger"
+	"syscall"
 
 	"github.com/gin-gonic/gin"
 	sdkginext "github.com/larksuite/oapi-sdk-gin"
@@ -20,6 +23,7 @@ func main() {
 	pflag.Parse()
 	config := initialization.GetConfig()
 	initialization.LoadLarkClient(*config)
+	initialization.LoadBotInfo()
 	gpt := openai.NewChatGPT(*config)
 	handlers.InitHandlers(gpt, *config)
 
@@ -47,7 +51,11 @@ func main() {
 		sdkginext.NewCardActionHandlerFunc(
 			cardHandler))
 
-	if err := initialization.StartServer(*config, r); err != nil {
-		logger.Fatalf("failed to start server: %v", err)
+	// 收到退出信号后先停止接收事件，再等待工作池中已排队的消息处理完毕
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := initialization.StartServer(ctx, *config, r); err != nil {
+		logger.Fatalf("%v", err)
 	}
+	handlers.Close()
 }
@@ -0,0 +1,158 @@
+package access
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Capability 需要授权的功能
+type Capability string
+
+const (
+	CapChat    Capability = "chat"
+	CapVision  Capability = "vision"
+	CapPicture Capability = "picture"
+	CapAudio   Capability = "audio"
+	CapAdmin   Capability = "admin"
+	// CapAll 规则中的 * 表示全部功能，不包括管理员
+	CapAll Capability = "*"
+)
+
+var capabilities = []Capability{CapChat, CapVision, CapPicture, CapAudio, CapAdmin, CapAll}
+
+// Subject 发起请求的用户和会话
+type Subject struct {
+	UserId      string
+	ChatId      string
+	Departments []string
+	// DepartmentsUnknown 查询所属部门失败，禁止名单中有部门时按命中处理
+	DepartmentsUnknown bool
+}
+
+// matches 用户、会话或所属部门之一在名单中
+func (s Subject) matches(ids []string) bool {
+	for _, id := range ids {
+		if id == s.UserId || id == s.ChatId {
+			return true
+		}
+		for _, department := range s.Departments {
+			if id == department {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// denied 命中禁止名单。所属部门未知时无法排除部门规则，宁可拒绝
+func (s Subject) denied(ids []string) bool {
+	if s.matches(ids) {
+		return true
+	}
+	return s.DepartmentsUnknown && hasDepartment(ids)
+}
+
+func hasDepartment(ids []string) bool {
+	for _, id := range ids {
+		if strings.HasPrefix(id, "od_") {
+			return true
+		}
+	}
+	return false
+}
+
+// Rules 每个功能对应的 open_id、部门 open_department_id 或 chat_id
+type Rules map[Capability][]string
+
+// ParseRules 解析形如 "picture=ou_xxx/od_xxx,*=oc_xxx" 的配置，斜杠分隔多个 id，
+// 按前缀区分 id 的类型：ou_ 用户，od_ 部门，oc_ 会话
+func ParseRules(entries []string) (Rules, error) {
+	rules := make(Rules)
+	for _, entry := range entries {
+		name, ids, ok := strings.Cut(entry, "=")
+		capability := Capability(strings.TrimSpace(name))
+		if !ok || !validCapability(capability) {
+			return nil, fmt.Errorf("invalid access rule %q", entry)
+		}
+		for _, id := range strings.Split(ids, "/") {
+			id = strings.TrimSpace(id)
+			if !validId(id) {
+				return nil, fmt.Errorf("invalid id %q in access rule %q", id, entry)
+			}
+			rules[capability] = append(rules[capability], id)
+		}
+	}
+	return rules, nil
+}
+
+func validCapability(c Capability) bool {
+	for _, v := range capabilities {
+		if v == c {
+			return true
+		}
+	}
+	return false
+}
+
+func validId(id string) bool {
+	return strings.HasPrefix(id, "ou_") || strings.HasPrefix(id, "od_") ||
+		strings.HasPrefix(id, "oc_")
+}
+
+// Decision 授权结果，Reason 为 deny 表示命中禁止名单，allow 表示不在允许名单中，
+// admin 表示需要管理员
+type Decision struct {
+	Allowed bool
+	Reason  string
+}
+
+// Policy 访问控制策略。禁止名单优先于允许名单；某个功能配置了允许名单时，
+// 只有名单中的用户、部门或会话可以使用；管理员可以使用全部功能
+type Policy struct {
+	admins []string
+	allow  Rules
+	deny   Rules
+}
+
+func NewPolicy(admins []string, allow Rules, deny Rules) *Policy {
+	return &Policy{admins: admins, allow: allow, deny: deny}
+}
+
+// NeedsDepartments 规则中是否有部门，没有时不需要查询用户所属部门
+func (p *Policy) NeedsDepartments() bool {
+	for _, rules := range []Rules{p.allow, p.deny} {
+		for _, ids := range rules {
+			if hasDepartment(ids) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// IsAdmin 用户在管理员名单中，或者命中 admin 的允许名单
+func (p *Policy) IsAdmin(s Subject) bool {
+	for _, id := range p.admins {
+		if id == s.UserId {
+			return true
+		}
+	}
+	return s.matches(p.allow[CapAdmin])
+}
+
+func (p *Policy) Check(capability Capability, s Subject) Decision {
+	if p.IsAdmin(s) {
+		return Decision{Allowed: true}
+	}
+	if capability == CapAdmin {
+		return Decision{Reason: "admin"}
+	}
+	if s.denied(p.deny[capability]) || s.denied(p.deny[CapAll]) {
+		return Decision{Reason: "deny"}
+	}
+	allow := append(append([]string{}, p.allow[capability]...), p.allow[CapAll]...)
+	if len(allow) > 0 && !s.matches(allow) {
+		return Decision{Reason: "allow"}
+	}
+	return Decision{Allowed: true}
+}
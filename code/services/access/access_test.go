@@ -0,0 +1,97 @@
+package access
+
+import "testing"
+
+func TestParseRules(t *testing.T) {
+	rules, err := ParseRules([]string{"picture=ou_a/od_b", "*=oc_c", "picture=oc_d"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := rules[CapPicture]; len(got) != 3 || got[2] != "oc_d" {
+		t.Errorf("picture rules = %v", got)
+	}
+	if got := rules[CapAll]; len(got) != 1 || got[0] != "oc_c" {
+		t.Errorf("* rules = %v", got)
+	}
+	for _, entry := range []string{"picture", "image=ou_a", "chat=", "chat=ou_a//ou_b", "chat=xyz"} {
+		if _, err := ParseRules([]string{entry}); err == nil {
+			t.Errorf("expected error for %q", entry)
+		}
+	}
+}
+
+func TestPolicyCheck(t *testing.T) {
+	allow, _ := ParseRules([]string{"picture=od_design/ou_alice", "admin=od_ops"})
+	deny, _ := ParseRules([]string{"*=ou_mallory", "audio=oc_quiet"})
+	p := NewPolicy([]string{"ou_root"}, allow, deny)
+
+	alice := Subject{UserId: "ou_alice", ChatId: "oc_a"}
+	bob := Subject{UserId: "ou_bob", ChatId: "oc_a", Departments: []string{"od_design"}}
+	carol := Subject{UserId: "ou_carol", ChatId: "oc_quiet"}
+	mallory := Subject{UserId: "ou_mallory", ChatId: "oc_a", Departments: []string{"od_design"}}
+	ops := Subject{UserId: "ou_dave", ChatId: "oc_quiet", Departments: []string{"od_ops"}}
+	root := Subject{UserId: "ou_root", ChatId: "oc_quiet"}
+
+	tests := []struct {
+		name       string
+		capability Capability
+		subject    Subject
+		allowed    bool
+		reason     string
+	}{
+		{"chat open to everyone", CapChat, carol, true, ""},
+		{"picture by user", CapPicture, alice, true, ""},
+		{"picture by department", CapPicture, bob, true, ""},
+		{"picture not in allowlist", CapPicture, carol, false, "allow"},
+		{"deny overrides allow", CapPicture, mallory, false, "deny"},
+		{"deny all", CapChat, mallory, false, "deny"},
+		{"deny audio in chat", CapAudio, carol, false, "deny"},
+		{"admin command", CapAdmin, alice, false, "admin"},
+		{"admin by department", CapAdmin, ops, true, ""},
+		{"admin bypasses deny", CapAudio, ops, true, ""},
+		{"configured admin", CapPicture, root, true, ""},
+	}
+	for _, tt := range tests {
+		got := p.Check(tt.capability, tt.subject)
+		if got.Allowed != tt.allowed || got.Reason != tt.reason {
+			t.Errorf("%s: Check(%s) = %+v", tt.name, tt.capability, got)
+		}
+	}
+	if !p.NeedsDepartments() {
+		t.Error("expected policy to need departments")
+	}
+	if NewPolicy(nil, Rules{CapChat: {"oc_a", "ou_b"}}, nil).NeedsDepartments() {
+		t.Error("expected policy without departments")
+	}
+}
+
+func TestPolicyCheckDepartmentsUnknown(t *testing.T) {
+	allow, _ := ParseRules([]string{"picture=od_design", "admin=od_ops"})
+	deny, _ := ParseRules([]string{"audio=od_intern", "vision=ou_mallory"})
+	p := NewPolicy([]string{"ou_root"}, allow, deny)
+
+	unknown := Subject{UserId: "ou_alice", ChatId: "oc_a", DepartmentsUnknown: true}
+	tests := []struct {
+		capability Capability
+		subject    Subject
+		allowed    bool
+		reason     string
+	}{
+		// 禁止名单中有部门，查询失败时拒绝
+		{CapAudio, unknown, false, "deny"},
+		// 允许名单中的部门无法匹配
+		{CapPicture, unknown, false, "allow"},
+		{CapAdmin, unknown, false, "admin"},
+		// 禁止名单中没有部门，不受影响
+		{CapVision, unknown, true, ""},
+		{CapChat, unknown, true, ""},
+		{CapAudio, Subject{UserId: "ou_root", DepartmentsUnknown: true}, true, ""},
+		{CapAudio, Subject{UserId: "ou_alice", Departments: []string{"od_design"}}, true, ""},
+	}
+	for _, tt := range tests {
+		got := p.Check(tt.capability, tt.subject)
+		if got.Allowed != tt.allowed || got.Reason != tt.reason {
+			t.Errorf("Check(%s, %+v) = %+v", tt.capability, tt.subject, got)
+		}
+	}
+}
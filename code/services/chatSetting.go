@@ -0,0 +1,87 @@
+package services
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+
+	"start-feishubot/logger"
+)
+
+// ChatSetting 按会话（私聊或群聊的 chat_id）保存的设置，与 12 小时过期的话题缓存不同，会持久化到文件
+type ChatSetting struct {
+	// GroupVoice 群聊中是否处理未 @ 机器人的语音消息
+	GroupVoice bool `json:"group_voice,omitempty"`
+	// TranscribeModel 语音转文字使用的模型，为空时使用 whisper-1
+	TranscribeModel string `json:"transcribe_model,omitempty"`
+	// TranscribeLanguage 语音的语言提示，ISO-639-1 格式，为空时自动识别
+	TranscribeLanguage string `json:"transcribe_language,omitempty"`
+}
+
+type ChatSettingServiceInterface interface {
+	Get(chatId string) ChatSetting
+	Set(chatId string, setting ChatSetting) error
+}
+
+type ChatSettingService struct {
+	mu       sync.RWMutex
+	settings map[string]ChatSetting
+	file     string
+}
+
+var chatSettingService *ChatSettingService
+
+func NewChatSettingService(file string) (*ChatSettingService, error) {
+	s := &ChatSettingService{settings: map[string]ChatSetting{}, file: file}
+	if file == "" {
+		return s, nil
+	}
+	data, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return s, err
+	}
+	if err := json.Unmarshal(data, &s.settings); err != nil {
+		return s, err
+	}
+	return s, nil
+}
+
+func (s *ChatSettingService) Get(chatId string) ChatSetting {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.settings[chatId]
+}
+
+// Set 保存会话设置，并将全部设置写回文件
+func (s *ChatSettingService) Set(chatId string, setting ChatSetting) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if setting == (ChatSetting{}) {
+		delete(s.settings, chatId)
+	} else {
+		s.settings[chatId] = setting
+	}
+	if s.file == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(s.settings, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(s.file, data, 0644)
+}
+
+// GetChatSettings 返回全局会话设置，首次调用时从 file 加载
+func GetChatSettings(file string) ChatSettingServiceInterface {
+	if chatSettingService == nil {
+		s, err := NewChatSettingService(file)
+		if err != nil {
+			logger.Errorf("load chat settings from %s failed: %v", file, err)
+		}
+		chatSettingService = s
+	}
+	return chatSettingService
+}
@@ -0,0 +1,418 @@
+package knowledge
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"start-feishubot/logger"
+	"start-feishubot/utils/document"
+)
+
+const (
+	// DefaultCollection 知识库根目录下的文件所属的集合
+	DefaultCollection = "default"
+	// ChunkSize 知识库片段的字符数，比文档问答小一些以提高检索精度
+	ChunkSize    = 800
+	ChunkOverlap = 100
+)
+
+// ErrIndexing 集合正在重建索引
+var ErrIndexing = errors.New("collection is being indexed")
+
+// Embedder 计算文本的向量，返回的向量与输入一一对应
+type Embedder func(texts []string) ([][]float32, error)
+
+// Chunk 一个带向量的文档片段
+type Chunk struct {
+	Source string    `json:"source"`
+	Index  int       `json:"index"`
+	Text   string    `json:"text"`
+	Vector []float32 `json:"vector"`
+}
+
+// File 已索引的文件，内容未变化时重建索引会复用已有的向量
+type File struct {
+	Path   string `json:"path"`
+	Hash   string `json:"hash"`
+	Chunks int    `json:"chunks"`
+}
+
+// Collection 知识库目录下的一个子目录
+type Collection struct {
+	Name      string    `json:"name"`
+	Model     string    `json:"model"`
+	Files     []File    `json:"files"`
+	Chunks    []Chunk   `json:"chunks"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Result 一条检索结果
+type Result struct {
+	Collection string
+	Source     string
+	Index      int
+	Text       string
+	Score      float64
+}
+
+// Stats 一次重建索引的统计
+type Stats struct {
+	Files    int
+	Chunks   int
+	Embedded int
+	Skipped  []string
+}
+
+// Index 本地向量索引，全部向量保存在内存中，并以 JSON 持久化到文件
+type Index struct {
+	mu          sync.RWMutex
+	file        string
+	collections map[string]*Collection
+	indexing    map[string]bool
+}
+
+var index *Index
+
+func NewIndex(file string) (*Index, error) {
+	idx := &Index{
+		file:        file,
+		collections: make(map[string]*Collection),
+		indexing:    make(map[string]bool),
+	}
+	if file == "" {
+		return idx, nil
+	}
+	data, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		return idx, nil
+	}
+	if err != nil {
+		return idx, err
+	}
+	if err := json.Unmarshal(data, &idx.collections); err != nil {
+		return idx, err
+	}
+	return idx, nil
+}
+
+// GetIndex 返回全局知识库索引，首次调用时从 file 加载
+func GetIndex(file string) *Index {
+	if index == nil {
+		idx, err := NewIndex(file)
+		if err != nil {
+			logger.Errorf("load knowledge index from %s failed: %v", file, err)
+		}
+		index = idx
+	}
+	return index
+}
+
+// Collections 按名称排序返回全部集合，不包含向量
+func (idx *Index) Collections() []Collection {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	var result []Collection
+	for _, c := range idx.collections {
+		result = append(result, Collection{
+			Name:      c.Name,
+			Model:     c.Model,
+			Files:     c.Files,
+			UpdatedAt: c.UpdatedAt,
+		})
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result
+}
+
+// Empty 索引中没有任何片段时返回 true
+func (idx *Index) Empty() bool {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	for _, c := range idx.collections {
+		if len(c.Chunks) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Search 返回与向量最相似的 k 个片段，相似度低于 minScore 的片段会被忽略。
+// 只检索使用 model 计算向量的集合
+func (idx *Index) Search(vector []float32, model string, k int,
+	minScore float64) []Result {
+	query := normalize(vector)
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	var results []Result
+	for _, c := range idx.collections {
+		if c.Model != model {
+			continue
+		}
+		for _, chunk := range c.Chunks {
+			score := dot(query, chunk.Vector)
+			if score < minScore {
+				continue
+			}
+			results = append(results, Result{
+				Collection: c.Name,
+				Source:     chunk.Source,
+				Index:      chunk.Index,
+				Text:       chunk.Text,
+				Score:      score,
+			})
+		}
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].Score > results[j].Score
+	})
+	if k > 0 && len(results) > k {
+		results = results[:k]
+	}
+	return results
+}
+
+// CollectionNames 列出知识库目录下的集合：每个子目录是一个集合，
+// 根目录下的文件属于 default 集合
+func CollectionNames(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	hasFiles := false
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		if entry.IsDir() {
+			names = append(names, entry.Name())
+		} else if document.IsSupported(entry.Name()) {
+			hasFiles = true
+		}
+	}
+	if hasFiles && !contains(names, DefaultCollection) {
+		names = append(names, DefaultCollection)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Reindex 重新索引一个集合并保存到文件。内容未变化的文件复用已有向量，
+// 已删除的文件会从索引中移除
+func (idx *Index) Reindex(dir, name, model string, embed Embedder) (Stats, error) {
+	idx.mu.Lock()
+	if idx.indexing[name] {
+		idx.mu.Unlock()
+		return Stats{}, ErrIndexing
+	}
+	idx.indexing[name] = true
+	previous := idx.collections[name]
+	idx.mu.Unlock()
+	defer func() {
+		idx.mu.Lock()
+		delete(idx.indexing, name)
+		idx.mu.Unlock()
+	}()
+
+	files, err := collectionFiles(dir, name)
+	if err != nil {
+		return Stats{}, err
+	}
+
+	// 向量模型相同时按文件内容复用向量
+	reuse := make(map[string][]Chunk)
+	if previous != nil && previous.Model == model {
+		hashes := make(map[string]string)
+		for _, f := range previous.Files {
+			hashes[f.Path] = f.Hash
+		}
+		for _, chunk := range previous.Chunks {
+			key := hashes[chunk.Source] + "/" + chunk.Source
+			reuse[key] = append(reuse[key], chunk)
+		}
+	}
+
+	var stats Stats
+	collection := &Collection{Name: name, Model: model}
+	var pending []Chunk
+	for _, file := range files {
+		data, err := os.ReadFile(filepath.Join(dir, file))
+		if err != nil {
+			return stats, err
+		}
+		sum := sha256.Sum256(data)
+		hash := hex.EncodeToString(sum[:])
+		if chunks, ok := reuse[hash+"/"+file]; ok {
+			collection.Files = append(collection.Files,
+				File{Path: file, Hash: hash, Chunks: len(chunks)})
+			collection.Chunks = append(collection.Chunks, chunks...)
+			continue
+		}
+		text, err := document.Extract(file, data)
+		if err != nil {
+			logger.Warnf("skip knowledge file %s: %v", file, err)
+			stats.Skipped = append(stats.Skipped, file)
+			continue
+		}
+		texts := document.Chunk(text, ChunkSize, ChunkOverlap)
+		for i, t := range texts {
+			pending = append(pending, Chunk{Source: file, Index: i, Text: t})
+		}
+		collection.Files = append(collection.Files,
+			File{Path: file, Hash: hash, Chunks: len(texts)})
+	}
+
+	if len(pending) > 0 {
+		inputs := make([]string, len(pending))
+		for i, chunk := range pending {
+			inputs[i] = chunk.Source + "\n" + chunk.Text
+		}
+		vectors, err := embed(inputs)
+		if err != nil {
+			return stats, err
+		}
+		if len(vectors) != len(pending) {
+			return stats, fmt.Errorf("expected %d vectors, got %d",
+				len(pending), len(vectors))
+		}
+		for i := range pending {
+			pending[i].Vector = normalize(vectors[i])
+		}
+		collection.Chunks = append(collection.Chunks, pending...)
+	}
+	sort.SliceStable(collection.Chunks, func(i, j int) bool {
+		a, b := collection.Chunks[i], collection.Chunks[j]
+		if a.Source != b.Source {
+			return a.Source < b.Source
+		}
+		return a.Index < b.Index
+	})
+	collection.UpdatedAt = time.Now()
+	stats.Files = len(collection.Files)
+	stats.Chunks = len(collection.Chunks)
+	stats.Embedded = len(pending)
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	if len(collection.Files) == 0 {
+		delete(idx.collections, name)
+	} else {
+		idx.collections[name] = collection
+	}
+	return stats, idx.save()
+}
+
+// Remove 删除目录中已经不存在的集合
+func (idx *Index) Remove(name string) error {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	delete(idx.collections, name)
+	return idx.save()
+}
+
+func (idx *Index) save() error {
+	if idx.file == "" {
+		return nil
+	}
+	data, err := json.Marshal(idx.collections)
+	if err != nil {
+		return err
+	}
+	// 先写临时文件再重命名，避免写入中断导致索引损坏
+	tmp := idx.file + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, idx.file)
+}
+
+// collectionFiles 返回集合中可以提取文字的文件，路径相对于知识库目录
+func collectionFiles(dir, name string) ([]string, error) {
+	if name == DefaultCollection {
+		if info, err := os.Stat(filepath.Join(dir, name)); err != nil || !info.IsDir() {
+			entries, err := os.ReadDir(dir)
+			if err != nil {
+				return nil, err
+			}
+			var files []string
+			for _, entry := range entries {
+				if !entry.IsDir() && !strings.HasPrefix(entry.Name(), ".") &&
+					document.IsSupported(entry.Name()) {
+					files = append(files, entry.Name())
+				}
+			}
+			return files, nil
+		}
+	}
+
+	var files []string
+	root := filepath.Join(dir, name)
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if strings.HasPrefix(info.Name(), ".") && path != root {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.IsDir() || !document.IsSupported(info.Name()) {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		files = append(files, filepath.ToSlash(rel))
+		return nil
+	})
+	return files, err
+}
+
+func contains(items []string, item string) bool {
+	for _, v := range items {
+		if v == item {
+			return true
+		}
+	}
+	return false
+}
+
+func normalize(v []float32) []float32 {
+	var sum float64
+	for _, x := range v {
+		sum += float64(x) * float64(x)
+	}
+	if sum == 0 {
+		return v
+	}
+	norm := float32(1 / math.Sqrt(sum))
+	result := make([]float32, len(v))
+	for i, x := range v {
+		result[i] = x * norm
+	}
+	return result
+}
+
+// dot 两个归一化向量的点积即余弦相似度
+func dot(a, b []float32) float64 {
+	if len(a) != len(b) {
+		return 0
+	}
+	var sum float64
+	for i := range a {
+		sum += float64(a[i]) * float64(b[i])
+	}
+	return sum
+}
@@ -0,0 +1,119 @@
+package knowledge
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// vocabulary 测试用的词表，向量的每一维表示一个词是否出现
+var vocabulary = []string{"年假", "报销", "部署", "加班", "发票"}
+
+func fakeEmbedder(calls *int) Embedder {
+	return func(texts []string) ([][]float32, error) {
+		*calls += len(texts)
+		vectors := make([][]float32, len(texts))
+		for i, text := range texts {
+			vectors[i] = embedText(text)
+		}
+		return vectors, nil
+	}
+}
+
+func embedText(text string) []float32 {
+	v := make([]float32, len(vocabulary))
+	for j, word := range vocabulary {
+		v[j] = float32(strings.Count(text, word))
+	}
+	return v
+}
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestReindexAndSearch(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"hr/leave.md":     "# 休假\n员工每年有五天年假，年假需要提前申请。",
+		"hr/expense.md":   "# 报销\n报销需要提供发票，发票抬头为公司全称。",
+		"ops/deploy.md":   "# 部署\n部署前需要通过集成测试。",
+		"ops/.draft.md":   "年假 年假 年假",
+		"ops/image.png":   "not a document",
+		"readme.txt":      "加班可以调休。",
+		"ignored/bin.exe": "MZ",
+	})
+
+	names, err := CollectionNames(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(names, ","); got != "default,hr,ignored,ops" {
+		t.Fatalf("collections = %s", got)
+	}
+
+	file := filepath.Join(dir, "index.json")
+	idx, err := NewIndex(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	calls := 0
+	for _, name := range names {
+		if _, err := idx.Reindex(dir, name, "m", fakeEmbedder(&calls)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if calls != 4 {
+		t.Errorf("embedded %d chunks, want 4", calls)
+	}
+
+	tests := []struct {
+		query  string
+		source string
+	}{
+		{"年假有几天", "hr/leave.md"},
+		{"发票怎么开", "hr/expense.md"},
+		{"部署流程", "ops/deploy.md"},
+		{"加班", "readme.txt"},
+	}
+	for _, tt := range tests {
+		results := idx.Search(embedText(tt.query), "m", 2, 0.5)
+		if len(results) == 0 || results[0].Source != tt.source {
+			t.Errorf("Search(%q) = %+v, want %s", tt.query, results, tt.source)
+		}
+	}
+	if results := idx.Search(embedText("年假"), "other", 2, 0); len(results) != 0 {
+		t.Errorf("searched collections of another model: %+v", results)
+	}
+
+	// 重新加载后内容不变的文件不再计算向量
+	loaded, err := NewIndex(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFiles(t, dir, map[string]string{"hr/expense.md": "报销流程已经调整，请联系财务。"})
+	os.Remove(filepath.Join(dir, "hr/leave.md"))
+	calls = 0
+	stats, err := loaded.Reindex(dir, "hr", "m", fakeEmbedder(&calls))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls != 1 || stats.Files != 1 || stats.Embedded != 1 {
+		t.Errorf("calls = %d, stats = %+v", calls, stats)
+	}
+	if results := loaded.Search(embedText("年假"), "m", 2, 0.5); len(results) != 0 {
+		t.Errorf("deleted file still searchable: %+v", results)
+	}
+	if len(loaded.Collections()) != 3 {
+		t.Errorf("got %d collections", len(loaded.Collections()))
+	}
+}
@@ -6,30 +6,119 @@ import (
 	"io"
 	"mime/multipart"
 	"os"
+	"path/filepath"
 )
 
 type AudioToTextRequestBody struct {
-	File           string `json:"file"`
-	Model          string `json:"model"`
-	ResponseFormat string `json:"response_format"`
+	File string `json:"file"`
+	// Reader 不为空时直接上传内存中的音频，File 只用作文件名
+	Reader         io.Reader `json:"-"`
+	Model          string    `json:"model"`
+	ResponseFormat string    `json:"response_format"`
+	// Language 音频语言提示，ISO-639-1 格式，为空时自动识别
+	Language string `json:"language,omitempty"`
 }
 
-type AudioToTextResponseBody struct {
-	Text string `json:"text"`
+const DefaultTranscribeModel = "whisper-1"
+
+// TranscribeModels 支持的语音转文字模型
+var TranscribeModels = []string{"whisper-1", "gpt-4o-transcribe",
+	"gpt-4o-mini-transcribe"}
+
+// TranscribeOptions 语音转文字的可选参数，零值表示使用 whisper-1 并自动识别语言
+type TranscribeOptions struct {
+	Model    string
+	Language string
+}
+
+func IsValidTranscribeModel(model string) bool {
+	for _, m := range TranscribeModels {
+		if m == model {
+			return true
+		}
+	}
+	return false
+}
+
+// ResponseFormat 转写结果的格式
+type ResponseFormat string
+
+const (
+	ResponseFormatJSON        ResponseFormat = "json"
+	ResponseFormatText        ResponseFormat = "text"
+	ResponseFormatSRT         ResponseFormat = "srt"
+	ResponseFormatVTT         ResponseFormat = "vtt"
+	ResponseFormatVerboseJSON ResponseFormat = "verbose_json"
+)
+
+var ResponseFormats = []ResponseFormat{ResponseFormatJSON, ResponseFormatText,
+	ResponseFormatSRT, ResponseFormatVTT, ResponseFormatVerboseJSON}
+
+func (f ResponseFormat) IsValid() bool {
+	for _, format := range ResponseFormats {
+		if f == format {
+			return true
+		}
+	}
+	return false
+}
+
+// IsJSON 接口是否以 JSON 返回，text、srt、vtt 直接返回文本
+func (f ResponseFormat) IsJSON() bool {
+	return f == ResponseFormatJSON || f == ResponseFormatVerboseJSON
+}
+
+// TranscriptionSegment verbose_json 返回的分段，时间单位为秒
+type TranscriptionSegment struct {
+	Id    int     `json:"id"`
+	Start float64 `json:"start"`
+	End   float64 `json:"end"`
+	Text  string  `json:"text"`
+}
+
+// Transcription 转写结果。text、srt、vtt 格式下 Text 为接口返回的原文，
+// Language、Duration 和 Segments 只在 verbose_json 格式下返回
+type Transcription struct {
+	Format   ResponseFormat         `json:"-"`
+	Text     string                 `json:"text"`
+	Language string                 `json:"language,omitempty"`
+	Duration float64                `json:"duration,omitempty"`
+	Segments []TranscriptionSegment `json:"segments,omitempty"`
+	// Usage whisper-1 在 json 格式下返回的计费时长
+	Usage *TranscriptionUsage `json:"usage,omitempty"`
+}
+
+type TranscriptionUsage struct {
+	Type    string  `json:"type"`
+	Seconds float64 `json:"seconds,omitempty"`
+}
+
+// usage 按音频时长计算的用量。接口没有返回时长时 Seconds 为 0，由调用方按音频长度补充
+func (t *Transcription) usage(model string) Usage {
+	seconds := t.Duration
+	if t.Usage != nil && t.Usage.Seconds > 0 {
+		seconds = t.Usage.Seconds
+	}
+	return Usage{Model: model, Seconds: seconds}
 }
 
 func audioMultipartForm(request AudioToTextRequestBody, w *multipart.Writer) error {
-	f, err := os.Open(request.File)
-	if err != nil {
-		return fmt.Errorf("opening audio file: %w", err)
+	audio := request.Reader
+	if audio == nil {
+		f, err := os.Open(request.File)
+		if err != nil {
+			return fmt.Errorf("opening audio file: %w", err)
+		}
+		defer f.Close()
+		audio = f
 	}
 
-	fw, err := w.CreateFormFile("file", f.Name())
+	fw, err := w.CreateFormFile("file", filepath.Base(request.File))
 	if err != nil {
 		return fmt.Errorf("creating form file: %w", err)
 	}
 
-	if _, err = io.Copy(fw, f); err != nil {
+	if _, err = io.Copy(fw, audio); err != nil {
 		return fmt.Errorf("reading from opened audio file: %w", err)
 	}
 
@@ -42,25 +131,100 @@ func audioMultipartForm(request AudioToTextRequestBody, w *multipart.Writer) err
 	if _, err = io.Copy(fw, modelName); err != nil {
 		return fmt.Errorf("writing model name: %w", err)
 	}
+
+	if request.ResponseFormat != "" {
+		if err = w.WriteField("response_format", request.ResponseFormat); err != nil {
+			return fmt.Errorf("writing response format: %w", err)
+		}
+	}
+
+	if request.Language != "" {
+		if err = w.WriteField("language", request.Language); err != nil {
+			return fmt.Errorf("writing language: %w", err)
+		}
+	}
 	w.Close()
 
 	return nil
 }
 
 func (gpt *ChatGPT) AudioToText(audio string) (string, error) {
-	requestBody := AudioToTextRequestBody{
-		File:           audio,
-		Model:          "whisper-1",
-		ResponseFormat: "text",
-	}
-	audioToTextResponseBody := &AudioToTextResponseBody{}
-	err := gpt.sendRequestWithBodyType(gpt.ApiUrl+"/v1/audio/transcriptions",
-		"POST", formVoiceDataBody, requestBody, audioToTextResponseBody)
-	//fmt.Println(audioToTextResponseBody)
+	transcription, err := gpt.AudioToTextWithFormat(audio, ResponseFormatJSON)
 	if err != nil {
-		//fmt.Println(err)
 		return "", err
 	}
+	return transcription.Text, nil
+}
+
+// AudioToTextWithFormat 转写本地音频文件，并按 format 指定的格式返回
+func (gpt *ChatGPT) AudioToTextWithFormat(audio string,
+	format ResponseFormat) (*Transcription, error) {
+	transcription, _, err := gpt.TranscribeWithFormat(audio, nil,
+		TranscribeOptions{}, format)
+	return transcription, err
+}
 
-	return audioToTextResponseBody.Text, nil
+// AudioToTextFromReader 直接转写内存中的音频，fileName 的扩展名决定音频格式
+func (gpt *ChatGPT) AudioToTextFromReader(fileName string,
+	audio io.Reader) (string, error) {
+	text, _, err := gpt.Transcribe(fileName, audio, TranscribeOptions{})
+	return text, err
+}
+
+// Transcribe 按指定的模型和语言转写内存中的音频
+func (gpt *ChatGPT) Transcribe(fileName string, audio io.Reader,
+	opts TranscribeOptions) (string, Usage, error) {
+	transcription, usage, err := gpt.TranscribeWithFormat(fileName, audio, opts,
+		ResponseFormatJSON)
+	if err != nil {
+		return "", usage, err
+	}
+	return transcription.Text, usage, nil
+}
+
+// TranscribeVerbose 转写音频并返回带时间戳的分段
+func (gpt *ChatGPT) TranscribeVerbose(fileName string, audio io.Reader,
+	opts TranscribeOptions) (*Transcription, Usage, error) {
+	return gpt.TranscribeWithFormat(fileName, audio, opts,
+		ResponseFormatVerboseJSON)
+}
+
+// TranscribeWithFormat 转写音频，audio 为空时读取 fileName 指向的本地文件。
+// 只有 whisper-1 支持 json、text 以外的格式，其他模型会改用 whisper-1
+func (gpt *ChatGPT) TranscribeWithFormat(fileName string, audio io.Reader,
+	opts TranscribeOptions, format ResponseFormat) (*Transcription, Usage, error) {
+	if !format.IsValid() {
+		return nil, Usage{}, fmt.Errorf("unsupported response format: %s", format)
+	}
+	model := opts.Model
+	if model == "" || (format != ResponseFormatJSON && format != ResponseFormatText) {
+		model = DefaultTranscribeModel
+	}
+	requestBody := AudioToTextRequestBody{
+		File:           fileName,
+		Reader:         audio,
+		Model:          model,
+		ResponseFormat: string(format),
+		Language:       opts.Language,
+	}
+
+	url := gpt.ApiUrl + "/v1/audio/transcriptions"
+	transcription := &Transcription{}
+	if format.IsJSON() {
+		err := gpt.sendRequestWithBodyType(url, "POST", formVoiceDataBody,
+			requestBody, transcription)
+		if err != nil {
+			return nil, Usage{}, err
+		}
+	} else {
+		var raw []byte
+		err := gpt.sendRequestWithBodyType(url, "POST", formVoiceDataBody,
+			requestBody, &raw)
+		if err != nil {
+			return nil, Usage{}, err
+		}
+		transcription.Text = string(raw)
+	}
+	transcription.Format = format
+	return transcription, transcription.usage(model), nil
 }
@@ -0,0 +1,80 @@
+package openai
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"strings"
+	"testing"
+)
+
+func TestAudioMultipartForm(t *testing.T) {
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	err := audioMultipartForm(AudioToTextRequestBody{
+		File:           "/tmp/voice.wav",
+		Reader:         strings.NewReader("RIFF"),
+		Model:          "whisper-1",
+		ResponseFormat: string(ResponseFormatSRT),
+		Language:       "zh",
+	}, w)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	form, err := multipart.NewReader(&body, w.Boundary()).ReadForm(1 << 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"model":           "whisper-1",
+		"response_format": "srt",
+		"language":        "zh",
+	}
+	for key, value := range want {
+		if got := form.Value[key]; len(got) != 1 || got[0] != value {
+			t.Errorf("form field %s = %v, want %s", key, got, value)
+		}
+	}
+	if files := form.File["file"]; len(files) != 1 || files[0].Filename != "voice.wav" {
+		t.Errorf("form file = %v, want voice.wav", files)
+	}
+}
+
+func TestResponseFormat(t *testing.T) {
+	for _, f := range ResponseFormats {
+		if !f.IsValid() {
+			t.Errorf("%s.IsValid() = false", f)
+		}
+	}
+	if ResponseFormat("docx").IsValid() {
+		t.Error("docx.IsValid() = true")
+	}
+	if ResponseFormatSRT.IsJSON() || !ResponseFormatVerboseJSON.IsJSON() {
+		t.Error("IsJSON() mismatch")
+	}
+}
+
+func TestTranscriptionUsage(t *testing.T) {
+	tests := []struct {
+		body string
+		want float64
+	}{
+		// whisper-1 的 json 格式返回计费时长
+		{`{"text":"你好","usage":{"type":"duration","seconds":3}}`, 3},
+		{`{"text":"你好","duration":12.5,"segments":[]}`, 12.5},
+		// gpt-4o-transcribe 按 token 返回，由调用方按音频长度补充
+		{`{"text":"你好","usage":{"type":"tokens","input_tokens":10}}`, 0},
+		{`{"text":"你好"}`, 0},
+	}
+	for _, tt := range tests {
+		var transcription Transcription
+		if err := json.Unmarshal([]byte(tt.body), &transcription); err != nil {
+			t.Fatal(err)
+		}
+		got := transcription.usage("whisper-1")
+		if got.Model != "whisper-1" || got.Seconds != tt.want {
+			t.Errorf("usage(%s) = %+v, want %v seconds", tt.body, got, tt.want)
+		}
+	}
+}
@@ -53,6 +53,7 @@ const (
 	jsonBody requestBodyType = iota
 	formVoiceDataBody
 	formPictureDataBody
+	formImageEditBody
 
 	nilBody
 )
@@ -73,19 +74,22 @@ func (gpt *ChatGPT) doAPIRequestWithRetry(url, method string,
 		bodyBytes, _ := json.Marshal(requestBody)
 		json.Unmarshal(bodyBytes, &bodyMap)
 
-		// 特殊处理 o4-mini 和 gpt-4o 模型
-		if gpt.Model == "o4-mini" || gpt.Model == "gpt-4o" {
-			// 删除 max_tokens 参数，因为这些模型不支持该参数
-			delete(bodyMap, "max_tokens")
-
-			// 添加 max_completion_tokens 参数
-			if gpt.MaxTokens > 0 {
-				bodyMap["max_completion_tokens"] = gpt.MaxTokens
-			}
-		} else {
-			// 对于其他模型，使用 max_tokens 参数
-			if gpt.MaxTokens > 0 {
-				bodyMap["max_tokens"] = gpt.MaxTokens
+		// 只有对话接口需要设置最大 token 数，图片、语音接口不接受该参数
+		if _, isChat := bodyMap["messages"]; isChat {
+			// 特殊处理 o4-mini 和 gpt-4o 模型
+			if gpt.Model == "o4-mini" || gpt.Model == "gpt-4o" {
+				// 删除 max_tokens 参数，因为这些模型不支持该参数
+				delete(bodyMap, "max_tokens")
+
+				// 添加 max_completion_tokens 参数
+				if gpt.MaxTokens > 0 {
+					bodyMap["max_completion_tokens"] = gpt.MaxTokens
+				}
+			} else {
+				// 对于其他模型，使用 max_tokens 参数
+				if gpt.MaxTokens > 0 {
+					bodyMap["max_tokens"] = gpt.MaxTokens
+				}
 			}
 		}
 
@@ -118,6 +122,14 @@ func (gpt *ChatGPT) doAPIRequestWithRetry(url, method string,
 			return err
 		}
 		requestBodyData = formBody.Bytes()
+	case formImageEditBody:
+		formBody := &bytes.Buffer{}
+		writer = multipart.NewWriter(formBody)
+		err = imageEditMultipartForm(requestBody.(ImageEditRequestBody), writer)
+		if err != nil {
+			return err
+		}
+		requestBodyData = formBody.Bytes()
 	case nilBody:
 		requestBodyData = nil
 
@@ -136,7 +148,8 @@ func (gpt *ChatGPT) doAPIRequestWithRetry(url, method string,
 	}
 
 	req.Header.Set("Content-Type", "application/json")
-	if bodyType == formVoiceDataBody || bodyType == formPictureDataBody {
+	if bodyType == formVoiceDataBody || bodyType == formPictureDataBody ||
+		bodyType == formImageEditBody {
 		req.Header.Set("Content-Type", writer.FormDataContentType())
 	}
 	if gpt.Platform == OpenAI {
@@ -187,9 +200,14 @@ func (gpt *ChatGPT) doAPIRequestWithRetry(url, method string,
 		return err
 	}
 
-	err = json.Unmarshal(body, responseBody)
-	if err != nil {
-		return err
+	// 语音合成等接口直接返回二进制数据
+	if raw, ok := responseBody.(*[]byte); ok {
+		*raw = body
+	} else {
+		err = json.Unmarshal(body, responseBody)
+		if err != nil {
+			return err
+		}
 	}
 
 	gpt.Lb.SetAvailability(api.Key, true)
@@ -0,0 +1,67 @@
+package openai
+
+import (
+	"errors"
+	"fmt"
+)
+
+const (
+	// DefaultEmbeddingModel 默认的向量模型
+	DefaultEmbeddingModel = "text-embedding-3-small"
+	// EmbeddingBatchSize 单次请求最多提交的文本数
+	EmbeddingBatchSize = 64
+)
+
+type EmbeddingRequestBody struct {
+	Model string   `json:"model"`
+	Input []string `json:"input"`
+}
+
+type EmbeddingResponseBody struct {
+	Data []struct {
+		Embedding []float32 `json:"embedding"`
+		Index     int       `json:"index"`
+	} `json:"data"`
+	Model string `json:"model"`
+	Usage Usage  `json:"usage"`
+}
+
+// Embeddings 计算文本的向量，超过 EmbeddingBatchSize 的输入会分批请求，
+// 返回的向量与输入一一对应
+func (gpt *ChatGPT) Embeddings(model string, inputs []string) ([][]float32,
+	Usage, error) {
+	if model == "" {
+		model = DefaultEmbeddingModel
+	}
+	vectors := make([][]float32, len(inputs))
+	total := Usage{Model: model}
+	for start := 0; start < len(inputs); start += EmbeddingBatchSize {
+		end := start + EmbeddingBatchSize
+		if end > len(inputs) {
+			end = len(inputs)
+		}
+		requestBody := EmbeddingRequestBody{Model: model, Input: inputs[start:end]}
+		var resp EmbeddingResponseBody
+		err := gpt.sendRequestWithBodyType(gpt.ApiUrl+"/v1/embeddings",
+			"POST", jsonBody, requestBody, &resp)
+		if err != nil {
+			return nil, total, err
+		}
+		if len(resp.Data) != end-start {
+			return nil, total, fmt.Errorf("expected %d embeddings, got %d",
+				end-start, len(resp.Data))
+		}
+		for _, item := range resp.Data {
+			if item.Index < 0 || item.Index >= end-start {
+				return nil, total, errors.New("embedding index out of range")
+			}
+			vectors[start+item.Index] = item.Embedding
+		}
+		if resp.Model != "" {
+			total.Model = resp.Model
+		}
+		total.PromptTokens += resp.Usage.PromptTokens
+		total.TotalTokens += resp.Usage.TotalTokens
+	}
+	return vectors, total, nil
+}
@@ -34,16 +34,33 @@ var AIModeStrs = []string{
 type Messages struct {
 	Role    string `json:"role"`
 	Content string `json:"content"`
+	// Images 图片推理时用户发送的图片，只保存引用，不会出现在文本对话的请求中
+	Images []ImageAttachment `json:"-"`
 }
 
 // ChatGPTResponseBody 请求体
 type ChatGPTResponseBody struct {
-	ID      string                 `json:"id"`
-	Object  string                 `json:"object"`
-	Created int                    `json:"created"`
-	Model   string                 `json:"model"`
-	Choices []ChatGPTChoiceItem    `json:"choices"`
-	Usage   map[string]interface{} `json:"usage"`
+	ID      string              `json:"id"`
+	Object  string              `json:"object"`
+	Created int                 `json:"created"`
+	Model   string              `json:"model"`
+	Choices []ChatGPTChoiceItem `json:"choices"`
+	Usage   Usage               `json:"usage"`
+}
+
+// Usage 单次请求的 token 消耗
+type Usage struct {
+	Model            string `json:"-"`
+	PromptTokens     int    `json:"prompt_tokens"`
+	CompletionTokens int    `json:"completion_tokens"`
+	TotalTokens      int    `json:"total_tokens"`
+	// Characters 语音合成等按字符计费的调用的字符数，只计入花费，不计入 token 用量
+	Characters int `json:"-"`
+	// Images、Size dall-e 按张计费，记录生成的图片数和尺寸
+	Images int    `json:"-"`
+	Size   string `json:"-"`
+	// Seconds 语音转文字按音频时长计费，记录音频秒数
+	Seconds float64 `json:"-"`
 }
 
 type ChatGPTChoiceItem struct {
@@ -71,6 +88,13 @@ func (msg *Messages) CalculateTokenLength() int {
 
 func (gpt *ChatGPT) Completions(msg []Messages, aiMode AIMode) (resp Messages,
 	err error) {
+	resp, _, err = gpt.CompletionsWithUsage(msg, aiMode)
+	return resp, err
+}
+
+// CompletionsWithUsage 与 Completions 相同，额外返回本次请求的 token 消耗
+func (gpt *ChatGPT) CompletionsWithUsage(msg []Messages, aiMode AIMode) (resp Messages,
+	usage Usage, err error) {
 	// Create base request body
 	requestBody := ChatGPTRequestBody{
 		Model:            gpt.Model,
@@ -91,15 +115,38 @@ func (gpt *ChatGPT) Completions(msg []Messages, aiMode AIMode) (resp Messages,
 	logger.Debug(url)
 	logger.Debug("request body ", requestBody)
 	if url == "" {
-		return resp, errors.New("无法获取openai请求地址")
+		return resp, usage, errors.New("无法获取openai请求地址")
 	}
 	err = gpt.sendRequestWithBodyType(url, "POST", jsonBody, requestBody, gptResponseBody)
 	if err == nil && len(gptResponseBody.Choices) > 0 {
 		resp = gptResponseBody.Choices[0].Message
+		usage = gptResponseBody.usage(gpt.Model)
 	} else {
 		logger.Errorf("ERROR %v", err)
 		resp = Messages{}
 		err = errors.New("openai 请求失败")
 	}
-	return resp, err
+	return resp, usage, err
+}
+
+// usage 返回响应中的 token 消耗，响应未带模型名时使用请求的模型名
+func (body *ChatGPTResponseBody) usage(model string) Usage {
+	usage := body.Usage
+	usage.Model = body.Model
+	if usage.Model == "" {
+		usage.Model = model
+	}
+	return usage
+}
+
+// EstimateUsage 在接口没有返回 usage 时（如流式输出），用本地分词器估算 token 消耗
+func EstimateUsage(model string, msg []Messages, answer string) Usage {
+	usage := Usage{Model: model}
+	for _, m := range msg {
+		usage.PromptTokens += m.CalculateTokenLength()
+	}
+	reply := Messages{Role: "assistant", Content: answer}
+	usage.CompletionTokens = reply.CalculateTokenLength()
+	usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
+	return usage
 }
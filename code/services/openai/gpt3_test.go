@@ -129,17 +129,6 @@ func TestVariateOneImageWithJpg(t *testing.T) {
 	}
 }
 
-// 余额接口已经被废弃
-func TestChatGPT_GetBalance(t *testing.T) {
-	config := initialization.LoadConfig("../../config.yaml")
-	gpt := NewChatGPT(*config)
-	balance, err := gpt.GetBalance()
-	if err != nil {
-		t.Errorf("TestChatGPT_GetBalance failed with error: %v", err)
-	}
-	fmt.Println("balance: ", balance)
-}
-
 func TestChatGPT_streamChat(t *testing.T) {
 	// 初始化配置
 	config := initialization.LoadConfig("../../config.yaml")
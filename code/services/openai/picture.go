@@ -3,6 +3,7 @@ package openai
 import (
 	"bufio"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"image"
 	"image/jpeg"
@@ -10,14 +11,18 @@ import (
 	"io"
 	"io/ioutil"
 	"mime/multipart"
+	"net/http"
+	"net/textproto"
 	"os"
+	"strings"
+	"sync"
 )
 
 type ImageGenerationRequestBody struct {
 	Prompt         string `json:"prompt"`
 	N              int    `json:"n"`
 	Size           string `json:"size"`
-	ResponseFormat string `json:"response_format"`
+	ResponseFormat string `json:"response_format,omitempty"`
 	Model          string `json:"model,omitempty"`
 	Style          string `json:"style,omitempty"`
 }
@@ -27,10 +32,86 @@ type ImageResponseBody struct {
 	Data    []struct {
 		Base64Json string `json:"b64_json"`
 	} `json:"data"`
+	// Usage 只有 gpt-image 系列模型会返回
+	Usage struct {
+		InputTokens  int `json:"input_tokens"`
+		OutputTokens int `json:"output_tokens"`
+		TotalTokens  int `json:"total_tokens"`
+	} `json:"usage"`
+}
+
+func (body *ImageResponseBody) b64s() []string {
+	var b64Pool []string
+	for _, data := range body.Data {
+		b64Pool = append(b64Pool, data.Base64Json)
+	}
+	return b64Pool
+}
+
+// usage gpt-image 模型返回 token 用量，dall-e 按生成的张数和尺寸计费
+func (body *ImageResponseBody) usage(model string, size string) Usage {
+	if !IsGPTImageModel(model) {
+		return Usage{Model: model, Images: len(body.Data), Size: size}
+	}
+	return Usage{
+		Model:            model,
+		PromptTokens:     body.Usage.InputTokens,
+		CompletionTokens: body.Usage.OutputTokens,
+		TotalTokens:      body.Usage.TotalTokens,
+	}
+}
+
+const (
+	ImageModelDallE2   = "dall-e-2"
+	ImageModelDallE3   = "dall-e-3"
+	ImageModelGPTImage = "gpt-image-1"
+)
+
+// ImageModels 图片创作可选的模型
+var ImageModels = []string{ImageModelDallE3, ImageModelGPTImage}
+
+// IsGPTImageModel gpt-image 系列模型总是返回 base64，不支持 style 参数，
+// 编辑时可以上传多张 PNG、JPEG 或 WEBP 图片
+func IsGPTImageModel(model string) bool {
+	return strings.HasPrefix(model, "gpt-image")
+}
+
+// gptImageSize 将 dall-e-3 的尺寸换算为 gpt-image 支持的尺寸
+func gptImageSize(size string) string {
+	switch size {
+	case "1024x1792":
+		return "1024x1536"
+	case "1792x1024":
+		return "1536x1024"
+	case "1024x1536", "1536x1024", "auto":
+		return size
+	default:
+		return "1024x1024"
+	}
+}
+
+// ImageFile 上传给图片接口的图片，Name 的扩展名不影响识别，格式按内容判断
+type ImageFile struct {
+	Name string
+	Data []byte
+}
+
+// ImageEditRequestBody 图片编辑请求。dall-e-2 只接受一张正方形 PNG，
+// gpt-image 系列模型最多接受 16 张图片。Mask 透明的区域为需要编辑的区域，
+// 尺寸需要与第一张图片一致
+type ImageEditRequestBody struct {
+	Images []ImageFile
+	Mask   *ImageFile
+	Prompt string
+	Model  string
+	N      int
+	Size   string
 }
 
 type ImageVariantRequestBody struct {
-	Image          string `json:"image"`
+	Image string `json:"image"`
+	// Data 图片内容，不为空时代替 Image 指定的文件
+	Data           []byte `json:"-"`
 	N              int    `json:"n"`
 	Size           string `json:"size"`
 	ResponseFormat string `json:"response_format"`
@@ -38,28 +119,166 @@ type ImageVariantRequestBody struct {
 
 func (gpt *ChatGPT) GenerateImage(prompt string, size string,
 	n int, style string) ([]string, error) {
+	b64s, _, err := gpt.GenerateImageWithModel(prompt, size, n, style,
+		ImageModelDallE3)
+	return b64s, err
+}
+
+// GenerateImageWithModel 使用指定模型生成图片，返回 base64 编码的图片
+func (gpt *ChatGPT) GenerateImageWithModel(prompt string, size string,
+	n int, style string, model string) ([]string, Usage, error) {
+	// dall-e-3 每次只能生成一张，多张时并发请求
+	if model == ImageModelDallE3 && n > 1 {
+		return gpt.generateImagesConcurrently(prompt, size, n, style, model)
+	}
+	return gpt.generateImages(prompt, size, n, style, model)
+}
+
+// generateImagesConcurrently 并发生成 n 张图片，部分失败时返回成功的图片
+func (gpt *ChatGPT) generateImagesConcurrently(prompt string, size string,
+	n int, style string, model string) ([]string, Usage, error) {
+	results := make([][]string, n)
+	usages := make([]Usage, n)
+	errs := make([]error, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], usages[i], errs[i] = gpt.generateImages(prompt, size, 1,
+				style, model)
+		}(i)
+	}
+	wg.Wait()
+
+	var b64s []string
+	total := Usage{Model: model, Size: size}
+	var lastErr error
+	for i := 0; i < n; i++ {
+		if errs[i] != nil {
+			lastErr = errs[i]
+			continue
+		}
+		b64s = append(b64s, results[i]...)
+		total.PromptTokens += usages[i].PromptTokens
+		total.CompletionTokens += usages[i].CompletionTokens
+		total.TotalTokens += usages[i].TotalTokens
+		total.Images += usages[i].Images
+	}
+	if len(b64s) == 0 {
+		return nil, Usage{}, lastErr
+	}
+	return b64s, total, nil
+}
+
+func (gpt *ChatGPT) generateImages(prompt string, size string,
+	n int, style string, model string) ([]string, Usage, error) {
 	requestBody := ImageGenerationRequestBody{
 		Prompt:         prompt,
 		N:              n,
 		Size:           size,
 		ResponseFormat: "b64_json",
-		Model:          "dall-e-3",
+		Model:          model,
 		Style:          style,
 	}
+	if IsGPTImageModel(model) {
+		requestBody.ResponseFormat = ""
+		requestBody.Style = ""
+		requestBody.Size = gptImageSize(size)
+	}
 
 	imageResponseBody := &ImageResponseBody{}
 	err := gpt.sendRequestWithBodyType(gpt.ApiUrl+"/v1/images/generations",
 		"POST", jsonBody, requestBody, imageResponseBody)
 
 	if err != nil {
-		return nil, err
+		return nil, Usage{}, err
 	}
+	return imageResponseBody.b64s(), imageResponseBody.usage(model,
+		requestBody.Size), nil
+}
 
-	var b64Pool []string
-	for _, data := range imageResponseBody.Data {
-		b64Pool = append(b64Pool, data.Base64Json)
+// EditImage 根据提示词编辑图片，返回 base64 编码的图片
+func (gpt *ChatGPT) EditImage(request ImageEditRequestBody) ([]string, Usage,
+	error) {
+	if len(request.Images) == 0 {
+		return nil, Usage{}, errors.New("no image to edit")
 	}
-	return b64Pool, nil
+	if request.Model == "" {
+		request.Model = ImageModelDallE2
+	}
+	if request.N <= 0 {
+		request.N = 1
+	}
+	if IsGPTImageModel(request.Model) {
+		request.Size = gptImageSize(request.Size)
+	} else if len(request.Images) > 1 {
+		return nil, Usage{}, fmt.Errorf("%s only accepts one image",
+			request.Model)
+	}
+
+	imageResponseBody := &ImageResponseBody{}
+	err := gpt.sendRequestWithBodyType(gpt.ApiUrl+"/v1/images/edits",
+		"POST", formImageEditBody, request, imageResponseBody)
+	if err != nil {
+		return nil, Usage{}, err
+	}
+	return imageResponseBody.b64s(), imageResponseBody.usage(request.Model,
+		request.Size), nil
+}
+
+func imageEditMultipartForm(request ImageEditRequestBody,
+	w *multipart.Writer) error {
+	// gpt-image 系列模型的多张图片使用 image[] 字段
+	field := "image"
+	if IsGPTImageModel(request.Model) {
+		field = "image[]"
+	}
+	for _, img := range request.Images {
+		if err := writeImagePart(w, field, img); err != nil {
+			return err
+		}
+	}
+	if request.Mask != nil {
+		if err := writeImagePart(w, "mask", *request.Mask); err != nil {
+			return err
+		}
+	}
+
+	fields := [][2]string{
+		{"prompt", request.Prompt},
+		{"model", request.Model},
+		{"n", fmt.Sprintf("%d", request.N)},
+	}
+	if request.Size != "" {
+		fields = append(fields, [2]string{"size", request.Size})
+	}
+	if !IsGPTImageModel(request.Model) {
+		fields = append(fields, [2]string{"response_format", "b64_json"})
+	}
+	for _, f := range fields {
+		if err := w.WriteField(f[0], f[1]); err != nil {
+			return fmt.Errorf("writing %s: %w", f[0], err)
+		}
+	}
+	return w.Close()
+}
+
+// writeImagePart 写入图片字段，接口按 Content-Type 校验图片格式，
+// 因此不能使用 CreateFormFile 默认的 application/octet-stream
+func writeImagePart(w *multipart.Writer, field string, img ImageFile) error {
+	header := make(textproto.MIMEHeader)
+	header.Set("Content-Disposition", fmt.Sprintf(
+		`form-data; name="%s"; filename="%s"`, field, img.Name))
+	header.Set("Content-Type", http.DetectContentType(img.Data))
+	part, err := w.CreatePart(header)
+	if err != nil {
+		return fmt.Errorf("creating form file: %w", err)
+	}
+	if _, err = part.Write(img.Data); err != nil {
+		return fmt.Errorf("writing image %s: %w", img.Name, err)
+	}
+	return nil
 }
 
 func (gpt *ChatGPT) GenerateOneImage(prompt string,
@@ -101,6 +320,27 @@ func (gpt *ChatGPT) GenerateImageVariation(images string,
 	return b64Pool, nil
 }
 
+// GenerateImageVariationFromData 根据内存中的 PNG 图片生成变体，变体只支持 dall-e-2
+func (gpt *ChatGPT) GenerateImageVariationFromData(data []byte,
+	size string, n int) ([]string, Usage, error) {
+	requestBody := ImageVariantRequestBody{
+		Image:          "image.png",
+		Data:           data,
+		N:              n,
+		Size:           size,
+		ResponseFormat: "b64_json",
+	}
+
+	imageResponseBody := &ImageResponseBody{}
+	err := gpt.sendRequestWithBodyType(gpt.ApiUrl+"/v1/images/variations",
+		"POST", formPictureDataBody, requestBody, imageResponseBody)
+	if err != nil {
+		return nil, Usage{}, err
+	}
+	return imageResponseBody.b64s(), imageResponseBody.usage(ImageModelDallE2,
+		size), nil
+}
+
 func (gpt *ChatGPT) GenerateOneImageVariation(images string,
 	size string) (string, error) {
 	b64s, err := gpt.GenerateImageVariation(images, size, 1)
@@ -113,19 +353,28 @@ func (gpt *ChatGPT) GenerateOneImageVariation(images string,
 func pictureMultipartForm(request ImageVariantRequestBody,
 	w *multipart.Writer) error {
 
-	f, err := os.Open(request.Image)
-	if err != nil {
-		return fmt.Errorf("opening audio file: %w", err)
-	}
-	fw, err := w.CreateFormFile("image", f.Name())
-	if err != nil {
-		return fmt.Errorf("creating form file: %w", err)
-	}
-	if _, err = io.Copy(fw, f); err != nil {
-		return fmt.Errorf("reading from opened audio file: %w", err)
+	if request.Data != nil {
+		err := writeImagePart(w, "image", ImageFile{Name: request.Image,
+			Data: request.Data})
+		if err != nil {
+			return err
+		}
+	} else {
+		f, err := os.Open(request.Image)
+		if err != nil {
+			return fmt.Errorf("opening audio file: %w", err)
+		}
+		defer f.Close()
+		fw, err := w.CreateFormFile("image", f.Name())
+		if err != nil {
+			return fmt.Errorf("creating form file: %w", err)
+		}
+		if _, err = io.Copy(fw, f); err != nil {
+			return fmt.Errorf("reading from opened audio file: %w", err)
+		}
 	}
 
-	err = w.WriteField("size", request.Size)
+	err := w.WriteField("size", request.Size)
 	if err != nil {
 		return fmt.Errorf("writing size: %w", err)
 	}
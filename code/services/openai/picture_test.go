@@ -0,0 +1,174 @@
+package openai
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"math/rand"
+	"mime/multipart"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"start-feishubot/utils/imaging"
+)
+
+func testPNG(t *testing.T, w, h int) []byte {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, w, h))); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestImageEditMultipartForm(t *testing.T) {
+	img := ImageFile{Name: "a.png", Data: testPNG(t, 4, 4)}
+	mask := ImageFile{Name: "mask.png", Data: testPNG(t, 4, 4)}
+
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	err := imageEditMultipartForm(ImageEditRequestBody{
+		Images: []ImageFile{img, img},
+		Mask:   &mask,
+		Prompt: "make it night",
+		Model:  ImageModelGPTImage,
+		N:      2,
+		Size:   "1792x1024",
+	}, w)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	form, err := multipart.NewReader(&body, w.Boundary()).ReadForm(1 << 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := len(form.File["image[]"]); got != 2 {
+		t.Errorf("image[] count = %d, want 2", got)
+	}
+	if got := form.File["mask"]; len(got) != 1 ||
+		got[0].Header.Get("Content-Type") != "image/png" {
+		t.Errorf("mask = %v, want one image/png part", got)
+	}
+	want := map[string]string{
+		"prompt": "make it night",
+		"model":  ImageModelGPTImage,
+		"n":      "2",
+		"size":   "1792x1024",
+	}
+	for key, value := range want {
+		if got := form.Value[key]; len(got) != 1 || got[0] != value {
+			t.Errorf("form field %s = %v, want %s", key, got, value)
+		}
+	}
+	if _, ok := form.Value["response_format"]; ok {
+		t.Error("response_format must not be sent for gpt-image models")
+	}
+}
+
+func TestGptImageSize(t *testing.T) {
+	tests := map[string]string{
+		"1024x1792": "1024x1536",
+		"1792x1024": "1536x1024",
+		"256x256":   "1024x1024",
+		"auto":      "auto",
+	}
+	for size, want := range tests {
+		if got := gptImageSize(size); got != want {
+			t.Errorf("gptImageSize(%s) = %s, want %s", size, got, want)
+		}
+	}
+}
+
+func TestPictureMultipartFormFromData(t *testing.T) {
+	data := testPNG(t, 4, 4)
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	err := pictureMultipartForm(ImageVariantRequestBody{
+		Image:          "image.png",
+		Data:           data,
+		N:              3,
+		Size:           "1024x1024",
+		ResponseFormat: "b64_json",
+	}, w)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	form, err := multipart.NewReader(&body, w.Boundary()).ReadForm(1 << 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := form.File["image"]
+	if len(files) != 1 || files[0].Size != int64(len(data)) {
+		t.Fatalf("image = %v, want one part of %d bytes", files, len(data))
+	}
+	if got := form.Value["n"]; len(got) != 1 || got[0] != "3" {
+		t.Errorf("n = %v, want 3", got)
+	}
+}
+
+// benchmarkPhoto 生成一张接近手机照片的 JPEG，渐变加少量噪点
+func benchmarkPhoto(b *testing.B) []byte {
+	const w, h = 2000, 1500
+	rng := rand.New(rand.NewSource(1))
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			i := img.PixOffset(x, y)
+			img.Pix[i] = uint8(x * 255 / w)
+			img.Pix[i+1] = uint8(y * 255 / h)
+			img.Pix[i+2] = uint8(rng.Intn(32))
+			img.Pix[i+3] = 255
+		}
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, nil); err != nil {
+		b.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// BenchmarkConvertFile 原有的流程：写入文件，ConvertJpegToPNG 后再 ConvertToRGBA
+func BenchmarkConvertFile(b *testing.B) {
+	data := benchmarkPhoto(b)
+	dir := b.TempDir()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		jpg := filepath.Join(dir, "image.jpg")
+		if err := os.WriteFile(jpg, data, 0644); err != nil {
+			b.Fatal(err)
+		}
+		if err := ConvertJpegToPNG(jpg); err != nil {
+			b.Fatal(err)
+		}
+		f := filepath.Join(dir, "image.png")
+		if err := ConvertToRGBA(f, f); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkProcessFullSize 内存中完成同样的转换，不缩放
+func BenchmarkProcessFullSize(b *testing.B) {
+	data := benchmarkPhoto(b)
+	opts := imaging.Options{Format: imaging.FormatPNG, ForceAlpha: true}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := imaging.Process(data, opts); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkProcessDallE2 实际使用的 dall-e-2 流程，补成正方形并缩小到 1024
+func BenchmarkProcessDallE2(b *testing.B) {
+	data := benchmarkPhoto(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := imaging.Process(data, imaging.DallE2); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
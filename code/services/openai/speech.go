@@ -0,0 +1,58 @@
+package openai
+
+import (
+	"errors"
+	"unicode/utf8"
+)
+
+const (
+	TTSModel = "tts-1"
+	// TTSMaxInput 语音合成接口单次最多接受的字符数
+	TTSMaxInput = 4096
+)
+
+// Voices 语音合成可选的音色
+var Voices = []string{"alloy", "echo", "fable", "onyx", "nova", "shimmer"}
+
+type TextToSpeechRequestBody struct {
+	Model          string  `json:"model"`
+	Input          string  `json:"input"`
+	Voice          string  `json:"voice"`
+	ResponseFormat string  `json:"response_format,omitempty"`
+	Speed          float64 `json:"speed,omitempty"`
+}
+
+func IsValidVoice(voice string) bool {
+	for _, v := range Voices {
+		if v == voice {
+			return true
+		}
+	}
+	return false
+}
+
+// TextToSpeech 将文字合成为 Ogg/Opus 格式的语音，过长的文字会被截断
+func (gpt *ChatGPT) TextToSpeech(text string, voice string) ([]byte, Usage,
+	error) {
+	if !IsValidVoice(voice) {
+		return nil, Usage{}, errors.New("unknown voice: " + voice)
+	}
+	if utf8.RuneCountInString(text) > TTSMaxInput {
+		text = string([]rune(text)[:TTSMaxInput])
+	}
+	requestBody := TextToSpeechRequestBody{
+		Model:          TTSModel,
+		Input:          text,
+		Voice:          voice,
+		ResponseFormat: "opus",
+	}
+	var speech []byte
+	err := gpt.sendRequestWithBodyType(gpt.ApiUrl+"/v1/audio/speech",
+		"POST", jsonBody, requestBody, &speech)
+	if err != nil {
+		return nil, Usage{}, err
+	}
+	// 语音合成按字符计费，字符数不占用 token 额度
+	return speech, Usage{Model: TTSModel,
+		Characters: utf8.RuneCountInString(text)}, nil
+}
@@ -2,10 +2,7 @@ package openai
 
 import (
 	"context"
-	"errors"
-	"fmt"
 	go_openai "github.com/sashabaranov/go-openai"
-	"io"
 	"time"
 )
 
@@ -39,6 +39,13 @@ const (
 // GetVisionInfo processes vision requests with the specified model
 func (gpt *ChatGPT) GetVisionInfo(msg []VisionMessages) (
 	resp Messages, err error) {
+	resp, _, err = gpt.GetVisionInfoWithUsage(msg)
+	return resp, err
+}
+
+// GetVisionInfoWithUsage 与 GetVisionInfo 相同，额外返回本次请求的 token 消耗
+func (gpt *ChatGPT) GetVisionInfoWithUsage(msg []VisionMessages) (
+	resp Messages, usage Usage, err error) {
 	// Default to gpt-4-vision-preview if not using o4-mini
 	visionModel := GPT4VisionPreview
 
@@ -64,16 +71,17 @@ func (gpt *ChatGPT) GetVisionInfo(msg []VisionMessages) (
 	url := gpt.FullUrl("chat/completions")
 	logger.Debug("request body ", requestBody)
 	if url == "" {
-		return resp, errors.New("无法获取openai请求地址")
+		return resp, usage, errors.New("无法获取openai请求地址")
 	}
 
 	err = gpt.sendRequestWithBodyType(url, "POST", jsonBody, requestBody, gptResponseBody)
 	if err == nil && len(gptResponseBody.Choices) > 0 {
 		resp = gptResponseBody.Choices[0].Message
+		usage = gptResponseBody.usage(string(visionModel))
 	} else {
 		logger.Errorf("ERROR %v", err)
 		resp = Messages{}
 		err = errors.New("openai 请求失败")
 	}
-	return resp, err
+	return resp, usage, err
 }
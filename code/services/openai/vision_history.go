@@ -0,0 +1,84 @@
+package openai
+
+import "fmt"
+
+// ImageAttachment 会话历史中的一张图片，追问时通过飞书消息重新下载
+type ImageAttachment struct {
+	MsgId    string
+	ImageKey string
+	Detail   string
+	// Tokens 发送时估算的 token 数，用于在不下载的情况下计算预算
+	Tokens int
+}
+
+// ImageLoader 根据引用重新获取可以发送的图片
+type ImageLoader func(image ImageAttachment) (VisionImage, error)
+
+// HasImages 判断会话历史中是否有图片
+func HasImages(msg []Messages) bool {
+	for _, m := range msg {
+		if len(m.Images) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// BuildVisionMessages 把会话历史转换为图片推理请求。最后一条消息的图片总是附带，
+// 更早的图片从新到旧在 budget 个 token 内重新附带，超出预算或无法获取的图片改为文字说明。
+// 返回实际附带的图片
+func BuildVisionMessages(msg []Messages, budget int,
+	load ImageLoader) ([]VisionMessages, []VisionImage, error) {
+	attach := make([]bool, len(msg))
+	remaining := budget
+	for i := len(msg) - 1; i >= 0; i-- {
+		if len(msg[i].Images) == 0 {
+			continue
+		}
+		cost := 0
+		for _, image := range msg[i].Images {
+			cost += image.Tokens
+		}
+		if i != len(msg)-1 && cost > remaining {
+			// 保证附带的历史图片是连续的最近几轮
+			break
+		}
+		attach[i] = true
+		remaining -= cost
+	}
+
+	result := make([]VisionMessages, 0, len(msg))
+	var attached []VisionImage
+	for i, m := range msg {
+		if len(m.Images) == 0 {
+			result = append(result, VisionMessages{Role: m.Role, Content: m.Content})
+			continue
+		}
+		content := []ContentType{{Type: "text", Text: m.Content}}
+		omitted := 0
+		for _, ref := range m.Images {
+			if !attach[i] {
+				omitted++
+				continue
+			}
+			image, err := load(ref)
+			if err != nil {
+				if i == len(msg)-1 {
+					return nil, nil, err
+				}
+				omitted++
+				continue
+			}
+			attached = append(attached, image)
+			content = append(content, ContentType{
+				Type:     "image_url",
+				ImageURL: &ImageURL{URL: image.URL, Detail: ref.Detail},
+			})
+		}
+		if omitted > 0 {
+			content[0].Text += fmt.Sprintf("\n[此处的 %d 张图片已省略]", omitted)
+		}
+		result = append(result, VisionMessages{Role: m.Role, Content: content})
+	}
+	return result, attached, nil
+}
@@ -0,0 +1,75 @@
+package openai
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestBuildVisionMessages(t *testing.T) {
+	ref := func(key string, tokens int) []ImageAttachment {
+		return []ImageAttachment{{MsgId: "om_" + key, ImageKey: key,
+			Detail: "high", Tokens: tokens}}
+	}
+	msg := []Messages{
+		{Role: "system", Content: "system"},
+		{Role: "user", Content: "first", Images: ref("old", 800)},
+		{Role: "assistant", Content: "a cat"},
+		{Role: "user", Content: "second", Images: ref("recent", 800)},
+		{Role: "assistant", Content: "a dog"},
+		{Role: "user", Content: "compare them", Images: ref("broken", 0)},
+	}
+	var loaded []string
+	load := func(image ImageAttachment) (VisionImage, error) {
+		loaded = append(loaded, image.ImageKey)
+		if image.ImageKey == "broken" {
+			return VisionImage{}, errors.New("download failed")
+		}
+		return VisionImage{URL: "data:" + image.ImageKey, Tokens: image.Tokens}, nil
+	}
+
+	// 最后一条消息的图片获取失败时返回错误
+	if _, _, err := BuildVisionMessages(msg, 1000, load); err == nil {
+		t.Fatal("expected error for the current image")
+	}
+
+	msg[len(msg)-1].Images = nil
+	loaded = nil
+	result, attached, err := BuildVisionMessages(msg, 1000, load)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result) != len(msg) {
+		t.Fatalf("len = %d, want %d", len(result), len(msg))
+	}
+	// 预算只够最近一轮的图片
+	if len(attached) != 1 || attached[0].URL != "data:recent" {
+		t.Errorf("attached = %v, want only the recent image", attached)
+	}
+	if strings.Join(loaded, ",") != "recent" {
+		t.Errorf("loaded = %v, want only the recent image", loaded)
+	}
+	if content, ok := result[0].Content.(string); !ok || content != "system" {
+		t.Errorf("system content = %v, want plain text", result[0].Content)
+	}
+	old := result[1].Content.([]ContentType)
+	if len(old) != 1 || !strings.Contains(old[0].Text, "1 张图片已省略") {
+		t.Errorf("old turn = %+v, want text with omission note", old)
+	}
+	recent := result[3].Content.([]ContentType)
+	if len(recent) != 2 || recent[1].ImageURL.URL != "data:recent" ||
+		recent[1].ImageURL.Detail != "high" {
+		t.Errorf("recent turn = %+v, want text and image", recent)
+	}
+
+	_, attached, err = BuildVisionMessages(msg, 2000, load)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(attached) != 2 {
+		t.Errorf("attached %d images, want 2 within budget", len(attached))
+	}
+	if !HasImages(msg) || HasImages(msg[:1]) {
+		t.Error("HasImages returned wrong result")
+	}
+}
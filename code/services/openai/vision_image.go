@@ -0,0 +1,142 @@
+package openai
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+
+	"start-feishubot/utils/imaging"
+)
+
+const (
+	// VisionDetailLowTokens detail 为 low 时每张图片固定消耗的 token
+	VisionDetailLowTokens = 85
+	visionTileTokens      = 170
+	visionTileSize        = 512
+	// high 模式下图片先缩放到 2048x2048 以内，再把短边缩放到 768
+	visionMaxSide   = 2048
+	visionShortSide = 768
+	// low 模式下模型只看 512x512 的缩略图
+	visionLowSide = 512
+	// visionMaxBytes 接口接受的单张图片大小上限
+	visionMaxBytes = 20 << 20
+)
+
+// VisionImage 处理后可以直接放入请求的图片
+type VisionImage struct {
+	URL    string
+	Width  int
+	Height int
+	// Tokens 按 OpenAI 的计费规则估算的图片 token 数
+	Tokens int
+}
+
+// VisionImageSize 返回模型实际处理图片时使用的尺寸，更大的图片只会浪费流量
+func VisionImageSize(width, height int, detail string) (int, int) {
+	maxSide := visionMaxSide
+	if detail == "low" {
+		maxSide = visionLowSide
+	}
+	w, h := float64(width), float64(height)
+	if w > float64(maxSide) || h > float64(maxSide) {
+		scale := float64(maxSide) / maxFloat(w, h)
+		w, h = w*scale, h*scale
+	}
+	if detail != "low" && minFloat(w, h) > visionShortSide {
+		scale := visionShortSide / minFloat(w, h)
+		w, h = w*scale, h*scale
+	}
+	return roundSize(w), roundSize(h)
+}
+
+// EstimateImageTokens 估算一张图片的 token 消耗: low 固定 85，
+// 其余按缩放后覆盖的 512x512 图块数计算，每块 170 再加 85
+func EstimateImageTokens(width, height int, detail string) int {
+	if detail == "low" {
+		return VisionDetailLowTokens
+	}
+	w, h := VisionImageSize(width, height, detail)
+	tiles := ((w + visionTileSize - 1) / visionTileSize) *
+		((h + visionTileSize - 1) / visionTileSize)
+	return VisionDetailLowTokens + tiles*visionTileTokens
+}
+
+// PrepareVisionImage 根据文件内容识别图片类型，按 detail 缩放后编码为 data URL。
+// WebP 无法在本地解码，会按原图发送
+func PrepareVisionImage(data []byte, detail string) (VisionImage, error) {
+	mime := http.DetectContentType(data)
+	switch mime {
+	case "image/jpeg", "image/png", "image/gif":
+	case "image/webp":
+		w, h, err := imaging.WebPSize(data)
+		if err != nil {
+			return VisionImage{}, err
+		}
+		if len(data) > visionMaxBytes {
+			return VisionImage{}, fmt.Errorf("图片需要小于 %d MB",
+				visionMaxBytes>>20)
+		}
+		return newVisionImage(data, mime, w, h, detail), nil
+	default:
+		return VisionImage{}, fmt.Errorf("不支持的图片格式: %s", mime)
+	}
+
+	img, _, err := imaging.Decode(data)
+	if err != nil {
+		return VisionImage{}, err
+	}
+	b := img.Bounds()
+	w, h := VisionImageSize(b.Dx(), b.Dy(), detail)
+	// 尺寸合适、方向正确的 jpeg 和 png 原样发送，避免重新编码损失画质
+	if w == b.Dx() && h == b.Dy() && mime != "image/gif" &&
+		imaging.ReadOrientation(data) == imaging.OrientationNormal &&
+		len(data) <= visionMaxBytes {
+		return newVisionImage(data, mime, w, h, detail), nil
+	}
+
+	opts := imaging.Options{Format: imaging.FormatPNG, MaxBytes: visionMaxBytes}
+	if mime == "image/jpeg" {
+		opts.Format = imaging.FormatJPEG
+		opts.JPEGQuality = 90
+	}
+	out, err := imaging.Encode(imaging.Resize(img, w, h), opts)
+	if err != nil {
+		return VisionImage{}, err
+	}
+	if len(out) > visionMaxBytes {
+		return VisionImage{}, fmt.Errorf("图片需要小于 %d MB", visionMaxBytes>>20)
+	}
+	return newVisionImage(out, "image/"+string(opts.Format), w, h, detail), nil
+}
+
+func newVisionImage(data []byte, mime string, w, h int,
+	detail string) VisionImage {
+	return VisionImage{
+		URL:    "data:" + mime + ";base64," + base64.StdEncoding.EncodeToString(data),
+		Width:  w,
+		Height: h,
+		Tokens: EstimateImageTokens(w, h, detail),
+	}
+}
+
+func roundSize(v float64) int {
+	n := int(v + 0.5)
+	if n < 1 {
+		return 1
+	}
+	return n
+}
+
+func maxFloat(a, b float64) float64 {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func minFloat(a, b float64) float64 {
+	if a < b {
+		return a
+	}
+	return b
+}
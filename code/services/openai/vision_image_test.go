@@ -0,0 +1,96 @@
+package openai
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image"
+	"image/jpeg"
+	"strings"
+	"testing"
+)
+
+func TestEstimateImageTokens(t *testing.T) {
+	tests := []struct {
+		width, height int
+		detail        string
+		wantW, wantH  int
+		wantTokens    int
+	}{
+		// OpenAI 文档中的示例
+		{1024, 1024, "high", 768, 768, 765},
+		{2048, 4096, "high", 768, 1536, 1105},
+		{4096, 8192, "low", 256, 512, 85},
+		{512, 256, "high", 512, 256, 255},
+		{100, 100, "", 100, 100, 255},
+	}
+	for _, tt := range tests {
+		w, h := VisionImageSize(tt.width, tt.height, tt.detail)
+		if w != tt.wantW || h != tt.wantH {
+			t.Errorf("VisionImageSize(%d, %d, %q) = %dx%d, want %dx%d",
+				tt.width, tt.height, tt.detail, w, h, tt.wantW, tt.wantH)
+		}
+		if got := EstimateImageTokens(tt.width, tt.height, tt.detail); got != tt.wantTokens {
+			t.Errorf("EstimateImageTokens(%d, %d, %q) = %d, want %d",
+				tt.width, tt.height, tt.detail, got, tt.wantTokens)
+		}
+	}
+}
+
+func decodeDataURL(t *testing.T, url string) (string, []byte) {
+	mime, data, ok := strings.Cut(strings.TrimPrefix(url, "data:"), ";base64,")
+	if !ok {
+		t.Fatalf("invalid data url %.40s", url)
+	}
+	raw, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return mime, raw
+}
+
+func TestPrepareVisionImage(t *testing.T) {
+	// 小图片原样发送，类型按内容识别
+	small := testPNG(t, 10, 10)
+	prepared, err := PrepareVisionImage(small, "high")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mime, raw := decodeDataURL(t, prepared.URL)
+	if mime != "image/png" || !bytes.Equal(raw, small) {
+		t.Errorf("small png: mime = %s, unchanged = %v", mime, bytes.Equal(raw, small))
+	}
+
+	// 大图片按 detail 缩小后重新编码，保持原格式
+	var buf bytes.Buffer
+	photo := image.NewGray(image.Rect(0, 0, 1600, 1200))
+	if err := jpeg.Encode(&buf, photo, nil); err != nil {
+		t.Fatal(err)
+	}
+	for detail, want := range map[string]image.Point{
+		"high": {1024, 768},
+		"low":  {512, 384},
+	} {
+		prepared, err := PrepareVisionImage(buf.Bytes(), detail)
+		if err != nil {
+			t.Fatal(err)
+		}
+		mime, raw := decodeDataURL(t, prepared.URL)
+		config, format, err := image.DecodeConfig(bytes.NewReader(raw))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if mime != "image/jpeg" || format != "jpeg" ||
+			config.Width != want.X || config.Height != want.Y {
+			t.Errorf("%s: got %s %dx%d, want image/jpeg %dx%d", detail, mime,
+				config.Width, config.Height, want.X, want.Y)
+		}
+		if prepared.Width != want.X || prepared.Height != want.Y {
+			t.Errorf("%s: reported size %dx%d", detail, prepared.Width,
+				prepared.Height)
+		}
+	}
+
+	if _, err := PrepareVisionImage([]byte("%PDF-1.4"), "high"); err == nil {
+		t.Error("expected error for non-image data")
+	}
+}
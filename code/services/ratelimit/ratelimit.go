@@ -0,0 +1,193 @@
+package ratelimit
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Scope string
+
+const (
+	ScopeUser   Scope = "user"
+	ScopeChat   Scope = "chat"
+	ScopeGlobal Scope = "global"
+)
+
+// sweepInterval 清理已经补满的令牌桶的间隔
+const sweepInterval = 10 * time.Minute
+
+// Rate 每个 Period 补充 Limit 个令牌，桶的容量同样为 Limit，Limit 为 0 表示不限制
+type Rate struct {
+	Limit  int
+	Period time.Duration
+}
+
+func (r Rate) Enabled() bool {
+	return r.Limit > 0 && r.Period > 0
+}
+
+// ParseRate 解析形如 10/1m、100/1h 的速率，空字符串或 0 表示不限制
+func ParseRate(s string) (Rate, error) {
+	s = strings.TrimSpace(s)
+	if s == "" || s == "0" {
+		return Rate{}, nil
+	}
+	limit, period, found := strings.Cut(s, "/")
+	if !found {
+		return Rate{}, fmt.Errorf("invalid rate %q, want count/period such as 10/1m", s)
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(limit))
+	if err != nil || n < 0 {
+		return Rate{}, fmt.Errorf("invalid count in rate %q", s)
+	}
+	d, err := time.ParseDuration(strings.TrimSpace(period))
+	if err != nil || d <= 0 {
+		return Rate{}, fmt.Errorf("invalid period in rate %q", s)
+	}
+	return Rate{Limit: n, Period: d}, nil
+}
+
+type Config struct {
+	User   Rate
+	Chat   Rate
+	Global Rate
+}
+
+// Denied 描述被触发的速率限制
+type Denied struct {
+	Scope   Scope
+	Rate    Rate
+	RetryAt time.Time
+}
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// refill 按经过的时间补充令牌，最多补满
+func (b *bucket) refill(rate Rate, now time.Time) {
+	elapsed := now.Sub(b.updated)
+	if elapsed <= 0 {
+		return
+	}
+	b.tokens = math.Min(float64(rate.Limit),
+		b.tokens+elapsed.Seconds()*float64(rate.Limit)/rate.Period.Seconds())
+	b.updated = now
+}
+
+// wait 距离桶中有一个令牌还需要的时间
+func (b *bucket) wait(rate Rate) time.Duration {
+	missing := 1 - b.tokens
+	if missing <= 0 {
+		return 0
+	}
+	return time.Duration(math.Ceil(missing * float64(rate.Period) / float64(rate.Limit)))
+}
+
+// Limiter 按用户、会话和全局的令牌桶限流
+type Limiter struct {
+	mu        sync.Mutex
+	config    Config
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+func NewLimiter(config Config) *Limiter {
+	return &Limiter{config: config, buckets: make(map[string]*bucket)}
+}
+
+func (l *Limiter) Config() Config {
+	return l.config
+}
+
+type check struct {
+	scope Scope
+	key   string
+	rate  Rate
+}
+
+// Allow 判断一次请求是否允许，允许时从每个桶中各取一个令牌；
+// 任何一个桶没有令牌时都不消耗令牌，并返回最晚可以重试的限制。
+// 会话限制只对群聊生效，私聊与个人限制相同
+func (l *Limiter) Allow(userId, chatId string, isGroup bool,
+	now time.Time) *Denied {
+	checks := []check{{ScopeGlobal, "", l.config.Global}}
+	if isGroup {
+		checks = append(checks, check{ScopeChat, chatId, l.config.Chat})
+	}
+	checks = append(checks, check{ScopeUser, userId, l.config.User})
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.sweep(now)
+	var denied *Denied
+	var taken []*bucket
+	for _, c := range checks {
+		if !c.rate.Enabled() {
+			continue
+		}
+		b := l.bucket(c, now)
+		b.refill(c.rate, now)
+		if b.tokens >= 1 {
+			taken = append(taken, b)
+			continue
+		}
+		retryAt := now.Add(b.wait(c.rate))
+		if denied == nil || retryAt.After(denied.RetryAt) {
+			denied = &Denied{Scope: c.scope, Rate: c.rate, RetryAt: retryAt}
+		}
+	}
+	if denied != nil {
+		return denied
+	}
+	for _, b := range taken {
+		b.tokens--
+	}
+	return nil
+}
+
+func (l *Limiter) bucket(c check, now time.Time) *bucket {
+	key := string(c.scope) + ":" + c.key
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(c.rate.Limit), updated: now}
+		l.buckets[key] = b
+	}
+	return b
+}
+
+// sweep 删除已经补满的桶，补满的桶与新建的桶没有区别
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < sweepInterval {
+		return
+	}
+	l.lastSweep = now
+	for key, b := range l.buckets {
+		scope, _, _ := strings.Cut(key, ":")
+		rate := l.rate(Scope(scope))
+		if !rate.Enabled() {
+			delete(l.buckets, key)
+			continue
+		}
+		b.refill(rate, now)
+		if b.tokens >= float64(rate.Limit) {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+func (l *Limiter) rate(scope Scope) Rate {
+	switch scope {
+	case ScopeUser:
+		return l.config.User
+	case ScopeChat:
+		return l.config.Chat
+	default:
+		return l.config.Global
+	}
+}
@@ -0,0 +1,95 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseRate(t *testing.T) {
+	tests := []struct {
+		input string
+		want  Rate
+		err   bool
+	}{
+		{"", Rate{}, false},
+		{"0", Rate{}, false},
+		{"10/1m", Rate{Limit: 10, Period: time.Minute}, false},
+		{" 100 / 1h ", Rate{Limit: 100, Period: time.Hour}, false},
+		{"10", Rate{}, true},
+		{"x/1m", Rate{}, true},
+		{"10/soon", Rate{}, true},
+		{"10/-1s", Rate{}, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseRate(tt.input)
+		if (err != nil) != tt.err || got != tt.want {
+			t.Errorf("ParseRate(%q) = %v, %v", tt.input, got, err)
+		}
+	}
+}
+
+func TestLimiterAllow(t *testing.T) {
+	now := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	l := NewLimiter(Config{
+		User: Rate{Limit: 2, Period: time.Minute},
+		Chat: Rate{Limit: 3, Period: time.Minute},
+	})
+	for i := 0; i < 2; i++ {
+		if d := l.Allow("u1", "oc_a", true, now); d != nil {
+			t.Fatalf("request %d denied: %+v", i, d)
+		}
+	}
+	d := l.Allow("u1", "oc_a", true, now)
+	if d == nil || d.Scope != ScopeUser || !d.RetryAt.Equal(now.Add(30*time.Second)) {
+		t.Fatalf("expected user limit, got %+v", d)
+	}
+
+	// 被拒绝的请求不消耗会话的令牌
+	if d := l.Allow("u2", "oc_a", true, now); d != nil {
+		t.Fatalf("u2 denied: %+v", d)
+	}
+	d = l.Allow("u3", "oc_a", true, now)
+	if d == nil || d.Scope != ScopeChat {
+		t.Fatalf("expected chat limit, got %+v", d)
+	}
+
+	// 私聊不受会话限制
+	if d := l.Allow("u3", "oc_p2p", false, now); d != nil {
+		t.Fatalf("private chat denied: %+v", d)
+	}
+
+	// 30 秒后补充一个令牌
+	if d := l.Allow("u1", "oc_b", true, now.Add(30*time.Second)); d != nil {
+		t.Fatalf("refilled request denied: %+v", d)
+	}
+	if d := l.Allow("u1", "oc_b", true, now.Add(30*time.Second)); d == nil {
+		t.Fatal("expected bucket to be empty again")
+	}
+}
+
+func TestLimiterRetryAtLatest(t *testing.T) {
+	now := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	l := NewLimiter(Config{
+		User:   Rate{Limit: 1, Period: time.Minute},
+		Global: Rate{Limit: 1, Period: time.Hour},
+	})
+	if d := l.Allow("u1", "oc_a", false, now); d != nil {
+		t.Fatalf("first request denied: %+v", d)
+	}
+	d := l.Allow("u1", "oc_a", false, now)
+	if d == nil || d.Scope != ScopeGlobal || !d.RetryAt.Equal(now.Add(time.Hour)) {
+		t.Fatalf("expected global limit with latest retry, got %+v", d)
+	}
+}
+
+func TestLimiterSweep(t *testing.T) {
+	now := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	l := NewLimiter(Config{User: Rate{Limit: 5, Period: time.Minute}})
+	for _, user := range []string{"u1", "u2", "u3"} {
+		l.Allow(user, "", false, now)
+	}
+	l.Allow("u4", "", false, now.Add(sweepInterval))
+	if len(l.buckets) != 1 {
+		t.Fatalf("expected refilled buckets to be swept, got %d", len(l.buckets))
+	}
+}
@@ -15,6 +15,21 @@ type SessionService struct {
 type PicSetting struct {
 	resolution Resolution
 	style      PicStyle
+	model      string
+	count      int
+}
+
+// ImageRef 飞书消息中的一张图片，通过 MsgId 和 ImageKey 重新下载，
+// MsgId 为空时表示机器人自己上传的图片
+type ImageRef struct {
+	MsgId    string `json:"msg_id"`
+	ImageKey string `json:"image_key"`
+}
+
+// Document 会话中用于问答的文档，保存切分后的文本片段
+type Document struct {
+	Name   string   `json:"name"`
+	Chunks []string `json:"chunks"`
 }
 type Resolution string
 type PicStyle string
@@ -25,6 +40,12 @@ type SessionMeta struct {
 	PicSetting   PicSetting        `json:"pic_setting,omitempty"`
 	AIMode       openai.AIMode     `json:"ai_mode,omitempty"`
 	VisionDetail VisionDetail      `json:"vision_detail,omitempty"`
+	// Voice 语音回复使用的音色，为空时只回复文字
+	Voice string `json:"voice,omitempty"`
+	// PicEditImages 图片创作模式下等待编辑指令的图片
+	PicEditImages []ImageRef `json:"pic_edit_images,omitempty"`
+	// Document 用户上传的文档，后续提问会基于文档内容回答
+	Document *Document `json:"document,omitempty"`
 }
 
 const (
@@ -38,6 +59,10 @@ const (
 	PicStyleVivid   PicStyle = "vivid"
 	PicStyleNatural PicStyle = "natural"
 )
+
+// MaxPicCount 单次最多生成的图片数
+const MaxPicCount = 4
+
 const (
 	VisionDetailHigh VisionDetail = "high"
 	VisionDetailLow  VisionDetail = "low"
@@ -62,8 +87,18 @@ type SessionServiceCacheInterface interface {
 	GetPicResolution(sessionId string) string
 	SetPicStyle(sessionId string, resolution PicStyle)
 	GetPicStyle(sessionId string) string
+	SetPicModel(sessionId string, model string)
+	GetPicModel(sessionId string) string
+	SetPicCount(sessionId string, count int)
+	GetPicCount(sessionId string) int
+	SetPicEditImages(sessionId string, images []ImageRef)
+	GetPicEditImages(sessionId string) []ImageRef
 	SetVisionDetail(sessionId string, visionDetail VisionDetail)
 	GetVisionDetail(sessionId string) string
+	SetVoice(sessionId string, voice string)
+	GetVoice(sessionId string) string
+	SetDocument(sessionId string, document *Document)
+	GetDocument(sessionId string) *Document
 	Clear(sessionId string)
 }
 
@@ -189,6 +224,88 @@ func (s *SessionService) GetPicStyle(sessionId string) string {
 	return string(sessionMeta.PicSetting.style)
 }
 
+func (s *SessionService) SetPicModel(sessionId string, model string) {
+	maxCacheTime := time.Hour * 12
+	sessionContext, ok := s.cache.Get(sessionId)
+	if !ok {
+		sessionMeta := &SessionMeta{PicSetting: PicSetting{model: model}}
+		s.cache.Set(sessionId, sessionMeta, maxCacheTime)
+		return
+	}
+	sessionMeta := sessionContext.(*SessionMeta)
+	sessionMeta.PicSetting.model = model
+	s.cache.Set(sessionId, sessionMeta, maxCacheTime)
+}
+
+// GetPicModel 返回图片创作使用的模型，默认为 dall-e-3
+func (s *SessionService) GetPicModel(sessionId string) string {
+	sessionContext, ok := s.cache.Get(sessionId)
+	if !ok {
+		return openai.ImageModelDallE3
+	}
+	sessionMeta := sessionContext.(*SessionMeta)
+	if sessionMeta.PicSetting.model == "" {
+		return openai.ImageModelDallE3
+	}
+	return sessionMeta.PicSetting.model
+}
+
+// SetPicCount 设置单次生成的图片数，超出范围时取最接近的有效值
+func (s *SessionService) SetPicCount(sessionId string, count int) {
+	if count < 1 {
+		count = 1
+	}
+	if count > MaxPicCount {
+		count = MaxPicCount
+	}
+	maxCacheTime := time.Hour * 12
+	sessionContext, ok := s.cache.Get(sessionId)
+	if !ok {
+		sessionMeta := &SessionMeta{PicSetting: PicSetting{count: count}}
+		s.cache.Set(sessionId, sessionMeta, maxCacheTime)
+		return
+	}
+	sessionMeta := sessionContext.(*SessionMeta)
+	sessionMeta.PicSetting.count = count
+	s.cache.Set(sessionId, sessionMeta, maxCacheTime)
+}
+
+// GetPicCount 返回单次生成的图片数，默认为 1
+func (s *SessionService) GetPicCount(sessionId string) int {
+	sessionContext, ok := s.cache.Get(sessionId)
+	if !ok {
+		return 1
+	}
+	sessionMeta := sessionContext.(*SessionMeta)
+	if sessionMeta.PicSetting.count < 1 {
+		return 1
+	}
+	return sessionMeta.PicSetting.count
+}
+
+func (s *SessionService) SetPicEditImages(sessionId string,
+	images []ImageRef) {
+	maxCacheTime := time.Hour * 12
+	sessionContext, ok := s.cache.Get(sessionId)
+	if !ok {
+		sessionMeta := &SessionMeta{PicEditImages: images}
+		s.cache.Set(sessionId, sessionMeta, maxCacheTime)
+		return
+	}
+	sessionMeta := sessionContext.(*SessionMeta)
+	sessionMeta.PicEditImages = images
+	s.cache.Set(sessionId, sessionMeta, maxCacheTime)
+}
+
+func (s *SessionService) GetPicEditImages(sessionId string) []ImageRef {
+	sessionContext, ok := s.cache.Get(sessionId)
+	if !ok {
+		return nil
+	}
+	sessionMeta := sessionContext.(*SessionMeta)
+	return sessionMeta.PicEditImages
+}
+
 func (s *SessionService) SetPicResolution(sessionId string,
 	resolution Resolution) {
 	maxCacheTime := time.Hour * 12
@@ -250,6 +367,52 @@ func (s *SessionService) SetVisionDetail(sessionId string,
 	s.cache.Set(sessionId, sessionMeta, maxCacheTime)
 }
 
+func (s *SessionService) GetVoice(sessionId string) string {
+	sessionContext, ok := s.cache.Get(sessionId)
+	if !ok {
+		return ""
+	}
+	sessionMeta := sessionContext.(*SessionMeta)
+	return sessionMeta.Voice
+}
+
+// SetVoice 设置语音回复的音色，传入空字符串关闭语音回复
+func (s *SessionService) SetVoice(sessionId string, voice string) {
+	maxCacheTime := time.Hour * 12
+	sessionContext, ok := s.cache.Get(sessionId)
+	if !ok {
+		sessionMeta := &SessionMeta{Voice: voice}
+		s.cache.Set(sessionId, sessionMeta, maxCacheTime)
+		return
+	}
+	sessionMeta := sessionContext.(*SessionMeta)
+	sessionMeta.Voice = voice
+	s.cache.Set(sessionId, sessionMeta, maxCacheTime)
+}
+
+// SetDocument 设置会话中的文档，传入 nil 清除
+func (s *SessionService) SetDocument(sessionId string, document *Document) {
+	maxCacheTime := time.Hour * 12
+	sessionContext, ok := s.cache.Get(sessionId)
+	if !ok {
+		sessionMeta := &SessionMeta{Document: document}
+		s.cache.Set(sessionId, sessionMeta, maxCacheTime)
+		return
+	}
+	sessionMeta := sessionContext.(*SessionMeta)
+	sessionMeta.Document = document
+	s.cache.Set(sessionId, sessionMeta, maxCacheTime)
+}
+
+func (s *SessionService) GetDocument(sessionId string) *Document {
+	sessionContext, ok := s.cache.Get(sessionId)
+	if !ok {
+		return nil
+	}
+	sessionMeta := sessionContext.(*SessionMeta)
+	return sessionMeta.Document
+}
+
 func GetSessionCache() SessionServiceCacheInterface {
 	if sessionServices == nil {
 		sessionServices = &SessionService{cache: cache.New(time.Hour*12, time.Hour*1)}
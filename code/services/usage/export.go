@@ -0,0 +1,91 @@
+package usage
+
+import (
+	"encoding/csv"
+	"io"
+	"strconv"
+	"time"
+)
+
+// Report 一段时间内的用量报表
+type Report struct {
+	Since  time.Time
+	Until  time.Time
+	Total  Total
+	Users  []Ranked
+	Groups []Ranked
+	Models []Ranked
+	// Daily 按天汇总，Key 为 2006-01-02 格式的日期，没有用量的日期也会出现
+	Daily []Ranked
+}
+
+func BuildReport(store StoreInterface, since, until time.Time) Report {
+	records := store.Query(Filter{Since: since, Until: until})
+	report := Report{
+		Since:  since,
+		Until:  until,
+		Total:  Sum(records),
+		Users:  GroupBy(records, ByUser),
+		Groups: GroupBy(groupRecords(records), ByChat),
+		Models: GroupBy(records, ByModel),
+	}
+
+	days := make(map[string]*Total)
+	for day := DayStart(since); day.Before(until); day = day.AddDate(0, 0, 1) {
+		key := day.Format("2006-01-02")
+		days[key] = &Total{}
+		report.Daily = append(report.Daily, Ranked{Key: key})
+	}
+	for _, r := range records {
+		if t, ok := days[r.Time.In(since.Location()).Format("2006-01-02")]; ok {
+			t.Add(r)
+		}
+	}
+	for i := range report.Daily {
+		report.Daily[i].Total = *days[report.Daily[i].Key]
+	}
+	return report
+}
+
+// ChatTypeGroup 群聊记录的 ChatType
+const ChatTypeGroup = "group"
+
+func groupRecords(records []Record) []Record {
+	var result []Record
+	for _, r := range records {
+		if r.ChatType == ChatTypeGroup {
+			result = append(result, r)
+		}
+	}
+	return result
+}
+
+var csvHeader = []string{"time", "user_id", "chat_id", "chat_type", "kind",
+	"model", "prompt_tokens", "completion_tokens", "images", "cost"}
+
+// WriteCSV 将用量记录导出为 CSV
+func WriteCSV(w io.Writer, records []Record) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(csvHeader); err != nil {
+		return err
+	}
+	for _, r := range records {
+		row := []string{
+			r.Time.Format(time.RFC3339),
+			r.UserId,
+			r.ChatId,
+			r.ChatType,
+			string(r.Kind),
+			r.Model,
+			strconv.Itoa(r.PromptTokens),
+			strconv.Itoa(r.CompletionTokens),
+			strconv.Itoa(r.Images),
+			strconv.FormatFloat(r.Cost, 'f', 6, 64),
+		}
+		if err := writer.Write(row); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
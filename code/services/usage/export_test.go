@@ -0,0 +1,95 @@
+package usage
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestBuildReport(t *testing.T) {
+	since := time.Date(2024, 5, 13, 0, 0, 0, 0, time.Local)
+	until := since.AddDate(0, 0, 3)
+	s := newStore("", time.Local)
+	for _, r := range []Record{
+		// 统计范围之外
+		{Time: since.Add(-time.Second), UserId: "u1", ChatId: "oc_a", PromptTokens: 1000},
+		{Time: until, UserId: "u1", ChatId: "oc_a", PromptTokens: 1000},
+
+		{Time: since, UserId: "u1", ChatId: "oc_a", ChatType: ChatTypeGroup,
+			Model: "gpt-4o", PromptTokens: 10, Cost: 0.5},
+		{Time: since.Add(26 * time.Hour), UserId: "u2", ChatId: "oc_p2p", ChatType: "p2p",
+			Model: "gpt-4o-mini", PromptTokens: 20, Cost: 0.1},
+		{Time: until.Add(-time.Second), UserId: "u2", ChatId: "oc_a", ChatType: ChatTypeGroup,
+			Model: "gpt-4o", CompletionTokens: 30, Cost: 0.2},
+	} {
+		s.insert(r)
+	}
+
+	report := BuildReport(s, since, until)
+	if report.Total.Requests != 3 || report.Total.Tokens != 60 {
+		t.Errorf("Total = %+v", report.Total)
+	}
+	if len(report.Users) != 2 || report.Users[0].Key != "u1" {
+		t.Errorf("Users = %+v", report.Users)
+	}
+	// 只统计群聊
+	if len(report.Groups) != 1 || report.Groups[0].Key != "oc_a" ||
+		report.Groups[0].Total.Tokens != 40 {
+		t.Errorf("Groups = %+v", report.Groups)
+	}
+	if len(report.Models) != 2 || report.Models[0].Key != "gpt-4o" {
+		t.Errorf("Models = %+v", report.Models)
+	}
+
+	// 没有用量的日期也有一项
+	wantDaily := []struct {
+		key    string
+		tokens int
+	}{
+		{"2024-05-13", 10},
+		{"2024-05-14", 20},
+		{"2024-05-15", 30},
+	}
+	if len(report.Daily) != len(wantDaily) {
+		t.Fatalf("Daily = %+v", report.Daily)
+	}
+	for i, want := range wantDaily {
+		if report.Daily[i].Key != want.key || report.Daily[i].Total.Tokens != want.tokens {
+			t.Errorf("Daily[%d] = %+v, want %s %d", i, report.Daily[i], want.key, want.tokens)
+		}
+	}
+
+	empty := BuildReport(newStore("", time.Local), since, since.AddDate(0, 0, 2))
+	if empty.Total != (Total{}) || len(empty.Daily) != 2 || len(empty.Users) != 0 {
+		t.Errorf("empty report = %+v", empty)
+	}
+}
+
+func TestWriteCSV(t *testing.T) {
+	now := time.Date(2024, 5, 15, 9, 30, 0, 0, time.UTC)
+	records := []Record{
+		{Time: now, UserId: "u1", ChatId: "oc_a", ChatType: ChatTypeGroup, Kind: KindChat,
+			Model: "gpt-4o", PromptTokens: 10, CompletionTokens: 5, Cost: 0.0125},
+		// 包含逗号和引号的字段需要转义
+		{Time: now, UserId: "u2", ChatId: "oc_b", Kind: KindImage,
+			Model: `my "model", v2`, Images: 1, Cost: 0.04},
+	}
+	tests := []struct {
+		records []Record
+		want    string
+	}{
+		{nil, "time,user_id,chat_id,chat_type,kind,model,prompt_tokens,completion_tokens,images,cost\n"},
+		{records, "time,user_id,chat_id,chat_type,kind,model,prompt_tokens,completion_tokens,images,cost\n" +
+			"2024-05-15T09:30:00Z,u1,oc_a,group,chat,gpt-4o,10,5,0,0.012500\n" +
+			`2024-05-15T09:30:00Z,u2,oc_b,,image,"my ""model"", v2",0,0,1,0.040000` + "\n"},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		if err := WriteCSV(&buf, tt.records); err != nil {
+			t.Fatal(err)
+		}
+		if got := buf.String(); got != tt.want {
+			t.Errorf("WriteCSV() = %q, want %q", got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package usage
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Price 每百万 token 的美元价格，按张计费的图片模型使用 Image，
+// 按时长计费的语音转文字模型使用 Minute
+type Price struct {
+	Input  float64
+	Output float64
+	// Image 每张图片的美元价格
+	Image float64
+	// Minute 每分钟音频的美元价格
+	Minute float64
+}
+
+var DefaultPrices = map[string]Price{
+	"gpt-3.5-turbo":        {Input: 0.5, Output: 1.5},
+	"gpt-4":                {Input: 30, Output: 60},
+	"gpt-4-32k":            {Input: 60, Output: 120},
+	"gpt-4-1106-preview":   {Input: 10, Output: 30},
+	"gpt-4-vision-preview": {Input: 10, Output: 30},
+	"gpt-4o":               {Input: 2.5, Output: 10},
+	"gpt-4o-mini":          {Input: 0.15, Output: 0.6},
+	"chatgpt-4o-latest":    {Input: 5, Output: 15},
+	"o4-mini":              {Input: 1.1, Output: 4.4},
+	"gpt-image-1":          {Input: 10, Output: 40},
+	// dall-e 按张计费，尺寸拼接在模型名后，未列出的尺寸使用模型的价格
+	"dall-e-2":           {Image: 0.02},
+	"dall-e-2-512x512":   {Image: 0.018},
+	"dall-e-2-256x256":   {Image: 0.016},
+	"dall-e-3":           {Image: 0.04},
+	"dall-e-3-1024x1792": {Image: 0.08},
+	"dall-e-3-1792x1024": {Image: 0.08},
+	// 语音合成按每百万字符计费
+	"tts-1":    {Input: 15},
+	"tts-1-hd": {Input: 30},
+	// 语音转文字按音频时长计费
+	"whisper-1":              {Minute: 0.006},
+	"gpt-4o-transcribe":      {Minute: 0.006},
+	"gpt-4o-mini-transcribe": {Minute: 0.003},
+	// 向量模型只按输入计费
+	"text-embedding-3-small": {Input: 0.02},
+	"text-embedding-3-large": {Input: 0.13},
+	"text-embedding-ada-002": {Input: 0.1},
+}
+
+// PriceOf 查找模型价格，接口返回的模型名通常带日期后缀（如 gpt-4o-2024-08-06），
+// 因此按最长前缀匹配
+func PriceOf(prices map[string]Price, model string) (Price, bool) {
+	var found string
+	for name := range prices {
+		if strings.HasPrefix(model, name) && len(name) > len(found) {
+			found = name
+		}
+	}
+	if found == "" {
+		return Price{}, false
+	}
+	return prices[found], true
+}
+
+// Cost 计算一次调用的美元花费，未知模型按 0 计
+func Cost(prices map[string]Price, model string, promptTokens, completionTokens int) float64 {
+	price, ok := PriceOf(prices, model)
+	if !ok {
+		return 0
+	}
+	return (float64(promptTokens)*price.Input + float64(completionTokens)*price.Output) / 1e6
+}
+
+// ImageCost 计算按张计费的图片花费，未知模型按 0 计
+func ImageCost(prices map[string]Price, model string, size string, n int) float64 {
+	price, ok := PriceOf(prices, model+"-"+size)
+	if !ok {
+		return 0
+	}
+	return price.Image * float64(n)
+}
+
+// AudioCost 计算按时长计费的语音转文字花费，未知模型按 0 计
+func AudioCost(prices map[string]Price, model string, seconds float64) float64 {
+	price, ok := PriceOf(prices, model)
+	if !ok {
+		return 0
+	}
+	return price.Minute * seconds / 60
+}
+
+// ParsePrices 解析价格配置并覆盖到默认价格表上，支持三种格式:
+//
+//	gpt-4o=2.5/10            每百万输入/输出 token 的美元价格，只填一个时输入输出相同
+//	dall-e-3=image:0.04      每张图片的美元价格
+//	whisper-1=minute:0.006   每分钟音频的美元价格
+//
+// 内置按张或按时长计费的模型必须使用对应的格式，否则花费会被记为 0
+func ParsePrices(entries []string) (map[string]Price, error) {
+	prices := make(map[string]Price, len(DefaultPrices)+len(entries))
+	for model, price := range DefaultPrices {
+		prices[model] = price
+	}
+	for _, entry := range entries {
+		model, value, found := strings.Cut(entry, "=")
+		model = strings.TrimSpace(model)
+		if !found || model == "" {
+			return prices, fmt.Errorf("invalid model price %q", entry)
+		}
+		price, err := parsePrice(strings.TrimSpace(value))
+		if err != nil {
+			return prices, fmt.Errorf("invalid price in %q: %w", entry, err)
+		}
+		if builtin, ok := PriceOf(DefaultPrices, model); ok {
+			if builtin.Image > 0 && price.Image == 0 {
+				return prices, fmt.Errorf("%s is priced per image, use %s=image:<price>",
+					model, model)
+			}
+			if builtin.Minute > 0 && price.Minute == 0 {
+				return prices, fmt.Errorf("%s is priced per minute, use %s=minute:<price>",
+					model, model)
+			}
+		}
+		prices[model] = price
+	}
+	return prices, nil
+}
+
+func parsePrice(value string) (Price, error) {
+	if unit, amount, found := strings.Cut(value, ":"); found {
+		n, err := strconv.ParseFloat(strings.TrimSpace(amount), 64)
+		if err != nil {
+			return Price{}, err
+		}
+		switch strings.TrimSpace(unit) {
+		case "image":
+			return Price{Image: n}, nil
+		case "minute":
+			return Price{Minute: n}, nil
+		}
+		return Price{}, fmt.Errorf("unknown price unit %q", unit)
+	}
+	input, output, _ := strings.Cut(value, "/")
+	var price Price
+	var err error
+	if price.Input, err = strconv.ParseFloat(strings.TrimSpace(input), 64); err != nil {
+		return Price{}, fmt.Errorf("input: %w", err)
+	}
+	price.Output = price.Input
+	if strings.TrimSpace(output) != "" {
+		if price.Output, err = strconv.ParseFloat(strings.TrimSpace(output), 64); err != nil {
+			return Price{}, fmt.Errorf("output: %w", err)
+		}
+	}
+	return price, nil
+}
@@ -0,0 +1,160 @@
+package usage
+
+import (
+	"math"
+	"testing"
+)
+
+func TestPriceOf(t *testing.T) {
+	prices := map[string]Price{
+		"gpt-4":       {Input: 30, Output: 60},
+		"gpt-4o":      {Input: 2.5, Output: 10},
+		"gpt-4o-mini": {Input: 0.15, Output: 0.6},
+	}
+	tests := []struct {
+		model string
+		want  Price
+		found bool
+	}{
+		{"gpt-4", Price{Input: 30, Output: 60}, true},
+		{"gpt-4-0613", Price{Input: 30, Output: 60}, true},
+		// 按最长前缀匹配
+		{"gpt-4o-2024-08-06", Price{Input: 2.5, Output: 10}, true},
+		{"gpt-4o-mini-2024-07-18", Price{Input: 0.15, Output: 0.6}, true},
+		{"gpt-3.5-turbo", Price{}, false},
+		{"", Price{}, false},
+	}
+	for _, tt := range tests {
+		got, found := PriceOf(prices, tt.model)
+		if got != tt.want || found != tt.found {
+			t.Errorf("PriceOf(%q) = %+v, %v, want %+v, %v", tt.model, got, found,
+				tt.want, tt.found)
+		}
+	}
+}
+
+func TestCost(t *testing.T) {
+	tests := []struct {
+		model      string
+		prompt     int
+		completion int
+		want       float64
+	}{
+		{"gpt-4o-2024-08-06", 1000000, 0, 2.5},
+		{"gpt-4o", 200000, 100000, 1.5},
+		{"tts-1", 1000, 0, 0.015},
+		{"unknown-model", 1000000, 1000000, 0},
+	}
+	for _, tt := range tests {
+		got := Cost(DefaultPrices, tt.model, tt.prompt, tt.completion)
+		if math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("Cost(%s, %d, %d) = %v, want %v", tt.model, tt.prompt,
+				tt.completion, got, tt.want)
+		}
+	}
+}
+
+func TestImageCost(t *testing.T) {
+	tests := []struct {
+		model string
+		size  string
+		n     int
+		want  float64
+	}{
+		{"dall-e-3", "1024x1024", 1, 0.04},
+		{"dall-e-3", "1792x1024", 2, 0.16},
+		{"dall-e-2", "256x256", 3, 0.048},
+		// 未列出的尺寸使用模型的价格
+		{"dall-e-2", "2048x2048", 1, 0.02},
+		{"gpt-image-1", "1024x1024", 1, 0},
+		{"unknown-model", "1024x1024", 1, 0},
+	}
+	for _, tt := range tests {
+		got := ImageCost(DefaultPrices, tt.model, tt.size, tt.n)
+		if math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("ImageCost(%s, %s, %d) = %v, want %v", tt.model, tt.size, tt.n,
+				got, tt.want)
+		}
+	}
+}
+
+func TestAudioCost(t *testing.T) {
+	tests := []struct {
+		model   string
+		seconds float64
+		want    float64
+	}{
+		{"whisper-1", 60, 0.006},
+		{"whisper-1", 90, 0.009},
+		{"gpt-4o-mini-transcribe", 120, 0.006},
+		{"gpt-4o-transcribe", 30, 0.003},
+		// 按 token 计费的模型没有时长价格
+		{"gpt-4o", 60, 0},
+		{"unknown-model", 60, 0},
+	}
+	for _, tt := range tests {
+		got := AudioCost(DefaultPrices, tt.model, tt.seconds)
+		if math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("AudioCost(%s, %v) = %v, want %v", tt.model, tt.seconds, got,
+				tt.want)
+		}
+	}
+}
+
+func TestParsePrices(t *testing.T) {
+	tests := []struct {
+		entries []string
+		model   string
+		want    Price
+		err     bool
+	}{
+		{nil, "gpt-4o", DefaultPrices["gpt-4o"], false},
+		// 覆盖内置价格
+		{[]string{"gpt-4o=3/12"}, "gpt-4o", Price{Input: 3, Output: 12}, false},
+		// 只填写一个价格时输入输出相同
+		{[]string{" my-model = 1.5 "}, "my-model", Price{Input: 1.5, Output: 1.5}, false},
+		{[]string{"my-model=1/"}, "my-model", Price{Input: 1, Output: 1}, false},
+		{[]string{"my-model"}, "", Price{}, true},
+		{[]string{"=1/2"}, "", Price{}, true},
+		{[]string{"my-model=x/2"}, "", Price{}, true},
+		{[]string{"my-model=1/y"}, "", Price{}, true},
+		// 按张和按时长计费
+		{[]string{"dall-e-3=image:0.05"}, "dall-e-3", Price{Image: 0.05}, false},
+		{[]string{" dall-e-2-512x512 = image: 0.02 "}, "dall-e-2-512x512",
+			Price{Image: 0.02}, false},
+		{[]string{"whisper-1=minute:0.01"}, "whisper-1", Price{Minute: 0.01}, false},
+		{[]string{"my-image-model=image:0.1"}, "my-image-model", Price{Image: 0.1}, false},
+		{[]string{"dall-e-3=image:x"}, "", Price{}, true},
+		{[]string{"dall-e-3=second:0.1"}, "", Price{}, true},
+		// 内置按张或按时长计费的模型不能按 token 配置
+		{[]string{"dall-e-3=0.04"}, "", Price{}, true},
+		{[]string{"dall-e-3-1024x1792=0.08"}, "", Price{}, true},
+		{[]string{"whisper-1=0.006"}, "", Price{}, true},
+	}
+	for _, tt := range tests {
+		prices, err := ParsePrices(tt.entries)
+		if (err != nil) != tt.err {
+			t.Errorf("ParsePrices(%q) error = %v", tt.entries, err)
+			continue
+		}
+		// 出错时仍然返回内置价格
+		if len(prices) < len(DefaultPrices) {
+			t.Errorf("ParsePrices(%q) dropped default prices", tt.entries)
+		}
+		if tt.err {
+			continue
+		}
+		if got := prices[tt.model]; got != tt.want {
+			t.Errorf("ParsePrices(%q)[%s] = %+v, want %+v", tt.entries, tt.model,
+				got, tt.want)
+		}
+	}
+
+	// 不修改内置价格表
+	if _, err := ParsePrices([]string{"gpt-4o=100/100"}); err != nil {
+		t.Fatal(err)
+	}
+	if DefaultPrices["gpt-4o"] != (Price{Input: 2.5, Output: 10}) {
+		t.Errorf("DefaultPrices modified: %+v", DefaultPrices["gpt-4o"])
+	}
+}
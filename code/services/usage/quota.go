@@ -0,0 +1,244 @@
+package usage
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Scope string
+type Period string
+
+const (
+	ScopeUser   Scope = "user"
+	ScopeGroup  Scope = "group"
+	ScopeGlobal Scope = "global"
+)
+
+const (
+	PeriodDay   Period = "day"
+	PeriodMonth Period = "month"
+)
+
+// Limit 额度上限，0 表示不限制
+type Limit struct {
+	Tokens int     `json:"tokens,omitempty"`
+	Cost   float64 `json:"cost,omitempty"`
+}
+
+func (l Limit) exceeded(t Total) bool {
+	if l.Tokens > 0 && t.Tokens >= l.Tokens {
+		return true
+	}
+	if l.Cost > 0 && t.Cost >= l.Cost {
+		return true
+	}
+	return false
+}
+
+type Quota struct {
+	Daily   Limit `json:"daily"`
+	Monthly Limit `json:"monthly"`
+}
+
+type QuotaConfig struct {
+	User   Quota
+	Group  Quota
+	Global Quota
+	// Overrides 配置文件中为单个用户设置的额度
+	Overrides map[string]Quota
+}
+
+// Exceeded 描述被触发的额度限制
+type Exceeded struct {
+	Scope   Scope
+	Period  Period
+	Used    Total
+	Limit   Limit
+	ResetAt time.Time
+}
+
+type QuotaManager struct {
+	mu        sync.RWMutex
+	store     StoreInterface
+	config    QuotaConfig
+	overrides map[string]Quota
+	// saved 通过指令设置、需要写入 file 的单独额度
+	saved map[string]Quota
+	file  string
+}
+
+func NewQuotaManager(store StoreInterface, config QuotaConfig) *QuotaManager {
+	q := &QuotaManager{
+		store:     store,
+		config:    config,
+		overrides: make(map[string]Quota, len(config.Overrides)),
+		saved:     make(map[string]Quota),
+	}
+	for userId, quota := range config.Overrides {
+		q.overrides[userId] = quota
+	}
+	return q
+}
+
+// LoadOverrides 从文件加载通过指令设置的单独额度，之后的修改会写回该文件。
+// 文件中的额度覆盖配置中的 QUOTA_OVERRIDES
+func (q *QuotaManager) LoadOverrides(file string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.file = file
+	if file == "" {
+		return nil
+	}
+	data, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, &q.saved); err != nil {
+		return err
+	}
+	for userId, quota := range q.saved {
+		q.overrides[userId] = quota
+	}
+	return nil
+}
+
+func (q *QuotaManager) Config() QuotaConfig {
+	return q.config
+}
+
+// SetOverride 为单个用户设置独立的额度，覆盖默认的用户额度，并写回文件
+func (q *QuotaManager) SetOverride(userId string, quota Quota) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.overrides[userId] = quota
+	q.saved[userId] = quota
+	return q.save()
+}
+
+// ClearOverride 取消通过指令设置的单独额度并写回文件，配置中设置了额度的用户恢复为配置的额度
+func (q *QuotaManager) ClearOverride(userId string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if quota, ok := q.config.Overrides[userId]; ok {
+		q.overrides[userId] = quota
+	} else {
+		delete(q.overrides, userId)
+	}
+	delete(q.saved, userId)
+	return q.save()
+}
+
+// save 将全部单独额度写回文件，调用方需要持有写锁
+func (q *QuotaManager) save() error {
+	if q.file == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(q.saved, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(q.file, data, 0644)
+}
+
+// UserQuota 返回用户实际生效的额度
+func (q *QuotaManager) UserQuota(userId string) (Quota, bool) {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	if quota, ok := q.overrides[userId]; ok {
+		return quota, true
+	}
+	return q.config.User, false
+}
+
+// Used 统计指定范围在当前周期内的用量
+func (q *QuotaManager) Used(filter Filter, period Period, now time.Time) Total {
+	return q.store.Total(filter, period, now)
+}
+
+// Check 依次检查用户、群组和全局额度，返回第一个被用完的额度，全部未用完时返回 nil
+func (q *QuotaManager) Check(userId, chatId string, isGroup bool,
+	now time.Time) *Exceeded {
+	userQuota, _ := q.UserQuota(userId)
+	if e := q.check(ScopeUser, Filter{UserId: userId}, userQuota, now); e != nil {
+		return e
+	}
+	if isGroup {
+		if e := q.check(ScopeGroup, Filter{ChatId: chatId}, q.config.Group, now); e != nil {
+			return e
+		}
+	}
+	return q.check(ScopeGlobal, Filter{}, q.config.Global, now)
+}
+
+func (q *QuotaManager) check(scope Scope, filter Filter, quota Quota,
+	now time.Time) *Exceeded {
+	for _, period := range []Period{PeriodDay, PeriodMonth} {
+		limit := quota.Daily
+		if period == PeriodMonth {
+			limit = quota.Monthly
+		}
+		if limit.Tokens <= 0 && limit.Cost <= 0 {
+			continue
+		}
+		used := q.Used(filter, period, now)
+		if limit.exceeded(used) {
+			return &Exceeded{
+				Scope:   scope,
+				Period:  period,
+				Used:    used,
+				Limit:   limit,
+				ResetAt: periodEnd(period, now),
+			}
+		}
+	}
+	return nil
+}
+
+func periodStart(period Period, now time.Time) time.Time {
+	if period == PeriodMonth {
+		return MonthStart(now)
+	}
+	return DayStart(now)
+}
+
+func periodEnd(period Period, now time.Time) time.Time {
+	if period == PeriodMonth {
+		return MonthStart(now).AddDate(0, 1, 0)
+	}
+	return DayStart(now).AddDate(0, 0, 1)
+}
+
+// ParseOverride 解析形如 "ou_xxx=100000/3000000" 的配置，
+// 分别表示该用户每日和每月的 token 上限
+func ParseOverride(s string) (string, Quota, error) {
+	userId, limits, found := strings.Cut(strings.TrimSpace(s), "=")
+	if !found || userId == "" {
+		return "", Quota{}, fmt.Errorf("invalid quota override %q", s)
+	}
+	daily, monthly, _ := strings.Cut(limits, "/")
+	var quota Quota
+	var err error
+	if quota.Daily.Tokens, err = parseTokens(daily); err != nil {
+		return "", Quota{}, fmt.Errorf("invalid daily tokens in %q: %w", s, err)
+	}
+	if quota.Monthly.Tokens, err = parseTokens(monthly); err != nil {
+		return "", Quota{}, fmt.Errorf("invalid monthly tokens in %q: %w", s, err)
+	}
+	return userId, quota, nil
+}
+
+func parseTokens(s string) (int, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, nil
+	}
+	return strconv.Atoi(s)
+}
@@ -0,0 +1,173 @@
+package usage
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPeriodEnd(t *testing.T) {
+	tests := []struct {
+		period Period
+		now    time.Time
+		want   time.Time
+	}{
+		{PeriodDay, time.Date(2024, 5, 15, 12, 30, 0, 0, time.UTC),
+			time.Date(2024, 5, 16, 0, 0, 0, 0, time.UTC)},
+		{PeriodDay, time.Date(2024, 12, 31, 23, 59, 0, 0, time.UTC),
+			time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{PeriodMonth, time.Date(2024, 1, 31, 8, 0, 0, 0, time.UTC),
+			time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)},
+		{PeriodMonth, time.Date(2024, 12, 1, 0, 0, 0, 0, time.UTC),
+			time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		if got := periodEnd(tt.period, tt.now); !got.Equal(tt.want) {
+			t.Errorf("periodEnd(%s, %v) = %v, want %v", tt.period, tt.now, got, tt.want)
+		}
+	}
+}
+
+func TestQuotaCheck(t *testing.T) {
+	now := time.Date(2024, 5, 15, 12, 0, 0, 0, time.Local)
+	s := newStore("", time.Local)
+	for _, r := range []Record{
+		{Time: now, UserId: "u1", ChatId: "oc_a", PromptTokens: 100, Cost: 0.1},
+		{Time: now.AddDate(0, 0, -1), UserId: "u1", ChatId: "oc_a", PromptTokens: 500},
+		{Time: now, UserId: "u2", ChatId: "oc_a", PromptTokens: 300},
+	} {
+		s.insert(r)
+	}
+	q := NewQuotaManager(s, QuotaConfig{
+		User:   Quota{Daily: Limit{Tokens: 200}, Monthly: Limit{Tokens: 550}},
+		Group:  Quota{Daily: Limit{Cost: 0.1}},
+		Global: Quota{Monthly: Limit{Tokens: 1000}},
+		Overrides: map[string]Quota{
+			"u3": {Daily: Limit{Tokens: 1}},
+		},
+	})
+
+	tests := []struct {
+		userId  string
+		chatId  string
+		isGroup bool
+		scope   Scope
+		period  Period
+	}{
+		// u1 今日 100 未超出，本月 600 超出
+		{"u1", "oc_p2p", false, ScopeUser, PeriodMonth},
+		{"u2", "oc_a", true, ScopeUser, PeriodDay},
+		// 私聊不检查群组额度
+		{"u4", "oc_a", false, "", ""},
+		{"u4", "oc_a", true, ScopeGroup, PeriodDay},
+		{"u4", "oc_b", true, "", ""},
+		// u3 单独设置了每日 1 token，还没有用量时不超出
+		{"u3", "oc_b", true, "", ""},
+	}
+	for _, tt := range tests {
+		e := q.Check(tt.userId, tt.chatId, tt.isGroup, now)
+		if tt.scope == "" {
+			if e != nil {
+				t.Errorf("Check(%s, %s) = %+v, want nil", tt.userId, tt.chatId, e)
+			}
+			continue
+		}
+		if e == nil || e.Scope != tt.scope || e.Period != tt.period {
+			t.Errorf("Check(%s, %s) = %+v, want %s %s", tt.userId, tt.chatId, e,
+				tt.scope, tt.period)
+			continue
+		}
+		if !e.ResetAt.Equal(periodEnd(tt.period, now)) {
+			t.Errorf("Check(%s, %s) reset at %v", tt.userId, tt.chatId, e.ResetAt)
+		}
+	}
+
+	s.insert(Record{Time: now, UserId: "u3", ChatId: "oc_b", PromptTokens: 1})
+	if e := q.Check("u3", "oc_b", true, now); e == nil || e.Scope != ScopeUser {
+		t.Errorf("Check(u3) after usage = %+v, want user limit", e)
+	}
+	s.insert(Record{Time: now, UserId: "u5", ChatId: "oc_c", PromptTokens: 400})
+	if e := q.Check("u6", "oc_c", true, now); e == nil || e.Scope != ScopeGlobal {
+		t.Errorf("Check(u6) = %+v, want global limit", e)
+	}
+}
+
+func TestQuotaOverrides(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "quota_overrides.json")
+	config := QuotaConfig{
+		User:      Quota{Daily: Limit{Tokens: 100}},
+		Overrides: map[string]Quota{"u1": {Daily: Limit{Tokens: 200}}},
+	}
+	q := NewQuotaManager(newStore("", time.Local), config)
+	if err := q.LoadOverrides(file); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.SetOverride("u2", Quota{Monthly: Limit{Tokens: 300}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.SetOverride("u1", Quota{Daily: Limit{Tokens: 400}}); err != nil {
+		t.Fatal(err)
+	}
+
+	// 重启后通过指令设置的额度仍然生效，并覆盖配置中的额度
+	reloaded := NewQuotaManager(newStore("", time.Local), config)
+	if err := reloaded.LoadOverrides(file); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		userId     string
+		want       Quota
+		overridden bool
+	}{
+		{"u1", Quota{Daily: Limit{Tokens: 400}}, true},
+		{"u2", Quota{Monthly: Limit{Tokens: 300}}, true},
+		{"u3", config.User, false},
+	}
+	for _, tt := range tests {
+		got, overridden := reloaded.UserQuota(tt.userId)
+		if got != tt.want || overridden != tt.overridden {
+			t.Errorf("UserQuota(%s) = %+v, %v, want %+v, %v", tt.userId, got,
+				overridden, tt.want, tt.overridden)
+		}
+	}
+
+	// 取消后恢复配置中的额度，没有配置的用户恢复默认额度
+	if err := reloaded.ClearOverride("u1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := reloaded.ClearOverride("u2"); err != nil {
+		t.Fatal(err)
+	}
+	q = NewQuotaManager(newStore("", time.Local), config)
+	if err := q.LoadOverrides(file); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := q.UserQuota("u1"); got != config.Overrides["u1"] {
+		t.Errorf("UserQuota(u1) after clear = %+v", got)
+	}
+	if got, overridden := q.UserQuota("u2"); overridden || got != config.User {
+		t.Errorf("UserQuota(u2) after clear = %+v, %v", got, overridden)
+	}
+}
+
+func TestParseOverride(t *testing.T) {
+	tests := []struct {
+		input  string
+		userId string
+		want   Quota
+		err    bool
+	}{
+		{"ou_a=100/3000", "ou_a", Quota{Daily: Limit{Tokens: 100}, Monthly: Limit{Tokens: 3000}}, false},
+		{" ou_a=100 ", "ou_a", Quota{Daily: Limit{Tokens: 100}}, false},
+		{"ou_a=/3000", "ou_a", Quota{Monthly: Limit{Tokens: 3000}}, false},
+		{"ou_a", "", Quota{}, true},
+		{"=100", "", Quota{}, true},
+		{"ou_a=x/1", "", Quota{}, true},
+	}
+	for _, tt := range tests {
+		userId, got, err := ParseOverride(tt.input)
+		if (err != nil) != tt.err || userId != tt.userId || got != tt.want {
+			t.Errorf("ParseOverride(%q) = %s, %+v, %v", tt.input, userId, got, err)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package usage
+
+import "sort"
+
+// Ranked 按某个维度汇总后的一项
+type Ranked struct {
+	Key   string
+	Total Total
+}
+
+// GroupBy 按 key 汇总记录，返回按花费（花费相同时按 token）从高到低排序的结果
+func GroupBy(records []Record, key func(Record) string) []Ranked {
+	totals := make(map[string]*Total)
+	var keys []string
+	for _, r := range records {
+		k := key(r)
+		t, ok := totals[k]
+		if !ok {
+			t = &Total{}
+			totals[k] = t
+			keys = append(keys, k)
+		}
+		t.Add(r)
+	}
+	result := make([]Ranked, 0, len(keys))
+	for _, k := range keys {
+		result = append(result, Ranked{Key: k, Total: *totals[k]})
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		if result[i].Total.Cost != result[j].Total.Cost {
+			return result[i].Total.Cost > result[j].Total.Cost
+		}
+		return result[i].Total.Tokens > result[j].Total.Tokens
+	})
+	return result
+}
+
+func ByUser(r Record) string  { return r.UserId }
+func ByChat(r Record) string  { return r.ChatId }
+func ByModel(r Record) string { return r.Model }
+
+// Top 返回前 n 项，n <= 0 时返回全部
+func Top(ranked []Ranked, n int) []Ranked {
+	if n <= 0 || len(ranked) <= n {
+		return ranked
+	}
+	return ranked[:n]
+}
@@ -0,0 +1,66 @@
+package usage
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestGroupBy(t *testing.T) {
+	records := []Record{
+		{UserId: "u1", Model: "gpt-4o", PromptTokens: 10, Cost: 0.1},
+		{UserId: "u2", Model: "gpt-4o-mini", PromptTokens: 500},
+		{UserId: "u1", Model: "gpt-4o-mini", PromptTokens: 20, Cost: 0.1},
+		{UserId: "u3", Model: "gpt-4o", PromptTokens: 100},
+	}
+	tests := []struct {
+		key  func(Record) string
+		want []Ranked
+	}{
+		{ByUser, []Ranked{
+			{Key: "u1", Total: Total{Requests: 2, Tokens: 30, Cost: 0.2}},
+			// 花费相同时按 token 排序
+			{Key: "u2", Total: Total{Requests: 1, Tokens: 500}},
+			{Key: "u3", Total: Total{Requests: 1, Tokens: 100}},
+		}},
+		{ByModel, []Ranked{
+			{Key: "gpt-4o-mini", Total: Total{Requests: 2, Tokens: 520, Cost: 0.1}},
+			{Key: "gpt-4o", Total: Total{Requests: 2, Tokens: 110, Cost: 0.1}},
+		}},
+	}
+	for _, tt := range tests {
+		got := GroupBy(records, tt.key)
+		if len(got) != len(tt.want) {
+			t.Errorf("GroupBy() = %+v, want %+v", got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i].Key != tt.want[i].Key || got[i].Total.Requests != tt.want[i].Total.Requests ||
+				got[i].Total.Tokens != tt.want[i].Total.Tokens {
+				t.Errorf("GroupBy()[%d] = %+v, want %+v", i, got[i], tt.want[i])
+			}
+		}
+	}
+
+	if got := GroupBy(nil, ByChat); len(got) != 0 {
+		t.Errorf("GroupBy(nil) = %+v, want empty", got)
+	}
+}
+
+func TestTop(t *testing.T) {
+	ranked := []Ranked{{Key: "a"}, {Key: "b"}, {Key: "c"}}
+	tests := []struct {
+		n    int
+		want []Ranked
+	}{
+		{2, ranked[:2]},
+		{3, ranked},
+		{10, ranked},
+		{0, ranked},
+		{-1, ranked},
+	}
+	for _, tt := range tests {
+		if got := Top(ranked, tt.n); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Top(%d) = %+v, want %+v", tt.n, got, tt.want)
+		}
+	}
+}
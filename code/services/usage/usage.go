@@ -0,0 +1,289 @@
+package usage
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"sort"
+	"start-feishubot/logger"
+	"sync"
+	"time"
+)
+
+type Kind string
+
+const (
+	KindChat   Kind = "chat"
+	KindVision Kind = "vision"
+	KindImage  Kind = "image"
+	KindAudio  Kind = "audio"
+	// KindEmbedding 知识库检索和建立索引时计算向量
+	KindEmbedding Kind = "embedding"
+)
+
+// Record 一次模型调用的用量记录
+type Record struct {
+	Time             time.Time `json:"time"`
+	UserId           string    `json:"user_id"`
+	ChatId           string    `json:"chat_id"`
+	ChatType         string    `json:"chat_type"`
+	Kind             Kind      `json:"kind"`
+	Model            string    `json:"model"`
+	PromptTokens     int       `json:"prompt_tokens"`
+	CompletionTokens int       `json:"completion_tokens"`
+	// Images dall-e 按张计费时生成的图片数
+	Images int     `json:"images,omitempty"`
+	Cost   float64 `json:"cost"`
+}
+
+func (r Record) Tokens() int {
+	return r.PromptTokens + r.CompletionTokens
+}
+
+// Filter 查询条件，空字段表示不限制
+type Filter struct {
+	UserId string
+	ChatId string
+	Since  time.Time
+	Until  time.Time
+}
+
+func (f Filter) match(r Record) bool {
+	if f.UserId != "" && r.UserId != f.UserId {
+		return false
+	}
+	if f.ChatId != "" && r.ChatId != f.ChatId {
+		return false
+	}
+	if !f.Since.IsZero() && r.Time.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && !r.Time.Before(f.Until) {
+		return false
+	}
+	return true
+}
+
+// Total 汇总后的用量
+type Total struct {
+	Requests int
+	Tokens   int
+	Cost     float64
+}
+
+func (t *Total) Add(r Record) {
+	t.Requests++
+	t.Tokens += r.Tokens()
+	t.Cost += r.Cost
+}
+
+func Sum(records []Record) Total {
+	var total Total
+	for _, r := range records {
+		total.Add(r)
+	}
+	return total
+}
+
+type StoreInterface interface {
+	Add(record Record) error
+	Query(filter Filter) []Record
+	// Total 返回用户（filter.UserId）、会话（filter.ChatId）或全局在当前自然日或自然月的用量
+	Total(filter Filter, period Period, now time.Time) Total
+}
+
+// RetentionDays 内存中保留最近多少天的记录，更早的记录只保存在文件中
+const RetentionDays = 366
+
+// bucket 汇总用量的维度，id 为空表示全局
+type bucket struct {
+	scope  Scope
+	id     string
+	period Period
+	start  int64
+}
+
+// Store 用量记录，内存中按时间顺序保存最近的记录，同时以 JSON Lines 追加写入文件。
+// 额度检查使用按用户、会话和全局维护的当日、当月汇总，不需要遍历记录
+type Store struct {
+	mu       sync.RWMutex
+	records  []Record
+	totals   map[bucket]*Total
+	prunedAt time.Time
+	file     string
+	loc      *time.Location
+}
+
+var store *Store
+
+func NewStore(file string) (*Store, error) {
+	s := newStore(file, time.Local)
+	if file == "" {
+		return s, nil
+	}
+	f, err := os.Open(file)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var r Record
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			logger.Warnf("skip broken usage record: %v", err)
+			continue
+		}
+		s.insert(r)
+	}
+	s.prune(time.Now())
+	return s, scanner.Err()
+}
+
+func newStore(file string, loc *time.Location) *Store {
+	return &Store{file: file, loc: loc, totals: make(map[bucket]*Total)}
+}
+
+func (s *Store) Add(record Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.insert(record)
+	if now := time.Now(); !DayStart(now.In(s.loc)).Equal(s.prunedAt) {
+		s.prune(now)
+	}
+	if s.file == "" {
+		return nil
+	}
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(s.file, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.Write(append(line, '\n'))
+	return err
+}
+
+// insert 按时间顺序插入记录并累加到汇总中，调用方需要持有写锁
+func (s *Store) insert(r Record) {
+	i := len(s.records)
+	if i > 0 && r.Time.Before(s.records[i-1].Time) {
+		i = sort.Search(len(s.records), func(j int) bool {
+			return s.records[j].Time.After(r.Time)
+		})
+	}
+	s.records = append(s.records, Record{})
+	copy(s.records[i+1:], s.records[i:])
+	s.records[i] = r
+
+	t := r.Time.In(s.loc)
+	for _, period := range []Period{PeriodDay, PeriodMonth} {
+		start := periodStart(period, t).Unix()
+		for _, b := range []bucket{
+			{scope: ScopeUser, id: r.UserId, period: period, start: start},
+			{scope: ScopeGroup, id: r.ChatId, period: period, start: start},
+			{scope: ScopeGlobal, period: period, start: start},
+		} {
+			total, ok := s.totals[b]
+			if !ok {
+				total = &Total{}
+				s.totals[b] = total
+			}
+			total.Add(r)
+		}
+	}
+}
+
+// prune 丢弃超过保留天数的记录和已经结束的周期的汇总，调用方需要持有写锁
+func (s *Store) prune(now time.Time) {
+	now = now.In(s.loc)
+	s.prunedAt = DayStart(now)
+	cutoff := s.prunedAt.AddDate(0, 0, -RetentionDays)
+	if i := sort.Search(len(s.records), func(j int) bool {
+		return !s.records[j].Time.Before(cutoff)
+	}); i > 0 {
+		s.records = append([]Record(nil), s.records[i:]...)
+	}
+	for b := range s.totals {
+		if b.start < periodStart(b.period, now).Unix() {
+			delete(s.totals, b)
+		}
+	}
+}
+
+// Query 返回符合条件的记录，按时间顺序排列，Since 通过二分查找定位
+func (s *Store) Query(filter Filter) []Record {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	i := 0
+	if !filter.Since.IsZero() {
+		i = sort.Search(len(s.records), func(j int) bool {
+			return !s.records[j].Time.Before(filter.Since)
+		})
+	}
+	var result []Record
+	for ; i < len(s.records); i++ {
+		r := s.records[i]
+		if !filter.Until.IsZero() && !r.Time.Before(filter.Until) {
+			break
+		}
+		if filter.match(r) {
+			result = append(result, r)
+		}
+	}
+	return result
+}
+
+func (s *Store) Total(filter Filter, period Period, now time.Time) Total {
+	b := bucket{scope: ScopeGlobal, period: period,
+		start: periodStart(period, now.In(s.loc)).Unix()}
+	switch {
+	case filter.UserId != "" && filter.ChatId != "":
+		filter.Since = periodStart(period, now.In(s.loc))
+		return Sum(s.Query(filter))
+	case filter.UserId != "":
+		b.scope, b.id = ScopeUser, filter.UserId
+	case filter.ChatId != "":
+		b.scope, b.id = ScopeGroup, filter.ChatId
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if total, ok := s.totals[b]; ok {
+		return *total
+	}
+	return Total{}
+}
+
+// GetStore 返回全局用量记录，首次调用时从 file 加载历史记录
+func GetStore(file string) StoreInterface {
+	if store == nil {
+		s, err := NewStore(file)
+		if err != nil {
+			logger.Errorf("load usage records from %s failed: %v", file, err)
+		}
+		if s == nil {
+			s = newStore(file, time.Local)
+		}
+		store = s
+	}
+	return store
+}
+
+// DayStart 返回 t 所在自然日的零点
+func DayStart(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+}
+
+// MonthStart 返回 t 所在自然月的第一天零点
+func MonthStart(t time.Time) time.Time {
+	y, m, _ := t.Date()
+	return time.Date(y, m, 1, 0, 0, 0, 0, t.Location())
+}
@@ -0,0 +1,138 @@
+package usage
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStoreQuery(t *testing.T) {
+	now := time.Now()
+	s := newStore("", time.Local)
+	records := []Record{
+		{Time: now.Add(-3 * time.Hour), UserId: "u1", ChatId: "oc_a", PromptTokens: 10},
+		{Time: now.Add(-1 * time.Hour), UserId: "u2", ChatId: "oc_a", PromptTokens: 20},
+		// 乱序写入的记录按时间插入
+		{Time: now.Add(-2 * time.Hour), UserId: "u1", ChatId: "oc_b", PromptTokens: 30},
+	}
+	for _, r := range records {
+		if err := s.Add(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		filter Filter
+		tokens []int
+	}{
+		{Filter{}, []int{10, 30, 20}},
+		{Filter{UserId: "u1"}, []int{10, 30}},
+		{Filter{ChatId: "oc_a"}, []int{10, 20}},
+		{Filter{Since: now.Add(-2 * time.Hour)}, []int{30, 20}},
+		{Filter{Until: now.Add(-2 * time.Hour)}, []int{10}},
+		{Filter{UserId: "u2", Until: now.Add(-2 * time.Hour)}, nil},
+	}
+	for _, tt := range tests {
+		var tokens []int
+		for _, r := range s.Query(tt.filter) {
+			tokens = append(tokens, r.Tokens())
+		}
+		if len(tokens) != len(tt.tokens) {
+			t.Errorf("Query(%+v) = %v, want %v", tt.filter, tokens, tt.tokens)
+			continue
+		}
+		for i := range tokens {
+			if tokens[i] != tt.tokens[i] {
+				t.Errorf("Query(%+v) = %v, want %v", tt.filter, tokens, tt.tokens)
+				break
+			}
+		}
+	}
+}
+
+func TestStoreTotal(t *testing.T) {
+	now := time.Date(2024, 5, 15, 12, 0, 0, 0, time.Local)
+	s := newStore("", time.Local)
+	for _, r := range []Record{
+		{Time: now.Add(-time.Hour), UserId: "u1", ChatId: "oc_a", PromptTokens: 10, Cost: 1},
+		{Time: now.AddDate(0, 0, -1), UserId: "u1", ChatId: "oc_b", PromptTokens: 20},
+		{Time: now.AddDate(0, -1, 0), UserId: "u1", ChatId: "oc_a", PromptTokens: 40},
+		{Time: now, UserId: "u2", ChatId: "oc_a", CompletionTokens: 5},
+	} {
+		s.insert(r)
+	}
+
+	tests := []struct {
+		filter Filter
+		period Period
+		want   Total
+	}{
+		{Filter{UserId: "u1"}, PeriodDay, Total{Requests: 1, Tokens: 10, Cost: 1}},
+		{Filter{UserId: "u1"}, PeriodMonth, Total{Requests: 2, Tokens: 30, Cost: 1}},
+		{Filter{ChatId: "oc_a"}, PeriodDay, Total{Requests: 2, Tokens: 15, Cost: 1}},
+		{Filter{ChatId: "oc_b"}, PeriodDay, Total{}},
+		{Filter{}, PeriodMonth, Total{Requests: 3, Tokens: 35, Cost: 1}},
+		{Filter{UserId: "u1", ChatId: "oc_b"}, PeriodMonth, Total{Requests: 1, Tokens: 20}},
+		{Filter{UserId: "u3"}, PeriodMonth, Total{}},
+	}
+	for _, tt := range tests {
+		if got := s.Total(tt.filter, tt.period, now); got != tt.want {
+			t.Errorf("Total(%+v, %s) = %+v, want %+v", tt.filter, tt.period, got, tt.want)
+		}
+	}
+
+	// 跨月后上个月的汇总被清理，记录仍然可以查询
+	s.prune(now.AddDate(0, 1, 0))
+	if got := s.Total(Filter{UserId: "u1"}, PeriodMonth, now); got != (Total{}) {
+		t.Errorf("Total after prune = %+v, want empty", got)
+	}
+	if got := len(s.Query(Filter{UserId: "u1"})); got != 3 {
+		t.Errorf("Query after prune returned %d records, want 3", got)
+	}
+
+	// 超过保留天数的记录只保留在文件中
+	s.prune(now.AddDate(0, 0, RetentionDays))
+	if got := len(s.Query(Filter{})); got != 2 {
+		t.Errorf("Query after retention returned %d records, want 2", got)
+	}
+}
+
+func TestStoreReload(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "usage.jsonl")
+	now := time.Now()
+	s, err := NewStore(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range []Record{
+		{Time: now, UserId: "u1", ChatId: "oc_a", Kind: KindChat, Model: "gpt-4o",
+			PromptTokens: 10, CompletionTokens: 5, Cost: 0.5},
+		{Time: now, UserId: "u2", ChatId: "oc_a", Kind: KindImage, Model: "dall-e-3", Cost: 0.04},
+	} {
+		if err := s.Add(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 损坏的行被跳过
+	f, err := os.OpenFile(file, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString("{broken\n")
+	f.Close()
+
+	reloaded, err := NewStore(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	records := reloaded.Query(Filter{})
+	if len(records) != 2 || records[0].Model != "gpt-4o" || records[1].Kind != KindImage {
+		t.Fatalf("reloaded records = %+v", records)
+	}
+	want := Total{Requests: 2, Tokens: 15, Cost: 0.54}
+	if got := reloaded.Total(Filter{ChatId: "oc_a"}, PeriodDay, now); got != want {
+		t.Errorf("reloaded Total = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,150 @@
+package workerpool
+
+import (
+	"errors"
+	"sync"
+
+	"start-feishubot/logger"
+)
+
+var (
+	// ErrFull 排队的任务已达上限
+	ErrFull = errors.New("worker pool queue is full")
+	// ErrKeyFull 同一个键排队的任务已达上限
+	ErrKeyFull = errors.New("worker pool queue for key is full")
+	// ErrClosed 工作池已关闭
+	ErrClosed = errors.New("worker pool is closed")
+)
+
+// Config 工作池配置
+type Config struct {
+	// Workers 同时执行的任务数
+	Workers int
+	// QueueSize 全部排队任务的上限，不包括正在执行的任务
+	QueueSize int
+	// KeyQueueSize 同一个键排队任务的上限，0 表示只受 QueueSize 限制
+	KeyQueueSize int
+}
+
+// Pool 有界工作池：相同键的任务按提交顺序依次执行，不同键的任务并发执行
+type Pool struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	config  Config
+	queues  map[string][]func()
+	ready   []string
+	running map[string]bool
+	waiting int
+	active  int
+	closed  bool
+	done    sync.WaitGroup
+}
+
+func New(config Config) *Pool {
+	if config.Workers <= 0 {
+		config.Workers = 1
+	}
+	p := &Pool{
+		config:  config,
+		queues:  make(map[string][]func()),
+		running: make(map[string]bool),
+	}
+	p.cond = sync.NewCond(&p.mu)
+	p.done.Add(config.Workers)
+	for i := 0; i < config.Workers; i++ {
+		go p.work()
+	}
+	return p
+}
+
+// Submit 提交任务，返回排队位置，0 表示立即执行。
+// 队列已满时返回 ErrFull 或 ErrKeyFull，任务不会执行
+func (p *Pool) Submit(key string, task func()) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return 0, ErrClosed
+	}
+	queue := p.queues[key]
+	if p.config.KeyQueueSize > 0 && len(queue) >= p.config.KeyQueueSize {
+		return 0, ErrKeyFull
+	}
+	if p.config.QueueSize > 0 && p.waiting >= p.config.QueueSize {
+		return 0, ErrFull
+	}
+	// 同一个键的任务正在执行时排在该键的队尾，否则排在等待工作者的键之后
+	position := 0
+	free := p.config.Workers - p.active
+	if p.running[key] || len(queue) > 0 {
+		position = len(queue) + 1
+	} else if len(p.ready) >= free {
+		position = len(p.ready) - free + 1
+	}
+	if len(queue) == 0 && !p.running[key] {
+		p.ready = append(p.ready, key)
+	}
+	p.queues[key] = append(queue, task)
+	p.waiting++
+	p.cond.Signal()
+	return position, nil
+}
+
+// Stats 返回正在执行和排队的任务数
+func (p *Pool) Stats() (active int, waiting int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.active, p.waiting
+}
+
+// Close 不再接受新任务，等待已提交的任务执行完毕
+func (p *Pool) Close() {
+	p.mu.Lock()
+	p.closed = true
+	p.cond.Broadcast()
+	p.mu.Unlock()
+	p.done.Wait()
+}
+
+func (p *Pool) work() {
+	defer p.done.Done()
+	p.mu.Lock()
+	for {
+		for len(p.ready) == 0 && !p.closed {
+			p.cond.Wait()
+		}
+		if len(p.ready) == 0 {
+			p.mu.Unlock()
+			return
+		}
+		key := p.ready[0]
+		p.ready = p.ready[1:]
+		task := p.queues[key][0]
+		p.queues[key] = p.queues[key][1:]
+		p.waiting--
+		p.active++
+		p.running[key] = true
+		p.mu.Unlock()
+
+		run(key, task)
+
+		p.mu.Lock()
+		p.active--
+		delete(p.running, key)
+		if len(p.queues[key]) > 0 {
+			p.ready = append(p.ready, key)
+			p.cond.Signal()
+		} else {
+			delete(p.queues, key)
+		}
+	}
+}
+
+// run 执行任务，panic 只影响当前任务
+func run(key string, task func()) {
+	defer func() {
+		if r := recover(); r != nil {
+			logger.Errorf("worker task for %s panicked: %v", key, r)
+		}
+	}()
+	task()
+}
@@ -0,0 +1,121 @@
+package workerpool
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPoolKeyOrder(t *testing.T) {
+	p := New(Config{Workers: 4})
+	var mu sync.Mutex
+	got := make(map[string][]int)
+	for i := 0; i < 20; i++ {
+		i := i
+		key := []string{"a", "b"}[i%2]
+		if _, err := p.Submit(key, func() {
+			time.Sleep(time.Millisecond)
+			mu.Lock()
+			got[key] = append(got[key], i)
+			mu.Unlock()
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	p.Close()
+	for key, order := range got {
+		for j := 1; j < len(order); j++ {
+			if order[j] < order[j-1] {
+				t.Fatalf("tasks for %s ran out of order: %v", key, order)
+			}
+		}
+	}
+	if len(got["a"])+len(got["b"]) != 20 {
+		t.Fatalf("expected 20 tasks, got %v", got)
+	}
+}
+
+func TestPoolConcurrency(t *testing.T) {
+	p := New(Config{Workers: 3})
+	var mu sync.Mutex
+	current, peak := 0, 0
+	for i := 0; i < 12; i++ {
+		p.Submit(string(rune('a'+i)), func() {
+			mu.Lock()
+			current++
+			if current > peak {
+				peak = current
+			}
+			mu.Unlock()
+			time.Sleep(5 * time.Millisecond)
+			mu.Lock()
+			current--
+			mu.Unlock()
+		})
+	}
+	p.Close()
+	if peak > 3 {
+		t.Fatalf("expected at most 3 concurrent tasks, got %d", peak)
+	}
+}
+
+func TestPoolBackpressure(t *testing.T) {
+	p := New(Config{Workers: 1, QueueSize: 3, KeyQueueSize: 2})
+	release := make(chan struct{})
+	started := make(chan struct{})
+	block := func() {
+		started <- struct{}{}
+		<-release
+	}
+	noop := func() {}
+
+	if pos, err := p.Submit("a", block); err != nil || pos != 0 {
+		t.Fatalf("first task: position %d, err %v", pos, err)
+	}
+	<-started
+
+	var positions []int
+	submit := func(key string, wantErr error) {
+		t.Helper()
+		pos, err := p.Submit(key, noop)
+		if !errors.Is(err, wantErr) {
+			t.Fatalf("Submit(%s) err = %v, want %v", key, err, wantErr)
+		}
+		if err == nil {
+			positions = append(positions, pos)
+		}
+	}
+	submit("a", nil)
+	submit("a", nil)
+	submit("a", ErrKeyFull)
+	submit("b", nil)
+	submit("c", ErrFull)
+	if want := []int{1, 2, 1}; !reflect.DeepEqual(positions, want) {
+		t.Fatalf("positions = %v, want %v", positions, want)
+	}
+	if active, waiting := p.Stats(); active != 1 || waiting != 3 {
+		t.Fatalf("stats = %d active, %d waiting", active, waiting)
+	}
+
+	close(release)
+	p.Close()
+	if _, err := p.Submit("a", noop); !errors.Is(err, ErrClosed) {
+		t.Fatalf("Submit after Close err = %v", err)
+	}
+	if active, waiting := p.Stats(); active != 0 || waiting != 0 {
+		t.Fatalf("stats after Close = %d active, %d waiting", active, waiting)
+	}
+}
+
+func TestPoolRecoversPanic(t *testing.T) {
+	p := New(Config{Workers: 1})
+	done := false
+	p.Submit("a", func() { panic("boom") })
+	p.Submit("a", func() { done = true })
+	p.Close()
+	if !done {
+		t.Fatal("task after panic did not run")
+	}
+}
@@ -0,0 +1,120 @@
+package audio
+
+import (
+	"errors"
+	"time"
+)
+
+// Format 描述 PCM 数据的格式
+type Format struct {
+	SampleRate int
+	Channels   int
+	BitDepth   int
+}
+
+func (f Format) Validate() error {
+	if f.SampleRate <= 0 {
+		return errors.New("sample rate must be positive")
+	}
+	if f.Channels <= 0 {
+		return errors.New("channels must be positive")
+	}
+	if f.BitDepth != 8 && f.BitDepth != 16 {
+		return errors.New("bit depth must be 8 or 16")
+	}
+	return nil
+}
+
+// Audio 内存中的 16 位 PCM 音频，多声道时采样交错存放
+type Audio struct {
+	SampleRate int
+	Channels   int
+	Samples    []int16
+}
+
+func (a *Audio) Format() Format {
+	return Format{SampleRate: a.SampleRate, Channels: a.Channels, BitDepth: 16}
+}
+
+// Frames 返回每个声道的采样数
+func (a *Audio) Frames() int {
+	if a.Channels <= 0 {
+		return 0
+	}
+	return len(a.Samples) / a.Channels
+}
+
+func (a *Audio) Duration() time.Duration {
+	if a.SampleRate <= 0 {
+		return 0
+	}
+	return time.Duration(a.Frames()) * time.Second / time.Duration(a.SampleRate)
+}
+
+// Mono 将多声道音频取平均混成单声道
+func (a *Audio) Mono() *Audio {
+	if a.Channels <= 1 {
+		return a
+	}
+	frames := a.Frames()
+	samples := make([]int16, frames)
+	for i := 0; i < frames; i++ {
+		var sum int
+		for c := 0; c < a.Channels; c++ {
+			sum += int(a.Samples[i*a.Channels+c])
+		}
+		samples[i] = int16(sum / a.Channels)
+	}
+	return &Audio{SampleRate: a.SampleRate, Channels: 1, Samples: samples}
+}
+
+// Resample 用线性插值将音频转换到指定采样率
+func (a *Audio) Resample(sampleRate int) *Audio {
+	if sampleRate <= 0 || sampleRate == a.SampleRate || a.Frames() == 0 {
+		return a
+	}
+	frames := a.Frames()
+	outFrames := int(int64(frames) * int64(sampleRate) / int64(a.SampleRate))
+	samples := make([]int16, outFrames*a.Channels)
+	step := float64(a.SampleRate) / float64(sampleRate)
+	for i := 0; i < outFrames; i++ {
+		pos := float64(i) * step
+		left := int(pos)
+		right := left + 1
+		if right >= frames {
+			right = frames - 1
+		}
+		frac := pos - float64(left)
+		for c := 0; c < a.Channels; c++ {
+			l := float64(a.Samples[left*a.Channels+c])
+			r := float64(a.Samples[right*a.Channels+c])
+			samples[i*a.Channels+c] = int16(l + (r-l)*frac)
+		}
+	}
+	return &Audio{SampleRate: sampleRate, Channels: a.Channels, Samples: samples}
+}
+
+// Slice 截取 [from, to) 时间段的音频，超出范围的部分会被裁掉
+func (a *Audio) Slice(from, to time.Duration) *Audio {
+	start := a.frameAt(from)
+	end := a.frameAt(to)
+	if end < start {
+		end = start
+	}
+	return &Audio{
+		SampleRate: a.SampleRate,
+		Channels:   a.Channels,
+		Samples:    a.Samples[start*a.Channels : end*a.Channels],
+	}
+}
+
+func (a *Audio) frameAt(d time.Duration) int {
+	if d <= 0 {
+		return 0
+	}
+	frame := int(int64(d) * int64(a.SampleRate) / int64(time.Second))
+	if frame > a.Frames() {
+		return a.Frames()
+	}
+	return frame
+}
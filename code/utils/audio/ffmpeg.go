@@ -0,0 +1,57 @@
+package audio
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+)
+
+var ErrFFmpegNotFound = errors.New("ffmpeg not found in PATH")
+
+// FFmpegAvailable 是否安装了 ffmpeg
+func FFmpegAvailable() bool {
+	_, err := exec.LookPath("ffmpeg")
+	return err == nil
+}
+
+// ExtractAudio 使用 ffmpeg 从任意音视频文件中提取音轨，转换为指定采样率的单声道 PCM。
+// 输出使用裸 PCM 而不是 WAV，因为写入管道时 ffmpeg 无法回填 WAV 头中的长度。
+// mp4 等格式的索引可能位于文件末尾，无法从管道读取，因此输入会先写入临时文件
+func ExtractAudio(ctx context.Context, input io.Reader,
+	sampleRate int) (*Audio, error) {
+	path, err := exec.LookPath("ffmpeg")
+	if err != nil {
+		return nil, ErrFFmpegNotFound
+	}
+
+	tmp, err := os.CreateTemp("", "media-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = io.Copy(tmp, input)
+	tmp.Close()
+	if err != nil {
+		return nil, fmt.Errorf("saving media: %w", err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, path, "-nostdin", "-loglevel", "error",
+		"-i", tmp.Name(), "-vn", "-ac", "1", "-ar", strconv.Itoa(sampleRate),
+		"-f", "s16le", "-acodec", "pcm_s16le", "pipe:1")
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("ffmpeg: %w: %s", err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	return &Audio{
+		SampleRate: sampleRate,
+		Channels:   1,
+		Samples:    pcmToSamples(stdout.Bytes(), 16),
+	}, nil
+}
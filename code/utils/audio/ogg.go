@@ -2,14 +2,23 @@ package audio
 
 import (
 	"bytes"
+	"encoding/binary"
 	"errors"
+	"fmt"
 	"io"
 	"os"
+	"time"
 
 	"github.com/pion/opus"
 	"github.com/pion/opus/pkg/oggreader"
 )
 
+// OpusSampleRate opus 解码输出的采样率
+const OpusSampleRate = 48000
+
+// opusFrameBytes 20ms 单声道 48kHz S16LE 的字节数
+const opusFrameBytes = OpusSampleRate / 50 * 2
+
 func OggToWavByPath(ogg string, wav string) error {
 	input, err := os.Open(ogg)
 	if err != nil {
@@ -26,36 +35,90 @@ func OggToWavByPath(ogg string, wav string) error {
 	return OggToWav(input, output)
 }
 
-func OggToWav(input io.Reader, output io.WriteSeeker) error {
-	ogg, _, err := oggreader.NewWith(input)
+// OggToWav 将飞书语音使用的 Ogg/Opus 转换为 48kHz 单声道 WAV
+func OggToWav(input io.Reader, output io.Writer) error {
+	a, err := DecodeOgg(input)
 	if err != nil {
 		return err
 	}
+	return WriteWav(output, a)
+}
 
-	out := make([]byte, 1920)
+// DecodeOgg 将 Ogg/Opus 解码为 48kHz 单声道 PCM，
+// 目前只支持飞书语音使用的 SILK 模式
+func DecodeOgg(input io.Reader) (*Audio, error) {
+	ogg, header, err := oggreader.NewWith(input)
+	if err != nil {
+		return nil, fmt.Errorf("reading ogg header: %w", err)
+	}
 
+	out := make([]byte, opusFrameBytes)
 	decoder := opus.NewDecoder()
-	encoder := NewEncoder(output, 44100, 16)
+	var pcm []byte
 
 	for {
 		segments, _, err := ogg.ParseNextPage()
 		if errors.Is(err, io.EOF) {
 			break
-		} else if bytes.HasPrefix(segments[0], []byte("OpusTags")) {
-			continue
 		}
-
 		if err != nil {
-			panic(err)
+			return nil, fmt.Errorf("reading ogg page: %w", err)
+		}
+		if len(segments) == 0 || bytes.HasPrefix(segments[0], []byte("OpusTags")) {
+			continue
 		}
 
 		for i := range segments {
+			if len(segments[i]) == 0 {
+				continue
+			}
 			if _, _, err = decoder.Decode(segments[i], out); err != nil {
-				panic(err)
+				return nil, fmt.Errorf("decoding opus packet: %w", err)
 			}
-			encoder.Write(out)
+			pcm = append(pcm, out...)
 		}
 	}
-	encoder.Close()
-	return nil
+
+	// 丢弃编码器引入的前导采样
+	skip := int(header.PreSkip) * 2
+	if skip > len(pcm) {
+		skip = len(pcm)
+	}
+	pcm = pcm[skip:]
+
+	samples := make([]int16, len(pcm)/2)
+	for i := range samples {
+		samples[i] = int16(binary.LittleEndian.Uint16(pcm[i*2:]))
+	}
+	return &Audio{SampleRate: OpusSampleRate, Channels: 1, Samples: samples}, nil
+}
+
+// OggDuration 根据最后一页的 granule position 计算 Ogg/Opus 的时长，无需解码
+func OggDuration(input io.Reader) (time.Duration, error) {
+	ogg, header, err := oggreader.NewWith(input)
+	if err != nil {
+		return 0, fmt.Errorf("reading ogg header: %w", err)
+	}
+
+	var granule uint64
+	for {
+		_, page, err := ogg.ParseNextPage()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return 0, fmt.Errorf("reading ogg page: %w", err)
+		}
+		// 头部页的 granule position 为 0
+		if page.GranulePosition > granule {
+			granule = page.GranulePosition
+		}
+	}
+
+	// granule position 始终以 48kHz 计数，并包含前导采样
+	if granule < uint64(header.PreSkip) {
+		return 0, nil
+	}
+	samples := granule - uint64(header.PreSkip)
+	return time.Duration(samples) * time.Second / OpusSampleRate, nil
 }
@@ -0,0 +1,64 @@
+package audio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+// oggPage 构造一个只有一个分段的 Ogg 页
+func oggPage(headerType byte, granule uint64, seq uint32, payload []byte) []byte {
+	page := make([]byte, 27, 28+len(payload))
+	copy(page, "OggS")
+	page[5] = headerType
+	binary.LittleEndian.PutUint64(page[6:], granule)
+	binary.LittleEndian.PutUint32(page[14:], 1)
+	binary.LittleEndian.PutUint32(page[18:], seq)
+	page[26] = 1
+	page = append(page, byte(len(payload)))
+	page = append(page, payload...)
+
+	var crc uint32
+	for _, b := range page {
+		crc ^= uint32(b) << 24
+		for i := 0; i < 8; i++ {
+			if crc&0x80000000 != 0 {
+				crc = crc<<1 ^ 0x04c11db7
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	binary.LittleEndian.PutUint32(page[22:], crc)
+	return page
+}
+
+func TestOggDuration(t *testing.T) {
+	head := make([]byte, 19)
+	copy(head, "OpusHead")
+	head[8] = 1
+	head[9] = 1
+	binary.LittleEndian.PutUint16(head[10:], 312)
+	binary.LittleEndian.PutUint32(head[12:], 48000)
+
+	var ogg []byte
+	ogg = append(ogg, oggPage(2, 0, 0, head)...)
+	ogg = append(ogg, oggPage(0, 0, 1, []byte("OpusTags"))...)
+	ogg = append(ogg, oggPage(0, 312+24000, 2, []byte{0})...)
+	ogg = append(ogg, oggPage(4, 312+72000, 3, []byte{0})...)
+
+	got, err := OggDuration(bytes.NewReader(ogg))
+	if err != nil {
+		t.Fatalf("OggDuration() error = %v", err)
+	}
+	if want := 1500 * time.Millisecond; got != want {
+		t.Errorf("OggDuration() = %v, want %v", got, want)
+	}
+}
+
+func TestDecodeOggInvalid(t *testing.T) {
+	if _, err := DecodeOgg(bytes.NewReader([]byte("not an ogg file"))); err == nil {
+		t.Error("DecodeOgg() error = nil, want error")
+	}
+}
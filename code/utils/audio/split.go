@@ -0,0 +1,33 @@
+package audio
+
+import "time"
+
+// Segment 长音频切分后的一段，Start 为该段在原音频中的起始时间
+type Segment struct {
+	Start time.Duration
+	Audio *Audio
+}
+
+// Split 将音频切分为每段 size 长度、相邻两段重叠 overlap 的片段，
+// 重叠部分用于拼接转写结果时对齐被切断的词句。音频不超过 size 时只返回一段
+func Split(a *Audio, size, overlap time.Duration) []Segment {
+	total := a.Duration()
+	if size <= 0 || total <= size {
+		return []Segment{{Start: 0, Audio: a}}
+	}
+	if overlap < 0 || overlap >= size {
+		overlap = 0
+	}
+
+	var segments []Segment
+	step := size - overlap
+	for start := time.Duration(0); start < total; start += step {
+		end := start + size
+		segments = append(segments, Segment{Start: start,
+			Audio: a.Slice(start, end)})
+		if end >= total {
+			break
+		}
+	}
+	return segments
+}
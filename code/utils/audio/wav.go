@@ -1,77 +1,65 @@
 package audio
 
 import (
+	"bytes"
 	"encoding/binary"
+	"errors"
+	"fmt"
 	"io"
 )
 
+const wavHeaderSize = 44
+
+// Encoder 以流式方式写入 WAV，数据长度在 Close 时回填到文件头中
 type Encoder struct {
 	Output          io.WriteSeeker
-	SampleRate      int
-	BitDepth        int
+	Format          Format
 	totalBytes      uint32
 	isHeaderWritten bool
 }
 
 func (e *Encoder) WriteHeader() error {
-	if err := writeLe(e.Output, []byte("RIFF")); err != nil {
-		return err
-	}
-
-	if err := writeLe(e.Output, uint32(0)); err != nil { // Placeholder for file size
-		return err
-	}
-
-	if err := writeLe(e.Output, []byte("WAVE")); err != nil {
-		return err
-	}
-
-	if err := writeLe(e.Output, []byte("fmt ")); err != nil {
-		return err
-	}
-	if err := writeLe(e.Output, uint32(16)); err != nil {
-		return err
-	}
-
-	if err := writeLe(e.Output, uint16(1)); err != nil { // Audio format: PCM
-		return err
-	}
-	if err := writeLe(e.Output, uint16(1)); err != nil { // Number of channels: 1 (mono)
-		return err
-	}
-	if err := writeLe(e.Output, uint32(e.SampleRate)); err != nil {
-		return err
-	}
-
-	if err := writeLe(e.Output, uint32(e.SampleRate*e.BitDepth/8)); err != nil {
-		return err
-	}
-
-	if err := writeLe(e.Output, uint16(e.BitDepth/8)); err != nil {
-		return err
-	}
-	if err := writeLe(e.Output, uint16(e.BitDepth)); err != nil {
+	if err := e.Format.Validate(); err != nil {
 		return err
 	}
-
-	if err := writeLe(e.Output, []byte("data")); err != nil {
-		return err
-	}
-
-	if err := writeLe(e.Output, uint32(0)); err != nil { //Placeholder for data size
+	if err := writeHeader(e.Output, e.Format, 0); err != nil {
 		return err
 	}
 	e.isHeaderWritten = true
 	return nil
 }
 
-func writeLe[T []byte | uint32 | uint16 | uint8](w io.Writer, data T) error {
-	return binary.Write(w, binary.LittleEndian, data)
+// writeHeader 写入 44 字节的 PCM WAV 文件头
+func writeHeader(w io.Writer, format Format, dataSize uint32) error {
+	blockAlign := format.Channels * format.BitDepth / 8
+	fields := []interface{}{
+		[]byte("RIFF"),
+		uint32(36 + dataSize),
+		[]byte("WAVE"),
+		[]byte("fmt "),
+		uint32(16),
+		uint16(1), // Audio format: PCM
+		uint16(format.Channels),
+		uint32(format.SampleRate),
+		uint32(format.SampleRate * blockAlign),
+		uint16(blockAlign),
+		uint16(format.BitDepth),
+		[]byte("data"),
+		dataSize,
+	}
+	for _, field := range fields {
+		if err := binary.Write(w, binary.LittleEndian, field); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func (e *Encoder) Write(data []byte) error {
 	if !e.isHeaderWritten {
-		e.WriteHeader()
+		if err := e.WriteHeader(); err != nil {
+			return err
+		}
 	}
 	n, err := e.Output.Write(data)
 	if err != nil {
@@ -82,6 +70,11 @@ func (e *Encoder) Write(data []byte) error {
 }
 
 func (e *Encoder) Close() error {
+	if !e.isHeaderWritten {
+		if err := e.WriteHeader(); err != nil {
+			return err
+		}
+	}
 	if _, err := e.Output.Seek(4, io.SeekStart); err != nil {
 		return err
 	}
@@ -94,14 +87,121 @@ func (e *Encoder) Close() error {
 	if err := binary.Write(e.Output, binary.LittleEndian, e.totalBytes); err != nil {
 		return err
 	}
-	return nil
+	_, err := e.Output.Seek(0, io.SeekEnd)
+	return err
 }
 
-func NewEncoder(w io.WriteSeeker, sampleRate int, bitDepth int) *Encoder {
+func NewEncoder(w io.WriteSeeker, format Format) *Encoder {
 	return &Encoder{
-		SampleRate:      sampleRate,
 		Output:          w,
-		BitDepth:        bitDepth,
+		Format:          format,
 		isHeaderWritten: false,
 	}
 }
+
+// WriteWav 将音频编码为 16 位 PCM WAV，输出不需要支持 Seek
+func WriteWav(w io.Writer, a *Audio) error {
+	format := a.Format()
+	if err := format.Validate(); err != nil {
+		return err
+	}
+	if err := writeHeader(w, format, uint32(len(a.Samples)*2)); err != nil {
+		return err
+	}
+	return binary.Write(w, binary.LittleEndian, a.Samples)
+}
+
+// EncodeWav 将音频编码为内存中的 WAV 数据
+func EncodeWav(a *Audio) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.Grow(wavHeaderSize + len(a.Samples)*2)
+	if err := WriteWav(&buf, a); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+var ErrNotWav = errors.New("not a RIFF/WAVE file")
+
+// ReadWav 解析 PCM WAV，会跳过 LIST 等非音频数据块，8 位采样会被转换为 16 位
+func ReadWav(r io.Reader) (*Audio, error) {
+	var riff [12]byte
+	if _, err := io.ReadFull(r, riff[:]); err != nil {
+		return nil, fmt.Errorf("reading wav header: %w", err)
+	}
+	if string(riff[0:4]) != "RIFF" || string(riff[8:12]) != "WAVE" {
+		return nil, ErrNotWav
+	}
+
+	var format Format
+	var hasFormat bool
+	for {
+		var chunk [8]byte
+		if _, err := io.ReadFull(r, chunk[:]); err != nil {
+			return nil, fmt.Errorf("reading wav chunk: %w", err)
+		}
+		id := string(chunk[0:4])
+		size := binary.LittleEndian.Uint32(chunk[4:8])
+
+		switch id {
+		case "fmt ":
+			body := make([]byte, size)
+			if _, err := io.ReadFull(r, body); err != nil {
+				return nil, fmt.Errorf("reading fmt chunk: %w", err)
+			}
+			if size < 16 {
+				return nil, fmt.Errorf("fmt chunk too short: %d", size)
+			}
+			if audioFormat := binary.LittleEndian.Uint16(body[0:2]); audioFormat != 1 {
+				return nil, fmt.Errorf("unsupported wav audio format: %d", audioFormat)
+			}
+			format.Channels = int(binary.LittleEndian.Uint16(body[2:4]))
+			format.SampleRate = int(binary.LittleEndian.Uint32(body[4:8]))
+			format.BitDepth = int(binary.LittleEndian.Uint16(body[14:16]))
+			if err := format.Validate(); err != nil {
+				return nil, err
+			}
+			hasFormat = true
+		case "data":
+			if !hasFormat {
+				return nil, errors.New("wav data chunk before fmt chunk")
+			}
+			// 流式写入的 WAV 长度可能是 0 或 0xFFFFFFFF，此时读到结尾为止
+			var data []byte
+			var err error
+			if size == 0 || size == 0xFFFFFFFF {
+				data, err = io.ReadAll(r)
+			} else {
+				data, err = io.ReadAll(io.LimitReader(r, int64(size)))
+			}
+			if err != nil {
+				return nil, fmt.Errorf("reading data chunk: %w", err)
+			}
+			return &Audio{
+				SampleRate: format.SampleRate,
+				Channels:   format.Channels,
+				Samples:    pcmToSamples(data, format.BitDepth),
+			}, nil
+		default:
+			// 数据块按偶数字节对齐
+			if _, err := io.CopyN(io.Discard, r, int64(size+size%2)); err != nil {
+				return nil, fmt.Errorf("skipping %q chunk: %w", id, err)
+			}
+		}
+	}
+}
+
+func pcmToSamples(data []byte, bitDepth int) []int16 {
+	if bitDepth == 8 {
+		samples := make([]int16, len(data))
+		for i, b := range data {
+			samples[i] = int16(int(b)-128) << 8
+		}
+		return samples
+	}
+	samples := make([]int16, len(data)/2)
+	for i := range samples {
+		samples[i] = int16(binary.LittleEndian.Uint16(data[i*2:]))
+	}
+	return samples
+}
@@ -0,0 +1,177 @@
+package audio
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"testing"
+	"time"
+)
+
+const testWav = "../../services/openai/test_file/test.wav"
+
+func TestReadWav(t *testing.T) {
+	f, err := os.Open(testWav)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	a, err := ReadWav(f)
+	if err != nil {
+		t.Fatalf("ReadWav() error = %v", err)
+	}
+	if a.SampleRate != 11025 || a.Channels != 2 {
+		t.Errorf("ReadWav() format = %d Hz %d ch, want 11025 Hz 2 ch",
+			a.SampleRate, a.Channels)
+	}
+	if len(a.Samples) != 0x9d80 {
+		t.Errorf("ReadWav() samples = %d, want %d", len(a.Samples), 0x9d80)
+	}
+}
+
+func TestReadWavNotWav(t *testing.T) {
+	_, err := ReadWav(bytes.NewReader([]byte("OggS0000000000000000")))
+	if !errors.Is(err, ErrNotWav) {
+		t.Errorf("ReadWav() error = %v, want %v", err, ErrNotWav)
+	}
+}
+
+func TestWriteWavRoundTrip(t *testing.T) {
+	want := &Audio{SampleRate: 48000, Channels: 1,
+		Samples: []int16{0, 1, -1, 32767, -32768, 1234}}
+	data, err := EncodeWav(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != wavHeaderSize+len(want.Samples)*2 {
+		t.Errorf("EncodeWav() size = %d", len(data))
+	}
+
+	got, err := ReadWav(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.SampleRate != want.SampleRate || got.Channels != want.Channels {
+		t.Errorf("ReadWav() format = %+v, want %+v", got.Format(), want.Format())
+	}
+	for i := range want.Samples {
+		if got.Samples[i] != want.Samples[i] {
+			t.Fatalf("ReadWav() samples = %v, want %v", got.Samples, want.Samples)
+		}
+	}
+}
+
+// seekBuffer 内存中的 io.WriteSeeker
+type seekBuffer struct {
+	buf []byte
+	pos int
+}
+
+func (s *seekBuffer) Write(p []byte) (int, error) {
+	if end := s.pos + len(p); end > len(s.buf) {
+		s.buf = append(s.buf, make([]byte, end-len(s.buf))...)
+	}
+	n := copy(s.buf[s.pos:], p)
+	s.pos += n
+	return n, nil
+}
+
+func (s *seekBuffer) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+		s.pos = int(offset)
+	case io.SeekCurrent:
+		s.pos += int(offset)
+	case io.SeekEnd:
+		s.pos = len(s.buf) + int(offset)
+	}
+	return int64(s.pos), nil
+}
+
+func TestEncoder(t *testing.T) {
+	out := &seekBuffer{}
+	e := NewEncoder(out, Format{SampleRate: 16000, Channels: 1, BitDepth: 16})
+	for i := 0; i < 3; i++ {
+		if err := e.Write([]byte{1, 0, 2, 0}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := e.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	a, err := ReadWav(bytes.NewReader(out.buf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.SampleRate != 16000 || len(a.Samples) != 6 {
+		t.Errorf("ReadWav() = %d Hz %d samples, want 16000 Hz 6 samples",
+			a.SampleRate, len(a.Samples))
+	}
+}
+
+func TestEncoderInvalidFormat(t *testing.T) {
+	e := NewEncoder(&seekBuffer{}, Format{SampleRate: 16000, Channels: 1, BitDepth: 24})
+	if err := e.Close(); err == nil {
+		t.Error("Close() error = nil, want error for 24 bit")
+	}
+}
+
+func TestAudioMonoResampleSlice(t *testing.T) {
+	stereo := &Audio{SampleRate: 4, Channels: 2,
+		Samples: []int16{0, 100, 100, 200, 200, 300, 300, 400}}
+
+	mono := stereo.Mono()
+	if want := []int16{50, 150, 250, 350}; !equalSamples(mono.Samples, want) {
+		t.Errorf("Mono() = %v, want %v", mono.Samples, want)
+	}
+	if mono.Duration() != time.Second {
+		t.Errorf("Duration() = %v, want 1s", mono.Duration())
+	}
+
+	up := mono.Resample(8)
+	if want := []int16{50, 100, 150, 200, 250, 300, 350, 350}; !equalSamples(up.Samples, want) {
+		t.Errorf("Resample(8) = %v, want %v", up.Samples, want)
+	}
+
+	slice := stereo.Slice(250*time.Millisecond, 10*time.Second)
+	if want := []int16{100, 200, 200, 300, 300, 400}; !equalSamples(slice.Samples, want) {
+		t.Errorf("Slice() = %v, want %v", slice.Samples, want)
+	}
+}
+
+func equalSamples(a, b []int16) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestSplit(t *testing.T) {
+	a := &Audio{SampleRate: 10, Channels: 1, Samples: make([]int16, 250)}
+
+	segments := Split(a, 10*time.Second, 2*time.Second)
+	wantStarts := []time.Duration{0, 8 * time.Second, 16 * time.Second}
+	if len(segments) != len(wantStarts) {
+		t.Fatalf("Split() = %d segments, want %d", len(segments), len(wantStarts))
+	}
+	for i, s := range segments {
+		if s.Start != wantStarts[i] {
+			t.Errorf("segment %d start = %v, want %v", i, s.Start, wantStarts[i])
+		}
+	}
+	if got := segments[2].Audio.Duration(); got != 9*time.Second {
+		t.Errorf("last segment duration = %v, want 9s", got)
+	}
+
+	if got := Split(a, time.Minute, time.Second); len(got) != 1 {
+		t.Errorf("Split() short audio = %d segments, want 1", len(got))
+	}
+}
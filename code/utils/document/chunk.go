@@ -0,0 +1,185 @@
+package document
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	// DefaultChunkSize 每个片段的字符数，兼顾检索精度和上下文长度
+	DefaultChunkSize = 1000
+	// DefaultChunkOverlap 相邻片段重叠的字符数，避免句子被切断后无法命中
+	DefaultChunkOverlap = 100
+)
+
+// Chunk 按行把文本切分为不超过 size 个字符的片段，相邻片段保留约 overlap 个字符的重叠
+func Chunk(text string, size, overlap int) []string {
+	if size <= 0 {
+		size = DefaultChunkSize
+	}
+	if overlap < 0 || overlap >= size {
+		overlap = 0
+	}
+
+	var pieces []string
+	for _, line := range strings.Split(text, "\n") {
+		runes := []rune(line)
+		for len(runes) > size {
+			pieces = append(pieces, string(runes[:size]))
+			runes = runes[size:]
+		}
+		pieces = append(pieces, string(runes))
+	}
+
+	var chunks []string
+	var current []string
+	length := 0
+	// fresh 表示 current 中有上一个片段之后新加入的行
+	fresh := false
+	flush := func() {
+		chunk := strings.TrimSpace(strings.Join(current, "\n"))
+		if chunk != "" {
+			chunks = append(chunks, chunk)
+		}
+		// 从末尾保留若干完整的行作为下一个片段的开头
+		kept := 0
+		i := len(current)
+		for i > 0 {
+			n := utf8.RuneCountInString(current[i-1]) + 1
+			if kept+n > overlap {
+				break
+			}
+			kept += n
+			i--
+		}
+		current = append([]string{}, current[i:]...)
+		length = kept
+		fresh = false
+	}
+	for _, piece := range pieces {
+		n := utf8.RuneCountInString(piece) + 1
+		if length+n > size && length > 0 {
+			flush()
+			// 重叠部分加上当前行仍然超长时放弃重叠
+			if length+n > size {
+				current, length = nil, 0
+			}
+		}
+		current = append(current, piece)
+		length += n
+		fresh = true
+	}
+	if fresh {
+		flush()
+	}
+	return chunks
+}
+
+// Rank 用 BM25 按与问题的相关度对片段排序，返回最相关的 k 个片段的下标。
+// 英文按单词匹配，中日韩文字按相邻两字匹配
+func Rank(query string, chunks []string, k int) []int {
+	queryTerms := terms(query)
+	if len(queryTerms) == 0 || len(chunks) == 0 {
+		return nil
+	}
+
+	const k1, b = 1.2, 0.75
+	freqs := make([]map[string]int, len(chunks))
+	lengths := make([]int, len(chunks))
+	df := make(map[string]int)
+	total := 0
+	for i, chunk := range chunks {
+		freqs[i] = make(map[string]int)
+		for _, term := range terms(chunk) {
+			freqs[i][term]++
+			lengths[i]++
+		}
+		for term := range freqs[i] {
+			df[term]++
+		}
+		total += lengths[i]
+	}
+	avg := float64(total) / float64(len(chunks))
+	if avg == 0 {
+		return nil
+	}
+
+	unique := make(map[string]bool)
+	scores := make([]float64, len(chunks))
+	for _, term := range queryTerms {
+		if unique[term] || df[term] == 0 {
+			continue
+		}
+		unique[term] = true
+		n := float64(df[term])
+		idf := math.Log(1 + (float64(len(chunks))-n+0.5)/(n+0.5))
+		for i := range chunks {
+			tf := float64(freqs[i][term])
+			if tf == 0 {
+				continue
+			}
+			norm := 1 - b + b*float64(lengths[i])/avg
+			scores[i] += idf * tf * (k1 + 1) / (tf + k1*norm)
+		}
+	}
+
+	var result []int
+	for i, score := range scores {
+		if score > 0 {
+			result = append(result, i)
+		}
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		return scores[result[i]] > scores[result[j]]
+	})
+	if k > 0 && len(result) > k {
+		result = result[:k]
+	}
+	return result
+}
+
+// terms 把文本拆分为检索用的词项
+func terms(text string) []string {
+	var result []string
+	var word []rune
+	var cjk []rune
+	flushWord := func() {
+		if len(word) > 1 || (len(word) == 1 && unicode.IsDigit(word[0])) {
+			result = append(result, string(word))
+		}
+		word = word[:0]
+	}
+	flushCJK := func() {
+		if len(cjk) == 1 {
+			result = append(result, string(cjk))
+		}
+		for i := 0; i+1 < len(cjk); i++ {
+			result = append(result, string(cjk[i:i+2]))
+		}
+		cjk = cjk[:0]
+	}
+	for _, r := range strings.ToLower(text) {
+		switch {
+		case isCJK(r):
+			flushWord()
+			cjk = append(cjk, r)
+		case unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_':
+			flushCJK()
+			word = append(word, r)
+		default:
+			flushWord()
+			flushCJK()
+		}
+	}
+	flushWord()
+	flushCJK()
+	return result
+}
+
+func isCJK(r rune) bool {
+	return unicode.Is(unicode.Han, r) || unicode.Is(unicode.Hiragana, r) ||
+		unicode.Is(unicode.Katakana, r) || unicode.Is(unicode.Hangul, r)
+}
@@ -0,0 +1,108 @@
+package document
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
+// ErrUnsupported 不支持的文件类型
+var ErrUnsupported = errors.New("unsupported document type")
+
+// textExtensions 按纯文本读取的扩展名，包括常见的源代码
+var textExtensions = map[string]bool{
+	".txt": true, ".md": true, ".markdown": true, ".rst": true, ".log": true,
+	".json": true, ".yaml": true, ".yml": true, ".toml": true, ".ini": true,
+	".xml": true, ".html": true, ".htm": true, ".sql": true, ".proto": true,
+	".go": true, ".py": true, ".js": true, ".ts": true, ".tsx": true,
+	".jsx": true, ".java": true, ".kt": true, ".c": true, ".h": true,
+	".cc": true, ".cpp": true, ".hpp": true, ".cs": true, ".rs": true,
+	".rb": true, ".php": true, ".swift": true, ".scala": true, ".lua": true,
+	".sh": true, ".bash": true, ".vue": true, ".css": true, ".scss": true,
+	".dart": true, ".r": true, ".m": true, ".gradle": true, ".conf": true,
+}
+
+// IsSupported 根据文件名判断是否可以提取文字
+func IsSupported(fileName string) bool {
+	switch ext := strings.ToLower(filepath.Ext(fileName)); ext {
+	case ".pdf", ".docx", ".xlsx", ".csv", ".tsv":
+		return true
+	default:
+		return textExtensions[ext]
+	}
+}
+
+// Extract 根据扩展名提取文档中的文字，表格按行输出，单元格之间用制表符分隔
+func Extract(fileName string, data []byte) (string, error) {
+	var text string
+	var err error
+	switch ext := strings.ToLower(filepath.Ext(fileName)); {
+	case ext == ".pdf":
+		text, err = ExtractPDF(data)
+	case ext == ".docx":
+		text, err = ExtractDocx(data)
+	case ext == ".xlsx":
+		text, err = ExtractXlsx(data)
+	case ext == ".csv", ext == ".tsv", textExtensions[ext]:
+		text, err = decodeText(data)
+	default:
+		return "", fmt.Errorf("%w: %s", ErrUnsupported, ext)
+	}
+	if err != nil {
+		return "", err
+	}
+	return normalize(text), nil
+}
+
+// decodeText 读取 UTF-8 或带 BOM 的 UTF-16 文本
+func decodeText(data []byte) (string, error) {
+	switch {
+	case bytes.HasPrefix(data, []byte{0xEF, 0xBB, 0xBF}):
+		data = data[3:]
+	case bytes.HasPrefix(data, []byte{0xFF, 0xFE}):
+		return decodeUTF16(data[2:], false), nil
+	case bytes.HasPrefix(data, []byte{0xFE, 0xFF}):
+		return decodeUTF16(data[2:], true), nil
+	}
+	if !utf8.Valid(data) {
+		return "", errors.New("文件不是 UTF-8 编码的文本")
+	}
+	return string(data), nil
+}
+
+func decodeUTF16(data []byte, bigEndian bool) string {
+	units := make([]uint16, len(data)/2)
+	for i := range units {
+		if bigEndian {
+			units[i] = uint16(data[2*i])<<8 | uint16(data[2*i+1])
+		} else {
+			units[i] = uint16(data[2*i+1])<<8 | uint16(data[2*i])
+		}
+	}
+	return utf16ToString(units)
+}
+
+// normalize 统一换行符，去掉行尾空白并合并连续的空行
+func normalize(text string) string {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	text = strings.ReplaceAll(text, "\r", "\n")
+	lines := strings.Split(text, "\n")
+	var result []string
+	blank := false
+	for _, line := range lines {
+		line = strings.TrimRight(line, " \t ")
+		if line == "" {
+			if !blank && len(result) > 0 {
+				result = append(result, "")
+			}
+			blank = true
+			continue
+		}
+		blank = false
+		result = append(result, line)
+	}
+	return strings.TrimSpace(strings.Join(result, "\n"))
+}
@@ -0,0 +1,274 @@
+package document
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// zipFiles 在内存中生成包含指定文件的压缩包
+func zipFiles(t *testing.T, files map[string]string) []byte {
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for name, content := range files {
+		f, err := w.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Write([]byte(content))
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func flate(data string) string {
+	var buf bytes.Buffer
+	w := zlib.NewWriter(&buf)
+	w.Write([]byte(data))
+	w.Close()
+	return buf.String()
+}
+
+// buildPDF 按顺序拼接对象并生成最简单的 PDF，对象编号从 1 开始，
+// 空字符串占位的编号不写入文件，用于存放在对象流中的对象
+func buildPDF(objects ...string) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("%PDF-1.4\n")
+	for i, obj := range objects {
+		if obj == "" {
+			continue
+		}
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", i+1, obj)
+	}
+	buf.WriteString("trailer\n<< /Root 1 0 R >>\n%%EOF\n")
+	return buf.Bytes()
+}
+
+func stream(dict, data string) string {
+	return fmt.Sprintf("<< %s /Length %d >>\nstream\n%s\nendstream", dict, len(data), data)
+}
+
+func TestExtractDocx(t *testing.T) {
+	body := `<?xml version="1.0" encoding="UTF-8"?>
+<w:document xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main"><w:body>
+<w:p><w:r><w:t>第一段</w:t></w:r><w:r><w:t xml:space="preserve"> 继续</w:t></w:r></w:p>
+<w:p><w:r><w:t>A</w:t><w:tab/><w:t>B</w:t></w:r></w:p>
+<w:tbl><w:tr><w:tc><w:p><w:r><w:t>姓名</w:t></w:r></w:p></w:tc><w:tc><w:p><w:r><w:t>年龄</w:t></w:r></w:p></w:tc></w:tr>
+<w:tr><w:tc><w:p><w:r><w:t>张三</w:t></w:r></w:p></w:tc><w:tc><w:p><w:r><w:t>18</w:t></w:r></w:p></w:tc></w:tr></w:tbl>
+<w:p><w:r><w:t>结尾</w:t></w:r></w:p>
+</w:body></w:document>`
+	data := zipFiles(t, map[string]string{"word/document.xml": body})
+	text, err := Extract("a.docx", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "第一段 继续\nA\tB\n姓名\t年龄\n张三\t18\n结尾"
+	if text != want {
+		t.Errorf("got %q, want %q", text, want)
+	}
+}
+
+func TestExtractXlsx(t *testing.T) {
+	files := map[string]string{
+		"xl/workbook.xml": `<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">
+<sheets><sheet name="销售" sheetId="1" r:id="rId1"/></sheets></workbook>`,
+		"xl/_rels/workbook.xml.rels": `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rId1" Target="worksheets/data.xml"/></Relationships>`,
+		"xl/sharedStrings.xml": `<sst><si><t>产品</t></si><si><r><t>数</t></r><r><t>量</t></r></si><si><t>苹果</t></si></sst>`,
+		"xl/worksheets/data.xml": `<worksheet><sheetData>
+<row r="1"><c r="A1" t="s"><v>0</v></c><c r="C1" t="s"><v>1</v></c></row>
+<row r="2"><c r="A2" t="s"><v>2</v></c><c r="B2" t="b"><v>1</v></c><c r="C2"><v>12</v></c></row>
+<row r="3"><c r="A3" t="inlineStr"><is><t>梨</t></is></c></row>
+</sheetData></worksheet>`,
+	}
+	text, err := Extract("a.xlsx", zipFiles(t, files))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "## 销售\n产品\t\t数量\n苹果\tTRUE\t12\n梨"
+	if text != want {
+		t.Errorf("got %q, want %q", text, want)
+	}
+}
+
+func TestExtractPDF(t *testing.T) {
+	toUnicode := `/CIDInit /ProcSet findresource begin
+begincmap
+1 begincodespacerange <0000> <FFFF> endcodespacerange
+2 beginbfchar
+<0001> <4F60>
+<0002> <597D>
+endbfchar
+1 beginbfrange
+<0010> <0012> <0041>
+endbfrange
+endcmap`
+	page1 := `BT /F1 12 Tf 72 720 Td (Hello) Tj 40 0 Td [(Wor) -20 (ld) -500 (again)] TJ 0 -14 Td (Line \(2\)) Tj ET`
+	page2 := `BT /F2 12 Tf 1 0 0 1 72 720 Tm <00010002> Tj 1 0 0 1 72 700 Tm <001000110012> Tj ET`
+
+	tests := []struct {
+		name string
+		data []byte
+		want string
+		err  error
+	}{
+		{
+			name: "pages with inherited resources",
+			data: buildPDF(
+				"<< /Type /Catalog /Pages 2 0 R >>",
+				"<< /Type /Pages /Kids [3 0 R 4 0 R] /Count 2 /Resources << /Font << /F1 5 0 R /F2 6 0 R >> >> >>",
+				"<< /Type /Page /Parent 2 0 R /Contents 7 0 R >>",
+				"<< /Type /Page /Parent 2 0 R /Contents [8 0 R] >>",
+				"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica >>",
+				"<< /Type /Font /Subtype /Type0 /BaseFont /SimSun /ToUnicode 9 0 R >>",
+				stream("", page1),
+				stream("/Filter /FlateDecode", flate(page2)),
+				stream("/Filter /FlateDecode", flate(toUnicode)),
+			),
+			want: "Hello World again\nLine (2)\n\n你好\nABC",
+		},
+		{
+			name: "objects in object stream",
+			data: buildPDF(
+				"<< /Type /Catalog /Pages 3 0 R >>",
+				stream("/Type /ObjStm /N 2 /First 9 /Filter /FlateDecode",
+					flate("3 0 4 43 << /Type /Pages /Kids [4 0 R] /Count 1 >>  "+
+						"<< /Type /Page /Parent 3 0 R /Contents 5 0 R >>")),
+				"",
+				"",
+				stream("", "BT (packed) Tj ET"),
+			),
+			want: "packed",
+		},
+		{
+			name: "scanned",
+			data: buildPDF(
+				"<< /Type /Catalog /Pages 2 0 R >>",
+				"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+				"<< /Type /Page /Parent 2 0 R /Contents 4 0 R >>",
+				stream("", "q 100 0 0 100 0 0 cm /Im1 Do Q"),
+			),
+			err: ErrNoText,
+		},
+		{
+			name: "encrypted",
+			data: append(buildPDF("<< /Type /Catalog >>"), "<< /Encrypt 9 0 R >>"...),
+			err:  ErrEncrypted,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			text, err := Extract("a.pdf", tt.data)
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("got error %v, want %v", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if text != tt.want {
+				t.Errorf("got %q, want %q", text, tt.want)
+			}
+		})
+	}
+}
+
+func TestExtractText(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		data    []byte
+		want    string
+		wantErr bool
+	}{
+		{"markdown", "a.md", []byte("# 标题\r\n\r\n\r\n正文  \n"), "# 标题\n\n正文", false},
+		{"utf8 bom", "a.csv", []byte("\xEF\xBB\xBFa,b\n1,2"), "a,b\n1,2", false},
+		{"utf16le", "a.txt", []byte{0xFF, 0xFE, 'h', 0, 'i', 0}, "hi", false},
+		{"source", "main.go", []byte("package main\n"), "package main", false},
+		{"gbk", "a.txt", []byte{0xC4, 0xE3, 0xBA, 0xC3}, "", true},
+		{"unsupported", "a.exe", []byte("MZ"), "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			text, err := Extract(tt.file, tt.data)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, wantErr %v", err, tt.wantErr)
+			}
+			if text != tt.want {
+				t.Errorf("got %q, want %q", text, tt.want)
+			}
+		})
+	}
+	if IsSupported("a.exe") || !IsSupported("报告.PDF") {
+		t.Error("IsSupported mismatch")
+	}
+}
+
+func TestChunk(t *testing.T) {
+	var lines []string
+	for i := 0; i < 30; i++ {
+		lines = append(lines, fmt.Sprintf("line %02d %s", i, strings.Repeat("x", 20)))
+	}
+	chunks := Chunk(strings.Join(lines, "\n"), 100, 30)
+	if len(chunks) < 2 {
+		t.Fatalf("got %d chunks", len(chunks))
+	}
+	for i, chunk := range chunks {
+		if n := len([]rune(chunk)); n > 100 {
+			t.Errorf("chunk %d has %d runes", i, n)
+		}
+		if i > 0 {
+			// 上一个片段的最后一行出现在下一个片段开头
+			prev := strings.Split(chunks[i-1], "\n")
+			if !strings.HasPrefix(chunk, prev[len(prev)-1]) {
+				t.Errorf("chunk %d does not overlap: %q", i, chunk)
+			}
+		}
+	}
+	if !strings.HasSuffix(chunks[len(chunks)-1], lines[len(lines)-1]) {
+		t.Error("last line missing")
+	}
+
+	long := Chunk(strings.Repeat("长", 250), 100, 10)
+	if len(long) != 3 || len([]rune(long[2])) != 50 {
+		t.Errorf("long line split into %d chunks", len(long))
+	}
+	if got := Chunk("  \n\n ", 100, 10); len(got) != 0 {
+		t.Errorf("got %q for blank text", got)
+	}
+}
+
+func TestRank(t *testing.T) {
+	chunks := []string{
+		"公司的年假制度：入职满一年可享受五天带薪年假。",
+		"报销流程需要先在系统中提交申请，再由主管审批。",
+		"The deployment pipeline runs integration tests before release.",
+		"加班需要提前申请，周末加班可以调休。",
+	}
+	tests := []struct {
+		query string
+		want  int
+	}{
+		{"年假有几天？", 0},
+		{"怎么报销", 1},
+		{"When do integration tests run?", 2},
+		{"周末加班怎么算", 3},
+	}
+	for _, tt := range tests {
+		got := Rank(tt.query, chunks, 2)
+		if len(got) == 0 || got[0] != tt.want {
+			t.Errorf("Rank(%q) = %v, want first %d", tt.query, got, tt.want)
+		}
+	}
+	if got := Rank("？？", chunks, 2); got != nil {
+		t.Errorf("got %v for empty query", got)
+	}
+}
@@ -0,0 +1,256 @@
+package document
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// maxZipEntrySize 单个压缩文件条目解压后的大小上限，防止压缩炸弹
+const maxZipEntrySize = 64 << 20
+
+func readZipFile(r *zip.Reader, name string) ([]byte, error) {
+	for _, f := range r.File {
+		if f.Name != name {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer rc.Close()
+		data, err := io.ReadAll(io.LimitReader(rc, maxZipEntrySize+1))
+		if err != nil {
+			return nil, err
+		}
+		if len(data) > maxZipEntrySize {
+			return nil, fmt.Errorf("%s is too large", name)
+		}
+		return data, nil
+	}
+	return nil, fmt.Errorf("%s not found", name)
+}
+
+// ExtractDocx 提取 Word 文档正文，段落之间换行，表格单元格之间用制表符分隔
+func ExtractDocx(data []byte) (string, error) {
+	r, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return "", fmt.Errorf("reading docx: %w", err)
+	}
+	body, err := readZipFile(r, "word/document.xml")
+	if err != nil {
+		return "", fmt.Errorf("reading docx: %w", err)
+	}
+
+	var sb strings.Builder
+	decoder := xml.NewDecoder(bytes.NewReader(body))
+	inText := false
+	cellStart := false
+	// cellPara 单元格内已经结束了一个段落，后续文字之前需要空格
+	cellPara := false
+	for {
+		token, err := decoder.Token()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return "", fmt.Errorf("parsing docx: %w", err)
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "t":
+				inText = true
+			case "tab":
+				sb.WriteByte('\t')
+			case "br", "cr":
+				sb.WriteByte('\n')
+			case "tc":
+				if cellStart {
+					sb.WriteByte('\t')
+				}
+				cellStart = true
+				cellPara = false
+			case "tr":
+				cellStart = false
+			}
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "t":
+				inText = false
+			case "p":
+				// 单元格内的段落用空格连接，保持一行一个表格行
+				if cellStart {
+					cellPara = true
+				} else {
+					sb.WriteByte('\n')
+				}
+			case "tr":
+				cellStart = false
+				cellPara = false
+				sb.WriteByte('\n')
+			}
+		case xml.CharData:
+			if inText {
+				if cellPara {
+					sb.WriteByte(' ')
+					cellPara = false
+				}
+				sb.Write(t)
+			}
+		}
+	}
+	return sb.String(), nil
+}
+
+type xlsxSharedStrings struct {
+	Items []struct {
+		Text string `xml:"t"`
+		Runs []struct {
+			Text string `xml:"t"`
+		} `xml:"r"`
+	} `xml:"si"`
+}
+
+type xlsxWorkbook struct {
+	Sheets []struct {
+		Name string `xml:"name,attr"`
+		Id   string `xml:"http://schemas.openxmlformats.org/officeDocument/2006/relationships id,attr"`
+	} `xml:"sheets>sheet"`
+}
+
+type xlsxRelationships struct {
+	Relationships []struct {
+		Id     string `xml:"Id,attr"`
+		Target string `xml:"Target,attr"`
+	} `xml:"Relationship"`
+}
+
+type xlsxWorksheet struct {
+	Rows []struct {
+		Cells []struct {
+			Ref    string `xml:"r,attr"`
+			Type   string `xml:"t,attr"`
+			Value  string `xml:"v"`
+			Inline struct {
+				Text string `xml:"t"`
+			} `xml:"is"`
+		} `xml:"c"`
+	} `xml:"sheetData>row"`
+}
+
+// ExtractXlsx 按工作表提取 Excel 中的单元格内容，每个工作表以标题开头
+func ExtractXlsx(data []byte) (string, error) {
+	r, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return "", fmt.Errorf("reading xlsx: %w", err)
+	}
+
+	var shared xlsxSharedStrings
+	// 没有字符串的表格不包含 sharedStrings.xml
+	if raw, err := readZipFile(r, "xl/sharedStrings.xml"); err == nil {
+		if err := xml.Unmarshal(raw, &shared); err != nil {
+			return "", fmt.Errorf("parsing shared strings: %w", err)
+		}
+	}
+	strs := make([]string, len(shared.Items))
+	for i, item := range shared.Items {
+		text := item.Text
+		for _, run := range item.Runs {
+			text += run.Text
+		}
+		strs[i] = text
+	}
+
+	var workbook xlsxWorkbook
+	raw, err := readZipFile(r, "xl/workbook.xml")
+	if err != nil {
+		return "", fmt.Errorf("reading xlsx: %w", err)
+	}
+	if err := xml.Unmarshal(raw, &workbook); err != nil {
+		return "", fmt.Errorf("parsing workbook: %w", err)
+	}
+	targets := make(map[string]string)
+	if raw, err := readZipFile(r, "xl/_rels/workbook.xml.rels"); err == nil {
+		var rels xlsxRelationships
+		if err := xml.Unmarshal(raw, &rels); err != nil {
+			return "", fmt.Errorf("parsing workbook relationships: %w", err)
+		}
+		for _, rel := range rels.Relationships {
+			target := strings.TrimPrefix(rel.Target, "/xl/")
+			targets[rel.Id] = path.Join("xl", target)
+		}
+	}
+
+	var sb strings.Builder
+	for i, sheet := range workbook.Sheets {
+		name, ok := targets[sheet.Id]
+		if !ok {
+			name = fmt.Sprintf("xl/worksheets/sheet%d.xml", i+1)
+		}
+		raw, err := readZipFile(r, name)
+		if err != nil {
+			return "", fmt.Errorf("reading sheet %s: %w", sheet.Name, err)
+		}
+		var ws xlsxWorksheet
+		if err := xml.Unmarshal(raw, &ws); err != nil {
+			return "", fmt.Errorf("parsing sheet %s: %w", sheet.Name, err)
+		}
+		sb.WriteString("## " + sheet.Name + "\n")
+		for _, row := range ws.Rows {
+			var cells []string
+			for j, c := range row.Cells {
+				// 跳过的空单元格只在 r 属性中体现
+				col := j
+				if c.Ref != "" {
+					col = columnIndex(c.Ref)
+				}
+				for len(cells) < col {
+					cells = append(cells, "")
+				}
+				value := c.Value
+				switch c.Type {
+				case "s":
+					if idx, err := strconv.Atoi(c.Value); err == nil &&
+						idx >= 0 && idx < len(strs) {
+						value = strs[idx]
+					}
+				case "inlineStr":
+					value = c.Inline.Text
+				case "b":
+					value = map[string]string{"0": "FALSE", "1": "TRUE"}[c.Value]
+				}
+				cells = append(cells, strings.ReplaceAll(value, "\n", " "))
+			}
+			if strings.TrimSpace(strings.Join(cells, "")) == "" {
+				continue
+			}
+			sb.WriteString(strings.Join(cells, "\t") + "\n")
+		}
+		sb.WriteString("\n")
+	}
+	return sb.String(), nil
+}
+
+// columnIndex 将 A1 形式的单元格引用转换为从 0 开始的列号
+func columnIndex(ref string) int {
+	col := 0
+	for _, ch := range ref {
+		if ch < 'A' || ch > 'Z' {
+			break
+		}
+		col = col*26 + int(ch-'A'+1)
+	}
+	return col - 1
+}
+
+func utf16ToString(units []uint16) string {
+	return string(utf16.Decode(units))
+}
@@ -0,0 +1,375 @@
+package document
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ErrNoText 文档中没有可提取的文字，例如扫描件
+var ErrNoText = errors.New("no text found in document")
+
+// ErrEncrypted 加密的 PDF 无法提取文字
+var ErrEncrypted = errors.New("pdf is encrypted")
+
+// maxStreamSize 单个流解压后的大小上限，防止压缩炸弹
+const maxStreamSize = 64 << 20
+
+var (
+	objHeaderRe = regexp.MustCompile(`(\d+)\s+(\d+)\s+obj\b`)
+	refRe       = regexp.MustCompile(`(\d+)\s+\d+\s+R\b`)
+	rootRe      = regexp.MustCompile(`/Root\s+(\d+)\s+\d+\s+R\b`)
+	lengthRe    = regexp.MustCompile(`/Length\s+(\d+)(\s+\d+\s+R)?`)
+	fontRefRe   = regexp.MustCompile(`/([^\s/<>\[\]()]+)\s+(\d+)\s+\d+\s+R`)
+)
+
+type pdfObject struct {
+	dict   string
+	stream []byte
+}
+
+type pdfFile struct {
+	objects map[int]*pdfObject
+	cmaps   map[int]*cmap
+}
+
+// ExtractPDF 按页提取 PDF 中的文字，支持对象流、Flate 压缩和 ToUnicode 编码表。
+// 只使用标准库，复杂排版下文字顺序可能与显示不完全一致
+func ExtractPDF(data []byte) (string, error) {
+	if !bytes.HasPrefix(bytes.TrimLeft(data, " \r\n\t"), []byte("%PDF")) {
+		return "", errors.New("not a pdf file")
+	}
+	if bytes.Contains(data, []byte("/Encrypt")) {
+		return "", ErrEncrypted
+	}
+	f := &pdfFile{objects: parseObjects(data), cmaps: make(map[int]*cmap)}
+
+	var sb strings.Builder
+	for _, page := range f.pages(data) {
+		text := f.pageText(page)
+		if strings.TrimSpace(text) == "" {
+			continue
+		}
+		sb.WriteString(text)
+		sb.WriteString("\n\n")
+	}
+	if strings.TrimSpace(sb.String()) == "" {
+		return "", ErrNoText
+	}
+	return sb.String(), nil
+}
+
+// parseObjects 扫描文件中的全部间接对象，后出现的定义覆盖先出现的（增量更新）
+func parseObjects(data []byte) map[int]*pdfObject {
+	objects := make(map[int]*pdfObject)
+	matches := objHeaderRe.FindAllSubmatchIndex(data, -1)
+	for i, m := range matches {
+		num, _ := strconv.Atoi(string(data[m[2]:m[3]]))
+		end := len(data)
+		if i+1 < len(matches) {
+			end = matches[i+1][0]
+		}
+		body := data[m[1]:end]
+		if idx := bytes.Index(body, []byte("endobj")); idx >= 0 {
+			body = body[:idx]
+		}
+		objects[num] = parseObjectBody(body)
+	}
+
+	// 对象流中的对象没有自己的 obj 头
+	for _, obj := range objects {
+		if !strings.Contains(obj.dict, "/ObjStm") || obj.stream == nil {
+			continue
+		}
+		decoded, err := decodeStream(obj)
+		if err != nil {
+			continue
+		}
+		n, _ := strconv.Atoi(dictValue(obj.dict, "N"))
+		first, _ := strconv.Atoi(dictValue(obj.dict, "First"))
+		if first <= 0 || first > len(decoded) {
+			continue
+		}
+		header := strings.Fields(string(decoded[:first]))
+		for j := 0; j+1 < len(header) && j/2 < n; j += 2 {
+			num, err1 := strconv.Atoi(header[j])
+			offset, err2 := strconv.Atoi(header[j+1])
+			if err1 != nil || err2 != nil || first+offset > len(decoded) {
+				continue
+			}
+			end := len(decoded)
+			if j+3 < len(header) {
+				if next, err := strconv.Atoi(header[j+3]); err == nil &&
+					first+next <= len(decoded) && next >= offset {
+					end = first + next
+				}
+			}
+			if _, ok := objects[num]; !ok {
+				objects[num] = &pdfObject{dict: string(decoded[first+offset : end])}
+			}
+		}
+	}
+	return objects
+}
+
+func parseObjectBody(body []byte) *pdfObject {
+	idx := bytes.Index(body, []byte("stream"))
+	if idx < 0 {
+		return &pdfObject{dict: string(body)}
+	}
+	obj := &pdfObject{dict: string(body[:idx])}
+	start := idx + len("stream")
+	if start < len(body) && body[start] == '\r' {
+		start++
+	}
+	if start < len(body) && body[start] == '\n' {
+		start++
+	}
+	end := bytes.LastIndex(body, []byte("endstream"))
+	if end < start {
+		end = len(body)
+	}
+	// /Length 是直接数值时用它截取，避免流末尾的换行混入数据
+	if m := lengthRe.FindStringSubmatch(obj.dict); m != nil && m[2] == "" {
+		if n, err := strconv.Atoi(m[1]); err == nil && start+n <= end {
+			end = start + n
+		}
+	}
+	obj.stream = body[start:end]
+	return obj
+}
+
+func decodeStream(obj *pdfObject) ([]byte, error) {
+	filter := dictValue(obj.dict, "Filter")
+	if filter == "" {
+		return obj.stream, nil
+	}
+	if !strings.Contains(filter, "FlateDecode") ||
+		strings.Count(filter, "/") > 1 {
+		return nil, fmt.Errorf("unsupported filter %s", filter)
+	}
+	r, err := zlib.NewReader(bytes.NewReader(obj.stream))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	data, err := io.ReadAll(io.LimitReader(r, maxStreamSize))
+	// 部分文件的压缩流末尾不完整，已解出的内容仍然可用
+	if err != nil && len(data) == 0 {
+		return nil, err
+	}
+	return data, nil
+}
+
+// pages 按页面树的顺序返回页面及其继承的资源
+func (f *pdfFile) pages(data []byte) []pdfPage {
+	var result []pdfPage
+	if m := rootRe.FindAllSubmatch(data, -1); len(m) > 0 {
+		root, _ := strconv.Atoi(string(m[len(m)-1][1]))
+		if catalog, ok := f.objects[root]; ok {
+			if pagesRef, ok := dictRef(catalog.dict, "Pages"); ok {
+				f.walkPages(pagesRef, "", &result, make(map[int]bool))
+			}
+		}
+	}
+	if len(result) > 0 {
+		return result
+	}
+
+	// 没有找到页面树时按对象编号顺序处理所有页面
+	var nums []int
+	for num, obj := range f.objects {
+		if isType(obj.dict, "Page") {
+			nums = append(nums, num)
+		}
+	}
+	sort.Ints(nums)
+	for _, num := range nums {
+		result = append(result, pdfPage{dict: f.objects[num].dict})
+	}
+	return result
+}
+
+type pdfPage struct {
+	dict      string
+	resources string
+}
+
+func (f *pdfFile) walkPages(num int, resources string, result *[]pdfPage,
+	visited map[int]bool) {
+	obj, ok := f.objects[num]
+	if !ok || visited[num] {
+		return
+	}
+	visited[num] = true
+	if res := f.resolve(dictValue(obj.dict, "Resources")); res != "" {
+		resources = res
+	}
+	if isType(obj.dict, "Pages") {
+		for _, kid := range refs(dictValue(obj.dict, "Kids")) {
+			f.walkPages(kid, resources, result, visited)
+		}
+		return
+	}
+	*result = append(*result, pdfPage{dict: obj.dict, resources: resources})
+}
+
+// resolve 间接引用时返回被引用对象的内容，否则原样返回
+func (f *pdfFile) resolve(value string) string {
+	value = strings.TrimSpace(value)
+	if m := refRe.FindStringSubmatch(value); m != nil && m[0] == value {
+		num, _ := strconv.Atoi(m[1])
+		if obj, ok := f.objects[num]; ok {
+			return obj.dict
+		}
+		return ""
+	}
+	return value
+}
+
+func (f *pdfFile) pageText(page pdfPage) string {
+	resources := page.resources
+	if res := f.resolve(dictValue(page.dict, "Resources")); res != "" {
+		resources = res
+	}
+	fonts := make(map[string]*cmap)
+	fontDict := f.resolve(dictValue(resources, "Font"))
+	for _, m := range fontRefRe.FindAllStringSubmatch(fontDict, -1) {
+		num, _ := strconv.Atoi(m[2])
+		fonts[m[1]] = f.fontCMap(num)
+	}
+
+	var content []byte
+	for _, num := range refs(dictValue(page.dict, "Contents")) {
+		obj, ok := f.objects[num]
+		if !ok {
+			continue
+		}
+		data, err := decodeStream(obj)
+		if err != nil {
+			continue
+		}
+		content = append(content, data...)
+		content = append(content, '\n')
+	}
+	return extractContentText(content, fonts)
+}
+
+// fontCMap 返回字体的 ToUnicode 编码表，没有编码表的简单字体按单字节处理
+func (f *pdfFile) fontCMap(num int) *cmap {
+	if c, ok := f.cmaps[num]; ok {
+		return c
+	}
+	var c *cmap
+	if obj, ok := f.objects[num]; ok {
+		if ref, ok := dictRef(obj.dict, "ToUnicode"); ok {
+			if stream, ok := f.objects[ref]; ok {
+				if data, err := decodeStream(stream); err == nil {
+					c = parseCMap(data)
+				}
+			}
+		}
+		if c == nil && strings.Contains(obj.dict, "/Type0") {
+			// 复合字体没有编码表时无法还原文字
+			c = &cmap{codeLen: 2, chars: map[string]string{}}
+		}
+	}
+	f.cmaps[num] = c
+	return c
+}
+
+// isType 判断字典的 /Type 是否为 name，/Pages 不会被误判为 /Page
+func isType(dict, name string) bool {
+	return dictValue(dict, "Type") == "/"+name
+}
+
+// dictValue 返回字典中 key 对应的原始值，支持嵌套的字典和数组
+func dictValue(dict, key string) string {
+	needle := "/" + key
+	for i := 0; ; {
+		idx := strings.Index(dict[i:], needle)
+		if idx < 0 {
+			return ""
+		}
+		start := i + idx + len(needle)
+		i = start
+		// 确保匹配的是完整的名字，而不是 /FontDescriptor 之类的前缀
+		if start < len(dict) && isRegular(dict[start]) {
+			continue
+		}
+		return readValue(dict[start:])
+	}
+}
+
+func isRegular(c byte) bool {
+	return !strings.ContainsRune(" \t\r\n\f\x00()<>[]{}/%", rune(c))
+}
+
+// readValue 读取一个完整的值：字典、数组、间接引用或单个记号
+func readValue(s string) string {
+	s = strings.TrimLeft(s, " \t\r\n\f\x00")
+	if s == "" {
+		return ""
+	}
+	switch {
+	case strings.HasPrefix(s, "<<"):
+		return balanced(s, "<<", ">>")
+	case s[0] == '[':
+		return balanced(s, "[", "]")
+	case s[0] == '(':
+		return balanced(s, "(", ")")
+	}
+	if m := refRe.FindStringIndex(s); m != nil && m[0] == 0 {
+		return s[:m[1]]
+	}
+	end := 1
+	for end < len(s) && isRegular(s[end]) {
+		end++
+	}
+	return s[:end]
+}
+
+func balanced(s, open, close string) string {
+	depth := 0
+	for i := 0; i < len(s); {
+		switch {
+		case strings.HasPrefix(s[i:], open):
+			depth++
+			i += len(open)
+		case strings.HasPrefix(s[i:], close):
+			depth--
+			i += len(close)
+			if depth == 0 {
+				return s[:i]
+			}
+		default:
+			i++
+		}
+	}
+	return s
+}
+
+func dictRef(dict, key string) (int, bool) {
+	m := refRe.FindStringSubmatch(dictValue(dict, key))
+	if m == nil {
+		return 0, false
+	}
+	num, err := strconv.Atoi(m[1])
+	return num, err == nil
+}
+
+func refs(value string) []int {
+	var result []int
+	for _, m := range refRe.FindAllStringSubmatch(value, -1) {
+		if num, err := strconv.Atoi(m[1]); err == nil {
+			result = append(result, num)
+		}
+	}
+	return result
+}
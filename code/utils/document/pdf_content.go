@@ -0,0 +1,408 @@
+package document
+
+import (
+	"bytes"
+	"encoding/hex"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// cmap 字体编码到 Unicode 的映射
+type cmap struct {
+	codeLen int
+	chars   map[string]string
+}
+
+var (
+	hexTokenRe = regexp.MustCompile(`<([0-9A-Fa-f\s]*)>|\[|\]`)
+	// maxCMapRange bfrange 最多展开的编码数，防止异常文件占用过多内存
+	maxCMapRange = 1 << 16
+)
+
+// parseCMap 解析 ToUnicode 编码表中的 bfchar 和 bfrange
+func parseCMap(data []byte) *cmap {
+	text := string(data)
+	c := &cmap{codeLen: 1, chars: make(map[string]string)}
+	if block := between(text, "begincodespacerange", "endcodespacerange"); block != "" {
+		if m := hexTokenRe.FindStringSubmatch(block); m != nil && m[1] != "" {
+			c.codeLen = len(hexBytes(m[1]))
+		}
+	}
+	for _, block := range allBetween(text, "beginbfchar", "endbfchar") {
+		tokens := hexTokens(block)
+		for i := 0; i+1 < len(tokens); i += 2 {
+			c.chars[string(hexBytes(tokens[i]))] = utf16BE(hexBytes(tokens[i+1]))
+		}
+	}
+	for _, block := range allBetween(text, "beginbfrange", "endbfrange") {
+		tokens := hexTokens(block)
+		for i := 0; i+2 < len(tokens); {
+			lo, hi := hexBytes(tokens[i]), hexBytes(tokens[i+1])
+			start, end := bytesToInt(lo), bytesToInt(hi)
+			if tokens[i+2] == "[" {
+				// <lo> <hi> [<dst1> <dst2> ...]
+				j := i + 3
+				for code := start; j < len(tokens) && tokens[j] != "]"; code++ {
+					c.chars[string(intToBytes(code, len(lo)))] =
+						utf16BE(hexBytes(tokens[j]))
+					j++
+				}
+				i = j + 1
+				continue
+			}
+			dst := hexBytes(tokens[i+2])
+			if end-start < maxCMapRange {
+				for code := start; code <= end; code++ {
+					c.chars[string(intToBytes(code, len(lo)))] =
+						utf16BE(incrementLast(dst, code-start))
+				}
+			}
+			i += 3
+		}
+	}
+	return c
+}
+
+func between(text, begin, end string) string {
+	blocks := allBetween(text, begin, end)
+	if len(blocks) == 0 {
+		return ""
+	}
+	return blocks[0]
+}
+
+func allBetween(text, begin, end string) []string {
+	var result []string
+	for {
+		i := strings.Index(text, begin)
+		if i < 0 {
+			return result
+		}
+		text = text[i+len(begin):]
+		j := strings.Index(text, end)
+		if j < 0 {
+			return result
+		}
+		result = append(result, text[:j])
+		text = text[j+len(end):]
+	}
+}
+
+func hexTokens(block string) []string {
+	var tokens []string
+	for _, m := range hexTokenRe.FindAllStringSubmatch(block, -1) {
+		if m[0] == "[" || m[0] == "]" {
+			tokens = append(tokens, m[0])
+		} else {
+			tokens = append(tokens, m[1])
+		}
+	}
+	return tokens
+}
+
+func hexBytes(s string) []byte {
+	s = strings.Join(strings.Fields(s), "")
+	if len(s)%2 == 1 {
+		s += "0"
+	}
+	b, _ := hex.DecodeString(s)
+	return b
+}
+
+func bytesToInt(b []byte) int {
+	n := 0
+	for _, c := range b {
+		n = n<<8 | int(c)
+	}
+	return n
+}
+
+func intToBytes(n, size int) []byte {
+	b := make([]byte, size)
+	for i := size - 1; i >= 0; i-- {
+		b[i] = byte(n)
+		n >>= 8
+	}
+	return b
+}
+
+// incrementLast 将目标编码的最后一个 UTF-16 码元加上 delta
+func incrementLast(dst []byte, delta int) []byte {
+	b := append([]byte{}, dst...)
+	if len(b) < 2 {
+		return b
+	}
+	v := int(b[len(b)-2])<<8 | int(b[len(b)-1])
+	v += delta
+	b[len(b)-2], b[len(b)-1] = byte(v>>8), byte(v)
+	return b
+}
+
+func utf16BE(b []byte) string {
+	units := make([]uint16, len(b)/2)
+	for i := range units {
+		units[i] = uint16(b[2*i])<<8 | uint16(b[2*i+1])
+	}
+	return utf16ToString(units)
+}
+
+func (c *cmap) decode(s []byte) string {
+	if c == nil {
+		// 没有编码表的简单字体按 Latin-1 解码
+		runes := make([]rune, len(s))
+		for i, b := range s {
+			runes[i] = rune(b)
+		}
+		return string(runes)
+	}
+	var sb strings.Builder
+	for i := 0; i+c.codeLen <= len(s); i += c.codeLen {
+		code := s[i : i+c.codeLen]
+		if text, ok := c.chars[string(code)]; ok {
+			sb.WriteString(text)
+		} else if c.codeLen == 1 {
+			sb.WriteRune(rune(code[0]))
+		}
+	}
+	return sb.String()
+}
+
+// pdfToken 内容流中的记号
+type pdfToken struct {
+	kind  byte // 's' 字符串，'n' 数字，'/' 名字，'[' 数组，'o' 操作符
+	str   []byte
+	num   float64
+	array []pdfToken
+}
+
+// extractContentText 解释内容流中的文本操作符
+func extractContentText(content []byte, fonts map[string]*cmap) string {
+	var sb strings.Builder
+	var font *cmap
+	var operands []pdfToken
+	lastY := 0.0
+	lex := &pdfLexer{data: content}
+	for {
+		token, ok := lex.next()
+		if !ok {
+			break
+		}
+		if token.kind != 'o' {
+			operands = append(operands, token)
+			continue
+		}
+		switch string(token.str) {
+		case "Tf":
+			if len(operands) >= 2 && operands[0].kind == '/' {
+				font = fonts[string(operands[0].str)]
+			}
+		case "Tj":
+			if len(operands) > 0 {
+				sb.WriteString(font.decode(operands[len(operands)-1].str))
+			}
+		case "'", "\"":
+			sb.WriteByte('\n')
+			if len(operands) > 0 {
+				sb.WriteString(font.decode(operands[len(operands)-1].str))
+			}
+		case "TJ":
+			if len(operands) > 0 {
+				for _, item := range operands[len(operands)-1].array {
+					if item.kind == 's' {
+						sb.WriteString(font.decode(item.str))
+					} else if item.kind == 'n' && item.num < -250 {
+						// 较大的字距调整通常是单词之间的空格
+						sb.WriteByte(' ')
+					}
+				}
+			}
+		case "Td", "TD":
+			if len(operands) >= 2 && operands[1].num != 0 {
+				sb.WriteByte('\n')
+			} else {
+				sb.WriteByte(' ')
+			}
+		case "T*":
+			sb.WriteByte('\n')
+		case "Tm":
+			if len(operands) >= 6 {
+				if y := operands[5].num; y != lastY {
+					sb.WriteByte('\n')
+					lastY = y
+				} else {
+					sb.WriteByte(' ')
+				}
+			}
+		case "ET":
+			sb.WriteByte(' ')
+		case "ID":
+			lex.skipInlineImage()
+		}
+		operands = operands[:0]
+	}
+	return collapseSpaces(sb.String())
+}
+
+var spacesRe = regexp.MustCompile(`[ \t]+`)
+
+func collapseSpaces(s string) string {
+	lines := strings.Split(spacesRe.ReplaceAllString(s, " "), "\n")
+	for i := range lines {
+		lines[i] = strings.TrimSpace(lines[i])
+	}
+	return strings.Join(lines, "\n")
+}
+
+type pdfLexer struct {
+	data []byte
+	pos  int
+}
+
+func isWhite(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\r' || c == '\n' || c == '\f' || c == 0
+}
+
+func (l *pdfLexer) next() (pdfToken, bool) {
+	for l.pos < len(l.data) {
+		c := l.data[l.pos]
+		switch {
+		case isWhite(c):
+			l.pos++
+		case c == '%':
+			for l.pos < len(l.data) && l.data[l.pos] != '\n' && l.data[l.pos] != '\r' {
+				l.pos++
+			}
+		case c == '(':
+			return pdfToken{kind: 's', str: l.literal()}, true
+		case c == '<' && l.pos+1 < len(l.data) && l.data[l.pos+1] == '<':
+			// 内容流中的字典只出现在标记内容的属性里，整体跳过
+			end := bytes.Index(l.data[l.pos:], []byte(">>"))
+			if end < 0 {
+				l.pos = len(l.data)
+			} else {
+				l.pos += end + 2
+			}
+		case c == '<':
+			end := bytes.IndexByte(l.data[l.pos:], '>')
+			if end < 0 {
+				end = len(l.data) - l.pos
+			}
+			s := hexBytes(string(l.data[l.pos+1 : l.pos+end]))
+			l.pos += end + 1
+			return pdfToken{kind: 's', str: s}, true
+		case c == '[':
+			l.pos++
+			var array []pdfToken
+			for {
+				token, ok := l.next()
+				if !ok || (token.kind == 'o' && string(token.str) == "]") {
+					break
+				}
+				array = append(array, token)
+			}
+			return pdfToken{kind: '[', array: array}, true
+		case c == ']':
+			l.pos++
+			return pdfToken{kind: 'o', str: []byte("]")}, true
+		case c == '/':
+			start := l.pos + 1
+			l.pos++
+			for l.pos < len(l.data) && isRegular(l.data[l.pos]) {
+				l.pos++
+			}
+			return pdfToken{kind: '/', str: l.data[start:l.pos]}, true
+		default:
+			start := l.pos
+			for l.pos < len(l.data) && isRegular(l.data[l.pos]) {
+				l.pos++
+			}
+			if l.pos == start {
+				// 不成对的分隔符，跳过
+				l.pos++
+				continue
+			}
+			word := l.data[start:l.pos]
+			if n, err := strconv.ParseFloat(string(word), 64); err == nil {
+				return pdfToken{kind: 'n', num: n}, true
+			}
+			return pdfToken{kind: 'o', str: word}, true
+		}
+	}
+	return pdfToken{}, false
+}
+
+// literal 读取括号字符串，处理嵌套括号和转义
+func (l *pdfLexer) literal() []byte {
+	var out []byte
+	depth := 0
+	for l.pos < len(l.data) {
+		c := l.data[l.pos]
+		l.pos++
+		switch c {
+		case '(':
+			if depth > 0 {
+				out = append(out, c)
+			}
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return out
+			}
+			out = append(out, c)
+		case '\\':
+			if l.pos >= len(l.data) {
+				return out
+			}
+			e := l.data[l.pos]
+			l.pos++
+			switch e {
+			case 'n':
+				out = append(out, '\n')
+			case 'r':
+				out = append(out, '\r')
+			case 't':
+				out = append(out, '\t')
+			case 'b':
+				out = append(out, '\b')
+			case 'f':
+				out = append(out, '\f')
+			case '\r':
+				// 行尾的反斜杠表示续行
+				if l.pos < len(l.data) && l.data[l.pos] == '\n' {
+					l.pos++
+				}
+			case '\n':
+			default:
+				if e >= '0' && e <= '7' {
+					v := int(e - '0')
+					for k := 0; k < 2 && l.pos < len(l.data) &&
+						l.data[l.pos] >= '0' && l.data[l.pos] <= '7'; k++ {
+						v = v*8 + int(l.data[l.pos]-'0')
+						l.pos++
+					}
+					out = append(out, byte(v))
+				} else {
+					out = append(out, e)
+				}
+			}
+		default:
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// skipInlineImage 跳过内嵌图片的二进制数据，直到 EI 操作符
+func (l *pdfLexer) skipInlineImage() {
+	for l.pos+2 < len(l.data) {
+		if isWhite(l.data[l.pos]) && l.data[l.pos+1] == 'E' && l.data[l.pos+2] == 'I' &&
+			(l.pos+3 == len(l.data) || isWhite(l.data[l.pos+3])) {
+			l.pos += 3
+			return
+		}
+		l.pos++
+	}
+	l.pos = len(l.data)
+}
@@ -0,0 +1,131 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+)
+
+// Orientation EXIF 中记录的拍摄方向，取值 1-8，1 表示无需旋转
+type Orientation int
+
+const (
+	OrientationNormal Orientation = 1
+	orientationTag                = 0x0112
+)
+
+// ReadOrientation 读取 JPEG 中 EXIF 的方向信息，没有 EXIF 或解析失败时返回 OrientationNormal
+func ReadOrientation(data []byte) Orientation {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return OrientationNormal
+	}
+	for i := 2; i+4 <= len(data); {
+		if data[i] != 0xFF {
+			return OrientationNormal
+		}
+		marker := data[i+1]
+		// 填充字节和没有长度的标记
+		if marker == 0xFF {
+			i++
+			continue
+		}
+		if marker == 0x01 || (marker >= 0xD0 && marker <= 0xD8) {
+			i += 2
+			continue
+		}
+		// 图像数据开始，后面不会再有 EXIF
+		if marker == 0xDA || marker == 0xD9 {
+			return OrientationNormal
+		}
+		size := int(binary.BigEndian.Uint16(data[i+2:]))
+		if size < 2 || i+2+size > len(data) {
+			return OrientationNormal
+		}
+		segment := data[i+4 : i+2+size]
+		if marker == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return tiffOrientation(segment[6:])
+		}
+		i += 2 + size
+	}
+	return OrientationNormal
+}
+
+// tiffOrientation 在 TIFF 结构的 IFD0 中查找方向标签
+func tiffOrientation(tiff []byte) Orientation {
+	if len(tiff) < 8 {
+		return OrientationNormal
+	}
+	var order binary.ByteOrder
+	switch string(tiff[0:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return OrientationNormal
+	}
+	if order.Uint16(tiff[2:4]) != 42 {
+		return OrientationNormal
+	}
+	offset := int(order.Uint32(tiff[4:8]))
+	if offset+2 > len(tiff) {
+		return OrientationNormal
+	}
+	count := int(order.Uint16(tiff[offset:]))
+	for i := 0; i < count; i++ {
+		entry := offset + 2 + i*12
+		if entry+12 > len(tiff) {
+			break
+		}
+		if order.Uint16(tiff[entry:]) != orientationTag {
+			continue
+		}
+		// SHORT 类型的值直接存放在条目的前两个字节中
+		o := Orientation(order.Uint16(tiff[entry+8:]))
+		if o < 1 || o > 8 {
+			return OrientationNormal
+		}
+		return o
+	}
+	return OrientationNormal
+}
+
+// Orient 按 EXIF 方向旋转或翻转图片，使其按正常方向显示
+func Orient(img *image.RGBA, o Orientation) *image.RGBA {
+	if o <= OrientationNormal || o > 8 {
+		return img
+	}
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	dw, dh := w, h
+	// 5-8 需要转置，宽高互换
+	if o >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < h; y++ {
+		src := img.Pix[img.PixOffset(b.Min.X, b.Min.Y+y):]
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch o {
+			case 2: // 水平翻转
+				dx, dy = w-1-x, y
+			case 3: // 旋转 180°
+				dx, dy = w-1-x, h-1-y
+			case 4: // 垂直翻转
+				dx, dy = x, h-1-y
+			case 5: // 转置
+				dx, dy = y, x
+			case 6: // 顺时针旋转 90°
+				dx, dy = h-1-y, x
+			case 7: // 反转置
+				dx, dy = h-1-y, w-1-x
+			case 8: // 逆时针旋转 90°
+				dx, dy = y, w-1-x
+			}
+			copy(dst.Pix[dst.PixOffset(dx, dy):dst.PixOffset(dx, dy)+4],
+				src[x*4:x*4+4])
+		}
+	}
+	return dst
+}
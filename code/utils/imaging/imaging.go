@@ -0,0 +1,227 @@
+package imaging
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"math"
+
+	// 注册 gif 解码器，jpeg 和 png 已在上面导入
+	_ "image/gif"
+)
+
+// Fit 把图片变为正方形的方式
+type Fit int
+
+const (
+	// FitNone 保持原始宽高比
+	FitNone Fit = iota
+	// FitPad 在短边两侧补透明像素，保留完整画面
+	FitPad
+	// FitCrop 从中间裁剪长边
+	FitCrop
+)
+
+// Format 输出的编码格式
+type Format string
+
+const (
+	FormatPNG  Format = "png"
+	FormatJPEG Format = "jpeg"
+)
+
+// Options 图片处理参数
+type Options struct {
+	Fit Fit
+	// MaxSide 最长边的像素数，为 0 时不限制
+	MaxSide int
+	// MaxBytes 编码后的最大字节数，超出时继续缩小图片，为 0 时不限制
+	MaxBytes int
+	Format   Format
+	// JPEGQuality 为 0 时使用 jpeg.DefaultQuality
+	JPEGQuality int
+	// ForceAlpha 让 PNG 始终带透明通道，完全不透明的图片默认会被编码为 RGB
+	ForceAlpha bool
+}
+
+var (
+	// DallE2 dall-e-2 的编辑和变体接口要求小于 4MB、带透明通道的正方形 PNG，
+	// 输出最大为 1024x1024，更大的输入没有意义
+	DallE2 = Options{
+		Fit:        FitPad,
+		MaxSide:    1024,
+		MaxBytes:   4 << 20,
+		Format:     FormatPNG,
+		ForceAlpha: true,
+	}
+	// GPTImage gpt-image 系列模型接受任意宽高比、小于 25MB 的 PNG
+	GPTImage = Options{
+		MaxSide:  2048,
+		MaxBytes: 25 << 20,
+		Format:   FormatPNG,
+	}
+)
+
+// ErrTooLarge 缩小到最小尺寸后仍然超过大小限制
+var ErrTooLarge = errors.New("image too large")
+
+// minSide 为满足大小限制缩小时的最小边长
+const minSide = 64
+
+// Decode 解码 jpeg、png、gif 格式的图片并按 EXIF 方向摆正
+func Decode(data []byte) (*image.RGBA, string, error) {
+	img, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", fmt.Errorf("decoding image: %w", err)
+	}
+	rgba := ToRGBA(img)
+	if format == "jpeg" {
+		rgba = Orient(rgba, ReadOrientation(data))
+	}
+	return rgba, format, nil
+}
+
+// Size 只解析图片头部，返回宽和高
+func Size(data []byte) (image.Point, error) {
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return image.Point{}, fmt.Errorf("decoding image: %w", err)
+	}
+	return image.Pt(config.Width, config.Height), nil
+}
+
+// ToRGBA 转换为从原点开始的 RGBA 图片，draw.Draw 对常见格式有快速路径
+func ToRGBA(img image.Image) *image.RGBA {
+	if rgba, ok := img.(*image.RGBA); ok && rgba.Bounds().Min == (image.Point{}) {
+		return rgba
+	}
+	b := img.Bounds()
+	rgba := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(rgba, rgba.Bounds(), img, b.Min, draw.Src)
+	return rgba
+}
+
+// Square 按 fit 把图片变为正方形
+func Square(img *image.RGBA, fit Fit) *image.RGBA {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w == h || fit == FitNone {
+		return img
+	}
+	if fit == FitCrop {
+		side := w
+		if h < side {
+			side = h
+		}
+		x := b.Min.X + (w-side)/2
+		y := b.Min.Y + (h-side)/2
+		return ToRGBA(img.SubImage(image.Rect(x, y, x+side, y+side)))
+	}
+	side := maxInt(w, h)
+	dst := image.NewRGBA(image.Rect(0, 0, side, side))
+	offset := image.Pt((side-w)/2, (side-h)/2)
+	draw.Draw(dst, b.Sub(b.Min).Add(offset), img, b.Min, draw.Src)
+	return dst
+}
+
+// Process 在内存中完成解码、摆正、变为正方形、缩放和编码，
+// 编码结果超过 MaxBytes 时逐步缩小图片直到满足限制
+func Process(data []byte, opts Options) ([]byte, error) {
+	img, _, err := Decode(data)
+	if err != nil {
+		return nil, err
+	}
+	// 先裁剪或先缩放都能减少后续需要处理的像素
+	if opts.Fit == FitPad {
+		img = Square(FitWithin(img, opts.MaxSide), opts.Fit)
+	} else {
+		img = FitWithin(Square(img, opts.Fit), opts.MaxSide)
+	}
+
+	for {
+		out, err := Encode(img, opts)
+		if err != nil {
+			return nil, err
+		}
+		if opts.MaxBytes <= 0 || len(out) <= opts.MaxBytes {
+			return out, nil
+		}
+		b := img.Bounds()
+		if b.Dx() <= minSide || b.Dy() <= minSide {
+			return nil, fmt.Errorf("%w: %d bytes at %dx%d", ErrTooLarge,
+				len(out), b.Dx(), b.Dy())
+		}
+		// 编码大小大致与像素数成正比，多缩小一点以减少重试次数
+		scale := math.Sqrt(float64(opts.MaxBytes)/float64(len(out))) * 0.9
+		if scale > 0.9 {
+			scale = 0.9
+		}
+		side := int(float64(maxInt(b.Dx(), b.Dy())) * scale)
+		if side < minSide {
+			side = minSide
+		}
+		img = FitWithin(img, side)
+	}
+}
+
+// Encode 按 opts 中的格式编码图片
+func Encode(img *image.RGBA, opts Options) ([]byte, error) {
+	var buf bytes.Buffer
+	switch opts.Format {
+	case FormatJPEG:
+		quality := opts.JPEGQuality
+		if quality <= 0 {
+			quality = jpeg.DefaultQuality
+		}
+		// JPEG 没有透明通道，透明区域合成到白色背景上
+		if err := jpeg.Encode(&buf, onWhite(img),
+			&jpeg.Options{Quality: quality}); err != nil {
+			return nil, err
+		}
+	case FormatPNG, "":
+		if opts.ForceAlpha {
+			img = withAlpha(img)
+		}
+		if err := png.Encode(&buf, img); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported image format: %s", opts.Format)
+	}
+	return buf.Bytes(), nil
+}
+
+// withAlpha 将左上角像素的透明度调低一级，肉眼无法察觉，
+// 但可以让 png 编码器保留透明通道
+func withAlpha(img *image.RGBA) *image.RGBA {
+	b := img.Bounds()
+	if b.Empty() || !img.Opaque() {
+		return img
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(dst, dst.Bounds(), img, b.Min, draw.Src)
+	c := dst.RGBAAt(0, 0)
+	// RGBA 是预乘格式，颜色分量需要随透明度一起缩放
+	dst.SetRGBA(0, 0, color.RGBA{
+		R: uint8(uint16(c.R) * 0xfe / 0xff),
+		G: uint8(uint16(c.G) * 0xfe / 0xff),
+		B: uint8(uint16(c.B) * 0xfe / 0xff),
+		A: 0xfe,
+	})
+	return dst
+}
+
+func onWhite(img *image.RGBA) image.Image {
+	if img.Opaque() {
+		return img
+	}
+	dst := image.NewRGBA(img.Bounds())
+	draw.Draw(dst, dst.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.Draw(dst, dst.Bounds(), img, img.Bounds().Min, draw.Over)
+	return dst
+}
@@ -0,0 +1,254 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"math/rand"
+	"testing"
+)
+
+var (
+	red  = color.RGBA{R: 255, A: 255}
+	blue = color.RGBA{B: 255, A: 255}
+)
+
+// exifSegment 生成只包含方向标签的 APP1 数据段
+func exifSegment(order binary.ByteOrder, o Orientation) []byte {
+	var tiff bytes.Buffer
+	if order == binary.LittleEndian {
+		tiff.WriteString("II")
+	} else {
+		tiff.WriteString("MM")
+	}
+	binary.Write(&tiff, order, uint16(42))
+	binary.Write(&tiff, order, uint32(8))
+	binary.Write(&tiff, order, uint16(1))
+	binary.Write(&tiff, order, uint16(orientationTag))
+	binary.Write(&tiff, order, uint16(3)) // SHORT
+	binary.Write(&tiff, order, uint32(1))
+	binary.Write(&tiff, order, uint16(o))
+	binary.Write(&tiff, order, uint16(0))
+	binary.Write(&tiff, order, uint32(0))
+
+	payload := append([]byte("Exif\x00\x00"), tiff.Bytes()...)
+	segment := []byte{0xFF, 0xE1, 0, 0}
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(payload)+2))
+	return append(segment, payload...)
+}
+
+// testJPEG 生成左红右蓝的 JPEG，o 不为 0 时在 SOI 之后插入 EXIF
+func testJPEG(t testing.TB, w, h int, order binary.ByteOrder, o Orientation) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			if x < w/2 {
+				img.SetRGBA(x, y, red)
+			} else {
+				img.SetRGBA(x, y, blue)
+			}
+		}
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 100}); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	if o == 0 {
+		return data
+	}
+	out := append([]byte{}, data[:2]...)
+	out = append(out, exifSegment(order, o)...)
+	return append(out, data[2:]...)
+}
+
+func TestReadOrientation(t *testing.T) {
+	tests := []struct {
+		name  string
+		order binary.ByteOrder
+		o     Orientation
+		want  Orientation
+	}{
+		{"no exif", binary.BigEndian, 0, OrientationNormal},
+		{"little endian", binary.LittleEndian, 6, 6},
+		{"big endian", binary.BigEndian, 8, 8},
+		{"invalid value", binary.BigEndian, 9, OrientationNormal},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := testJPEG(t, 8, 8, tt.order, tt.o)
+			if got := ReadOrientation(data); got != tt.want {
+				t.Errorf("ReadOrientation() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+	if got := ReadOrientation([]byte("not a jpeg")); got != OrientationNormal {
+		t.Errorf("ReadOrientation(garbage) = %d, want 1", got)
+	}
+}
+
+func TestOrient(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 2, 1))
+	img.SetRGBA(0, 0, red)
+	img.SetRGBA(1, 0, blue)
+
+	tests := []struct {
+		o          Orientation
+		w, h       int
+		redX, redY int
+	}{
+		{1, 2, 1, 0, 0},
+		{2, 2, 1, 1, 0},
+		{3, 2, 1, 1, 0},
+		{4, 2, 1, 0, 0},
+		{5, 1, 2, 0, 0},
+		{6, 1, 2, 0, 0},
+		{7, 1, 2, 0, 1},
+		{8, 1, 2, 0, 1},
+	}
+	for _, tt := range tests {
+		got := Orient(img, tt.o)
+		if b := got.Bounds(); b.Dx() != tt.w || b.Dy() != tt.h {
+			t.Errorf("Orient(%d) size = %dx%d, want %dx%d", tt.o, b.Dx(), b.Dy(),
+				tt.w, tt.h)
+			continue
+		}
+		if c := got.RGBAAt(tt.redX, tt.redY); c != red {
+			t.Errorf("Orient(%d) pixel (%d,%d) = %v, want red", tt.o, tt.redX,
+				tt.redY, c)
+		}
+	}
+}
+
+func TestDecodeAutoOrients(t *testing.T) {
+	img, format, err := Decode(testJPEG(t, 16, 8, binary.LittleEndian, 6))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if format != "jpeg" {
+		t.Errorf("format = %s, want jpeg", format)
+	}
+	if b := img.Bounds(); b.Dx() != 8 || b.Dy() != 16 {
+		t.Fatalf("size = %dx%d, want 8x16", b.Dx(), b.Dy())
+	}
+	// 顺时针旋转后原来左边的红色在上方
+	if c := img.RGBAAt(4, 2); c.R < 200 || c.B > 50 {
+		t.Errorf("top pixel = %v, want red", c)
+	}
+
+	if _, _, err := Decode([]byte("not an image")); !errors.Is(err, image.ErrFormat) {
+		t.Errorf("Decode(garbage) error = %v, want image.ErrFormat", err)
+	}
+}
+
+func TestSquare(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 4, 2))
+	for i := range img.Pix {
+		img.Pix[i] = 255
+	}
+
+	padded := Square(img, FitPad)
+	if b := padded.Bounds(); b.Dx() != 4 || b.Dy() != 4 {
+		t.Fatalf("padded size = %dx%d, want 4x4", b.Dx(), b.Dy())
+	}
+	if c := padded.RGBAAt(0, 0); c.A != 0 {
+		t.Errorf("padding = %v, want transparent", c)
+	}
+	if c := padded.RGBAAt(0, 1); c.A != 255 {
+		t.Errorf("content = %v, want opaque", c)
+	}
+
+	cropped := Square(img, FitCrop)
+	if b := cropped.Bounds(); b.Dx() != 2 || b.Dy() != 2 {
+		t.Errorf("cropped size = %dx%d, want 2x2", b.Dx(), b.Dy())
+	}
+	if got := Square(img, FitNone); got != img {
+		t.Error("FitNone should return the image unchanged")
+	}
+}
+
+func TestResize(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 100, 50))
+	for i := 0; i < len(img.Pix); i += 4 {
+		copy(img.Pix[i:], []byte{10, 20, 30, 255})
+	}
+	for _, size := range []image.Point{{25, 12}, {200, 100}, {1, 1}} {
+		got := Resize(img, size.X, size.Y)
+		if b := got.Bounds(); b.Dx() != size.X || b.Dy() != size.Y {
+			t.Errorf("Resize size = %dx%d, want %dx%d", b.Dx(), b.Dy(), size.X,
+				size.Y)
+			continue
+		}
+		if c := got.RGBAAt(size.X/2, size.Y/2); c != (color.RGBA{10, 20, 30, 255}) {
+			t.Errorf("Resize(%v) color = %v, want solid color preserved", size, c)
+		}
+	}
+
+	fitted := FitWithin(img, 40)
+	if b := fitted.Bounds(); b.Dx() != 40 || b.Dy() != 20 {
+		t.Errorf("FitWithin size = %dx%d, want 40x20", b.Dx(), b.Dy())
+	}
+}
+
+func noisePNG(t testing.TB, w, h int) []byte {
+	rng := rand.New(rand.NewSource(1))
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	rng.Read(img.Pix)
+	for i := 3; i < len(img.Pix); i += 4 {
+		img.Pix[i] = 255
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestProcessMaxBytes(t *testing.T) {
+	const maxBytes = 100 << 10
+	data := noisePNG(t, 600, 400)
+	out, err := Process(data, Options{Fit: FitPad, MaxBytes: maxBytes,
+		Format: FormatPNG, ForceAlpha: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out) > maxBytes {
+		t.Errorf("len = %d, want <= %d", len(out), maxBytes)
+	}
+	img, err := png.Decode(bytes.NewReader(out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := img.Bounds(); b.Dx() != b.Dy() || b.Dx() >= 600 {
+		t.Errorf("size = %dx%d, want a downsized square", b.Dx(), b.Dy())
+	}
+	// dall-e-2 要求 RGBA 格式，完全不透明的图片也需要带透明通道
+	if _, ok := img.(*image.NRGBA); !ok {
+		t.Errorf("color model = %T, want *image.NRGBA", img)
+	}
+
+	_, err = Process(data, Options{MaxBytes: 10, Format: FormatPNG})
+	if !errors.Is(err, ErrTooLarge) {
+		t.Errorf("Process() error = %v, want ErrTooLarge", err)
+	}
+}
+
+func TestProcessJPEG(t *testing.T) {
+	out, err := Process(noisePNG(t, 64, 32), Options{Format: FormatJPEG,
+		Fit: FitCrop})
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, format, err := image.DecodeConfig(bytes.NewReader(out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if format != "jpeg" || config.Width != 32 || config.Height != 32 {
+		t.Errorf("got %s %dx%d, want jpeg 32x32", format, config.Width,
+			config.Height)
+	}
+}
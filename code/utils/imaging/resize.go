@@ -0,0 +1,158 @@
+package imaging
+
+import (
+	"image"
+	"math"
+)
+
+// Resize 用按缩放比例展宽的三角滤波缩放图片，缩小时对源像素做加权平均以避免锯齿。
+// RGBA 是预乘透明度的格式，直接插值不会在透明边缘产生暗边
+func Resize(img *image.RGBA, width, height int) *image.RGBA {
+	b := img.Bounds()
+	if width <= 0 || height <= 0 {
+		return image.NewRGBA(image.Rect(0, 0, 0, 0))
+	}
+	if width == b.Dx() && height == b.Dy() {
+		return img
+	}
+	return resizeVertical(resizeHorizontal(img, width), height)
+}
+
+// FitWithin 等比缩小图片使最长边不超过 maxSide，图片本身更小时原样返回
+func FitWithin(img *image.RGBA, maxSide int) *image.RGBA {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if maxSide <= 0 || (w <= maxSide && h <= maxSide) {
+		return img
+	}
+	scale := float64(maxSide) / float64(maxInt(w, h))
+	return Resize(img, scaled(w, scale), scaled(h, scale))
+}
+
+func scaled(n int, scale float64) int {
+	v := int(math.Round(float64(n) * scale))
+	if v < 1 {
+		return 1
+	}
+	return v
+}
+
+// weights 一个输出像素对应的源像素起点和权重
+type weights struct {
+	start  int
+	values []float64
+}
+
+func computeWeights(src, dst int) []weights {
+	scale := float64(src) / float64(dst)
+	support := math.Max(scale, 1)
+	result := make([]weights, dst)
+	for i := range result {
+		center := (float64(i)+0.5)*scale - 0.5
+		start := int(math.Ceil(center - support))
+		end := int(math.Floor(center + support))
+		if start < 0 {
+			start = 0
+		}
+		if end > src-1 {
+			end = src - 1
+		}
+		values := make([]float64, 0, end-start+1)
+		var sum float64
+		for j := start; j <= end; j++ {
+			w := 1 - math.Abs(float64(j)-center)/support
+			if w < 0 {
+				w = 0
+			}
+			values = append(values, w)
+			sum += w
+		}
+		if sum == 0 {
+			// 放大时中心恰好落在像素上，直接取最近的像素
+			nearest := int(math.Round(center))
+			if nearest < 0 {
+				nearest = 0
+			}
+			if nearest > src-1 {
+				nearest = src - 1
+			}
+			result[i] = weights{start: nearest, values: []float64{1}}
+			continue
+		}
+		for j := range values {
+			values[j] /= sum
+		}
+		result[i] = weights{start: start, values: values}
+	}
+	return result
+}
+
+func resizeHorizontal(img *image.RGBA, width int) *image.RGBA {
+	b := img.Bounds()
+	height := b.Dy()
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	ws := computeWeights(b.Dx(), width)
+	for y := 0; y < height; y++ {
+		row := img.Pix[img.PixOffset(b.Min.X, b.Min.Y+y):]
+		out := dst.Pix[y*dst.Stride:]
+		for x, w := range ws {
+			var r, g, bl, a float64
+			for k, v := range w.values {
+				p := row[(w.start+k)*4:]
+				r += float64(p[0]) * v
+				g += float64(p[1]) * v
+				bl += float64(p[2]) * v
+				a += float64(p[3]) * v
+			}
+			setPixel(out[x*4:], r, g, bl, a)
+		}
+	}
+	return dst
+}
+
+func resizeVertical(img *image.RGBA, height int) *image.RGBA {
+	b := img.Bounds()
+	width := b.Dx()
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	ws := computeWeights(b.Dy(), height)
+	for y, w := range ws {
+		out := dst.Pix[y*dst.Stride:]
+		for x := 0; x < width; x++ {
+			var r, g, bl, a float64
+			for k, v := range w.values {
+				p := img.Pix[img.PixOffset(b.Min.X+x, b.Min.Y+w.start+k):]
+				r += float64(p[0]) * v
+				g += float64(p[1]) * v
+				bl += float64(p[2]) * v
+				a += float64(p[3]) * v
+			}
+			setPixel(out[x*4:], r, g, bl, a)
+		}
+	}
+	return dst
+}
+
+func setPixel(p []byte, r, g, b, a float64) {
+	p[0] = clampByte(r)
+	p[1] = clampByte(g)
+	p[2] = clampByte(b)
+	p[3] = clampByte(a)
+}
+
+func clampByte(v float64) uint8 {
+	v = math.Round(v)
+	if v < 0 {
+		return 0
+	}
+	if v > 255 {
+		return 255
+	}
+	return uint8(v)
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
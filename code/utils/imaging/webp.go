@@ -0,0 +1,43 @@
+package imaging
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+var ErrNotWebP = errors.New("not a webp image")
+
+// WebPSize 从文件头读取 WebP 图片的宽高，标准库没有 WebP 解码器，
+// 无法缩放时至少可以估算尺寸
+func WebPSize(data []byte) (int, int, error) {
+	if len(data) < 30 || string(data[0:4]) != "RIFF" ||
+		string(data[8:12]) != "WEBP" {
+		return 0, 0, ErrNotWebP
+	}
+	chunk := data[12:]
+	switch string(chunk[0:4]) {
+	case "VP8 ":
+		// 有损格式: 3 字节帧标记后是起始码 9d 01 2a，随后为 14 位宽高
+		frame := chunk[8:]
+		if frame[3] != 0x9d || frame[4] != 0x01 || frame[5] != 0x2a {
+			return 0, 0, ErrNotWebP
+		}
+		w := int(binary.LittleEndian.Uint16(frame[6:8]) & 0x3fff)
+		h := int(binary.LittleEndian.Uint16(frame[8:10]) & 0x3fff)
+		return w, h, nil
+	case "VP8L":
+		// 无损格式: 签名 0x2f 后是两个 14 位的宽减一和高减一
+		if chunk[8] != 0x2f {
+			return 0, 0, ErrNotWebP
+		}
+		bits := binary.LittleEndian.Uint32(chunk[9:13])
+		return int(bits&0x3fff) + 1, int((bits>>14)&0x3fff) + 1, nil
+	case "VP8X":
+		// 扩展格式: 4 字节标记后是 24 位的画布宽减一和高减一
+		b := chunk[12:18]
+		w := int(b[0]) | int(b[1])<<8 | int(b[2])<<16
+		h := int(b[3]) | int(b[4])<<8 | int(b[5])<<16
+		return w + 1, h + 1, nil
+	}
+	return 0, 0, ErrNotWebP
+}
@@ -0,0 +1,65 @@
+package imaging
+
+import (
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+func webpFile(chunk string, payload []byte) []byte {
+	data := []byte("RIFF\x00\x00\x00\x00WEBP" + chunk)
+	data = append(data, le32(uint32(len(payload)))...)
+	data = append(data, payload...)
+	for len(data) < 32 {
+		data = append(data, 0)
+	}
+	binary.LittleEndian.PutUint32(data[4:], uint32(len(data)-8))
+	return data
+}
+
+func le16(v uint16) []byte {
+	b := make([]byte, 2)
+	binary.LittleEndian.PutUint16(b, v)
+	return b
+}
+
+func le32(v uint32) []byte {
+	b := make([]byte, 4)
+	binary.LittleEndian.PutUint32(b, v)
+	return b
+}
+
+func TestWebPSize(t *testing.T) {
+	lossy := []byte{0x50, 0x01, 0x00, 0x9d, 0x01, 0x2a}
+	lossy = append(lossy, le16(640)...)
+	lossy = append(lossy, le16(480)...)
+
+	lossless := []byte{0x2f}
+	lossless = append(lossless, le32(uint32(99)|uint32(49)<<14)...)
+
+	extended := []byte{0x10, 0, 0, 0, 0x1f, 0x03, 0x00, 0xff, 0x02, 0x00}
+
+	tests := []struct {
+		name string
+		data []byte
+		w, h int
+	}{
+		{"lossy", webpFile("VP8 ", lossy), 640, 480},
+		{"lossless", webpFile("VP8L", lossless), 100, 50},
+		{"extended", webpFile("VP8X", extended), 800, 768},
+	}
+	for _, tt := range tests {
+		w, h, err := WebPSize(tt.data)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if w != tt.w || h != tt.h {
+			t.Errorf("%s: size = %dx%d, want %dx%d", tt.name, w, h, tt.w, tt.h)
+		}
+	}
+
+	if _, _, err := WebPSize([]byte("RIFF\x00\x00\x00\x00WAVEfmt ")); !errors.Is(err, ErrNotWebP) {
+		t.Errorf("WebPSize(wav) error = %v, want ErrNotWebP", err)
+	}
+}
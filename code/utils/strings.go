@@ -1,6 +1,10 @@
 package utils
 
-import "strings"
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
 
 func CutPrefix(s, prefix string) (string, bool) {
 	if strings.HasPrefix(s, prefix) {
@@ -36,3 +40,61 @@ func EitherTrimEqual(s string, prefix ...string) (string, bool) {
 	}
 	return s, false
 }
+
+// MergeOverlap 拼接两段有重叠的文字，例如相邻的两段音频转写结果。
+// 在 prev 末尾和 next 开头各 window 个字符内查找最长的公共片段，
+// 找到不少于 minOverlap 个字符的公共片段时去掉重复部分，否则直接拼接
+func MergeOverlap(prev, next string, window, minOverlap int) string {
+	a := []rune(prev)
+	b := []rune(next)
+	if len(a) == 0 || len(b) == 0 {
+		return prev + next
+	}
+	tailStart := len(a) - window
+	if tailStart < 0 {
+		tailStart = 0
+	}
+	tail := a[tailStart:]
+	head := b
+	if len(head) > window {
+		head = head[:window]
+	}
+
+	// 最长公共子串，记录其在 tail 和 head 中的结束位置
+	best, endA, endB := 0, 0, 0
+	lengths := make([]int, len(head)+1)
+	for i := 1; i <= len(tail); i++ {
+		prevDiag := 0
+		for j := 1; j <= len(head); j++ {
+			saved := lengths[j]
+			if tail[i-1] == head[j-1] {
+				lengths[j] = prevDiag + 1
+				if lengths[j] > best {
+					best, endA, endB = lengths[j], i, j
+				}
+			} else {
+				lengths[j] = 0
+			}
+			prevDiag = saved
+		}
+	}
+	if best < minOverlap {
+		return JoinText(prev, next)
+	}
+	return string(a[:tailStart+endA]) + string(b[endB:])
+}
+
+// JoinText 拼接两段文字，两侧都是西文时补一个空格
+func JoinText(prev, next string) string {
+	prev = strings.TrimRightFunc(prev, unicode.IsSpace)
+	next = strings.TrimLeftFunc(next, unicode.IsSpace)
+	if prev == "" || next == "" {
+		return prev + next
+	}
+	last, _ := utf8.DecodeLastRuneInString(prev)
+	first, _ := utf8.DecodeRuneInString(next)
+	if last < utf8.RuneSelf && first < utf8.RuneSelf {
+		return prev + " " + next
+	}
+	return prev + next
+}
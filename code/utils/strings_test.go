@@ -107,3 +107,69 @@ func TestEitherTrimEqual(t *testing.T) {
 		})
 	}
 }
+
+func TestMergeOverlap(t *testing.T) {
+	tests := []struct {
+		name string
+		prev string
+		next string
+		want string
+	}{
+		{
+			name: "English overlap",
+			prev: "we will ship the release next week after",
+			next: "release next week after the final review",
+			want: "we will ship the release next week after the final review",
+		},
+		{
+			name: "Chinese overlap with cut word",
+			prev: "今天我们讨论一下下周的发布计划",
+			next: "周的发布计划以及测试安排",
+			want: "今天我们讨论一下下周的发布计划以及测试安排",
+		},
+		{
+			name: "No overlap English",
+			prev: "hello there",
+			next: "general kenobi",
+			want: "hello there general kenobi",
+		},
+		{
+			name: "No overlap Chinese",
+			prev: "你好",
+			next: "世界",
+			want: "你好世界",
+		},
+		{
+			name: "Empty prev",
+			prev: "",
+			next: "世界",
+			want: "世界",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := MergeOverlap(tt.prev, tt.next, 50, 4); got != tt.want {
+				t.Errorf("MergeOverlap() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestJoinText(t *testing.T) {
+	tests := []struct {
+		prev, next, want string
+	}{
+		{"hello", "world", "hello world"},
+		{"hello ", " world", "hello world"},
+		{"你好", "世界", "你好世界"},
+		{"版本", "v2", "版本v2"},
+		{"", "world", "world"},
+		{"hello", "", "hello"},
+	}
+	for _, tt := range tests {
+		if got := JoinText(tt.prev, tt.next); got != tt.want {
+			t.Errorf("JoinText(%q, %q) = %q, want %q", tt.prev, tt.next, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package transcript
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"start-feishubot/utils"
+)
+
+// Segment 一段带时间戳的转写文字
+type Segment struct {
+	Start time.Duration
+	End   time.Duration
+	Text  string
+}
+
+// Turn 同一位说话人连续的发言
+type Turn struct {
+	Speaker int
+	Start   time.Duration
+	End     time.Duration
+	Text    string
+}
+
+// mergeTolerance 判断相邻音频片段重叠部分是否重复时允许的时间误差
+const mergeTolerance = 500 * time.Millisecond
+
+// Merge 拼接相邻两段音频的转写分段，next 的时间戳需已加上片段的起始偏移。
+// 两段音频有重叠时，next 中开始于 prev 最后一段结束之前的分段视为重复内容丢弃
+func Merge(prev, next []Segment) []Segment {
+	if len(prev) == 0 {
+		return next
+	}
+	last := prev[len(prev)-1].End
+	for i, s := range next {
+		if s.Start+mergeTolerance >= last {
+			return append(prev, next[i:]...)
+		}
+	}
+	return prev
+}
+
+// Turns 按停顿推测说话人轮换：两段之间的停顿不短于 pause，
+// 或者上一段以问句结尾时视为换人发言。只能区分轮换，说话人编号在 0 和 1 之间交替
+func Turns(segments []Segment, pause time.Duration) []Turn {
+	var turns []Turn
+	for _, s := range segments {
+		text := strings.TrimSpace(s.Text)
+		if text == "" {
+			continue
+		}
+		if len(turns) == 0 {
+			turns = append(turns, Turn{Start: s.Start, End: s.End, Text: text})
+			continue
+		}
+		current := &turns[len(turns)-1]
+		if s.Start-current.End >= pause || endsWithQuestion(current.Text) {
+			turns = append(turns, Turn{Speaker: 1 - current.Speaker,
+				Start: s.Start, End: s.End, Text: text})
+			continue
+		}
+		current.End = s.End
+		current.Text = utils.JoinText(current.Text, text)
+	}
+	return turns
+}
+
+func endsWithQuestion(text string) bool {
+	r, _ := utf8.DecodeLastRuneInString(text)
+	return r == '?' || r == '？'
+}
+
+// SpeakerName 说话人的显示名称
+func SpeakerName(speaker int) string {
+	return fmt.Sprintf("说话人 %c", 'A'+speaker)
+}
+
+// FormatTimestamp 将时长格式化为 hh:mm:ss
+func FormatTimestamp(d time.Duration) string {
+	d = d.Round(time.Second)
+	h := d / time.Hour
+	m := (d % time.Hour) / time.Minute
+	s := (d % time.Minute) / time.Second
+	return fmt.Sprintf("%02d:%02d:%02d", h, m, s)
+}
+
+// Text 将发言整理为带时间戳和说话人的纯文本
+func Text(turns []Turn) string {
+	var b strings.Builder
+	for i, t := range turns {
+		if i > 0 {
+			b.WriteString("\n\n")
+		}
+		fmt.Fprintf(&b, "[%s] %s\n%s", FormatTimestamp(t.Start),
+			SpeakerName(t.Speaker), t.Text)
+	}
+	return b.String()
+}
+
+// SRT 将分段输出为 SubRip 字幕
+func SRT(segments []Segment) string {
+	var b strings.Builder
+	index := 0
+	for _, s := range segments {
+		text := strings.TrimSpace(s.Text)
+		if text == "" {
+			continue
+		}
+		index++
+		fmt.Fprintf(&b, "%d\n%s --> %s\n%s\n\n", index,
+			formatCueTime(s.Start, ','), formatCueTime(s.End, ','), text)
+	}
+	return b.String()
+}
+
+// VTT 将分段输出为 WebVTT 字幕
+func VTT(segments []Segment) string {
+	var b strings.Builder
+	b.WriteString("WEBVTT\n\n")
+	for _, s := range segments {
+		text := strings.TrimSpace(s.Text)
+		if text == "" {
+			continue
+		}
+		fmt.Fprintf(&b, "%s --> %s\n%s\n\n", formatCueTime(s.Start, '.'),
+			formatCueTime(s.End, '.'), text)
+	}
+	return b.String()
+}
+
+// formatCueTime 字幕时间格式 hh:mm:ss,mmm，SRT 与 WebVTT 只有毫秒分隔符不同
+func formatCueTime(d time.Duration, sep byte) string {
+	d = d.Round(time.Millisecond)
+	h := d / time.Hour
+	m := (d % time.Hour) / time.Minute
+	s := (d % time.Minute) / time.Second
+	ms := (d % time.Second) / time.Millisecond
+	return fmt.Sprintf("%02d:%02d:%02d%c%03d", h, m, s, sep, ms)
+}
@@ -0,0 +1,88 @@
+package transcript
+
+import (
+	"testing"
+	"time"
+)
+
+func sec(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+func TestMerge(t *testing.T) {
+	prev := []Segment{
+		{Start: 0, End: sec(4), Text: "a"},
+		{Start: sec(4), End: sec(9.5), Text: "b"},
+	}
+	next := []Segment{
+		{Start: sec(7), End: sec(9.4), Text: "b (dup)"},
+		{Start: sec(9.2), End: sec(12), Text: "c"},
+		{Start: sec(12), End: sec(15), Text: "d"},
+	}
+	got := Merge(prev, next)
+	want := []string{"a", "b", "c", "d"}
+	if len(got) != len(want) {
+		t.Fatalf("Merge() = %v, want texts %v", got, want)
+	}
+	for i := range want {
+		if got[i].Text != want[i] {
+			t.Errorf("Merge()[%d] = %q, want %q", i, got[i].Text, want[i])
+		}
+	}
+}
+
+func TestTurns(t *testing.T) {
+	segments := []Segment{
+		{Start: 0, End: sec(3), Text: "我们先看一下进度"},
+		{Start: sec(3.2), End: sec(6), Text: "后端已经完成了吗？"},
+		{Start: sec(6.3), End: sec(9), Text: "完成了"},
+		{Start: sec(9.1), End: sec(10), Text: "下周提测"},
+		{Start: sec(12), End: sec(14), Text: " "},
+		{Start: sec(14), End: sec(16), Text: "ok sounds good"},
+	}
+	turns := Turns(segments, 1500*time.Millisecond)
+	want := []Turn{
+		{Speaker: 0, Start: 0, End: sec(6), Text: "我们先看一下进度后端已经完成了吗？"},
+		{Speaker: 1, Start: sec(6.3), End: sec(10), Text: "完成了下周提测"},
+		{Speaker: 0, Start: sec(14), End: sec(16), Text: "ok sounds good"},
+	}
+	if len(turns) != len(want) {
+		t.Fatalf("Turns() = %+v, want %+v", turns, want)
+	}
+	for i := range want {
+		if turns[i] != want[i] {
+			t.Errorf("Turns()[%d] = %+v, want %+v", i, turns[i], want[i])
+		}
+	}
+}
+
+func TestText(t *testing.T) {
+	turns := []Turn{
+		{Speaker: 0, Start: sec(5), Text: "hello"},
+		{Speaker: 1, Start: sec(3725), Text: "hi"},
+	}
+	want := "[00:00:05] 说话人 A\nhello\n\n[01:02:05] 说话人 B\nhi"
+	if got := Text(turns); got != want {
+		t.Errorf("Text() = %q, want %q", got, want)
+	}
+}
+
+func TestSubtitles(t *testing.T) {
+	segments := []Segment{
+		{Start: sec(0.5), End: sec(2.25), Text: " 大家好 "},
+		{Start: sec(2.25), End: sec(3), Text: ""},
+		{Start: sec(3661.001), End: sec(3662), Text: "bye"},
+	}
+
+	wantSRT := "1\n00:00:00,500 --> 00:00:02,250\n大家好\n\n" +
+		"2\n01:01:01,001 --> 01:01:02,000\nbye\n\n"
+	if got := SRT(segments); got != wantSRT {
+		t.Errorf("SRT() = %q, want %q", got, wantSRT)
+	}
+
+	wantVTT := "WEBVTT\n\n00:00:00.500 --> 00:00:02.250\n大家好\n\n" +
+		"01:01:01.001 --> 01:01:02.000\nbye\n\n"
+	if got := VTT(segments); got != wantVTT {
+		t.Errorf("VTT() = %q, want %q", got, wantVTT)
+	}
+}